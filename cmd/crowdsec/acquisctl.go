@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/crowdsecurity/go-cs-lib/trace"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition"
+	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
+)
+
+// The read-only health endpoint is served on the prometheus listener:
+//
+//	GET  /acquisition/health              -> state of every datasource
+//
+// The endpoints that act on the datasources are not authenticated, they are only served on
+// the listener of acquisition_control, bound to 127.0.0.1 by default:
+//
+//	POST /acquisition/pause?name=<name>   -> stop forwarding the events of a datasource
+//	POST /acquisition/resume?name=<name>  -> resume a paused datasource
+//	POST /acquisition/reload?name=<name>  -> apply the new query of a datasource, from its acquisition file
+
+func registerAcquisitionHealth(mux *http.ServeMux) {
+	mux.HandleFunc("/acquisition/health", serveAcquisitionHealth)
+}
+
+func registerAcquisitionControl(mux *http.ServeMux) {
+	mux.HandleFunc("/acquisition/pause", serveAcquisitionAction(func(_ context.Context, name string) error {
+		return acquisition.PauseSource(name)
+	}))
+	mux.HandleFunc("/acquisition/resume", serveAcquisitionAction(func(_ context.Context, name string) error {
+		return acquisition.ResumeSource(name)
+	}))
+	mux.HandleFunc("/acquisition/reload", serveAcquisitionAction(acquisition.ReloadSourceQuery))
+}
+
+// serveAcquisitionControl runs the listener of the acquisition control endpoints, it is
+// started once and not restarted by a reload.
+func serveAcquisitionControl(config *csconfig.AcquisitionControlCfg) {
+	defer trace.CatchPanic("crowdsec/serveAcquisitionControl")
+
+	mux := http.NewServeMux()
+	registerAcquisitionHealth(mux)
+	registerAcquisitionControl(mux)
+
+	addr := fmt.Sprintf("%s:%d", config.ListenAddr, config.ListenPort)
+	log.Infof("serving the acquisition control endpoints on %s", addr)
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Warningf("acquisition control: %s", err)
+	}
+}
+
+func serveAcquisitionHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(acquisition.SourcesStatus()); err != nil {
+		log.Errorf("while encoding acquisition health: %s", err)
+	}
+}
+
+func serveAcquisitionAction(action func(context.Context, string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "missing datasource name", http.StatusBadRequest)
+			return
+		}
+
+		if err := action(r.Context(), name); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, acquisition.ErrUnknownSource) {
+				status = http.StatusNotFound
+			}
+
+			http.Error(w, err.Error(), status)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -65,5 +65,9 @@ func StartRunSvc() error {
 		}()
 	}
 
+	if !cConfig.DisableAgent && cConfig.Crowdsec.AcquisitionControl != nil {
+		go serveAcquisitionControl(cConfig.Crowdsec.AcquisitionControl)
+	}
+
 	return Serve(cConfig, agentReady)
 }
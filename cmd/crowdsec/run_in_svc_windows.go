@@ -97,5 +97,10 @@ func WindowsRun() error {
 		registerPrometheus(cConfig.Prometheus)
 		go servePrometheus(cConfig.Prometheus, dbClient, agentReady)
 	}
+
+	if !cConfig.DisableAgent && cConfig.Crowdsec.AcquisitionControl != nil {
+		go serveAcquisitionControl(cConfig.Crowdsec.AcquisitionControl)
+	}
+
 	return Serve(cConfig, agentReady)
 }
@@ -59,6 +59,20 @@ type DataSource interface {
 	Dump() any
 }
 
+// HealthReporter is implemented by the datasources that can report a degraded state,
+// for example while a remote service is unreachable. The reason is free-form.
+type HealthReporter interface {
+	Degraded() (bool, string)
+}
+
+// QueryReloader is implemented by the datasources that can change their query (or filters)
+// while running. ReloadQuery receives the new configuration of the datasource: it must fail,
+// leaving the datasource untouched, if anything else than the query changed or if the new
+// query is not valid.
+type QueryReloader interface {
+	ReloadQuery(ctx context.Context, yamlConfig []byte) error
+}
+
 var (
 	// We declare everything here so we can tell if they are unsupported, or excluded from the build
 	AcquisitionSources = map[string]func() DataSource{}
@@ -145,6 +159,8 @@ func DataSourceConfigure(commonConfig configuration.DataSourceCommonCfg, yamlCon
 }
 
 func LoadAcquisitionFromDSN(dsn string, labels map[string]string, transformExpr string) ([]DataSource, error) {
+	resetSourceRuntimes()
+
 	frags := strings.Split(dsn, ":")
 	if len(frags) == 1 {
 		return nil, fmt.Errorf("%s isn't valid dsn (no protocol)", dsn)
@@ -176,6 +192,17 @@ func LoadAcquisitionFromDSN(dsn string, labels map[string]string, transformExpr
 		return nil, fmt.Errorf("while configuration datasource for %s: %w", dsn, err)
 	}
 
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Source:   frags[0],
+		Labels:   labels,
+		UniqueId: uniqueId,
+	}, dataSrc.GetMode())
+	if err != nil {
+		return nil, err
+	}
+
+	registerSourceRuntime(rt, dataSrc)
+
 	return []DataSource{dataSrc}, nil
 }
 
@@ -335,6 +362,15 @@ func sourcesFromFile(acquisFile string, metrics_level int) ([]DataSource, error)
 			transformRuntimes[uniqueId] = vm
 		}
 
+		rt, err := newSourceRuntime(sub, src.GetMode())
+		if err != nil {
+			return nil, fmt.Errorf("while configuring datasource of type %s from %s (position %d): %w", sub.Source, acquisFile, idx, err)
+		}
+
+		rt.acquisFile = acquisFile
+
+		registerSourceRuntime(rt, src)
+
 		sources = append(sources, src)
 	}
 
@@ -345,8 +381,20 @@ func sourcesFromFile(acquisFile string, metrics_level int) ([]DataSource, error)
 func LoadAcquisitionFromFiles(config *csconfig.CrowdsecServiceCfg, prom *csconfig.PrometheusCfg) ([]DataSource, error) {
 	var allSources []DataSource
 
+	resetSourceRuntimes()
+
 	metrics_level := GetMetricsLevelFromPromCfg(prom)
 
+	acquisitionMemory.setLimit(config.MaxAcquisitionMemory)
+
+	if config.AcquisitionDedup != nil {
+		acquisitionDedup.Store(newDedupFilter(*config.AcquisitionDedup))
+	} else {
+		acquisitionDedup.Store(nil)
+	}
+
+	acquisitionMerge.Store(config.AcquisitionMerge)
+
 	for _, acquisFile := range config.AcquisitionFiles {
 		sources, err := sourcesFromFile(acquisFile, metrics_level)
 		if err != nil {
@@ -362,6 +410,15 @@ func LoadAcquisitionFromFiles(config *csconfig.CrowdsecServiceCfg, prom *csconfi
 func GetMetrics(sources []DataSource, aggregated bool) error {
 	var metrics []prometheus.Collector
 
+	for _, metric := range managerMetrics() {
+		if err := prometheus.Register(metric); err != nil {
+			var alreadyRegisteredErr prometheus.AlreadyRegisteredError
+			if !errors.As(err, &alreadyRegisteredErr) {
+				return fmt.Errorf("could not register acquisition metrics: %w", err)
+			}
+		}
+	}
+
 	for i := range sources {
 		if aggregated {
 			metrics = sources[i].GetMetrics()
@@ -457,16 +514,32 @@ func StartAcquisition(ctx context.Context, sources []DataSource, output chan typ
 		return nil
 	}
 
+	var mergeInput chan types.Event
+
+	if cfg := acquisitionMerge.Load(); cfg != nil && slices.ContainsFunc(sources, func(src DataSource) bool {
+		return src.GetMode() == configuration.TAIL_MODE
+	}) {
+		// the streaming datasources write to the merge, that writes to the output
+		mergeInput = make(chan types.Event)
+
+		go merge(mergeInput, output, *cfg, acquisTomb)
+	}
+
 	for i := range sources {
 		subsrc := sources[i] // ensure its a copy
 		log.Debugf("starting one source %d/%d ->> %T", i, len(sources), subsrc)
 
+		srcOutput := output
+		if mergeInput != nil && subsrc.GetMode() == configuration.TAIL_MODE {
+			srcOutput = mergeInput
+		}
+
 		acquisTomb.Go(func() error {
 			defer trace.CatchPanic("crowdsec/acquis")
 
 			var err error
 
-			outChan := output
+			outChan := srcOutput
 
 			log.Debugf("datasource %s UUID: %s", subsrc.GetName(), subsrc.GetUuid())
 
@@ -481,15 +554,57 @@ func StartAcquisition(ctx context.Context, sources []DataSource, output chan typ
 				})
 
 				acquisTomb.Go(func() error {
-					transform(outChan, output, acquisTomb, transformRuntime, transformLogger)
+					transform(outChan, srcOutput, acquisTomb, transformRuntime, transformLogger)
 					return nil
 				})
 			}
 
+			var rtChan chan types.Event
+
+			srcCtx := ctx
+
+			rt, ok := getSourceRuntime(subsrc.GetUuid())
+			if ok {
+				rtChan = make(chan types.Event)
+				rtOutput := outChan
+				outChan = rtChan
+
+				if subsrc.GetMode() == configuration.TAIL_MODE {
+					// streaming datasources can keep writing after StreamingAcquisition returns,
+					// keep forwarding until every goroutine of the tomb is done
+					go rt.forward(rtChan, rtOutput, acquisTomb)
+				} else {
+					// canceled with max_events
+					var cancel context.CancelFunc
+
+					srcCtx, cancel = rt.sourceContext(ctx)
+					defer cancel()
+
+					// make sure buffered events are flushed before the acquisition is over
+					acquisTomb.Go(func() error {
+						rt.forward(rtChan, rtOutput, acquisTomb)
+						return nil
+					})
+				}
+			}
+
 			if subsrc.GetMode() == configuration.TAIL_MODE {
-				err = subsrc.StreamingAcquisition(ctx, outChan, acquisTomb)
+				err = subsrc.StreamingAcquisition(srcCtx, outChan, acquisTomb)
 			} else {
-				err = subsrc.OneShotAcquisition(ctx, outChan, acquisTomb)
+				err = subsrc.OneShotAcquisition(srcCtx, outChan, acquisTomb)
+
+				if rtChan != nil {
+					if rt.stoppedByLimit() {
+						err = nil
+					}
+
+					if err != nil {
+						rt.setStopReason(err)
+					}
+
+					// one-shot datasources are done writing when they return
+					close(rtChan)
+				}
 			}
 
 			if err != nil {
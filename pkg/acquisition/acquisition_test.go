@@ -297,6 +297,20 @@ func TestLoadAcquisitionFromFiles(t *testing.T) {
 			},
 			ExpectedLen: 1,
 		},
+		{
+			TestName: "metadata",
+			Config: csconfig.CrowdsecServiceCfg{
+				AcquisitionFiles: []string{"testdata/metadata.yaml"},
+			},
+			ExpectedLen: 1,
+		},
+		{
+			TestName: "reserved_metadata",
+			Config: csconfig.CrowdsecServiceCfg{
+				AcquisitionFiles: []string{"testdata/reserved_metadata.yaml"},
+			},
+			ExpectedError: "metadata: 'source_ip' is a reserved key",
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.TestName, func(t *testing.T) {
@@ -316,6 +330,11 @@ func TestLoadAcquisitionFromFiles(t *testing.T) {
 				assert.Equal(t, "${NON_EXISTING}", mock.Labels["non_existing"])
 				assert.Equal(t, log.InfoLevel, mock.logger.Logger.Level)
 			}
+
+			if tc.TestName == "metadata" {
+				mock := dss[0].Dump().(*MockSource)
+				assert.Equal(t, map[string]string{"team": "payments", "env": "test_value2"}, mock.Metadata)
+			}
 		})
 	}
 }
@@ -0,0 +1,162 @@
+package acquisition
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	tomb "gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// backlogQueue holds the events of a datasource that are waiting for the parsers, up to
+// max_backlog. When it's full, the datasource is blocked on its output until the parsers catch
+// up: its read loop is held, the same way as when the datasource is paused. Backpressure is
+// reported when the queue gets full, and cleared once it's back under half its size.
+// The queued events are accounted in acquisitionMemory: while max_acquisition_memory is
+// exceeded, the datasource is blocked the same way until the queue is drained.
+type backlogQueue struct {
+	queue      chan types.Event
+	released   chan struct{} // signaled by drain when an event left the queue
+	pressure   atomic.Bool
+	datasource string // for the metrics
+	logger     *log.Entry
+}
+
+func newBacklogQueue(size int, datasource string, logger *log.Entry) *backlogQueue {
+	return &backlogQueue{
+		queue:      make(chan types.Event, size),
+		released:   make(chan struct{}, 1),
+		datasource: datasource,
+		logger:     logger,
+	}
+}
+
+func (b *backlogQueue) size() int {
+	return len(b.queue)
+}
+
+func (b *backlogQueue) underPressure() bool {
+	return b.pressure.Load()
+}
+
+func (b *backlogQueue) updateGauge() {
+	sourceBacklog.With(prometheus.Labels{"datasource": b.datasource}).Set(float64(len(b.queue)))
+}
+
+// push adds an event to the queue, blocking while it's full. It returns false if the
+// acquisition is over.
+func (b *backlogQueue) push(evt types.Event, acquisTomb *tomb.Tomb) bool {
+	if !b.waitMemory(acquisTomb) {
+		return false
+	}
+
+	size := eventSize(&evt)
+	acquisitionMemory.reserve(size)
+
+	select {
+	case b.queue <- evt:
+		b.updateGauge()
+		return true
+	default:
+	}
+
+	if !b.pressure.Swap(true) {
+		b.logger.Warningf("backlog of %d events reached, slowing down the datasource", cap(b.queue))
+	}
+
+	start := time.Now()
+
+	defer func() {
+		backpressureSeconds.With(prometheus.Labels{"datasource": b.datasource}).Add(time.Since(start).Seconds())
+	}()
+
+	select {
+	case b.queue <- evt:
+		b.updateGauge()
+		return true
+	case <-acquisTomb.Dead():
+		acquisitionMemory.release(size)
+		return false
+	}
+}
+
+// waitMemory blocks while max_acquisition_memory is exceeded and the queue holds events, which
+// are released as they are drained. An empty queue does not wait: the memory is held by other
+// buffers. It returns false if the acquisition is over.
+func (b *backlogQueue) waitMemory(acquisTomb *tomb.Tomb) bool {
+	if !acquisitionMemory.exceeded() || len(b.queue) == 0 {
+		return true
+	}
+
+	if !b.pressure.Swap(true) {
+		b.logger.Warning("max_acquisition_memory reached, slowing down the datasource")
+	}
+
+	start := time.Now()
+
+	defer func() {
+		backpressureSeconds.With(prometheus.Labels{"datasource": b.datasource}).Add(time.Since(start).Seconds())
+	}()
+
+	for acquisitionMemory.exceeded() && len(b.queue) > 0 {
+		select {
+		case <-b.released:
+		case <-acquisTomb.Dead():
+			return false
+		}
+	}
+
+	return true
+}
+
+// close is called when no more events are pushed, the queued ones are still drained.
+func (b *backlogQueue) close() {
+	close(b.queue)
+}
+
+// drain sends the queued events to the output until the queue is closed and empty, or the
+// acquisition is over.
+func (b *backlogQueue) drain(output chan types.Event, acquisTomb *tomb.Tomb) {
+	defer sourceBacklog.Delete(prometheus.Labels{"datasource": b.datasource})
+	defer b.discard()
+
+	for evt := range b.queue {
+		acquisitionMemory.release(eventSize(&evt))
+
+		select {
+		case b.released <- struct{}{}:
+		default:
+		}
+
+		select {
+		case output <- evt:
+		case <-acquisTomb.Dead():
+			return
+		}
+
+		b.updateGauge()
+
+		if len(b.queue) <= cap(b.queue)/2 && b.pressure.CompareAndSwap(true, false) {
+			b.logger.Infof("backlog back to %d events", len(b.queue))
+		}
+	}
+}
+
+// discard releases the memory of the events left in the queue when the acquisition is over.
+func (b *backlogQueue) discard() {
+	for {
+		select {
+		case evt, ok := <-b.queue:
+			if !ok {
+				return
+			}
+
+			acquisitionMemory.release(eventSize(&evt))
+		default:
+			return
+		}
+	}
+}
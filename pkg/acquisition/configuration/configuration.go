@@ -1,18 +1,42 @@
 package configuration
 
 import (
+	"time"
+
 	log "github.com/sirupsen/logrus"
 )
 
 type DataSourceCommonCfg struct {
-	Mode           string            `yaml:"mode,omitempty"`
-	Labels         map[string]string `yaml:"labels,omitempty"`
-	LogLevel       *log.Level        `yaml:"log_level,omitempty"`
-	Source         string            `yaml:"source,omitempty"`
-	Name           string            `yaml:"name,omitempty"`
-	UseTimeMachine bool              `yaml:"use_time_machine,omitempty"`
-	UniqueId       string            `yaml:"unique_id,omitempty"`
-	TransformExpr  string            `yaml:"transform,omitempty"`
+	Mode             string            `yaml:"mode,omitempty"`
+	Labels           map[string]string `yaml:"labels,omitempty"`
+	LogLevel         *log.Level        `yaml:"log_level,omitempty"`
+	Source           string            `yaml:"source,omitempty"`
+	Name             string            `yaml:"name,omitempty"`
+	UseTimeMachine   bool              `yaml:"use_time_machine,omitempty"`
+	UniqueId         string            `yaml:"unique_id,omitempty"`
+	TransformExpr    string            `yaml:"transform,omitempty"`
+	ReorderWindow    time.Duration     `yaml:"reorder_window,omitempty"`        // cat mode only: sort events by timestamp within this window
+	ReorderMaxEvents int               `yaml:"reorder_max_events,omitempty"`    // max events held by the reordering buffer
+	Metadata         map[string]string `yaml:"metadata,omitempty"`              // static metadata added to every event
+	IncludeSequence  bool              `yaml:"include_sequence,omitempty"`      // add a per-source sequence number to every event
+	WarmupDiscard    string            `yaml:"warmup_discard,omitempty"`        // drop the first events of a run: a count ("100") or a duration ("30s")
+	PipelineTag      string            `yaml:"pipeline_tag,omitempty"`          // only the parsers and scenarios without pipeline_tags, or with this tag, see the events
+	IngestLatency    bool              `yaml:"ingest_latency,omitempty"`        // add the delay between the timestamp of the log and its reception to every event
+	MaxBacklog       int               `yaml:"max_backlog,omitempty"`           // events queued toward the parsers before the datasource is slowed down
+	LifecycleEvents  bool              `yaml:"emit_lifecycle_events,omitempty"` // emit an event when the datasource starts and stops
+	MaxEvents        int               `yaml:"max_events,omitempty"`            // cat mode only: stop the datasource once it has sent this many events
+	EventID          bool              `yaml:"event_id,omitempty"`              // add a hash of event_id_fields to every event, for idempotent processing
+	EventIDFields    []string          `yaml:"event_id_fields,omitempty"`       // source, src, line, time or meta.<key> (default: source, src, line)
+	Redact           []RedactRule      `yaml:"redact,omitempty"`                // masked in the lines and in the metadata set by the datasource
+	LogLevelNorm     bool              `yaml:"normalize_log_level,omitempty"`   // add the level of the line, as trace, debug, info, warning, error or critical
+	LogLevelField    string            `yaml:"log_level_field,omitempty"`       // metadata holding the level, instead of looking for it in the line
+	LogLevelRegexp   string            `yaml:"log_level_regexp,omitempty"`      // finds the level in the line, instead of the built-in heuristic
+}
+
+// RedactRule replaces the matches of a regular expression.
+type RedactRule struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"` // can refer to the groups of the pattern ($1, ${name}), the matches are removed if empty
 }
 
 const (
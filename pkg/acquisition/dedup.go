@@ -0,0 +1,113 @@
+package acquisition
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// dedupFilter drops the lines that were already received, from the same datasource or another
+// one, with acquisition_dedup. A line is identified by a hash of its text and of the window its
+// timestamp falls in.
+//
+// The lines are remembered in a rolling pair of bloom filters: once the current filter holds
+// `capacity` lines, it becomes the previous one and a new filter is started, so between capacity
+// and twice capacity lines are remembered. This is probabilistic: a line that was never seen
+// is dropped with a probability up to false_positive_rate (roughly twice that, since both filters
+// are checked), and duplicates are missed when they are further apart than the filters remember,
+// or when the two copies have timestamps on each side of a window boundary.
+type dedupFilter struct {
+	mu       sync.Mutex
+	window   time.Duration
+	capacity int
+	bits     uint64 // size of each filter
+	hashes   int
+
+	current  []uint64
+	previous []uint64
+	count    int // lines added to current
+}
+
+// acquisitionDedup is shared by all the datasources, nil unless acquisition_dedup is set.
+var acquisitionDedup atomic.Pointer[dedupFilter]
+
+func newDedupFilter(cfg csconfig.AcquisitionDedupCfg) *dedupFilter {
+	// optimal size and number of hash functions for the capacity and the false positive rate
+	bits := math.Ceil(-float64(cfg.Capacity) * math.Log(cfg.FalsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := max(int(math.Round(bits/float64(cfg.Capacity)*math.Ln2)), 1)
+
+	words := uint64(math.Ceil(bits / 64))
+
+	return &dedupFilter{
+		window:   cfg.Window,
+		capacity: cfg.Capacity,
+		bits:     words * 64,
+		hashes:   hashes,
+		current:  make([]uint64, words),
+		previous: make([]uint64, words),
+	}
+}
+
+func (d *dedupFilter) lineHash(evt *types.Event) (uint64, uint64) {
+	h := xxhash.New()
+	_, _ = h.WriteString(evt.Line.Raw)
+
+	bucket := make([]byte, 8)
+	binary.BigEndian.PutUint64(bucket, uint64(evt.Line.Time.Truncate(d.window).Unix()))
+	_, _ = h.Write(bucket)
+
+	h1 := h.Sum64()
+
+	// second hash for double hashing, it must be odd to go through all the positions
+	h2 := (h1>>33 | h1<<31) ^ 0x9e3779b97f4a7c15 | 1
+
+	return h1, h2
+}
+
+func (*dedupFilter) contains(filter []uint64, positions []uint64) bool {
+	for _, pos := range positions {
+		if filter[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// seen records the line of an event, and returns true if it was (probably) received before.
+func (d *dedupFilter) seen(evt *types.Event) bool {
+	h1, h2 := d.lineHash(evt)
+
+	positions := make([]uint64, d.hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % d.bits
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.contains(d.current, positions) || d.contains(d.previous, positions) {
+		return true
+	}
+
+	if d.count >= d.capacity {
+		d.previous, d.current = d.current, d.previous
+		clear(d.current)
+		d.count = 0
+	}
+
+	for _, pos := range positions {
+		d.current[pos/64] |= 1 << (pos % 64)
+	}
+
+	d.count++
+
+	return false
+}
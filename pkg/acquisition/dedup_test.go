@@ -0,0 +1,94 @@
+package acquisition
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tomb "gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func dedupEvent(line string, ts time.Time) *types.Event {
+	return &types.Event{Line: types.Line{Raw: line, Time: ts}}
+}
+
+func TestDedupFilter(t *testing.T) {
+	d := newDedupFilter(csconfig.AcquisitionDedupCfg{Capacity: 100, FalsePositiveRate: 0.001, Window: time.Minute})
+
+	ts := time.Date(2025, 1, 1, 10, 0, 10, 0, time.UTC)
+
+	assert.False(t, d.seen(dedupEvent("GET /", ts)))
+	assert.True(t, d.seen(dedupEvent("GET /", ts.Add(30*time.Second))))
+	// another window
+	assert.False(t, d.seen(dedupEvent("GET /", ts.Add(time.Minute))))
+	assert.False(t, d.seen(dedupEvent("GET /login", ts)))
+
+	// the lines are remembered for at least capacity lines
+	for i := range 100 {
+		d.seen(dedupEvent(fmt.Sprintf("filler %d", i), ts))
+	}
+
+	assert.True(t, d.seen(dedupEvent("GET /login", ts)))
+
+	// and forgotten after twice that
+	for i := range 200 {
+		d.seen(dedupEvent(fmt.Sprintf("other filler %d", i), ts))
+	}
+
+	assert.False(t, d.seen(dedupEvent("GET /login", ts)))
+}
+
+func TestDedupFalsePositives(t *testing.T) {
+	d := newDedupFilter(csconfig.AcquisitionDedupCfg{Capacity: 10000, FalsePositiveRate: 0.01, Window: time.Minute})
+
+	ts := time.Now()
+	falsePositives := 0
+
+	for i := range 10000 {
+		if d.seen(dedupEvent(fmt.Sprintf("line %d", i), ts)) {
+			falsePositives++
+		}
+	}
+
+	// 1% expected, with some slack
+	assert.Less(t, falsePositives, 200)
+}
+
+func TestDedupAcrossSources(t *testing.T) {
+	acquisitionDedup.Store(newDedupFilter(csconfig.AcquisitionDedupCfg{Capacity: 100, FalsePositiveRate: 0.001, Window: time.Minute}))
+	t.Cleanup(func() { acquisitionDedup.Store(nil) })
+
+	ts := time.Now()
+	output := make(chan types.Event, 10)
+
+	for _, name := range []string{"loki", "file"} {
+		rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+			Name:     name,
+			UniqueId: name + "-dedup-test-uuid",
+		}, configuration.CAT_MODE)
+		require.NoError(t, err)
+
+		input := make(chan types.Event, 2)
+		input <- *dedupEvent("line 1", ts)
+		input <- *dedupEvent("line 2 from "+name, ts)
+		close(input)
+
+		acquisTomb := tomb.Tomb{}
+		rt.forward(input, output, &acquisTomb)
+	}
+
+	close(output)
+
+	lines := []string{}
+	for evt := range output {
+		lines = append(lines, evt.Line.Raw)
+	}
+
+	assert.Equal(t, []string{"line 1", "line 2 from loki", "line 2 from file"}, lines)
+}
@@ -0,0 +1,12 @@
+//go:build !no_datasource_elasticsearch
+
+package acquisition
+
+import (
+	elasticsearchacquisition "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/elasticsearch"
+)
+
+//nolint:gochecknoinits
+func init() {
+	registerDataSource("elasticsearch", func() DataSource { return &elasticsearchacquisition.ElasticsearchSource{} })
+}
@@ -0,0 +1,89 @@
+package acquisition
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// eventIDMetaKey is set on the events of the datasources with event_id.
+const eventIDMetaKey = "event_id"
+
+const eventIDMetaPrefix = "meta."
+
+// defaultEventIDFields identify a line by the datasource, the file/stream/topic it comes from and
+// its text. Identical lines of the same source have the same id: when it matters, an offset or a
+// timestamp set by the datasource must be added.
+var defaultEventIDFields = []string{"source", "src", "line"}
+
+// eventIDField returns a value of the event that goes into its id.
+type eventIDField func(rt *sourceRuntime, evt *types.Event) string
+
+// eventIDHasher computes the event_id of the events, a hash of the event_id_fields. The same
+// fields give the same id across runs and instances of crowdsec, so that downstream systems can
+// drop the events they already processed.
+//
+// The id is the first 128 bits of a SHA-256 of the fields, as 32 hex characters: two different
+// events get the same id with a probability around n²/2^129 for n events (about 1 in 10^20 for
+// a billion events), so the collisions come from the choice of fields, not from the hash.
+type eventIDHasher struct {
+	fields []eventIDField
+}
+
+func newEventIDHasher(names []string) (*eventIDHasher, error) {
+	if len(names) == 0 {
+		names = defaultEventIDFields
+	}
+
+	h := &eventIDHasher{}
+
+	for _, name := range names {
+		var field eventIDField
+
+		switch name {
+		case "source":
+			field = func(rt *sourceRuntime, _ *types.Event) string { return rt.name }
+		case "src":
+			field = func(_ *sourceRuntime, evt *types.Event) string { return evt.Line.Src }
+		case "line":
+			field = func(_ *sourceRuntime, evt *types.Event) string { return evt.Line.Raw }
+		case "time":
+			field = func(_ *sourceRuntime, evt *types.Event) string { return evt.Line.Time.UTC().Format(time.RFC3339Nano) }
+		default:
+			key, ok := strings.CutPrefix(name, eventIDMetaPrefix)
+			if !ok || key == "" {
+				return nil, fmt.Errorf("event_id_fields: unknown field '%s', must be source, src, line, time or meta.<key>", name)
+			}
+
+			field = func(_ *sourceRuntime, evt *types.Event) string { return evt.GetMeta(key) }
+		}
+
+		h.fields = append(h.fields, field)
+	}
+
+	return h, nil
+}
+
+func writeField(h hash.Hash, value string) {
+	// length-prefixed, so that the values can't shift from a field to the next one
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(value)))
+	h.Write(size)
+	h.Write([]byte(value))
+}
+
+func (e *eventIDHasher) id(rt *sourceRuntime, evt *types.Event) string {
+	h := sha256.New()
+
+	for _, field := range e.fields {
+		writeField(h, field(rt, evt))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
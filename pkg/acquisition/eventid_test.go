@@ -0,0 +1,94 @@
+package acquisition
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tomb "gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func eventIDEvent(src string, line string, ts time.Time, meta map[string]string) *types.Event {
+	evt := types.MakeEvent(false, types.LOG, true)
+	evt.Line = types.Line{Src: src, Raw: line, Time: ts}
+
+	for key, value := range meta {
+		evt.SetMeta(key, value)
+	}
+
+	return &evt
+}
+
+func TestEventIDHasher(t *testing.T) {
+	rt := &sourceRuntime{name: "nginx"}
+	other := &sourceRuntime{name: "apache"}
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	h, err := newEventIDHasher(nil)
+	require.NoError(t, err)
+
+	id := h.id(rt, eventIDEvent("/var/log/access.log", "GET /", ts, nil))
+	assert.Len(t, id, 32)
+
+	// stable, and the default fields don't include the time
+	assert.Equal(t, id, h.id(rt, eventIDEvent("/var/log/access.log", "GET /", ts.Add(time.Hour), nil)))
+	assert.NotEqual(t, id, h.id(other, eventIDEvent("/var/log/access.log", "GET /", ts, nil)))
+	assert.NotEqual(t, id, h.id(rt, eventIDEvent("/var/log/other.log", "GET /", ts, nil)))
+	assert.NotEqual(t, id, h.id(rt, eventIDEvent("/var/log/access.log", "GET /login", ts, nil)))
+
+	// the values don't shift from a field to the next one
+	assert.NotEqual(t,
+		h.id(rt, eventIDEvent("ab", "c", ts, nil)),
+		h.id(rt, eventIDEvent("a", "bc", ts, nil)))
+
+	h, err = newEventIDHasher([]string{"line", "time", "meta.offset"})
+	require.NoError(t, err)
+
+	id = h.id(rt, eventIDEvent("a", "GET /", ts, map[string]string{"offset": "42"}))
+	assert.Equal(t, id, h.id(other, eventIDEvent("b", "GET /", ts, map[string]string{"offset": "42"})))
+	assert.NotEqual(t, id, h.id(rt, eventIDEvent("a", "GET /", ts, map[string]string{"offset": "43"})))
+	assert.NotEqual(t, id, h.id(rt, eventIDEvent("a", "GET /", ts.Add(time.Nanosecond), map[string]string{"offset": "42"})))
+
+	for _, fields := range [][]string{{"offset"}, {"meta."}} {
+		_, err = newEventIDHasher(fields)
+		cstest.RequireErrorContains(t, err, "event_id_fields: unknown field '"+fields[0]+"', must be source, src, line, time or meta.<key>")
+	}
+}
+
+func TestEventID(t *testing.T) {
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:          "with-id",
+		UniqueId:      "event-id-test-uuid",
+		Metadata:      map[string]string{"env": "prod"},
+		EventID:       true,
+		EventIDFields: []string{"line", "meta.env"},
+	}, configuration.CAT_MODE)
+	require.NoError(t, err)
+
+	input := make(chan types.Event, 2)
+	output := make(chan types.Event, 2)
+
+	input <- types.Event{Line: types.Line{Raw: "a line"}}
+	input <- types.Event{Line: types.Line{Raw: "a line"}}
+
+	close(input)
+	rt.forward(input, output, &tomb.Tomb{})
+
+	first := <-output
+	second := <-output
+
+	assert.Len(t, first.Meta[eventIDMetaKey], 32)
+	assert.Equal(t, first.Meta[eventIDMetaKey], second.Meta[eventIDMetaKey])
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{EventIDFields: []string{"line"}}, configuration.CAT_MODE)
+	require.EqualError(t, err, "event_id_fields requires event_id")
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{Metadata: map[string]string{"event_id": "x"}}, configuration.CAT_MODE)
+	require.EqualError(t, err, "metadata: 'event_id' is a reserved key")
+}
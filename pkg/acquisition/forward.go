@@ -0,0 +1,12 @@
+//go:build !no_datasource_forward
+
+package acquisition
+
+import (
+	forwardacquisition "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/forward"
+)
+
+//nolint:gochecknoinits
+func init() {
+	registerDataSource("forward", func() DataSource { return &forwardacquisition.ForwardSource{} })
+}
@@ -0,0 +1,12 @@
+//go:build !no_datasource_gelf
+
+package acquisition
+
+import (
+	gelfacquisition "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/gelf"
+)
+
+//nolint:gochecknoinits
+func init() {
+	registerDataSource("gelf", func() DataSource { return &gelfacquisition.GelfSource{} })
+}
@@ -0,0 +1,12 @@
+//go:build !no_datasource_grpc
+
+package acquisition
+
+import (
+	grpcacquisition "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/grpc"
+)
+
+//nolint:gochecknoinits
+func init() {
+	registerDataSource("grpc", func() DataSource { return &grpcacquisition.GRPCSource{} })
+}
@@ -0,0 +1,76 @@
+package acquisition
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const (
+	lifecycleStarted = "started"
+	lifecycleStopped = "stopped"
+
+	// the type label of the lifecycle events, not the one of the logs of the datasource
+	lifecycleLogType = "acquisition_lifecycle"
+
+	lifecycleSourceMetaKey = "acquisition_source"
+	lifecycleReasonMetaKey = "acquisition_lifecycle_reason"
+)
+
+// lifecycleEvent reports the datasource starting or stopping, with emit_lifecycle_events.
+// The events are marked with types.LifecycleMetaKey: they skip the parsers, which have nothing
+// to extract from them, and don't reach the scenarios.
+func (rt *sourceRuntime) lifecycleEvent(state string, reason string) types.Event {
+	evt := types.MakeEvent(false, types.LOG, true)
+	evt.Line = types.Line{
+		Raw:     fmt.Sprintf("datasource %s %s: %s", rt.name, state, reason),
+		Src:     rt.name,
+		Time:    time.Now().UTC(),
+		Labels:  map[string]string{"type": lifecycleLogType},
+		Process: true,
+		Module:  rt.sourceType,
+	}
+
+	for key, value := range rt.metadata {
+		evt.SetMeta(key, value)
+	}
+
+	if rt.pipelineTag != "" {
+		evt.SetMeta(types.PipelineTagMetaKey, rt.pipelineTag)
+	}
+
+	evt.SetMeta(types.LifecycleMetaKey, state)
+	evt.SetMeta(lifecycleSourceMetaKey, rt.name)
+	evt.SetMeta(lifecycleReasonMetaKey, reason)
+
+	return evt
+}
+
+// setStopReason is called when a one-shot datasource returns an error, before its input is closed.
+func (rt *sourceRuntime) setStopReason(err error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.stopReason = err.Error()
+}
+
+// stoppedReason returns why a datasource stopped: max_events, the error it returned, or the end
+// of its input or of the acquisition.
+func (rt *sourceRuntime) stoppedReason(acquisErr error, inputClosed bool) string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	switch {
+	case rt.limitReached:
+		return "max_events reached"
+	case rt.stopReason != "":
+		return rt.stopReason
+	case inputClosed:
+		return "completed"
+	case acquisErr != nil:
+		return acquisErr.Error()
+	default:
+		return "shutdown"
+	}
+}
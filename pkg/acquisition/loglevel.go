@@ -0,0 +1,157 @@
+package acquisition
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// logLevelMetaKey is set on the events of the datasources with normalize_log_level, when the
+// level of the line is recognized.
+const logLevelMetaKey = "log_level"
+
+// the normalized levels
+const (
+	levelTrace    = "trace"
+	levelDebug    = "debug"
+	levelInfo     = "info"
+	levelWarning  = "warning"
+	levelError    = "error"
+	levelCritical = "critical"
+)
+
+var levelTokens = map[string]string{
+	"trace":         levelTrace,
+	"debug":         levelDebug,
+	"dbg":           levelDebug,
+	"info":          levelInfo,
+	"information":   levelInfo,
+	"informational": levelInfo,
+	"notice":        levelInfo,
+	"warn":          levelWarning,
+	"warning":       levelWarning,
+	"err":           levelError,
+	"error":         levelError,
+	"crit":          levelCritical,
+	"critical":      levelCritical,
+	"fatal":         levelCritical,
+	"panic":         levelCritical,
+	"alert":         levelCritical,
+	"emerg":         levelCritical,
+	"emergency":     levelCritical,
+}
+
+// syslogLevels are indexed by the syslog severity.
+var syslogLevels = []string{
+	levelCritical, // emergency
+	levelCritical, // alert
+	levelCritical, // critical
+	levelError,
+	levelWarning,
+	levelInfo, // notice
+	levelInfo,
+	levelDebug,
+}
+
+// syslogPriority is the <PRI> at the start of the lines of the raw syslog messages.
+var syslogPriority = regexp.MustCompile(`^<(\d{1,3})>`)
+
+// defaultLevelRegexp finds the first level of a line, as a key (level=warn, "level":"warn",
+// severity: warn), in brackets ([warn]) or as an upper case word (WARN). Lower case words are
+// not matched, they are too common in the messages.
+var defaultLevelRegexp = regexp.MustCompile(
+	`(?i:\b(?:level|lvl|severity)"?\s*[=:]\s*"?([a-z]+))` +
+		`|\[(?i:(trace|debug|info|notice|warn|warning|error|err|crit|critical|fatal|panic|alert|emerg))\]` +
+		`|\b(TRACE|DEBUG|INFO|NOTICE|WARN|WARNING|ERROR|ERR|CRIT|CRITICAL|FATAL|PANIC|ALERT|EMERG)\b`)
+
+// normalizeLevel returns the normalized level of a token, a syslog severity or a level name in
+// any case, or an empty string.
+func normalizeLevel(token string) string {
+	if severity, err := strconv.Atoi(token); err == nil {
+		if severity >= 0 && severity < len(syslogLevels) {
+			return syslogLevels[severity]
+		}
+
+		return ""
+	}
+
+	return levelTokens[strings.ToLower(token)]
+}
+
+// logLevelExtractor sets the log_level metadata of the events to a normalized level: trace,
+// debug, info, warning, error or critical. The level is taken from log_level_field, a metadata
+// set by the datasource, or found in the line with log_level_regexp or a built-in heuristic.
+// The events whose level is not recognized don't get the metadata.
+type logLevelExtractor struct {
+	field     string
+	re        *regexp.Regexp
+	heuristic bool // re is defaultLevelRegexp, the syslog priority is looked for too
+}
+
+func newLogLevelExtractor(field string, expr string) (*logLevelExtractor, error) {
+	e := &logLevelExtractor{field: field, re: defaultLevelRegexp, heuristic: true}
+
+	if expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("log_level_regexp: %w", err)
+		}
+
+		if re.NumSubexp() == 0 {
+			return nil, errors.New("log_level_regexp: a group must capture the level")
+		}
+
+		e.re = re
+		e.heuristic = false
+	}
+
+	return e, nil
+}
+
+// fromLine returns the level found in the line. With several groups, the first one that matched
+// is used, unless one is named level.
+func (e *logLevelExtractor) fromLine(line string) string {
+	if e.heuristic {
+		if m := syslogPriority.FindStringSubmatch(line); m != nil {
+			pri, _ := strconv.Atoi(m[1])
+			return normalizeLevel(strconv.Itoa(pri % 8))
+		}
+	}
+
+	m := e.re.FindStringSubmatch(line)
+	if m == nil {
+		return ""
+	}
+
+	if i := e.re.SubexpIndex("level"); i > 0 {
+		return normalizeLevel(m[i])
+	}
+
+	for _, group := range m[1:] {
+		if group != "" {
+			return normalizeLevel(group)
+		}
+	}
+
+	return ""
+}
+
+func (e *logLevelExtractor) apply(evt *types.Event) {
+	level := ""
+
+	if e.field != "" {
+		level = normalizeLevel(evt.GetMeta(e.field))
+	}
+
+	if level == "" {
+		level = e.fromLine(evt.Line.Raw)
+	}
+
+	if level != "" {
+		evt.SetMeta(logLevelMetaKey, level)
+	}
+}
@@ -0,0 +1,96 @@
+package acquisition
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tomb "gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestLogLevelHeuristic(t *testing.T) {
+	e, err := newLogLevelExtractor("", "")
+	require.NoError(t, err)
+
+	for line, expected := range map[string]string{
+		`2024-03-01T12:00:00Z ERROR failed to connect`:                 levelError,
+		`2024/03/01 12:00:00 [warn] 1234#0: upstream timed out`:        levelWarning,
+		`[Fri Mar 01 12:00:00 2024] [core:crit] [pid 42] AH00001`:      "",
+		`[Fri Mar 01 12:00:00 2024] [crit] [pid 42] AH00001`:           levelCritical,
+		`time="2024-03-01T12:00:00Z" level=info msg="started"`:         levelInfo,
+		`{"level":"Debug","msg":"query"}`:                              levelDebug,
+		`severity: NOTICE something happened`:                          levelInfo,
+		`<11>Mar  1 12:00:00 host sshd[42]: error: kex_exchange`:       levelError,
+		`<190>Mar  1 12:00:00 host nginx: GET / 200`:                   levelInfo,
+		`Mar  1 12:00:00 host sshd[42]: Accepted password for root`:    "",
+		`Mar  1 12:00:00 host kernel: an error occurred, user warned`:  "",
+		`level=verbose msg="not a level we know"`:                      "",
+		`2024-03-01 12:00:00,000 FATAL [main] org.example.App - crash`: levelCritical,
+	} {
+		evt := types.MakeEvent(false, types.LOG, true)
+		evt.Line.Raw = line
+
+		e.apply(&evt)
+
+		level, ok := evt.Meta[logLevelMetaKey]
+		assert.Equal(t, expected, level, line)
+		assert.Equal(t, expected != "", ok, line)
+	}
+}
+
+func TestLogLevelFieldAndRegexp(t *testing.T) {
+	e, err := newLogLevelExtractor("gelf_level", `^\S+ (?P<prefix>\w+)/(?P<level>\w+):`)
+	require.NoError(t, err)
+
+	evt := types.MakeEvent(false, types.LOG, true)
+	evt.Line.Raw = "12:00:00 app/W: low disk space"
+	evt.Meta["gelf_level"] = "3"
+
+	e.apply(&evt)
+	assert.Equal(t, levelError, evt.Meta[logLevelMetaKey])
+
+	// not in the field, the regexp is used
+	evt.Line.Raw = "12:00:00 app/warn: low disk space"
+	evt.Meta["gelf_level"] = ""
+
+	e.apply(&evt)
+	assert.Equal(t, levelWarning, evt.Meta[logLevelMetaKey])
+
+	_, err = newLogLevelExtractor("", `(`)
+	cstest.RequireErrorContains(t, err, "log_level_regexp: error parsing regexp: missing closing ): `(`")
+
+	_, err = newLogLevelExtractor("", `level=\w+`)
+	cstest.RequireErrorContains(t, err, "log_level_regexp: a group must capture the level")
+}
+
+func TestNormalizeLogLevel(t *testing.T) {
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:         "with-level",
+		UniqueId:     "log-level-test-uuid",
+		LogLevelNorm: true,
+	}, configuration.CAT_MODE)
+	require.NoError(t, err)
+
+	input := make(chan types.Event, 2)
+	output := make(chan types.Event, 2)
+
+	input <- types.Event{Line: types.Line{Raw: "WARN disk is almost full"}}
+	input <- types.Event{Line: types.Line{Raw: "disk is almost full"}}
+
+	close(input)
+	rt.forward(input, output, &tomb.Tomb{})
+
+	assert.Equal(t, levelWarning, (<-output).Meta[logLevelMetaKey])
+	assert.NotContains(t, (<-output).Meta, logLevelMetaKey)
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{LogLevelField: "level"}, configuration.CAT_MODE)
+	require.EqualError(t, err, "log_level_field and log_level_regexp require normalize_log_level")
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{Metadata: map[string]string{"log_level": "info"}}, configuration.CAT_MODE)
+	require.EqualError(t, err, "metadata: 'log_level' is a reserved key")
+}
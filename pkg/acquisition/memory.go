@@ -0,0 +1,59 @@
+package acquisition
+
+import (
+	"sync/atomic"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// eventOverhead approximates the memory used by an event besides its strings: the struct,
+// the map headers and the line labels.
+const eventOverhead = 512
+
+// memoryBudget accounts the memory held by the buffers of the acquisition manager, across all
+// the datasources: the reorder buffers (reorder_window, acquisition_merge) and the backlog
+// queues (max_backlog). The buffers internal to the datasources, such as the buffer_size channels or
+// the S3 prefetch, are not accounted.
+// The accounting is done with an estimation of the size of the events (see eventSize) rather
+// than the actual allocations: it bounds the payload that is retained, not the process memory.
+// A buffer checks the budget around adding an event, so the limit can be exceeded by one event
+// per buffer.
+type memoryBudget struct {
+	limit atomic.Int64 // 0 for no limit
+	used  atomic.Int64
+}
+
+// acquisitionMemory is the budget shared by all the datasources, set by max_acquisition_memory.
+var acquisitionMemory = &memoryBudget{}
+
+func (m *memoryBudget) setLimit(limit int64) {
+	m.limit.Store(limit)
+}
+
+func (m *memoryBudget) reserve(size int64) {
+	bufferedBytes.Set(float64(m.used.Add(size)))
+}
+
+func (m *memoryBudget) release(size int64) {
+	bufferedBytes.Set(float64(m.used.Add(-size)))
+}
+
+// exceeded is true when the buffers must release events before taking new ones.
+func (m *memoryBudget) exceeded() bool {
+	limit := m.limit.Load()
+
+	return limit > 0 && m.used.Load() > limit
+}
+
+// eventSize estimates the memory retained by a buffered event.
+func eventSize(evt *types.Event) int64 {
+	size := int64(eventOverhead + len(evt.Line.Raw) + len(evt.Line.Src))
+
+	for _, m := range []map[string]string{evt.Meta, evt.Parsed, evt.Enriched} {
+		for k, v := range m {
+			size += int64(len(k) + len(v))
+		}
+	}
+
+	return size
+}
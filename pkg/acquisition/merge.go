@@ -0,0 +1,87 @@
+package acquisition
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/trace"
+
+	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// mergeDatasource is the datasource label of the metrics of acquisition_merge.
+const mergeDatasource = "acquisition_merge"
+
+// acquisitionMerge is nil unless acquisition_merge is set.
+var acquisitionMerge atomic.Pointer[csconfig.AcquisitionMergeCfg]
+
+// merge sorts the events of all the streaming datasources by timestamp, in a reordering buffer
+// shared by the datasources. An event is emitted once an event more recent by window was
+// received, or once it was held for window, whichever comes first: the order is only restored
+// between the datasources that are less than window apart, and every event is delayed by up to
+// window. The events older than the last emitted one are emitted right away, as late events.
+//
+// When the acquisition stops, the buffered events are emitted and the events sent by the
+// datasources while stopping are not held.
+func merge(input chan types.Event, output chan types.Event, cfg csconfig.AcquisitionMergeCfg, acquisTomb *tomb.Tomb) {
+	defer trace.CatchPanic("crowdsec/acquis/merge")
+
+	buffer := newReorderBuffer(cfg.Window, cfg.MaxEvents)
+	buffer.maxWait = cfg.Window
+	buffer.datasource = mergeDatasource
+
+	ticker := time.NewTicker(max(cfg.Window/10, 10*time.Millisecond))
+	defer ticker.Stop()
+
+	tick := ticker.C
+	dying := acquisTomb.Dying()
+
+	send := func(evts []types.Event) bool {
+		for _, evt := range evts {
+			select {
+			case output <- evt:
+			case <-acquisTomb.Dead():
+				return false
+			}
+		}
+
+		return true
+	}
+
+	for {
+		select {
+		case <-acquisTomb.Dead():
+			return
+		case <-dying:
+			dying = nil
+			tick = nil
+
+			if !send(buffer.flush()) {
+				return
+			}
+		case now := <-tick:
+			if !send(buffer.expire(now)) {
+				return
+			}
+		case evt := <-input:
+			ready := []types.Event{evt}
+
+			if dying != nil {
+				var late bool
+
+				ready, late = buffer.push(evt)
+				if late {
+					lateEvents.With(prometheus.Labels{"datasource": mergeDatasource}).Inc()
+				}
+			}
+
+			if !send(ready) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package acquisition
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tomb "gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestMerge(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	input := make(chan types.Event)
+	output := make(chan types.Event, 10)
+	tmb := tomb.Tomb{}
+	release := make(chan struct{})
+
+	// a datasource still stopping
+	tmb.Go(func() error {
+		<-release
+		return nil
+	})
+
+	go merge(input, output, csconfig.AcquisitionMergeCfg{Window: 200 * time.Millisecond, MaxEvents: 100}, &tmb)
+
+	send := func(offset time.Duration, raw string) {
+		evt := types.Event{}
+		evt.Line.Time = base.Add(offset)
+		evt.Line.Raw = raw
+		input <- evt
+	}
+
+	receive := func() string {
+		select {
+		case evt := <-output:
+			return evt.Line.Raw
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+
+		return ""
+	}
+
+	// two datasources, interleaved out of order
+	send(30*time.Millisecond, "syslog 2")
+	send(10*time.Millisecond, "file 1")
+	send(20*time.Millisecond, "syslog 1")
+	send(40*time.Millisecond, "file 2")
+
+	// held until the end of the window
+	select {
+	case evt := <-output:
+		t.Fatalf("unexpected event %s", evt.Line.Raw)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	got := []string{receive(), receive(), receive(), receive()}
+	assert.Equal(t, []string{"file 1", "syslog 1", "syslog 2", "file 2"}, got)
+
+	// too late to be reordered, emitted right away
+	send(0, "late")
+	assert.Equal(t, "late", receive())
+
+	send(50*time.Millisecond, "buffered")
+
+	// flushed when the acquisition stops
+	tmb.Kill(nil)
+	assert.Equal(t, "buffered", receive())
+
+	close(release)
+	require.NoError(t, tmb.Wait())
+}
@@ -0,0 +1,75 @@
+package acquisition
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Metrics of the acquisition manager, shared by all the datasources.
+
+var lateEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_acquisition_late_events_total",
+		Help: "Total events that arrived after the reordering window and were emitted out of order.",
+	},
+	[]string{"datasource"})
+
+var bufferedBytes = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "cs_acquisition_buffered_bytes",
+		Help: "Estimated memory held by the acquisition buffers, accounted against max_acquisition_memory.",
+	})
+
+var memoryLimitedEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_acquisition_memory_limited_events_total",
+		Help: "Total events released early from a buffer because max_acquisition_memory was reached.",
+	},
+	[]string{"datasource"})
+
+var warmupDiscarded = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_acquisition_warmup_discarded_total",
+		Help: "Total events dropped at the start of a datasource run because of warmup_discard.",
+	},
+	[]string{"datasource"})
+
+var duplicateEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_acquisition_duplicate_events_total",
+		Help: "Total events dropped by acquisition_dedup because the same line was already received.",
+	},
+	[]string{"datasource"})
+
+var ingestLatencySeconds = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "cs_acquisition_ingest_latency_seconds",
+		Help:    "Delay between the timestamp of the logs and their reception, for the datasources with ingest_latency.",
+		Buckets: []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600},
+	},
+	[]string{"datasource"})
+
+var sourceBacklog = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "cs_acquisition_source_backlog",
+		Help: "Events of a datasource with max_backlog that are waiting for the parsers.",
+	},
+	[]string{"datasource"})
+
+var backpressureSeconds = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_acquisition_backpressure_seconds_total",
+		Help: "Time a datasource was blocked because its backlog reached max_backlog.",
+	},
+	[]string{"datasource"})
+
+var redactedMatches = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_acquisition_redactions_total",
+		Help: "Total matches of the redact patterns that were masked in the events.",
+	},
+	[]string{"datasource"})
+
+func managerMetrics() []prometheus.Collector {
+	return []prometheus.Collector{
+		lateEvents, bufferedBytes, memoryLimitedEvents, warmupDiscarded, duplicateEvents, ingestLatencySeconds,
+		sourceBacklog, backpressureSeconds, redactedMatches,
+	}
+}
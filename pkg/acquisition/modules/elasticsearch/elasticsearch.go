@@ -0,0 +1,609 @@
+package elasticsearchacquisition
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/trace"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/elasticsearch/internal/esclient"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/jsonpath"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const (
+	dataSourceName      = "elasticsearch"
+	defaultBatchSize    = 500
+	maxBatchSize        = 10000 // default index.max_result_window
+	defaultPollInterval = 10 * time.Second
+	defaultTimeout      = 30 * time.Second
+	defaultWaitForReady = 10 * time.Second
+	scrollKeepAlive     = time.Minute
+)
+
+var linesRead = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_elasticsearchsource_hits_total",
+		Help: "Total lines that were read from index",
+	},
+	[]string{"index"})
+
+type AuthConfiguration struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	APIKey   string `yaml:"api_key"`
+}
+
+type TLSConfig struct {
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	ClientCert         string `yaml:"client_cert"`
+	ClientKey          string `yaml:"client_key"`
+	CaCert             string `yaml:"ca_cert"`
+}
+
+type ElasticsearchConfiguration struct {
+	URL            string            `yaml:"url"`
+	Index          string            `yaml:"index"`           // index, alias, data stream or pattern (e.g. logs-*), rollovers are transparent
+	Query          string            `yaml:"query"`           // query string (lucene syntax)
+	QueryDSL       string            `yaml:"query_dsl"`       // query in JSON query DSL, exclusive with query
+	TimestampField string            `yaml:"timestamp_field"` // date field used to sort the documents
+	Tiebreaker     string            `yaml:"tiebreaker_field"`
+	MessageField   string            `yaml:"message_field"` // path of the log line in _source
+	MetaFields     map[string]string `yaml:"meta_fields"`   // metadata key -> path in _source
+	BatchSize      int               `yaml:"batch_size"`
+	PollInterval   time.Duration     `yaml:"poll_interval"`
+	Since          time.Duration     `yaml:"since"`
+	Until          time.Duration     `yaml:"until"` // cat mode only: stop at now - until
+	Headers        map[string]string `yaml:"headers"`
+	Auth           AuthConfiguration `yaml:"auth"`
+	TLS            *TLSConfig        `yaml:"tls"`
+	Timeout        time.Duration     `yaml:"timeout"`
+	WaitForReady   time.Duration     `yaml:"wait_for_ready"`
+
+	configuration.DataSourceCommonCfg `yaml:",inline"`
+}
+
+type ElasticsearchSource struct {
+	metricsLevel int
+	Config       ElasticsearchConfiguration
+
+	Client *esclient.ESClient
+
+	logger     *log.Entry
+	query      json.RawMessage
+	message    *jsonpath.Path
+	metaFields map[string]*jsonpath.Path
+}
+
+func (e *ElasticsearchSource) GetUuid() string {
+	return e.Config.UniqueId
+}
+
+func (e *ElasticsearchSource) UnmarshalConfig(yamlConfig []byte) error {
+	e.Config = ElasticsearchConfiguration{}
+
+	err := yaml.UnmarshalWithOptions(yamlConfig, &e.Config, yaml.Strict())
+	if err != nil {
+		return fmt.Errorf("cannot parse %s datasource configuration: %s", dataSourceName, yaml.FormatError(err, false, false))
+	}
+
+	if e.Config.Mode == "" {
+		e.Config.Mode = configuration.TAIL_MODE
+	}
+
+	return e.validate()
+}
+
+func (e *ElasticsearchSource) validate() error {
+	if e.Config.URL == "" {
+		return errors.New("url is mandatory")
+	}
+
+	if e.Config.Index == "" {
+		return errors.New("index is mandatory")
+	}
+
+	if e.Config.Mode != configuration.TAIL_MODE && e.Config.Mode != configuration.CAT_MODE {
+		return fmt.Errorf("unsupported mode %s for %s datasource", e.Config.Mode, dataSourceName)
+	}
+
+	if e.Config.Auth.APIKey != "" && (e.Config.Auth.Username != "" || e.Config.Auth.Password != "") {
+		return errors.New("auth: api_key and username/password are mutually exclusive")
+	}
+
+	switch {
+	case e.Config.Query != "" && e.Config.QueryDSL != "":
+		return errors.New("query and query_dsl are mutually exclusive")
+	case e.Config.Query != "":
+		e.query, _ = json.Marshal(map[string]any{"query_string": map[string]any{"query": e.Config.Query}})
+	case e.Config.QueryDSL != "":
+		if !json.Valid([]byte(e.Config.QueryDSL)) {
+			return errors.New("query_dsl is not valid JSON")
+		}
+
+		e.query = json.RawMessage(e.Config.QueryDSL)
+	}
+
+	if e.Config.TimestampField == "" {
+		e.Config.TimestampField = "@timestamp"
+	}
+
+	if e.Config.MessageField == "" {
+		e.Config.MessageField = "message"
+	}
+
+	var err error
+
+	if e.message, err = jsonpath.Compile(e.Config.MessageField); err != nil {
+		return fmt.Errorf("message_field: %w", err)
+	}
+
+	e.metaFields = make(map[string]*jsonpath.Path, len(e.Config.MetaFields))
+
+	for key, path := range e.Config.MetaFields {
+		if e.metaFields[key], err = jsonpath.Compile(path); err != nil {
+			return fmt.Errorf("meta_fields.%s: %w", key, err)
+		}
+	}
+
+	if e.Config.BatchSize == 0 {
+		e.Config.BatchSize = defaultBatchSize
+	}
+
+	if e.Config.BatchSize < 0 || e.Config.BatchSize > maxBatchSize {
+		return fmt.Errorf("batch_size must be between 1 and %d", maxBatchSize)
+	}
+
+	if e.Config.PollInterval == 0 {
+		e.Config.PollInterval = defaultPollInterval
+	}
+
+	if e.Config.PollInterval < 0 || e.Config.Since < 0 || e.Config.Until < 0 {
+		return errors.New("poll_interval, since and until must be positive")
+	}
+
+	if e.Config.Until > 0 && e.Config.Mode != configuration.CAT_MODE {
+		return errors.New("until is only supported in cat mode")
+	}
+
+	if e.Config.Since > 0 && e.Config.Until >= e.Config.Since {
+		return errors.New("until must be lower than since")
+	}
+
+	if e.Config.Timeout == 0 {
+		e.Config.Timeout = defaultTimeout
+	}
+
+	if e.Config.WaitForReady == 0 {
+		e.Config.WaitForReady = defaultWaitForReady
+	}
+
+	return nil
+}
+
+func (c *TLSConfig) newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("while loading client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CaCert != "" {
+		caCert, err := os.ReadFile(c.CaCert)
+		if err != nil {
+			return nil, fmt.Errorf("while reading CA certificate: %w", err)
+		}
+
+		caCertPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load system CA certificates: %w", err)
+		}
+
+		if caCertPool == nil {
+			caCertPool = x509.NewCertPool()
+		}
+
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no valid certificate found in %s", c.CaCert)
+		}
+
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	return tlsConfig, nil
+}
+
+func (e *ElasticsearchSource) newClient() error {
+	clientConfig := esclient.Config{
+		URL:      e.Config.URL,
+		Index:    e.Config.Index,
+		Headers:  e.Config.Headers,
+		Username: e.Config.Auth.Username,
+		Password: e.Config.Auth.Password,
+		APIKey:   e.Config.Auth.APIKey,
+		Timeout:  e.Config.Timeout,
+	}
+
+	if e.Config.TLS != nil {
+		tlsConfig, err := e.Config.TLS.newTLSConfig()
+		if err != nil {
+			return err
+		}
+
+		clientConfig.TLS = tlsConfig
+	}
+
+	e.Client = esclient.NewESClient(clientConfig)
+	e.Client.Logger = e.logger.WithFields(log.Fields{"component": "elasticsearch-client", "source": e.Config.URL})
+
+	return nil
+}
+
+func (e *ElasticsearchSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLevel int) error {
+	e.logger = logger
+	e.metricsLevel = metricsLevel
+
+	err := e.UnmarshalConfig(yamlConfig)
+	if err != nil {
+		return err
+	}
+
+	return e.newClient()
+}
+
+// ConfigureByDSN handles elasticsearch://[user:password@]host:port/index?query=...&since=1h
+func (e *ElasticsearchSource) ConfigureByDSN(dsn string, labels map[string]string, logger *log.Entry, uuid string) error {
+	e.logger = logger
+	e.Config = ElasticsearchConfiguration{}
+	e.Config.Mode = configuration.CAT_MODE
+	e.Config.Labels = labels
+	e.Config.UniqueId = uuid
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return fmt.Errorf("while parsing dsn '%s': %w", dsn, err)
+	}
+
+	if u.Scheme != dataSourceName {
+		return fmt.Errorf("invalid DSN %s for %s source, must start with %s://", dsn, dataSourceName, dataSourceName)
+	}
+
+	if u.Host == "" {
+		return errors.New("empty host")
+	}
+
+	e.Config.Index = u.Path
+	if len(e.Config.Index) > 0 && e.Config.Index[0] == '/' {
+		e.Config.Index = e.Config.Index[1:]
+	}
+
+	scheme := "http"
+
+	params := u.Query()
+	if params.Get("ssl") != "" {
+		scheme = "https"
+	}
+
+	e.Config.URL = fmt.Sprintf("%s://%s", scheme, u.Host)
+
+	if u.User != nil {
+		e.Config.Auth.Username = u.User.Username()
+		e.Config.Auth.Password, _ = u.User.Password()
+	}
+
+	e.Config.Query = params.Get("query")
+	e.Config.TimestampField = params.Get("timestamp_field")
+	e.Config.MessageField = params.Get("message_field")
+
+	for param, value := range map[string]*time.Duration{"since": &e.Config.Since, "until": &e.Config.Until} {
+		if v := params.Get(param); v != "" {
+			if *value, err = time.ParseDuration(v); err != nil {
+				return fmt.Errorf("invalid %s in dsn: %w", param, err)
+			}
+		}
+	}
+
+	if batchSize := params.Get("batch_size"); batchSize != "" {
+		if e.Config.BatchSize, err = strconv.Atoi(batchSize); err != nil {
+			return fmt.Errorf("invalid batch_size in dsn: %w", err)
+		}
+	}
+
+	if logLevel := params.Get("log_level"); logLevel != "" {
+		level, err := log.ParseLevel(logLevel)
+		if err != nil {
+			return fmt.Errorf("invalid log_level in dsn: %w", err)
+		}
+
+		e.Config.LogLevel = &level
+		e.logger.Logger.SetLevel(level)
+	}
+
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	return e.newClient()
+}
+
+func (e *ElasticsearchSource) GetMode() string {
+	return e.Config.Mode
+}
+
+func (*ElasticsearchSource) GetName() string {
+	return dataSourceName
+}
+
+func (*ElasticsearchSource) CanRun() error {
+	return nil
+}
+
+func (*ElasticsearchSource) GetMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead}
+}
+
+func (*ElasticsearchSource) GetAggregMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead}
+}
+
+func (e *ElasticsearchSource) Dump() any {
+	return e
+}
+
+func (e *ElasticsearchSource) waitForReady(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, e.Config.WaitForReady)
+	defer cancel()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		err := e.Client.Ready(ctx)
+		if err == nil {
+			return nil
+		}
+
+		e.logger.Debugf("%s is not ready: %s", e.Config.URL, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s is not ready: %w", e.Config.URL, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *ElasticsearchSource) searchRequest(since time.Time, until time.Time) esclient.SearchRequest {
+	return esclient.SearchRequest{
+		Query:          e.query,
+		TimestampField: e.Config.TimestampField,
+		Tiebreaker:     e.Config.Tiebreaker,
+		Since:          since,
+		Until:          until,
+		Size:           e.Config.BatchSize,
+	}
+}
+
+func (e *ElasticsearchSource) emit(hit esclient.Hit, out chan types.Event) {
+	var doc any
+
+	if err := json.Unmarshal(hit.Source, &doc); err != nil {
+		e.logger.Warnf("cannot decode document %s/%s: %s", hit.Index, hit.ID, err)
+		return
+	}
+
+	message, ok := e.message.Lookup(doc)
+	if !ok {
+		// send the whole document, the parsers may handle it
+		message = string(hit.Source)
+	}
+
+	l := types.Line{
+		Raw:     message,
+		Labels:  e.Config.Labels,
+		Time:    hitTime(hit),
+		Src:     hit.Index,
+		Process: true,
+		Module:  e.GetName(),
+	}
+
+	if e.metricsLevel != configuration.METRICS_NONE {
+		linesRead.With(prometheus.Labels{"index": hit.Index}).Inc()
+	}
+
+	evt := types.MakeEvent(e.Config.UseTimeMachine, types.LOG, true)
+	evt.Line = l
+	evt.Meta["es_index"] = hit.Index
+
+	for key, path := range e.metaFields {
+		value, _ := path.Lookup(doc)
+		evt.Meta[key] = value
+	}
+
+	out <- evt
+}
+
+// hitTime returns the timestamp of the document, from its first sort value (epoch milliseconds).
+func hitTime(hit esclient.Hit) time.Time {
+	if len(hit.Sort) > 0 {
+		if n, ok := hit.Sort[0].(json.Number); ok {
+			if ms, err := n.Int64(); err == nil {
+				return time.UnixMilli(ms).UTC()
+			}
+		}
+	}
+
+	return time.Now().UTC()
+}
+
+// OneShotAcquisition reads the documents of the time range with a scroll, and returns when done.
+func (e *ElasticsearchSource) OneShotAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	if err := e.waitForReady(ctx); err != nil {
+		return err
+	}
+
+	var since, until time.Time
+
+	now := time.Now()
+	if e.Config.Since > 0 {
+		since = now.Add(-e.Config.Since)
+	}
+
+	if e.Config.Until > 0 {
+		until = now.Add(-e.Config.Until)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-t.Dying():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	resp, err := e.Client.Search(ctx, e.searchRequest(since, until), scrollKeepAlive)
+	if err != nil {
+		return fmt.Errorf("while searching %s: %w", e.Config.Index, err)
+	}
+
+	scrollID := resp.ScrollID
+
+	defer func() {
+		if scrollID == "" {
+			return
+		}
+
+		clearCtx, clearCancel := context.WithTimeout(context.Background(), e.Config.Timeout)
+		defer clearCancel()
+
+		if err := e.Client.ClearScroll(clearCtx, scrollID); err != nil { //nolint:contextcheck
+			e.logger.Warnf("while clearing scroll: %s", err)
+		}
+	}()
+
+	count := 0
+
+	for len(resp.Hits.Hits) > 0 {
+		for _, hit := range resp.Hits.Hits {
+			e.emit(hit, out)
+		}
+
+		count += len(resp.Hits.Hits)
+
+		resp, err = e.Client.Scroll(ctx, scrollID, scrollKeepAlive)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("while scrolling %s: %w", e.Config.Index, err)
+		}
+
+		if resp.ScrollID != "" {
+			scrollID = resp.ScrollID
+		}
+	}
+
+	e.logger.Infof("%s acquisition done, read %d documents", dataSourceName, count)
+
+	return nil
+}
+
+// poll reads the new documents after the cursor, page by page, and returns the updated cursor.
+func (e *ElasticsearchSource) poll(ctx context.Context, since time.Time, cursor []any, out chan types.Event) ([]any, error) {
+	for {
+		req := e.searchRequest(since, time.Time{})
+		req.SearchAfter = cursor
+
+		resp, err := e.Client.Search(ctx, req, 0)
+		if err != nil {
+			return cursor, err
+		}
+
+		hits := resp.Hits.Hits
+
+		for _, hit := range hits {
+			e.emit(hit, out)
+		}
+
+		if len(hits) > 0 {
+			cursor = hits[len(hits)-1].Sort
+		}
+
+		if len(hits) < e.Config.BatchSize {
+			return cursor, nil
+		}
+	}
+}
+
+// StreamingAcquisition polls the index for new documents, with a search_after cursor.
+func (e *ElasticsearchSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	if err := e.waitForReady(ctx); err != nil {
+		return err
+	}
+
+	since := time.Now().Add(-e.Config.Since)
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	t.Go(func() error {
+		defer trace.CatchPanic("crowdsec/acquis/elasticsearch/live")
+		defer cancel()
+
+		var (
+			cursor []any
+			err    error
+		)
+
+		ticker := time.NewTicker(e.Config.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			cursor, err = e.poll(ctx, since, cursor, out)
+			if err != nil {
+				var esErr *esclient.Error
+
+				switch {
+				case ctx.Err() != nil:
+				case errors.As(err, &esErr) && esErr.IndexNotFound():
+					e.logger.Debugf("index %s not found, waiting for it to be created", e.Config.Index)
+				default:
+					e.logger.Errorf("while polling %s: %s", e.Config.Index, err)
+				}
+			}
+
+			select {
+			case <-t.Dying():
+				e.logger.Infof("%s datasource stopping", dataSourceName)
+				return nil
+			case <-ticker.C:
+			}
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package esclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/crowdsecurity/crowdsec/pkg/apiclient/useragent"
+)
+
+type Config struct {
+	URL     string
+	Index   string
+	Headers map[string]string
+
+	Username string
+	Password string
+	APIKey   string
+
+	TLS     *tls.Config
+	Timeout time.Duration
+}
+
+type ESClient struct {
+	Logger *log.Entry
+
+	config         Config
+	requestHeaders map[string]string
+	client         *http.Client
+}
+
+// Hit is a document returned by a search.
+type Hit struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Source json.RawMessage `json:"_source"`
+	Sort   []any           `json:"sort"`
+}
+
+type SearchResponse struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []Hit `json:"hits"`
+	} `json:"hits"`
+}
+
+// SearchRequest describes a search sorted by timestamp.
+type SearchRequest struct {
+	Query          json.RawMessage // query DSL, match_all if empty
+	TimestampField string
+	Tiebreaker     string // optional unique field, to sort documents sharing a timestamp
+	Since          time.Time
+	Until          time.Time
+	SearchAfter    []any
+	Size           int
+}
+
+func (r SearchRequest) body() ([]byte, error) {
+	filters := []any{}
+
+	if len(r.Query) > 0 {
+		filters = append(filters, r.Query)
+	}
+
+	if !r.Since.IsZero() || !r.Until.IsZero() {
+		timeRange := map[string]any{"format": "strict_date_optional_time"}
+
+		if !r.Since.IsZero() {
+			timeRange["gte"] = r.Since.UTC().Format(time.RFC3339Nano)
+		}
+
+		if !r.Until.IsZero() {
+			timeRange["lte"] = r.Until.UTC().Format(time.RFC3339Nano)
+		}
+
+		filters = append(filters, map[string]any{"range": map[string]any{r.TimestampField: timeRange}})
+	}
+
+	sort := []any{map[string]any{r.TimestampField: map[string]any{"order": "asc", "numeric_type": "date"}}}
+	if r.Tiebreaker != "" {
+		sort = append(sort, map[string]any{r.Tiebreaker: "asc"})
+	}
+
+	body := map[string]any{
+		"size":  r.Size,
+		"sort":  sort,
+		"query": map[string]any{"bool": map[string]any{"filter": filters}},
+	}
+
+	if len(r.SearchAfter) > 0 {
+		body["search_after"] = r.SearchAfter
+	}
+
+	return json.Marshal(body)
+}
+
+// Error is returned when Elasticsearch answers with an unexpected status code.
+type Error struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("bad HTTP response code: %d: %s", e.StatusCode, e.Body)
+}
+
+// IndexNotFound is true if the index (or index pattern) does not exist (yet).
+func (e *Error) IndexNotFound() bool {
+	return e.StatusCode == http.StatusNotFound && strings.Contains(e.Body, "index_not_found_exception")
+}
+
+func (c *ESClient) do(ctx context.Context, method string, path []string, params url.Values, body []byte, v any) error {
+	u, err := url.Parse(c.config.URL)
+	if err != nil {
+		return err
+	}
+
+	u = u.JoinPath(path...)
+	u.RawQuery = params.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range c.requestHeaders {
+		request.Header.Set(k, v)
+	}
+
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	c.Logger.Debugf("%s %s", method, u.String())
+	c.Logger.Tracef("request body: %s", string(body))
+
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &Error{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	if v == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	// keep the sort values as-is, they are sent back in search_after
+	decoder.UseNumber()
+
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("cannot decode response: %w", err)
+	}
+
+	return nil
+}
+
+// Ready checks that the cluster answers.
+func (c *ESClient) Ready(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, nil, nil, nil, nil)
+}
+
+func searchParams() url.Values {
+	params := url.Values{}
+	// an index pattern or alias may match no index before the first one is created, or between rollovers
+	params.Set("ignore_unavailable", "true")
+	params.Set("allow_no_indices", "true")
+
+	return params
+}
+
+// Search runs a search on the configured index. If keepAlive is not zero, a scroll is opened.
+func (c *ESClient) Search(ctx context.Context, req SearchRequest, keepAlive time.Duration) (*SearchResponse, error) {
+	body, err := req.body()
+	if err != nil {
+		return nil, err
+	}
+
+	params := searchParams()
+	if keepAlive > 0 {
+		params.Set("scroll", keepAlive.String())
+	}
+
+	resp := &SearchResponse{}
+	if err := c.do(ctx, http.MethodPost, []string{c.config.Index, "_search"}, params, body, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Scroll returns the next page of a scroll opened by Search.
+func (c *ESClient) Scroll(ctx context.Context, scrollID string, keepAlive time.Duration) (*SearchResponse, error) {
+	body, err := json.Marshal(map[string]string{"scroll": keepAlive.String(), "scroll_id": scrollID})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &SearchResponse{}
+	if err := c.do(ctx, http.MethodPost, []string{"_search", "scroll"}, nil, body, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// ClearScroll releases the resources of a scroll.
+func (c *ESClient) ClearScroll(ctx context.Context, scrollID string) error {
+	body, err := json.Marshal(map[string][]string{"scroll_id": {scrollID}})
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodDelete, []string{"_search", "scroll"}, nil, body, nil)
+}
+
+func NewESClient(config Config) *ESClient {
+	headers := make(map[string]string)
+	maps.Copy(headers, config.Headers)
+
+	switch {
+	case config.APIKey != "":
+		headers["Authorization"] = "ApiKey " + config.APIKey
+	case config.Username != "" || config.Password != "":
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(config.Username+":"+config.Password))
+	}
+
+	headers["User-Agent"] = useragent.Default()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.TLS != nil {
+		transport.TLSClientConfig = config.TLS
+	}
+
+	return &ESClient{
+		Logger:         log.WithField("component", "elasticsearch-client"),
+		config:         config,
+		requestHeaders: headers,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   config.Timeout,
+		},
+	}
+}
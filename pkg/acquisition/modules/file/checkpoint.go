@@ -0,0 +1,114 @@
+package fileacquisition
+
+import (
+	"slices"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/statefile"
+)
+
+// the checkpoint is saved every checkpointInterval lines, and when a file is completed
+const checkpointInterval = 1000
+
+// checkpointState is saved in checkpoint_path while the files are read in cat mode.
+type checkpointState struct {
+	Completed []string `json:"completed"`
+	Current   string   `json:"current,omitempty"`
+	Lines     int64    `json:"lines,omitempty"` // lines of the current file that were sent to the parsers
+}
+
+// checkpoint records the progress of a cat run, so that a run that was interrupted can be
+// resumed: the completed files are skipped, and the lines that were already read in the
+// interrupted one. The methods are no-ops on a nil checkpoint, without checkpoint_path.
+type checkpoint struct {
+	file   *statefile.File
+	state  checkpointState
+	logger *log.Entry
+}
+
+func newCheckpoint(path string, logger *log.Entry) *checkpoint {
+	if path == "" {
+		return nil
+	}
+
+	return &checkpoint{
+		file:   statefile.New(path, statefile.Config{}, logger),
+		logger: logger,
+	}
+}
+
+func (c *checkpoint) load() {
+	if c == nil {
+		return
+	}
+
+	found, err := c.file.Load(&c.state)
+	if err != nil {
+		c.logger.Warnf("while loading checkpoint from %s: %s", c.file.Path(), err)
+		return
+	}
+
+	if found {
+		c.logger.Infof("resuming from checkpoint %s: %d files completed", c.file.Path(), len(c.state.Completed))
+	}
+}
+
+func (c *checkpoint) isCompleted(filename string) bool {
+	return c != nil && slices.Contains(c.state.Completed, filename)
+}
+
+// resumeAt returns the number of lines of the file that were already read.
+func (c *checkpoint) resumeAt(filename string) int64 {
+	if c == nil || c.state.Current != filename {
+		return 0
+	}
+
+	return c.state.Lines
+}
+
+// progress is called after each line of the file is read.
+func (c *checkpoint) progress(filename string, lines int64) {
+	if c == nil {
+		return
+	}
+
+	c.state.Current = filename
+	c.state.Lines = lines
+
+	if lines%checkpointInterval == 0 {
+		c.save()
+	}
+}
+
+func (c *checkpoint) complete(filename string) {
+	if c == nil {
+		return
+	}
+
+	c.state.Completed = append(c.state.Completed, filename)
+	c.state.Current = ""
+	c.state.Lines = 0
+	c.save()
+}
+
+func (c *checkpoint) save() {
+	if c == nil {
+		return
+	}
+
+	if err := c.file.Save(c.state); err != nil {
+		c.logger.Errorf("while saving checkpoint to %s: %s", c.file.Path(), err)
+	}
+}
+
+// clear removes the checkpoint once all the files were read.
+func (c *checkpoint) clear() {
+	if c == nil {
+		return
+	}
+
+	if err := c.file.Remove(); err != nil {
+		c.logger.Errorf("while removing checkpoint %s: %s", c.file.Path(), err)
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/crowdsecurity/go-cs-lib/trace"
 
 	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/jsonpath"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
 
@@ -39,6 +40,13 @@ var linesRead = prometheus.NewCounterVec(
 	},
 	[]string{"source"})
 
+var freshFiles = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_filesource_fresh_files_total",
+		Help: "Total files that were still being written, their reading was delayed by min_file_age.",
+	},
+	[]string{"source"})
+
 type FileConfiguration struct {
 	Filenames                         []string
 	ExcludeRegexps                    []string `yaml:"exclude_regexps"`
@@ -48,6 +56,10 @@ type FileConfiguration struct {
 	PollWithoutInotify                *bool         `yaml:"poll_without_inotify"`
 	DiscoveryPollEnable               bool          `yaml:"discovery_poll_enable"`
 	DiscoveryPollInterval             time.Duration `yaml:"discovery_poll_interval"`
+	PartialLineTimeout                time.Duration `yaml:"partial_line_timeout"` // tail mode only, 0 to emit partial lines right away
+	MinFileAge                        time.Duration `yaml:"min_file_age"`         // cat mode only: wait until the files were not modified for this long
+	CheckpointPath                    string        `yaml:"checkpoint_path"`      // cat mode only: record the progress to resume an interrupted run
+	jsonpath.Config                   `yaml:",inline"`
 	configuration.DataSourceCommonCfg `yaml:",inline"`
 }
 
@@ -61,6 +73,7 @@ type FileSource struct {
 	files              []string
 	exclude_regexps    []*regexp.Regexp
 	tailMapMutex       *sync.RWMutex
+	jsonExtractor      *jsonpath.Extractor
 }
 
 func (f *FileSource) GetUuid() string {
@@ -95,6 +108,22 @@ func (f *FileSource) UnmarshalConfig(yamlConfig []byte) error {
 		return fmt.Errorf("unsupported mode %s for file source", f.config.Mode)
 	}
 
+	if f.config.PartialLineTimeout < 0 {
+		return errors.New("partial_line_timeout must be positive")
+	}
+
+	if f.config.MinFileAge < 0 {
+		return errors.New("min_file_age must be positive")
+	}
+
+	if f.config.MinFileAge > 0 && f.config.Mode != configuration.CAT_MODE {
+		return errors.New("min_file_age is only supported in cat mode")
+	}
+
+	if f.config.CheckpointPath != "" && f.config.Mode != configuration.CAT_MODE {
+		return errors.New("checkpoint_path is only supported in cat mode")
+	}
+
 	for _, exclude := range f.config.ExcludeRegexps {
 		re, err := regexp.Compile(exclude)
 		if err != nil {
@@ -104,6 +133,11 @@ func (f *FileSource) UnmarshalConfig(yamlConfig []byte) error {
 		f.exclude_regexps = append(f.exclude_regexps, re)
 	}
 
+	f.jsonExtractor, err = jsonpath.NewExtractor(f.config.Config, f.GetName())
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -229,6 +263,27 @@ func (f *FileSource) ConfigureByDSN(dsn string, labels map[string]string, logger
 				}
 
 				f.config.MaxBufferSize = maxBufferSize
+			case "min_file_age":
+				if len(value) != 1 {
+					return errors.New("expected zero or one value for 'min_file_age'")
+				}
+
+				minFileAge, err := time.ParseDuration(value[0])
+				if err != nil {
+					return fmt.Errorf("could not parse min_file_age %s: %w", value[0], err)
+				}
+
+				if minFileAge < 0 {
+					return errors.New("min_file_age must be positive")
+				}
+
+				f.config.MinFileAge = minFileAge
+			case "checkpoint_path":
+				if len(value) != 1 {
+					return errors.New("expected zero or one value for 'checkpoint_path'")
+				}
+
+				f.config.CheckpointPath = value[0]
 			default:
 				return fmt.Errorf("unknown parameter %s", key)
 			}
@@ -275,7 +330,15 @@ func (f *FileSource) SupportedModes() []string {
 func (f *FileSource) OneShotAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
 	f.logger.Debug("In oneshot")
 
+	checkpoint := newCheckpoint(f.config.CheckpointPath, f.logger)
+	checkpoint.load()
+
 	for _, file := range f.files {
+		if checkpoint.isCompleted(file) {
+			f.logger.Infof("skipping %s, completed in a previous run", file)
+			continue
+		}
+
 		fi, err := os.Stat(file)
 		if err != nil {
 			return fmt.Errorf("could not stat file %s : %w", file, err)
@@ -286,23 +349,75 @@ func (f *FileSource) OneShotAcquisition(ctx context.Context, out chan types.Even
 			continue
 		}
 
+		complete, err := f.waitForFileAge(file, fi, t)
+		if err != nil {
+			return err
+		}
+
+		if !complete {
+			return nil
+		}
+
 		f.logger.Infof("reading %s at once", file)
 
-		err = f.readFile(file, out, t)
+		complete, err = f.readFile(ctx, file, checkpoint, out, t)
 		if err != nil {
+			checkpoint.save()
 			return err
 		}
+
+		if !complete {
+			checkpoint.save()
+			return nil
+		}
+
+		checkpoint.complete(file)
 	}
 
+	checkpoint.clear()
+	t.Kill(nil)
+
 	return nil
 }
 
+// waitForFileAge waits until the file was not modified for min_file_age, so that a file that
+// is still being written is not read partially. It returns false if the acquisition is over.
+func (f *FileSource) waitForFileAge(filename string, fi os.FileInfo, t *tomb.Tomb) (bool, error) {
+	wait := f.config.MinFileAge - time.Since(fi.ModTime())
+	if f.config.MinFileAge == 0 || wait <= 0 {
+		return true, nil
+	}
+
+	f.logger.Infof("%s was modified less than %s ago, waiting for it to be complete", filename, f.config.MinFileAge)
+
+	if f.metricsLevel != configuration.METRICS_NONE {
+		freshFiles.With(prometheus.Labels{"source": filename}).Inc()
+	}
+
+	for wait > 0 {
+		select {
+		case <-t.Dying():
+			return false, nil
+		case <-time.After(wait):
+		}
+
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return false, fmt.Errorf("could not stat file %s : %w", filename, err)
+		}
+
+		wait = f.config.MinFileAge - time.Since(fi.ModTime())
+	}
+
+	return true, nil
+}
+
 func (f *FileSource) GetMetrics() []prometheus.Collector {
-	return []prometheus.Collector{linesRead}
+	return []prometheus.Collector{linesRead, freshFiles, jsonpath.MissingFields}
 }
 
 func (f *FileSource) GetAggregMetrics() []prometheus.Collector {
-	return []prometheus.Collector{linesRead}
+	return []prometheus.Collector{linesRead, freshFiles, jsonpath.MissingFields}
 }
 
 func (f *FileSource) GetName() string {
@@ -508,6 +623,13 @@ func (f *FileSource) setupTailForFile(file string, out chan types.Event, seekEnd
 		seekInfo.Whence = io.SeekEnd
 	}
 
+	// the size can change before the tailer opens the file, partial_line_timeout only
+	// relies on it to tell if the first line read is complete
+	start := int64(0)
+	if seekInfo.Whence == io.SeekEnd {
+		start = fi.Size()
+	}
+
 	tail, err := tail.TailFile(file, tail.Config{
 		ReOpen:   true,
 		Follow:   true,
@@ -525,20 +647,48 @@ func (f *FileSource) setupTailForFile(file string, out chan types.Event, seekEnd
 
 	t.Go(func() error {
 		defer trace.CatchPanic("crowdsec/acquis/tailfile")
-		return f.tailFile(out, t, tail)
+		return f.tailFile(out, t, tail, start)
 	})
 
 	return nil
 }
 
-func (f *FileSource) tailFile(out chan types.Event, t *tomb.Tomb, tail *tail.Tail) error {
+// tailFile reads the lines sent by the tailer. start is the offset where the tailer starts reading.
+func (f *FileSource) tailFile(out chan types.Event, t *tomb.Tomb, tail *tail.Tail, start int64) error {
 	logger := f.logger.WithField("tail", tail.Filename)
 	logger.Debug("-> start tailing")
 
+	var (
+		joiner     *lineJoiner
+		flushTimer *time.Timer
+		flushWait  <-chan time.Time
+	)
+
+	if f.config.PartialLineTimeout > 0 {
+		joiner = newLineJoiner(start)
+
+		flushTimer = time.NewTimer(f.config.PartialLineTimeout)
+		flushTimer.Stop()
+
+		defer flushTimer.Stop()
+	}
+
+	// emits what is left of a partial line before leaving
+	flush := func() {
+		if joiner == nil {
+			return
+		}
+
+		if line := joiner.flush(); line != nil {
+			f.sendTailLine(line, tail.Filename, logger, out)
+		}
+	}
+
 	for {
 		select {
 		case <-t.Dying():
 			logger.Info("File datasource stopping")
+			flush()
 
 			if err := tail.Stop(); err != nil {
 				f.logger.Errorf("error in stop : %s", err)
@@ -555,6 +705,7 @@ func (f *FileSource) tailFile(out chan types.Event, t *tomb.Tomb, tail *tail.Tai
 			}
 
 			logger.Warning(errMsg)
+			flush()
 
 			// Just remove the dead tailer from our map and return
 			// monitorNewFiles will pick up the file again if it's recreated
@@ -574,45 +725,74 @@ func (f *FileSource) tailFile(out chan types.Event, t *tomb.Tomb, tail *tail.Tai
 				return line.Err
 			}
 
-			if line.Text == "" { // skip empty lines
+			if joiner == nil {
+				f.sendTailLine(line, tail.Filename, logger, out)
 				continue
 			}
 
-			if f.metricsLevel != configuration.METRICS_NONE {
-				linesRead.With(prometheus.Labels{"source": tail.Filename}).Inc()
+			for _, l := range joiner.add(line) {
+				f.sendTailLine(l, tail.Filename, logger, out)
 			}
 
-			src := tail.Filename
-			if f.metricsLevel == configuration.METRICS_AGGREGATE {
-				src = filepath.Base(tail.Filename)
-			}
+			// the timeout starts over with each part of the line
+			flushWait = nil
 
-			l := types.Line{
-				Raw:     trimLine(line.Text),
-				Labels:  f.config.Labels,
-				Time:    line.Time,
-				Src:     src,
-				Process: true,
-				Module:  f.GetName(),
+			if joiner.pending != nil {
+				flushTimer.Reset(f.config.PartialLineTimeout)
+				flushWait = flushTimer.C
 			}
-			// we're tailing, it must be real time logs
-			logger.Debugf("pushing %+v", l)
+		case <-flushWait:
+			flushWait = nil
 
-			evt := types.MakeEvent(f.config.UseTimeMachine, types.LOG, true)
-			evt.Line = l
-			out <- evt
+			if line := joiner.flush(); line != nil {
+				logger.Debugf("no newline after %s, emitting partial line", f.config.PartialLineTimeout)
+				f.sendTailLine(line, tail.Filename, logger, out)
+			}
 		}
 	}
 }
 
-func (f *FileSource) readFile(filename string, out chan types.Event, t *tomb.Tomb) error {
+func (f *FileSource) sendTailLine(line *tail.Line, filename string, logger *log.Entry, out chan types.Event) {
+	if line.Text == "" { // skip empty lines
+		return
+	}
+
+	if f.metricsLevel != configuration.METRICS_NONE {
+		linesRead.With(prometheus.Labels{"source": filename}).Inc()
+	}
+
+	src := filename
+	if f.metricsLevel == configuration.METRICS_AGGREGATE {
+		src = filepath.Base(filename)
+	}
+
+	l := types.Line{
+		Raw:     trimLine(line.Text),
+		Labels:  f.config.Labels,
+		Time:    line.Time,
+		Src:     src,
+		Process: true,
+		Module:  f.GetName(),
+	}
+	// we're tailing, it must be real time logs
+	logger.Debugf("pushing %+v", l)
+
+	evt := types.MakeEvent(f.config.UseTimeMachine, types.LOG, true)
+	evt.Line = l
+	f.jsonExtractor.Apply(&evt)
+	out <- evt
+}
+
+// readFile sends the lines of a file, after the ones that were read before the checkpoint. It
+// returns false if the acquisition is stopped before the end of the file.
+func (f *FileSource) readFile(ctx context.Context, filename string, checkpoint *checkpoint, out chan types.Event, t *tomb.Tomb) (bool, error) {
 	var scanner *bufio.Scanner
 
 	logger := f.logger.WithField("oneshot", filename)
 
 	fd, err := os.Open(filename)
 	if err != nil {
-		return fmt.Errorf("failed opening %s: %w", filename, err)
+		return false, fmt.Errorf("failed opening %s: %w", filename, err)
 	}
 
 	defer fd.Close()
@@ -621,7 +801,7 @@ func (f *FileSource) readFile(filename string, out chan types.Event, t *tomb.Tom
 		gz, err := gzip.NewReader(fd)
 		if err != nil {
 			logger.Errorf("Failed to read gz file: %s", err)
-			return fmt.Errorf("failed to read gz %s: %w", filename, err)
+			return false, fmt.Errorf("failed to read gz %s: %w", filename, err)
 		}
 
 		defer gz.Close()
@@ -637,12 +817,29 @@ func (f *FileSource) readFile(filename string, out chan types.Event, t *tomb.Tom
 		scanner.Buffer(buf, f.config.MaxBufferSize)
 	}
 
+	skip := checkpoint.resumeAt(filename)
+	if skip > 0 {
+		logger.Infof("resuming after line %d", skip)
+	}
+
+	lines := int64(0)
+
 	for scanner.Scan() {
 		select {
 		case <-t.Dying():
 			logger.Info("File datasource stopping")
-			return nil
+			return false, nil
+		case <-ctx.Done():
+			// stopped by the acquisition manager, with max_events
+			logger.Info("File datasource stopping")
+			return false, nil
 		default:
+			lines++
+
+			if lines <= skip {
+				continue
+			}
+
 			if scanner.Text() == "" {
 				continue
 			}
@@ -659,7 +856,11 @@ func (f *FileSource) readFile(filename string, out chan types.Event, t *tomb.Tom
 			linesRead.With(prometheus.Labels{"source": filename}).Inc()
 
 			// we're reading logs at once, it must be time-machine buckets
-			out <- types.Event{Line: l, Process: true, Type: types.LOG, ExpectMode: types.TIMEMACHINE, Unmarshaled: make(map[string]any)}
+			evt := types.Event{Line: l, Process: true, Type: types.LOG, ExpectMode: types.TIMEMACHINE, Unmarshaled: make(map[string]any)}
+			f.jsonExtractor.Apply(&evt)
+			out <- evt
+
+			checkpoint.progress(filename, lines)
 		}
 	}
 
@@ -667,12 +868,10 @@ func (f *FileSource) readFile(filename string, out chan types.Event, t *tomb.Tom
 		logger.Errorf("Error while reading file: %s", err)
 		t.Kill(err)
 
-		return err
+		return false, err
 	}
 
-	t.Kill(nil)
-
-	return nil
+	return true, nil
 }
 
 // IsTailing returns whether a given file is currently being tailed. For testing purposes.
@@ -1,6 +1,7 @@
 package fileacquisition_test
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -54,6 +55,30 @@ exclude_regexps: ["as[a-$d"]`,
 filenames: ["ase.log"]`,
 			expectedErr: `cannot parse FileAcquisition configuration: [2:1] mapping key "filenames" already defined at [1:1]`,
 		},
+		{
+			name: "bad json path",
+			config: `filenames: ["asd.log"]
+json_message_field: ".event..message"`,
+			expectedErr: "json_message_field: invalid json path '.event..message': empty segment",
+		},
+		{
+			name: "negative partial_line_timeout",
+			config: `filenames: ["asd.log"]
+partial_line_timeout: -1s`,
+			expectedErr: "partial_line_timeout must be positive",
+		},
+		{
+			name: "min_file_age in tail mode",
+			config: `filenames: ["asd.log"]
+min_file_age: 10s`,
+			expectedErr: "min_file_age is only supported in cat mode",
+		},
+		{
+			name: "checkpoint_path in tail mode",
+			config: `filenames: ["asd.log"]
+checkpoint_path: /tmp/checkpoint`,
+			expectedErr: "checkpoint_path is only supported in cat mode",
+		},
 	}
 
 	subLogger := log.WithField("type", "file")
@@ -93,6 +118,16 @@ func TestConfigureDSN(t *testing.T) {
 			dsn:         fmt.Sprintf("file://%s?log_level=foobar", file),
 			expectedErr: "unknown level foobar: not a valid logrus Level:",
 		},
+		{
+			dsn: fmt.Sprintf("file://%s?min_file_age=1m", file),
+		},
+		{
+			dsn:         fmt.Sprintf("file://%s?min_file_age=foo", file),
+			expectedErr: "could not parse min_file_age foo",
+		},
+		{
+			dsn: fmt.Sprintf("file://%s?checkpoint_path=/tmp/checkpoint", file),
+		},
 	}
 
 	subLogger := log.WithField("type", "file")
@@ -647,3 +682,250 @@ mode: tail
 	tomb.Kill(nil)
 	tomb.Wait()
 }
+
+func TestPartialLineTimeout(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+
+	testFile := filepath.Join(dir, "test.log")
+	err := os.WriteFile(testFile, nil, 0o644)
+	require.NoError(t, err)
+
+	f := &fileacquisition.FileSource{}
+	err = f.Configure([]byte(fmt.Sprintf(`
+filename: '%s'
+mode: tail
+poll_without_inotify: false
+partial_line_timeout: 500ms`, testFile)), log.NewEntry(log.New()), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	out := make(chan types.Event, 10)
+	tomb := tomb.Tomb{}
+
+	err = f.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+
+	fd, err := os.OpenFile(testFile, os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+
+	defer fd.Close()
+
+	write := func(s string) {
+		_, err := fd.WriteString(s)
+		require.NoError(t, err)
+	}
+
+	read := func() string {
+		select {
+		case evt := <-out:
+			return evt.Line.Raw
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+
+		return ""
+	}
+
+	// the parts of a line written before the timeout are joined
+	write("first ")
+	time.Sleep(200 * time.Millisecond)
+	write("line\nsecond line\n")
+
+	assert.Equal(t, "first line", read())
+	assert.Equal(t, "second line", read())
+
+	// once the timeout is reached, the partial line is emitted and the rest is a new event
+	write("partial")
+	assert.Equal(t, "partial", read())
+
+	write(" end\n")
+	assert.Equal(t, " end", read())
+
+	tomb.Kill(nil)
+	require.NoError(t, tomb.Wait())
+}
+
+func TestMinFileAge(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+
+	testFile := filepath.Join(dir, "test.log")
+	err := os.WriteFile(testFile, []byte("first line\n"), 0o644)
+	require.NoError(t, err)
+
+	f := fileacquisition.FileSource{}
+	err = f.Configure([]byte(fmt.Sprintf(`
+filename: '%s'
+mode: cat
+min_file_age: 1s`, testFile)), log.NewEntry(log.New()), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	// still being written
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+
+		fd, err := os.OpenFile(testFile, os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return
+		}
+
+		defer fd.Close()
+
+		_, _ = fd.WriteString("second line\n")
+	}()
+
+	out := make(chan types.Event, 10)
+	tmb := tomb.Tomb{}
+
+	start := time.Now()
+
+	err = f.OneShotAcquisition(ctx, out, &tmb)
+	require.NoError(t, err)
+
+	// read once it was not modified for one second after the last write
+	assert.GreaterOrEqual(t, time.Since(start), 1200*time.Millisecond)
+	require.Len(t, out, 2)
+	assert.Equal(t, "first line", (<-out).Line.Raw)
+	assert.Equal(t, "second line", (<-out).Line.Raw)
+}
+
+func TestCheckpoint(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint")
+
+	expected := map[string]int{}
+
+	for i := range 3 {
+		lines := ""
+
+		for j := range 2500 {
+			line := fmt.Sprintf("file %d line %d", i, j)
+			lines += line + "\n"
+			expected[line] = 1
+		}
+
+		err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("test%d.log", i)), []byte(lines), 0o644)
+		require.NoError(t, err)
+	}
+
+	config := []byte(fmt.Sprintf(`
+filename: '%s'
+mode: cat
+checkpoint_path: '%s'`, filepath.Join(dir, "*.log"), checkpointPath))
+
+	read := map[string]int{}
+
+	// interrupted in the middle of the second file
+	f := fileacquisition.FileSource{}
+	require.NoError(t, f.Configure(config, log.NewEntry(log.New()), configuration.METRICS_NONE))
+
+	out := make(chan types.Event)
+	tmb := tomb.Tomb{}
+
+	tmb.Go(func() error {
+		return f.OneShotAcquisition(ctx, out, &tmb)
+	})
+
+	for range 3734 {
+		read[(<-out).Line.Raw]++
+	}
+
+	tmb.Kill(nil)
+
+	// the line that was being sent
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case evt := <-out:
+				read[evt.Line.Raw]++
+			case <-tmb.Dead():
+				close(done)
+				return
+			}
+		}
+	}()
+
+	require.NoError(t, tmb.Wait())
+	<-done
+
+	assert.FileExists(t, checkpointPath)
+	assert.Less(t, len(read), len(expected))
+
+	// resumed
+	f = fileacquisition.FileSource{}
+	require.NoError(t, f.Configure(config, log.NewEntry(log.New()), configuration.METRICS_NONE))
+
+	out = make(chan types.Event, 10000)
+	tmb = tomb.Tomb{}
+
+	tmb.Go(func() error {
+		return f.OneShotAcquisition(ctx, out, &tmb)
+	})
+	require.NoError(t, tmb.Wait())
+	close(out)
+
+	for evt := range out {
+		read[evt.Line.Raw]++
+	}
+
+	assert.Equal(t, expected, read)
+
+	// cleared once completed
+	assert.NoFileExists(t, checkpointPath)
+}
+
+func TestOneShotCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	dir := t.TempDir()
+
+	lines := ""
+	for i := range 100 {
+		lines += fmt.Sprintf("line %d\n", i)
+	}
+
+	err := os.WriteFile(filepath.Join(dir, "test.log"), []byte(lines), 0o644)
+	require.NoError(t, err)
+
+	f := fileacquisition.FileSource{}
+	require.NoError(t, f.Configure([]byte(fmt.Sprintf(`
+filename: '%s'
+mode: cat`, filepath.Join(dir, "test.log"))), log.NewEntry(log.New()), configuration.METRICS_NONE))
+
+	out := make(chan types.Event)
+	tmb := tomb.Tomb{}
+
+	tmb.Go(func() error {
+		return f.OneShotAcquisition(ctx, out, &tmb)
+	})
+
+	assert.Equal(t, "line 0", (<-out).Line.Raw)
+
+	// stopped by the acquisition manager
+	cancel()
+
+	// the line that was being sent
+	read := 0
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-out:
+				read++
+			case <-tmb.Dead():
+				close(done)
+				return
+			}
+		}
+	}()
+
+	require.NoError(t, tmb.Wait())
+	<-done
+
+	assert.LessOrEqual(t, read, 1)
+}
@@ -0,0 +1,58 @@
+package fileacquisition
+
+import (
+	"github.com/nxadm/tail"
+)
+
+// lineJoiner holds the lines that don't end with a newline yet, for partial_line_timeout.
+//
+// The tailer sends whatever it read when reaching the end of the file, so a line written
+// in several steps is received in several parts. The parts are told apart from complete lines
+// by their offset: a complete line ends one byte (the newline) after its text.
+type lineJoiner struct {
+	end     int64 // offset right after the last line
+	pending *tail.Line
+}
+
+func newLineJoiner(start int64) *lineJoiner {
+	return &lineJoiner{end: start}
+}
+
+// add returns the lines that can be emitted. A partial line is kept until the rest of it is
+// received, or until flush is called.
+func (j *lineJoiner) add(line *tail.Line) []*tail.Line {
+	start := line.SeekInfo.Offset - int64(len(line.Text))
+	partial := start == j.end
+	contiguous := partial || start-1 == j.end
+
+	j.end = line.SeekInfo.Offset
+
+	var ret []*tail.Line
+
+	if j.pending != nil {
+		if contiguous {
+			line.Text = j.pending.Text + line.Text
+			line.Time = j.pending.Time
+		} else {
+			// the file was truncated or rotated
+			ret = append(ret, j.pending)
+		}
+
+		j.pending = nil
+	}
+
+	if partial {
+		j.pending = line
+		return ret
+	}
+
+	return append(ret, line)
+}
+
+// flush returns the partial line, if any. The rest of the line will be emitted on its own.
+func (j *lineJoiner) flush() *tail.Line {
+	line := j.pending
+	j.pending = nil
+
+	return line
+}
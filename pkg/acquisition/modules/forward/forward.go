@@ -0,0 +1,562 @@
+package forwardacquisition
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/trace"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/connlimit"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/ipfilter"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const (
+	dataSourceName = "forward"
+
+	defaultListenAddr        = "127.0.0.1"
+	defaultListenPort        = 24224
+	defaultMaxMessageSize    = 8 * 1024 * 1024
+	defaultMaxInflightChunks = 16
+	handshakeTimeout         = 10 * time.Second
+)
+
+// defaultMessageKeys are tried in order when message_key is not set: "log" is used by
+// Fluent Bit and the fluentd tail input, "message" by most of the other inputs.
+var defaultMessageKeys = []string{"log", "message"}
+
+var linesRead = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_forwardsource_hits_total",
+		Help: "Total records that were received.",
+	},
+	[]string{"tag"})
+
+var messagesDropped = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_forwardsource_dropped_total",
+		Help: "Total messages that were dropped: malformed, too large or not authenticated.",
+	},
+	[]string{"source", "reason"})
+
+type ForwardConfiguration struct {
+	ListenAddr                        string     `yaml:"listen_addr"`
+	ListenPort                        int        `yaml:"listen_port"`
+	TLS                               *TLSConfig `yaml:"tls"`
+	SharedKey                         string     `yaml:"shared_key"`
+	SelfHostname                      string     `yaml:"self_hostname"`
+	MessageKey                        string     `yaml:"message_key"`
+	MaxMessageSize                    int        `yaml:"max_message_size"` // after decompression
+	MaxInflightChunks                 int        `yaml:"max_inflight_chunks"`
+	MaxConnections                    int        `yaml:"max_connections"`
+	ipfilter.Config                   `yaml:",inline"`
+	configuration.DataSourceCommonCfg `yaml:",inline"`
+}
+
+type TLSConfig struct {
+	ServerCert string `yaml:"server_cert"`
+	ServerKey  string `yaml:"server_key"`
+	CaCert     string `yaml:"ca_cert"` // if set, the clients must present a certificate
+}
+
+// ForwardSource receives the logs sent with the forward protocol by fluentd or Fluent Bit.
+// Messages with a chunk option are acknowledged once all their records are sent to the
+// parsers, so the client resends them if crowdsec stops before.
+type ForwardSource struct {
+	metricsLevel int
+	config       ForwardConfiguration
+	logger       *log.Entry
+	addr         string
+	tlsConfig    *tls.Config
+	ipFilter     *ipfilter.Filter
+
+	// inflight limits the messages that are decoded and not yet acknowledged, across connections.
+	// When it's full, the clients are not read anymore and they buffer on their side.
+	inflight chan struct{}
+
+	connsMu sync.Mutex
+	conns   map[net.Conn]struct{}
+}
+
+func (f *ForwardSource) GetUuid() string {
+	return f.config.UniqueId
+}
+
+func (f *ForwardSource) UnmarshalConfig(yamlConfig []byte) error {
+	f.config = ForwardConfiguration{}
+
+	err := yaml.UnmarshalWithOptions(yamlConfig, &f.config, yaml.Strict())
+	if err != nil {
+		return fmt.Errorf("cannot parse %s datasource configuration: %s", dataSourceName, yaml.FormatError(err, false, false))
+	}
+
+	if f.config.ListenAddr == "" {
+		f.config.ListenAddr = defaultListenAddr
+	}
+
+	if net.ParseIP(f.config.ListenAddr) == nil {
+		return fmt.Errorf("invalid listen_addr '%s'", f.config.ListenAddr)
+	}
+
+	if f.config.ListenPort == 0 {
+		f.config.ListenPort = defaultListenPort
+	}
+
+	if f.config.ListenPort < 0 || f.config.ListenPort > 65535 {
+		return fmt.Errorf("invalid listen_port %d", f.config.ListenPort)
+	}
+
+	if f.config.TLS != nil && (f.config.TLS.ServerCert == "" || f.config.TLS.ServerKey == "") {
+		return errors.New("tls: server_cert and server_key are required")
+	}
+
+	if f.config.SelfHostname == "" && f.config.SharedKey != "" {
+		f.config.SelfHostname, err = os.Hostname()
+		if err != nil {
+			return fmt.Errorf("cannot get hostname for self_hostname: %w", err)
+		}
+	}
+
+	if f.config.MaxMessageSize < 0 {
+		return errors.New("max_message_size must be positive")
+	}
+
+	if f.config.MaxMessageSize == 0 {
+		f.config.MaxMessageSize = defaultMaxMessageSize
+	}
+
+	if f.config.MaxInflightChunks < 0 {
+		return errors.New("max_inflight_chunks must be positive")
+	}
+
+	if f.config.MaxInflightChunks == 0 {
+		f.config.MaxInflightChunks = defaultMaxInflightChunks
+	}
+
+	if f.config.MaxConnections < 0 {
+		return errors.New("max_connections must be positive")
+	}
+
+	f.ipFilter, err = ipfilter.New(f.config.Config)
+	if err != nil {
+		return err
+	}
+
+	if f.config.Mode == "" {
+		f.config.Mode = configuration.TAIL_MODE
+	}
+
+	if f.config.Mode != configuration.TAIL_MODE {
+		return fmt.Errorf("unsupported mode %s for %s datasource", f.config.Mode, dataSourceName)
+	}
+
+	return nil
+}
+
+func (c *TLSConfig) newTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.ServerCert, c.ServerKey)
+	if err != nil {
+		return nil, fmt.Errorf("while loading server certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.CaCert != "" {
+		caCert, err := os.ReadFile(c.CaCert)
+		if err != nil {
+			return nil, fmt.Errorf("while reading CA certificate: %w", err)
+		}
+
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no valid certificate found in %s", c.CaCert)
+		}
+
+		tlsConfig.ClientCAs = caCertPool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+func (f *ForwardSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLevel int) error {
+	f.logger = logger
+	f.metricsLevel = metricsLevel
+
+	err := f.UnmarshalConfig(yamlConfig)
+	if err != nil {
+		return err
+	}
+
+	if f.config.TLS != nil {
+		f.tlsConfig, err = f.config.TLS.newTLSConfig()
+		if err != nil {
+			return fmt.Errorf("failed to create tls config: %w", err)
+		}
+	}
+
+	f.addr = net.JoinHostPort(f.config.ListenAddr, strconv.Itoa(f.config.ListenPort))
+	f.inflight = make(chan struct{}, f.config.MaxInflightChunks)
+
+	return nil
+}
+
+func (*ForwardSource) ConfigureByDSN(string, map[string]string, *log.Entry, string) error {
+	return fmt.Errorf("%s datasource does not support command-line acquisition", dataSourceName)
+}
+
+func (f *ForwardSource) GetMode() string {
+	return f.config.Mode
+}
+
+func (*ForwardSource) GetName() string {
+	return dataSourceName
+}
+
+func (*ForwardSource) OneShotAcquisition(_ context.Context, _ chan types.Event, _ *tomb.Tomb) error {
+	return fmt.Errorf("%s datasource does not support one-shot acquisition", dataSourceName)
+}
+
+func (*ForwardSource) CanRun() error {
+	return nil
+}
+
+func (*ForwardSource) GetMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, messagesDropped, connlimit.Rejected, ipfilter.Rejected}
+}
+
+func (*ForwardSource) GetAggregMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, messagesDropped, connlimit.Rejected, ipfilter.Rejected}
+}
+
+func (f *ForwardSource) Dump() any {
+	return f
+}
+
+func (f *ForwardSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	lc := net.ListenConfig{}
+
+	listener, err := lc.Listen(ctx, "tcp", f.addr)
+	if err != nil {
+		return fmt.Errorf("while listening on %s: %w", f.addr, err)
+	}
+
+	f.logger.Infof("listening on tcp %s", f.addr)
+
+	reject := func(conn net.Conn) {
+		f.logger.Debugf("rejecting connection from %s", conn.RemoteAddr())
+
+		if f.metricsLevel != configuration.METRICS_NONE {
+			connlimit.Rejected.With(prometheus.Labels{"datasource": dataSourceName}).Inc()
+		}
+	}
+
+	rejectSource := func(conn net.Conn) {
+		f.logger.Debugf("rejecting connection from %s: source not allowed", conn.RemoteAddr())
+
+		if f.metricsLevel != configuration.METRICS_NONE {
+			ipfilter.Rejected.With(prometheus.Labels{"datasource": dataSourceName}).Inc()
+		}
+	}
+
+	listener = connlimit.Listen(ipfilter.Listen(listener, f.ipFilter, rejectSource), f.config.MaxConnections, reject)
+
+	if f.tlsConfig != nil {
+		listener = tls.NewListener(listener, f.tlsConfig)
+	}
+
+	t.Go(func() error {
+		defer trace.CatchPanic("crowdsec/acquis/forward/live")
+		return f.serve(listener, out, t)
+	})
+
+	return nil
+}
+
+func (f *ForwardSource) serve(listener net.Listener, out chan types.Event, t *tomb.Tomb) error {
+	f.conns = map[net.Conn]struct{}{}
+
+	t.Go(func() error {
+		<-t.Dying()
+		f.logger.Infof("%s datasource stopping", dataSourceName)
+
+		err := listener.Close()
+
+		f.connsMu.Lock()
+		for conn := range f.conns {
+			conn.Close()
+		}
+		f.connsMu.Unlock()
+
+		return err
+	})
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !t.Alive() {
+				return nil
+			}
+
+			return fmt.Errorf("while accepting connections on %s: %w", f.addr, err)
+		}
+
+		f.connsMu.Lock()
+		f.conns[conn] = struct{}{}
+		f.connsMu.Unlock()
+
+		t.Go(func() error {
+			defer trace.CatchPanic("crowdsec/acquis/forward/conn")
+			f.serveConn(conn, out, t)
+
+			return nil
+		})
+	}
+}
+
+// serveConn reads the messages of a connection, after the handshake if shared_key is set.
+// The connection is closed on the first decoding error, since the stream can't be resynchronized.
+func (f *ForwardSource) serveConn(conn net.Conn, out chan types.Event, t *tomb.Tomb) {
+	defer func() {
+		f.connsMu.Lock()
+		delete(f.conns, conn)
+		f.connsMu.Unlock()
+		conn.Close()
+	}()
+
+	client := addrIP(conn.RemoteAddr())
+
+	lr := &limitReader{r: conn, n: int64(f.config.MaxMessageSize)}
+	dec := msgpack.NewDecoder(bufio.NewReader(lr))
+	enc := msgpack.NewEncoder(conn)
+
+	if f.config.SharedKey != "" {
+		_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+		if err := handshake(dec, enc, f.config.SharedKey, f.config.SelfHostname); err != nil {
+			f.logger.Warnf("closing connection from %s: %s", client, err)
+			f.drop(client, "auth")
+
+			return
+		}
+
+		_ = conn.SetDeadline(time.Time{})
+	}
+
+	for {
+		// the limit applies to what's read from the connection, it's reset for each message
+		lr.n = int64(f.config.MaxMessageSize)
+
+		// wait for the next message before taking an in-flight slot
+		if _, err := dec.PeekCode(); err != nil {
+			f.closeOnError(client, lr, err, t)
+			return
+		}
+
+		select {
+		case f.inflight <- struct{}{}:
+		case <-t.Dying():
+			return
+		}
+
+		ok := f.handleMessage(dec, enc, client, lr, out, t)
+
+		<-f.inflight
+
+		if !ok {
+			return
+		}
+	}
+}
+
+// handleMessage decodes a message, sends its records and acknowledges it. It returns false if
+// the connection must be closed.
+func (f *ForwardSource) handleMessage(dec *msgpack.Decoder, enc *msgpack.Encoder, client string, lr *limitReader, out chan types.Event, t *tomb.Tomb) bool {
+	msg, err := decodeMessage(dec, f.config.MaxMessageSize)
+	if err != nil {
+		f.closeOnError(client, lr, err, t)
+		return false
+	}
+
+	if f.metricsLevel != configuration.METRICS_NONE {
+		linesRead.With(prometheus.Labels{"tag": msg.tag}).Add(float64(len(msg.entries)))
+	}
+
+	for _, e := range msg.entries {
+		select {
+		case out <- f.makeEvent(msg.tag, e, client):
+		case <-t.Dying():
+			return false
+		}
+	}
+
+	if msg.chunk == "" {
+		return true
+	}
+
+	if err := enc.Encode(map[string]string{"ack": msg.chunk}); err != nil {
+		f.logger.Warnf("closing connection from %s: while sending ack: %s", client, err)
+		return false
+	}
+
+	return true
+}
+
+func (f *ForwardSource) closeOnError(client string, lr *limitReader, err error, t *tomb.Tomb) {
+	if errors.Is(err, io.EOF) || !t.Alive() {
+		return
+	}
+
+	switch {
+	case lr.exceeded || errors.Is(err, errTooLarge):
+		f.drop(client, "too_large")
+	default:
+		f.drop(client, "malformed")
+	}
+
+	f.logger.Warnf("closing connection from %s: %s", client, err)
+}
+
+// makeEvent creates the event of a record. The message field is the log line, the other
+// scalar fields are stored in the metadata as forward_<field>. If the record has no message
+// field, the whole record is the log line, as JSON.
+func (f *ForwardSource) makeEvent(tag string, e entry, client string) types.Event {
+	evt := types.MakeEvent(f.config.UseTimeMachine, types.LOG, true)
+	evt.Line = types.Line{
+		Labels:  f.config.Labels,
+		Time:    e.time,
+		Src:     client,
+		Process: true,
+		Module:  dataSourceName,
+	}
+
+	if evt.Line.Time.IsZero() {
+		evt.Line.Time = time.Now().UTC()
+	}
+
+	evt.Meta["forward_tag"] = tag
+
+	messageKey, found := f.findMessageKey(e.record)
+
+	for name, value := range e.record {
+		if name == messageKey {
+			continue
+		}
+
+		if s, ok := scalarString(value); ok {
+			evt.Meta["forward_"+name] = s
+		}
+	}
+
+	if found {
+		evt.Line.Raw, _ = scalarString(e.record[messageKey])
+		return evt
+	}
+
+	raw, err := json.Marshal(jsonValue(e.record))
+	if err != nil {
+		f.logger.Debugf("cannot encode record from %s as JSON: %s", client, err)
+	}
+
+	evt.Line.Raw = string(raw)
+
+	return evt
+}
+
+func (f *ForwardSource) findMessageKey(record map[string]any) (string, bool) {
+	keys := defaultMessageKeys
+	if f.config.MessageKey != "" {
+		keys = []string{f.config.MessageKey}
+	}
+
+	for _, key := range keys {
+		if _, ok := scalarString(record[key]); ok {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+// scalarString returns the string form of the scalar values. It returns false for maps,
+// arrays and null values.
+func scalarString(v any) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case []byte:
+		return string(s), true
+	case bool, float32, float64:
+		return fmt.Sprint(s), true
+	case uint64:
+		return strconv.FormatUint(s, 10), true
+	}
+
+	if n, ok := toInt64(v); ok {
+		return strconv.FormatInt(n, 10), true
+	}
+
+	return "", false
+}
+
+// jsonValue converts the binary values, which json.Marshal would encode in base64, to strings.
+func jsonValue(v any) any {
+	switch val := v.(type) {
+	case []byte:
+		return string(val)
+	case map[string]any:
+		ret := make(map[string]any, len(val))
+		for k, item := range val {
+			ret[k] = jsonValue(item)
+		}
+
+		return ret
+	case []any:
+		ret := make([]any, len(val))
+		for i, item := range val {
+			ret[i] = jsonValue(item)
+		}
+
+		return ret
+	}
+
+	return v
+}
+
+func (f *ForwardSource) drop(client string, reason string) {
+	if f.metricsLevel == configuration.METRICS_NONE {
+		return
+	}
+
+	messagesDropped.With(prometheus.Labels{"source": client, "reason": reason}).Inc()
+}
+
+func addrIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
@@ -0,0 +1,374 @@
+package forwardacquisition
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		config      string
+		expectedErr string
+	}{
+		{
+			config:      `foobar: asd`,
+			expectedErr: `cannot parse forward datasource configuration: [1:1] unknown field "foobar"`,
+		},
+		{
+			config: `
+source: forward
+listen_addr: localhost`,
+			expectedErr: "invalid listen_addr 'localhost'",
+		},
+		{
+			config: `
+source: forward
+listen_port: 70000`,
+			expectedErr: "invalid listen_port 70000",
+		},
+		{
+			config: `
+source: forward
+mode: cat`,
+			expectedErr: "unsupported mode cat for forward datasource",
+		},
+		{
+			config: `
+source: forward
+tls:
+  server_cert: cert.pem`,
+			expectedErr: "tls: server_cert and server_key are required",
+		},
+		{
+			config: `
+source: forward
+max_inflight_chunks: -1`,
+			expectedErr: "max_inflight_chunks must be positive",
+		},
+		{
+			config: `
+source: forward
+allowed_sources:
+  - 10.0.0.300`,
+			expectedErr: "allowed_sources: invalid address '10.0.0.300'",
+		},
+		{
+			config: `
+source: forward
+listen_addr: 0.0.0.0
+shared_key: secret
+self_hostname: crowdsec
+message_key: msg
+max_message_size: 4096
+max_inflight_chunks: 4
+max_connections: 10`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.config, func(t *testing.T) {
+			f := ForwardSource{}
+			err := f.Configure([]byte(tc.config), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+// encode returns the msgpack encoding of the values, one after the other.
+func encode(t *testing.T, values ...any) []byte {
+	buf := bytes.Buffer{}
+	enc := msgpack.NewEncoder(&buf)
+
+	for _, v := range values {
+		require.NoError(t, enc.Encode(v))
+	}
+
+	return buf.Bytes()
+}
+
+// eventTime is a msgpack value encoded as an EventTime extension.
+type eventTime time.Time
+
+func (et eventTime) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if err := enc.EncodeExtHeader(eventTimeExt, 8); err != nil {
+		return err
+	}
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b[:4], uint32(time.Time(et).Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(time.Time(et).Nanosecond()))
+
+	_, err := enc.Writer().Write(b)
+
+	return err
+}
+
+func TestDecodeMessage(t *testing.T) {
+	ts := time.Unix(1700000000, 0).UTC()
+	record := map[string]any{"log": "line"}
+
+	gzipped := bytes.Buffer{}
+	gz := gzip.NewWriter(&gzipped)
+	_, err := gz.Write(encode(t, []any{ts.Unix(), record}, []any{ts.Unix(), record}))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	tests := []struct {
+		name        string
+		data        []byte
+		expected    int
+		chunk       string
+		expectedErr string
+	}{
+		{
+			name:     "message",
+			data:     encode(t, []any{"app", ts.Unix(), record}),
+			expected: 1,
+		},
+		{
+			name:     "message with event time",
+			data:     encode(t, []any{"app", eventTime(ts.Add(500 * time.Millisecond)), record, map[string]any{"chunk": "c1"}}),
+			expected: 1,
+			chunk:    "c1",
+		},
+		{
+			name:     "forward",
+			data:     encode(t, []any{"app", []any{[]any{ts.Unix(), record}, []any{eventTime(ts), record}}, map[string]any{"chunk": "c2"}}),
+			expected: 2,
+			chunk:    "c2",
+		},
+		{
+			name:     "packed forward",
+			data:     encode(t, []any{"app", encode(t, []any{ts.Unix(), record}, []any{ts.Unix(), record}, []any{ts.Unix(), record})}),
+			expected: 3,
+		},
+		{
+			name:     "compressed packed forward",
+			data:     encode(t, []any{"app", gzipped.Bytes(), map[string]any{"compressed": "gzip", "size": 2}}),
+			expected: 2,
+		},
+		{
+			name:        "decompressed too large",
+			data:        encode(t, []any{"app", gzipped.Bytes(), map[string]any{"compressed": "gzip"}}),
+			expectedErr: "message too large",
+		},
+		{
+			name:        "unknown compression",
+			data:        encode(t, []any{"app", gzipped.Bytes(), map[string]any{"compressed": "zstd"}}),
+			expectedErr: "unsupported compression 'zstd'",
+		},
+		{
+			name:        "not an array",
+			data:        encode(t, "app"),
+			expectedErr: "msgpack: invalid code=a3 decoding array length",
+		},
+		{
+			name:        "missing record",
+			data:        encode(t, []any{"app", ts.Unix()}),
+			expectedErr: "message without record",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			maxSize := 1024
+			if tc.name == "decompressed too large" {
+				maxSize = 10
+			}
+
+			msg, err := decodeMessage(msgpack.NewDecoder(bytes.NewReader(tc.data)), maxSize)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+
+			if tc.expectedErr != "" {
+				return
+			}
+
+			assert.Equal(t, "app", msg.tag)
+			assert.Equal(t, tc.chunk, msg.chunk)
+			require.Len(t, msg.entries, tc.expected)
+			assert.Equal(t, "line", msg.entries[0].record["log"])
+			assert.Equal(t, ts.Unix(), msg.entries[0].time.Unix())
+		})
+	}
+}
+
+func TestMakeEvent(t *testing.T) {
+	f := ForwardSource{}
+	err := f.Configure([]byte(`source: forward`), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	ts := time.Unix(1700000000, 0).UTC()
+
+	evt := f.makeEvent("nginx.access", entry{time: ts, record: map[string]any{
+		"log":    "GET / 200",
+		"stream": []byte("stdout"),
+		"pid":    int64(42),
+		"kubernetes": map[string]any{
+			"pod_name": "web-1",
+		},
+	}}, "10.0.0.1")
+
+	assert.Equal(t, "GET / 200", evt.Line.Raw)
+	assert.Equal(t, ts, evt.Line.Time)
+	assert.Equal(t, "10.0.0.1", evt.Line.Src)
+	assert.Equal(t, "nginx.access", evt.Meta["forward_tag"])
+	assert.Equal(t, "stdout", evt.Meta["forward_stream"])
+	assert.Equal(t, "42", evt.Meta["forward_pid"])
+	assert.NotContains(t, evt.Meta, "forward_log")
+	assert.NotContains(t, evt.Meta, "forward_kubernetes")
+
+	// without a message field, the record is the log line
+	evt = f.makeEvent("app", entry{record: map[string]any{"msg": []byte("hello"), "level": "info"}}, "10.0.0.1")
+
+	assert.JSONEq(t, `{"msg": "hello", "level": "info"}`, evt.Line.Raw)
+	assert.Equal(t, "info", evt.Meta["forward_level"])
+	assert.False(t, evt.Line.Time.IsZero())
+}
+
+func startSource(t *testing.T, config string) chan types.Event {
+	f := ForwardSource{}
+	err := f.Configure([]byte(config), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	out := make(chan types.Event, 10)
+	tmb := &tomb.Tomb{}
+
+	require.NoError(t, f.StreamingAcquisition(t.Context(), out, tmb))
+
+	t.Cleanup(func() {
+		tmb.Kill(nil)
+		require.NoError(t, tmb.Wait())
+	})
+
+	return out
+}
+
+func readEvent(t *testing.T, out chan types.Event) types.Event {
+	select {
+	case evt := <-out:
+		return evt
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for an event")
+	}
+
+	return types.Event{}
+}
+
+func TestStreamingAcquisition(t *testing.T) {
+	out := startSource(t, `
+source: forward
+listen_port: 49311
+labels:
+  type: fluent`)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:49311")
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	ts := time.Unix(1700000000, 0).UTC()
+
+	_, err = conn.Write(encode(t,
+		[]any{"app", ts.Unix(), map[string]any{"message": "first"}},
+		[]any{"app", []any{[]any{eventTime(ts), map[string]any{"log": "second"}}}, map[string]any{"chunk": "Y2h1bmsx"}},
+	))
+	require.NoError(t, err)
+
+	evt := readEvent(t, out)
+	assert.Equal(t, "first", evt.Line.Raw)
+	assert.Equal(t, "fluent", evt.Line.Labels["type"])
+	assert.Equal(t, "app", evt.Meta["forward_tag"])
+	assert.Equal(t, ts, evt.Line.Time)
+
+	assert.Equal(t, "second", readEvent(t, out).Line.Raw)
+
+	// the message with a chunk option is acknowledged once sent
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+
+	ack := map[string]string{}
+	require.NoError(t, msgpack.NewDecoder(conn).Decode(&ack))
+	assert.Equal(t, "Y2h1bmsx", ack["ack"])
+}
+
+// ping answers the HELO of the server, and returns the PONG.
+func ping(t *testing.T, conn net.Conn, sharedKey string) []any {
+	dec := msgpack.NewDecoder(conn)
+
+	helo := []any{}
+	require.NoError(t, dec.Decode(&helo))
+	require.Equal(t, "HELO", helo[0])
+
+	nonce, ok := helo[1].(map[string]any)["nonce"].([]byte)
+	require.True(t, ok)
+
+	salt := []byte("0123456789abcdef")
+	digest := sharedKeyDigest(salt, []byte("client"), nonce, sharedKey)
+
+	_, err := conn.Write(encode(t, []any{"PING", "client", salt, digest, "", ""}))
+	require.NoError(t, err)
+
+	pong := []any{}
+	require.NoError(t, dec.Decode(&pong))
+	require.Equal(t, "PONG", pong[0])
+
+	if pong[1] == true {
+		assert.Equal(t, sharedKeyDigest(salt, []byte("crowdsec"), nonce, sharedKey), pong[4])
+	}
+
+	return pong
+}
+
+func TestStreamingSharedKey(t *testing.T) {
+	out := startSource(t, `
+source: forward
+listen_port: 49312
+shared_key: secret
+self_hostname: crowdsec`)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:49312")
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	pong := ping(t, conn, "secret")
+	assert.Equal(t, true, pong[1])
+	assert.Equal(t, "crowdsec", pong[3])
+
+	_, err = conn.Write(encode(t, []any{"app", time.Now().Unix(), map[string]any{"log": "authenticated"}}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "authenticated", readEvent(t, out).Line.Raw)
+
+	// wrong key
+	conn2, err := net.Dial("tcp", "127.0.0.1:49312")
+	require.NoError(t, err)
+
+	defer conn2.Close()
+
+	pong = ping(t, conn2, "wrong")
+	assert.Equal(t, false, pong[1])
+	assert.Equal(t, "shared_key mismatch", pong[2])
+
+	// the server closes the connection
+	require.NoError(t, conn2.SetReadDeadline(time.Now().Add(2*time.Second)))
+
+	_, err = conn2.Read(make([]byte, 1))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,367 @@
+package forwardacquisition
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// eventTimeExt is the msgpack extension type of the EventTime timestamps (seconds and nanoseconds).
+const eventTimeExt = 0
+
+var errTooLarge = errors.New("message too large")
+
+// entry is a record with its timestamp.
+type entry struct {
+	time   time.Time
+	record map[string]any
+}
+
+// message is a decoded forward protocol message, in any of its modes.
+type message struct {
+	tag     string
+	entries []entry
+	chunk   string // set if the client expects an ack
+}
+
+// limitReader fails once more than n bytes have been read. n is reset before each message.
+type limitReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		l.exceeded = true
+		return 0, errTooLarge
+	}
+
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+
+	return n, err
+}
+
+// decodeMessage reads a message in the Message, Forward, PackedForward or CompressedPackedForward
+// mode:
+//
+//	[tag, time, record, option?]
+//	[tag, [[time, record], ...], option?]
+//	[tag, bin(msgpack stream of [time, record]), option?]
+func decodeMessage(dec *msgpack.Decoder, maxSize int) (*message, error) {
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 2 || n > 4 {
+		return nil, fmt.Errorf("unexpected message with %d elements", n)
+	}
+
+	msg := &message{}
+
+	if msg.tag, err = dec.DecodeString(); err != nil {
+		return nil, fmt.Errorf("invalid tag: %w", err)
+	}
+
+	code, err := dec.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		packed    []byte
+		remaining int
+	)
+
+	switch {
+	case msgpcode.IsFixedArray(code) || code == msgpcode.Array16 || code == msgpcode.Array32:
+		// Forward
+		count, err := dec.DecodeArrayLen()
+		if err != nil {
+			return nil, err
+		}
+
+		for range count {
+			e, err := decodeEntry(dec)
+			if err != nil {
+				return nil, err
+			}
+
+			msg.entries = append(msg.entries, e)
+		}
+
+		remaining = n - 2
+	case msgpcode.IsBin(code) || msgpcode.IsString(code):
+		// PackedForward, the entries are decoded once we know if they are compressed
+		if packed, err = dec.DecodeBytes(); err != nil {
+			return nil, err
+		}
+
+		remaining = n - 2
+	default:
+		// Message
+		if n < 3 {
+			return nil, errors.New("message without record")
+		}
+
+		e := entry{}
+
+		if e.time, err = decodeTime(dec); err != nil {
+			return nil, err
+		}
+
+		if e.record, err = dec.DecodeMap(); err != nil {
+			return nil, fmt.Errorf("invalid record: %w", err)
+		}
+
+		msg.entries = append(msg.entries, e)
+		remaining = n - 3
+	}
+
+	options := map[string]any{}
+
+	if remaining > 0 {
+		if options, err = dec.DecodeMap(); err != nil {
+			return nil, fmt.Errorf("invalid options: %w", err)
+		}
+	}
+
+	msg.chunk, _ = options["chunk"].(string)
+
+	if packed != nil {
+		compressed, _ := options["compressed"].(string)
+
+		if msg.entries, err = decodePacked(packed, compressed, maxSize); err != nil {
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
+
+// decodePacked decodes the entries of a PackedForward message, optionally gzipped.
+func decodePacked(packed []byte, compressed string, maxSize int) ([]entry, error) {
+	var r io.Reader = bytes.NewReader(packed)
+
+	switch compressed {
+	case "", "text":
+	case "gzip":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip data: %w", err)
+		}
+		defer gz.Close()
+
+		data, err := io.ReadAll(io.LimitReader(gz, int64(maxSize)+1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip data: %w", err)
+		}
+
+		if len(data) > maxSize {
+			return nil, errTooLarge
+		}
+
+		r = bytes.NewReader(data)
+	default:
+		return nil, fmt.Errorf("unsupported compression '%s'", compressed)
+	}
+
+	dec := msgpack.NewDecoder(r)
+
+	var entries []entry
+
+	for {
+		e, err := decodeEntry(dec)
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+}
+
+// decodeEntry reads a [time, record] pair.
+func decodeEntry(dec *msgpack.Decoder) (entry, error) {
+	e := entry{}
+
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return e, err
+	}
+
+	if n != 2 {
+		return e, fmt.Errorf("unexpected entry with %d elements", n)
+	}
+
+	if e.time, err = decodeTime(dec); err != nil {
+		return e, err
+	}
+
+	if e.record, err = dec.DecodeMap(); err != nil {
+		return e, fmt.Errorf("invalid record: %w", err)
+	}
+
+	return e, nil
+}
+
+// decodeTime reads a timestamp, either an integer (seconds) or an EventTime.
+func decodeTime(dec *msgpack.Decoder) (time.Time, error) {
+	code, err := dec.PeekCode()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if msgpcode.IsExt(code) {
+		extID, extLen, err := dec.DecodeExtHeader()
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if extID != eventTimeExt || extLen != 8 {
+			return time.Time{}, fmt.Errorf("unexpected time extension %d of %d bytes", extID, extLen)
+		}
+
+		b := make([]byte, 8)
+		if err := dec.ReadFull(b); err != nil {
+			return time.Time{}, err
+		}
+
+		sec := binary.BigEndian.Uint32(b[:4])
+		nsec := binary.BigEndian.Uint32(b[4:])
+
+		return time.Unix(int64(sec), int64(nsec)).UTC(), nil
+	}
+
+	v, err := dec.DecodeInterface()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if sec, ok := toInt64(v); ok {
+		return time.Unix(sec, 0).UTC(), nil
+	}
+
+	switch t := v.(type) {
+	case float32:
+		return floatTime(float64(t)), nil
+	case float64:
+		return floatTime(t), nil
+	case nil:
+		return time.Time{}, nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid time %v", v)
+	}
+}
+
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	}
+
+	return 0, false
+}
+
+func floatTime(f float64) time.Time {
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)).UTC()
+}
+
+// handshake authenticates the client with the shared key:
+//
+//	server: ["HELO", {"nonce": nonce, "auth": "", "keepalive": true}]
+//	client: ["PING", hostname, salt, hex(sha512(salt + hostname + nonce + shared_key)), username, password]
+//	server: ["PONG", ok, reason, self_hostname, hex(sha512(salt + self_hostname + nonce + shared_key))]
+//
+// User authentication is not supported, the username and password are ignored.
+func handshake(dec *msgpack.Decoder, enc *msgpack.Encoder, sharedKey string, selfHostname string) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	helo := []any{"HELO", map[string]any{"nonce": nonce, "auth": "", "keepalive": true}}
+	if err := enc.Encode(helo); err != nil {
+		return fmt.Errorf("while sending HELO: %w", err)
+	}
+
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return fmt.Errorf("while reading PING: %w", err)
+	}
+
+	if n != 6 {
+		return fmt.Errorf("unexpected PING with %d elements", n)
+	}
+
+	fields := make([][]byte, n)
+
+	for i := range fields {
+		if fields[i], err = dec.DecodeBytes(); err != nil {
+			return fmt.Errorf("while reading PING: %w", err)
+		}
+	}
+
+	if string(fields[0]) != "PING" {
+		return fmt.Errorf("expected PING, got '%s'", fields[0])
+	}
+
+	hostname, salt, digest := fields[1], fields[2], fields[3]
+
+	if subtle.ConstantTimeCompare(digest, []byte(sharedKeyDigest(salt, hostname, nonce, sharedKey))) != 1 {
+		_ = enc.Encode([]any{"PONG", false, "shared_key mismatch", selfHostname, ""})
+		return errors.New("shared_key mismatch")
+	}
+
+	pong := []any{"PONG", true, "", selfHostname, sharedKeyDigest(salt, []byte(selfHostname), nonce, sharedKey)}
+	if err := enc.Encode(pong); err != nil {
+		return fmt.Errorf("while sending PONG: %w", err)
+	}
+
+	return nil
+}
+
+func sharedKeyDigest(salt []byte, hostname []byte, nonce []byte, sharedKey string) string {
+	h := sha512.New()
+	h.Write(salt)
+	h.Write(hostname)
+	h.Write(nonce)
+	h.Write([]byte(sharedKey))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
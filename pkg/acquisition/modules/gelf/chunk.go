@@ -0,0 +1,119 @@
+package gelfacquisition
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	chunkHeaderSize = 12 // magic, message id, sequence number, sequence count
+	maxChunks       = 128
+)
+
+var chunkMagic = []byte{0x1e, 0x0f}
+
+// chunkAssembler reassembles the chunked GELF messages received over UDP. Messages that are
+// not complete within the timeout are dropped.
+type chunkAssembler struct {
+	timeout    time.Duration
+	maxSize    int
+	maxPending int
+	pending    map[[8]byte]*pendingMessage
+}
+
+type pendingMessage struct {
+	chunks   [][]byte
+	received int
+	size     int
+	first    time.Time
+	client   string
+}
+
+func newChunkAssembler(timeout time.Duration, maxSize int, maxPending int) *chunkAssembler {
+	return &chunkAssembler{
+		timeout:    timeout,
+		maxSize:    maxSize,
+		maxPending: maxPending,
+		pending:    map[[8]byte]*pendingMessage{},
+	}
+}
+
+func isChunk(packet []byte) bool {
+	return len(packet) >= 2 && packet[0] == chunkMagic[0] && packet[1] == chunkMagic[1]
+}
+
+// add stores a chunk and returns the reassembled payload when the message is complete,
+// nil otherwise.
+func (a *chunkAssembler) add(packet []byte, client string, now time.Time) ([]byte, error) {
+	if len(packet) < chunkHeaderSize {
+		return nil, errors.New("chunk too short")
+	}
+
+	var id [8]byte
+
+	copy(id[:], packet[2:10])
+	seq, count := int(packet[10]), int(packet[11])
+
+	if count == 0 || count > maxChunks || seq >= count {
+		return nil, errors.New("invalid chunk sequence")
+	}
+
+	msg, ok := a.pending[id]
+	if !ok {
+		if len(a.pending) >= a.maxPending {
+			return nil, errors.New("too many incomplete messages")
+		}
+
+		msg = &pendingMessage{chunks: make([][]byte, count), first: now, client: client}
+		a.pending[id] = msg
+	}
+
+	if len(msg.chunks) != count {
+		delete(a.pending, id)
+		return nil, errors.New("inconsistent chunk count")
+	}
+
+	if msg.chunks[seq] != nil {
+		// duplicate chunk
+		return nil, nil
+	}
+
+	data := packet[chunkHeaderSize:]
+
+	msg.size += len(data)
+	if msg.size > a.maxSize {
+		delete(a.pending, id)
+		return nil, errTooLarge
+	}
+
+	msg.chunks[seq] = append([]byte(nil), data...)
+	msg.received++
+
+	if msg.received < count {
+		return nil, nil
+	}
+
+	delete(a.pending, id)
+
+	payload := make([]byte, 0, msg.size)
+	for _, chunk := range msg.chunks {
+		payload = append(payload, chunk...)
+	}
+
+	return payload, nil
+}
+
+// expire drops the messages that are still incomplete after the timeout, and returns the
+// clients that sent them.
+func (a *chunkAssembler) expire(now time.Time) []string {
+	var clients []string
+
+	for id, msg := range a.pending {
+		if now.Sub(msg.first) > a.timeout {
+			delete(a.pending, id)
+			clients = append(clients, msg.client)
+		}
+	}
+
+	return clients
+}
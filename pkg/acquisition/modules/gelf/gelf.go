@@ -0,0 +1,494 @@
+package gelfacquisition
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/trace"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/connlimit"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/ipfilter"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const (
+	dataSourceName = "gelf"
+
+	defaultListenAddr     = "127.0.0.1"
+	defaultListenPort     = 12201
+	defaultMaxMessageSize = 1024 * 1024
+	defaultChunkTimeout   = 5 * time.Second // as recommended by the specification
+	maxPendingMessages    = 1024
+	udpPacketSize         = 65536
+)
+
+var linesRead = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_gelfsource_hits_total",
+		Help: "Total GELF messages that were received.",
+	},
+	[]string{"source"})
+
+var messagesDropped = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_gelfsource_dropped_total",
+		Help: "Total GELF messages that were dropped: malformed, too large or with missing chunks.",
+	},
+	[]string{"source", "reason"})
+
+type GelfConfiguration struct {
+	Protocol                          string        `yaml:"protocol"` // udp or tcp
+	ListenAddr                        string        `yaml:"listen_addr"`
+	ListenPort                        int           `yaml:"listen_port"`
+	MaxMessageSize                    int           `yaml:"max_message_size"` // after decompression and reassembly
+	ChunkTimeout                      time.Duration `yaml:"chunk_timeout"`
+	MaxConnections                    int           `yaml:"max_connections"` // tcp only
+	ipfilter.Config                   `yaml:",inline"`
+	configuration.DataSourceCommonCfg `yaml:",inline"`
+}
+
+type GelfSource struct {
+	metricsLevel int
+	config       GelfConfiguration
+	logger       *log.Entry
+	addr         string
+	ipFilter     *ipfilter.Filter
+
+	connsMu sync.Mutex
+	conns   map[net.Conn]struct{}
+}
+
+func (g *GelfSource) GetUuid() string {
+	return g.config.UniqueId
+}
+
+func (g *GelfSource) UnmarshalConfig(yamlConfig []byte) error {
+	g.config = GelfConfiguration{}
+
+	err := yaml.UnmarshalWithOptions(yamlConfig, &g.config, yaml.Strict())
+	if err != nil {
+		return fmt.Errorf("cannot parse %s datasource configuration: %s", dataSourceName, yaml.FormatError(err, false, false))
+	}
+
+	switch g.config.Protocol {
+	case "":
+		g.config.Protocol = "udp"
+	case "udp", "tcp":
+	default:
+		return fmt.Errorf("invalid protocol '%s': must be udp or tcp", g.config.Protocol)
+	}
+
+	if g.config.ListenAddr == "" {
+		g.config.ListenAddr = defaultListenAddr
+	}
+
+	if net.ParseIP(g.config.ListenAddr) == nil {
+		return fmt.Errorf("invalid listen_addr '%s'", g.config.ListenAddr)
+	}
+
+	if g.config.ListenPort == 0 {
+		g.config.ListenPort = defaultListenPort
+	}
+
+	if g.config.ListenPort < 0 || g.config.ListenPort > 65535 {
+		return fmt.Errorf("invalid listen_port %d", g.config.ListenPort)
+	}
+
+	if g.config.MaxMessageSize < 0 {
+		return errors.New("max_message_size must be positive")
+	}
+
+	if g.config.MaxMessageSize == 0 {
+		g.config.MaxMessageSize = defaultMaxMessageSize
+	}
+
+	if g.config.ChunkTimeout < 0 {
+		return errors.New("chunk_timeout must be positive")
+	}
+
+	if g.config.ChunkTimeout == 0 {
+		g.config.ChunkTimeout = defaultChunkTimeout
+	}
+
+	if g.config.MaxConnections < 0 {
+		return errors.New("max_connections must be positive")
+	}
+
+	if g.config.MaxConnections > 0 && g.config.Protocol != "tcp" {
+		return errors.New("max_connections is only supported with tcp")
+	}
+
+	g.ipFilter, err = ipfilter.New(g.config.Config)
+	if err != nil {
+		return err
+	}
+
+	if g.config.Mode == "" {
+		g.config.Mode = configuration.TAIL_MODE
+	}
+
+	if g.config.Mode != configuration.TAIL_MODE {
+		return fmt.Errorf("unsupported mode %s for %s datasource", g.config.Mode, dataSourceName)
+	}
+
+	return nil
+}
+
+func (g *GelfSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLevel int) error {
+	g.logger = logger
+	g.metricsLevel = metricsLevel
+
+	err := g.UnmarshalConfig(yamlConfig)
+	if err != nil {
+		return err
+	}
+
+	g.addr = net.JoinHostPort(g.config.ListenAddr, strconv.Itoa(g.config.ListenPort))
+
+	return nil
+}
+
+func (*GelfSource) ConfigureByDSN(string, map[string]string, *log.Entry, string) error {
+	return fmt.Errorf("%s datasource does not support command-line acquisition", dataSourceName)
+}
+
+func (g *GelfSource) GetMode() string {
+	return g.config.Mode
+}
+
+func (*GelfSource) GetName() string {
+	return dataSourceName
+}
+
+func (*GelfSource) OneShotAcquisition(_ context.Context, _ chan types.Event, _ *tomb.Tomb) error {
+	return fmt.Errorf("%s datasource does not support one-shot acquisition", dataSourceName)
+}
+
+func (*GelfSource) CanRun() error {
+	return nil
+}
+
+func (*GelfSource) GetMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, messagesDropped, connlimit.Rejected, ipfilter.Rejected}
+}
+
+func (*GelfSource) GetAggregMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, messagesDropped, connlimit.Rejected, ipfilter.Rejected}
+}
+
+func (g *GelfSource) Dump() any {
+	return g
+}
+
+func (g *GelfSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	lc := net.ListenConfig{}
+
+	if g.config.Protocol == "tcp" {
+		listener, err := lc.Listen(ctx, "tcp", g.addr)
+		if err != nil {
+			return fmt.Errorf("could not start %s server: %w", dataSourceName, err)
+		}
+
+		listener = ipfilter.Listen(listener, g.ipFilter, func(conn net.Conn) {
+			g.rejectSource(conn.RemoteAddr().String())
+		})
+
+		// GELF has no way to report an error to the client, the extra connections are just closed
+		listener = connlimit.Listen(listener, g.config.MaxConnections, func(conn net.Conn) {
+			g.logger.Debugf("rejecting connection from %s: max_connections reached", conn.RemoteAddr())
+
+			if g.metricsLevel != configuration.METRICS_NONE {
+				connlimit.Rejected.With(prometheus.Labels{"datasource": dataSourceName, "addr": g.addr}).Inc()
+			}
+		})
+
+		g.logger.Infof("listening for GELF messages on tcp://%s", g.addr)
+
+		t.Go(func() error {
+			defer trace.CatchPanic("crowdsec/acquis/gelf/live")
+			return g.serveTCP(listener, out, t)
+		})
+
+		return nil
+	}
+
+	conn, err := lc.ListenPacket(ctx, "udp", g.addr)
+	if err != nil {
+		return fmt.Errorf("could not start %s server: %w", dataSourceName, err)
+	}
+
+	g.logger.Infof("listening for GELF messages on udp://%s", g.addr)
+
+	t.Go(func() error {
+		defer trace.CatchPanic("crowdsec/acquis/gelf/live")
+		return g.serveUDP(conn, out, t)
+	})
+
+	return nil
+}
+
+func (g *GelfSource) serveUDP(conn net.PacketConn, out chan types.Event, t *tomb.Tomb) error {
+	assembler := newChunkAssembler(g.config.ChunkTimeout, g.config.MaxMessageSize, maxPendingMessages)
+	buf := make([]byte, udpPacketSize)
+	lastExpire := time.Now()
+
+	t.Go(func() error {
+		<-t.Dying()
+		g.logger.Infof("%s datasource stopping", dataSourceName)
+
+		return conn.Close()
+	})
+
+	for {
+		// wake up regularly to drop the messages with missing chunks
+		if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			return err
+		}
+
+		n, addr, err := conn.ReadFrom(buf)
+
+		now := time.Now()
+		if now.Sub(lastExpire) >= time.Second {
+			for _, client := range assembler.expire(now) {
+				g.logger.Debugf("dropping incomplete chunked message from %s", client)
+				g.drop(client, "incomplete", 1)
+			}
+
+			lastExpire = now
+		}
+
+		if err != nil {
+			if !t.Alive() {
+				return nil
+			}
+
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+
+			return fmt.Errorf("while reading from %s: %w", g.addr, err)
+		}
+
+		client := addrIP(addr)
+		payload := buf[:n]
+
+		// before reassembly, so that rejected clients can't fill the pending messages
+		if !g.ipFilter.AllowedHost(client) {
+			g.rejectSource(client)
+			continue
+		}
+
+		if isChunk(payload) {
+			payload, err = assembler.add(payload, client, now)
+			if err != nil {
+				g.logger.Debugf("dropping chunk from %s: %s", client, err)
+				g.drop(client, dropReason(err), 1)
+
+				continue
+			}
+
+			if payload == nil {
+				continue
+			}
+		}
+
+		if !g.handlePayload(payload, client, out, t) {
+			return nil
+		}
+	}
+}
+
+func (g *GelfSource) serveTCP(listener net.Listener, out chan types.Event, t *tomb.Tomb) error {
+	g.conns = map[net.Conn]struct{}{}
+
+	t.Go(func() error {
+		<-t.Dying()
+		g.logger.Infof("%s datasource stopping", dataSourceName)
+
+		err := listener.Close()
+
+		g.connsMu.Lock()
+		for conn := range g.conns {
+			conn.Close()
+		}
+		g.connsMu.Unlock()
+
+		return err
+	})
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !t.Alive() {
+				return nil
+			}
+
+			return fmt.Errorf("while accepting connections on %s: %w", g.addr, err)
+		}
+
+		g.connsMu.Lock()
+		g.conns[conn] = struct{}{}
+		g.connsMu.Unlock()
+
+		t.Go(func() error {
+			defer trace.CatchPanic("crowdsec/acquis/gelf/conn")
+			g.serveConn(conn, out, t)
+
+			return nil
+		})
+	}
+}
+
+// serveConn reads the null-delimited messages of a TCP connection.
+func (g *GelfSource) serveConn(conn net.Conn, out chan types.Event, t *tomb.Tomb) {
+	defer func() {
+		g.connsMu.Lock()
+		delete(g.conns, conn)
+		g.connsMu.Unlock()
+		conn.Close()
+	}()
+
+	client := addrIP(conn.RemoteAddr())
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), g.config.MaxMessageSize+1)
+	scanner.Split(scanNull)
+
+	for scanner.Scan() {
+		payload := bytes.TrimSpace(scanner.Bytes())
+		if len(payload) == 0 {
+			continue
+		}
+
+		if !g.handlePayload(payload, client, out, t) {
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil && t.Alive() {
+		if errors.Is(err, bufio.ErrTooLong) {
+			g.drop(client, "too_large", 1)
+		}
+
+		g.logger.Warnf("closing connection from %s: %s", client, err)
+	}
+}
+
+// handlePayload decodes a complete GELF message and sends it. It returns false if the
+// datasource is stopping.
+func (g *GelfSource) handlePayload(payload []byte, client string, out chan types.Event, t *tomb.Tomb) bool {
+	data, err := decompress(payload, g.config.MaxMessageSize)
+	if err != nil {
+		g.logger.Debugf("dropping message from %s: %s", client, err)
+		g.drop(client, dropReason(err), 1)
+
+		return true
+	}
+
+	msg, err := parseMessage(data)
+	if err != nil {
+		g.logger.Debugf("dropping message from %s: %s", client, err)
+		g.drop(client, "malformed", 1)
+
+		return true
+	}
+
+	if g.metricsLevel != configuration.METRICS_NONE {
+		linesRead.With(prometheus.Labels{"source": client}).Inc()
+	}
+
+	evt := types.MakeEvent(g.config.UseTimeMachine, types.LOG, true)
+	evt.Line = types.Line{
+		Raw:     msg.ShortMessage,
+		Labels:  g.config.Labels,
+		Time:    msg.Timestamp,
+		Src:     client,
+		Process: true,
+		Module:  dataSourceName,
+	}
+
+	if evt.Line.Time.IsZero() {
+		evt.Line.Time = time.Now().UTC()
+	}
+
+	evt.Meta["gelf_host"] = msg.Host
+
+	if msg.Level != "" {
+		evt.Meta["gelf_level"] = msg.Level
+	}
+
+	for name, value := range msg.Fields {
+		evt.Meta["gelf_"+name] = value
+	}
+
+	select {
+	case out <- evt:
+		return true
+	case <-t.Dying():
+		return false
+	}
+}
+
+func (g *GelfSource) drop(client string, reason string, count int) {
+	if g.metricsLevel == configuration.METRICS_NONE {
+		return
+	}
+
+	messagesDropped.With(prometheus.Labels{"source": client, "reason": reason}).Add(float64(count))
+}
+
+func dropReason(err error) string {
+	if errors.Is(err, errTooLarge) {
+		return "too_large"
+	}
+
+	return "malformed"
+}
+
+func (g *GelfSource) rejectSource(client string) {
+	g.logger.Debugf("rejecting message from %s: source not allowed", client)
+
+	if g.metricsLevel != configuration.METRICS_NONE {
+		ipfilter.Rejected.With(prometheus.Labels{"datasource": dataSourceName}).Inc()
+	}
+}
+
+func addrIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+// scanNull is a bufio.SplitFunc for null-delimited messages.
+func scanNull(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
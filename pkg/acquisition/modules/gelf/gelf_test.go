@@ -0,0 +1,335 @@
+package gelfacquisition
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		config      string
+		expectedErr string
+	}{
+		{
+			config:      `foobar: asd`,
+			expectedErr: `cannot parse gelf datasource configuration: [1:1] unknown field "foobar"`,
+		},
+		{
+			config: `
+source: gelf
+protocol: http`,
+			expectedErr: "invalid protocol 'http': must be udp or tcp",
+		},
+		{
+			config: `
+source: gelf
+listen_addr: localhost`,
+			expectedErr: "invalid listen_addr 'localhost'",
+		},
+		{
+			config: `
+source: gelf
+listen_port: 70000`,
+			expectedErr: "invalid listen_port 70000",
+		},
+		{
+			config: `
+source: gelf
+mode: cat`,
+			expectedErr: "unsupported mode cat for gelf datasource",
+		},
+		{
+			config: `
+source: gelf
+max_connections: 10`,
+			expectedErr: "max_connections is only supported with tcp",
+		},
+		{
+			config: `
+source: gelf
+denied_sources:
+  - 10.0.0.0/8
+  - 192.168.1.300`,
+			expectedErr: "denied_sources: invalid address '192.168.1.300'",
+		},
+		{
+			config: `
+source: gelf
+protocol: tcp
+max_connections: 10
+listen_addr: 0.0.0.0
+chunk_timeout: 2s
+max_message_size: 4096`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.config, func(t *testing.T) {
+			g := GelfSource{}
+			err := g.Configure([]byte(tc.config), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	msg, err := parseMessage([]byte(`{
+		"version": "1.1",
+		"host": "web-1",
+		"short_message": "GET /login 401",
+		"timestamp": 1700000000.25,
+		"level": 4,
+		"_user": "admin",
+		"_status": 401,
+		"_tls": true,
+		"_id": "ignored",
+		"_nested": {"ignored": true}
+	}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, "web-1", msg.Host)
+	assert.Equal(t, "GET /login 401", msg.ShortMessage)
+	assert.Equal(t, time.Unix(1700000000, 250000000).UTC(), msg.Timestamp)
+	assert.Equal(t, "4", msg.Level)
+	assert.Equal(t, map[string]string{"user": "admin", "status": "401", "tls": "true"}, msg.Fields)
+
+	_, err = parseMessage([]byte(`{"host": "web-1"}`))
+	require.EqualError(t, err, "missing short_message")
+
+	_, err = parseMessage([]byte(`not json`))
+	cstest.RequireErrorContains(t, err, "invalid JSON")
+}
+
+func compress(t *testing.T, algo string, data []byte) []byte {
+	var buf bytes.Buffer
+
+	switch algo {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	case "zlib":
+		w := zlib.NewWriter(&buf)
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	payload := []byte(`{"short_message": "hello"}`)
+
+	for _, algo := range []string{"gzip", "zlib"} {
+		data, err := decompress(compress(t, algo, payload), 1024)
+		require.NoError(t, err, algo)
+		assert.Equal(t, payload, data, algo)
+
+		_, err = decompress(compress(t, algo, payload), 10)
+		require.ErrorIs(t, err, errTooLarge, algo)
+	}
+
+	data, err := decompress(payload, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, payload, data)
+
+	_, err = decompress(payload, 10)
+	require.ErrorIs(t, err, errTooLarge)
+}
+
+func chunks(id string, payload []byte, size int) [][]byte {
+	var ret [][]byte
+
+	count := (len(payload) + size - 1) / size
+
+	for i := range count {
+		chunk := append([]byte{}, chunkMagic...)
+		chunk = append(chunk, []byte(id)...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, payload[i*size:min((i+1)*size, len(payload))]...)
+		ret = append(ret, chunk)
+	}
+
+	return ret
+}
+
+func TestChunkAssembler(t *testing.T) {
+	now := time.Now()
+	a := newChunkAssembler(5*time.Second, 1024, 2)
+
+	payload := []byte(`{"short_message": "a chunked message"}`)
+	parts := chunks("msgid-01", payload, 10)
+	require.Len(t, parts, 4)
+
+	// out of order, with a duplicate
+	for _, i := range []int{2, 0, 0, 3} {
+		data, err := a.add(parts[i], "1.2.3.4", now)
+		require.NoError(t, err)
+		assert.Nil(t, data)
+	}
+
+	data, err := a.add(parts[1], "1.2.3.4", now)
+	require.NoError(t, err)
+	assert.Equal(t, payload, data)
+	assert.Empty(t, a.pending)
+
+	// incomplete messages expire
+	_, err = a.add(chunks("msgid-02", payload, 10)[0], "1.2.3.4", now)
+	require.NoError(t, err)
+	_, err = a.add(chunks("msgid-03", payload, 10)[0], "5.6.7.8", now)
+	require.NoError(t, err)
+
+	_, err = a.add(chunks("msgid-04", payload, 10)[0], "5.6.7.8", now)
+	require.EqualError(t, err, "too many incomplete messages")
+
+	assert.Empty(t, a.expire(now.Add(time.Second)))
+	assert.ElementsMatch(t, []string{"1.2.3.4", "5.6.7.8"}, a.expire(now.Add(6*time.Second)))
+	assert.Empty(t, a.pending)
+
+	// too large once reassembled
+	small := newChunkAssembler(5*time.Second, 15, 10)
+	parts = chunks("msgid-05", payload, 10)
+	_, err = small.add(parts[0], "1.2.3.4", now)
+	require.NoError(t, err)
+	_, err = small.add(parts[1], "1.2.3.4", now)
+	require.ErrorIs(t, err, errTooLarge)
+
+	_, err = a.add([]byte{0x1e, 0x0f, 1, 2}, "1.2.3.4", now)
+	require.EqualError(t, err, "chunk too short")
+
+	_, err = a.add(append([]byte{0x1e, 0x0f}, []byte("msgid-06\x05\x02")...), "1.2.3.4", now)
+	require.EqualError(t, err, "invalid chunk sequence")
+}
+
+func startSource(t *testing.T, config string) (chan types.Event, *tomb.Tomb) {
+	g := GelfSource{}
+	err := g.Configure([]byte(config), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	out := make(chan types.Event, 10)
+	tmb := &tomb.Tomb{}
+
+	require.NoError(t, g.StreamingAcquisition(t.Context(), out, tmb))
+
+	t.Cleanup(func() {
+		tmb.Kill(nil)
+		require.NoError(t, tmb.Wait())
+	})
+
+	return out, tmb
+}
+
+func readEvent(t *testing.T, out chan types.Event) types.Event {
+	select {
+	case evt := <-out:
+		return evt
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for an event")
+	}
+
+	return types.Event{}
+}
+
+func TestStreamingUDP(t *testing.T) {
+	out, _ := startSource(t, `
+source: gelf
+listen_port: 49301
+labels:
+  type: gelf`)
+
+	conn, err := net.Dial("udp", "127.0.0.1:49301")
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	_, err = conn.Write(compress(t, "zlib", []byte(`{"host": "web-1", "short_message": "unchunked", "_user": "admin"}`)))
+	require.NoError(t, err)
+
+	evt := readEvent(t, out)
+	assert.Equal(t, "unchunked", evt.Line.Raw)
+	assert.Equal(t, "127.0.0.1", evt.Line.Src)
+	assert.Equal(t, "gelf", evt.Line.Labels["type"])
+	assert.Equal(t, "web-1", evt.Meta["gelf_host"])
+	assert.Equal(t, "admin", evt.Meta["gelf_user"])
+
+	payload := compress(t, "gzip", []byte(`{"host": "web-2", "short_message": "chunked", "timestamp": 1700000000}`))
+
+	for _, chunk := range chunks("msgid-01", payload, 20) {
+		_, err = conn.Write(chunk)
+		require.NoError(t, err)
+	}
+
+	evt = readEvent(t, out)
+	assert.Equal(t, "chunked", evt.Line.Raw)
+	assert.Equal(t, "web-2", evt.Meta["gelf_host"])
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), evt.Line.Time)
+
+	// malformed messages are dropped
+	_, err = conn.Write([]byte(`{"host": "web-1"}`))
+	require.NoError(t, err)
+
+	select {
+	case evt := <-out:
+		t.Fatalf("unexpected event %s", evt.Line.Raw)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestStreamingUDPDeniedSources(t *testing.T) {
+	out, _ := startSource(t, `
+source: gelf
+listen_port: 49303
+allowed_sources:
+  - 10.0.0.0/8`)
+
+	conn, err := net.Dial("udp", "127.0.0.1:49303")
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(`{"host": "web-1", "short_message": "rejected"}`))
+	require.NoError(t, err)
+
+	select {
+	case evt := <-out:
+		t.Fatalf("unexpected event %s", evt.Line.Raw)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestStreamingTCP(t *testing.T) {
+	out, _ := startSource(t, `
+source: gelf
+protocol: tcp
+listen_port: 49302`)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:49302")
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("{\"short_message\": \"first\"}\x00{\"short_message\": \"second\", \"level\": 3}\x00"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "first", readEvent(t, out).Line.Raw)
+
+	evt := readEvent(t, out)
+	assert.Equal(t, "second", evt.Line.Raw)
+	assert.Equal(t, "3", evt.Meta["gelf_level"])
+}
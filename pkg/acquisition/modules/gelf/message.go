@@ -0,0 +1,123 @@
+package gelfacquisition
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errTooLarge = errors.New("message too large")
+
+// message is a decoded GELF payload. Custom fields are the ones starting with an underscore.
+type message struct {
+	Host         string
+	ShortMessage string
+	FullMessage  string
+	Timestamp    time.Time
+	Level        string
+	Fields       map[string]string
+}
+
+// decompress returns the payload as-is if it is not compressed, or its gzip or zlib decoded
+// content. The decoded size is limited to maxSize.
+func decompress(payload []byte, maxSize int) ([]byte, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+
+	switch {
+	case len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(payload))
+	case len(payload) >= 2 && payload[0]&0x0f == 8 && (uint16(payload[0])<<8|uint16(payload[1]))%31 == 0:
+		r, err = zlib.NewReader(bytes.NewReader(payload))
+	default:
+		if len(payload) > maxSize {
+			return nil, errTooLarge
+		}
+
+		return payload, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxSize {
+		return nil, errTooLarge
+	}
+
+	return data, nil
+}
+
+// parseMessage decodes a GELF JSON payload. Only short_message is mandatory: host and version
+// are required by the specification, but some clients omit them.
+func parseMessage(data []byte) (*message, error) {
+	var raw map[string]any
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	msg := &message{Fields: map[string]string{}}
+
+	var ok bool
+
+	if msg.ShortMessage, ok = raw["short_message"].(string); !ok || msg.ShortMessage == "" {
+		return nil, errors.New("missing short_message")
+	}
+
+	msg.Host, _ = raw["host"].(string)
+	msg.FullMessage, _ = raw["full_message"].(string)
+
+	if ts, ok := raw["timestamp"].(json.Number); ok {
+		seconds, err := ts.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %w", err)
+		}
+
+		sec, frac := math.Modf(seconds)
+		msg.Timestamp = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+	}
+
+	if level, ok := raw["level"].(json.Number); ok {
+		msg.Level = level.String()
+	}
+
+	for key, value := range raw {
+		name, ok := strings.CutPrefix(key, "_")
+		if !ok || name == "" || name == "id" {
+			// _id is reserved by the specification
+			continue
+		}
+
+		switch v := value.(type) {
+		case string:
+			msg.Fields[name] = v
+		case json.Number:
+			msg.Fields[name] = v.String()
+		case bool:
+			msg.Fields[name] = strconv.FormatBool(v)
+		}
+	}
+
+	return msg, nil
+}
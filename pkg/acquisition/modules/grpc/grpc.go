@@ -0,0 +1,361 @@
+package grpcacquisition
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/trace"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/grpc/protobufs"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const (
+	dataSourceName    = "grpc"
+	defaultField      = "message"
+	initialBackoff    = 1 * time.Second
+	defaultMaxBackoff = 1 * time.Minute
+)
+
+var linesRead = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_grpcsource_hits_total",
+		Help: "Total lines that were read from the stream",
+	},
+	[]string{"endpoint"})
+
+var connected = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "cs_grpcsource_connected",
+		Help: "Whether the datasource is receiving logs from the endpoint (1) or not (0).",
+	},
+	[]string{"endpoint"})
+
+type GRPCConfiguration struct {
+	Endpoint   string            `yaml:"endpoint"`
+	Field      string            `yaml:"field"`    // "message", or the name of one of the fields of the messages
+	Selector   map[string]string `yaml:"selector"` // sent to the server when subscribing
+	Insecure   bool              `yaml:"insecure"` // connect without TLS
+	TLS        *TLSConfig        `yaml:"tls"`
+	AuthToken  string            `yaml:"auth_token"`  // sent as a bearer token in the authorization metadata
+	Metadata   map[string]string `yaml:"metadata"`    // sent with the subscription
+	MaxBackoff time.Duration     `yaml:"max_backoff"` // max delay between two reconnections
+
+	configuration.DataSourceCommonCfg `yaml:",inline"`
+}
+
+type TLSConfig struct {
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	ClientCert         string `yaml:"client_cert"`
+	ClientKey          string `yaml:"client_key"`
+	CaCert             string `yaml:"ca_cert"`
+}
+
+type GRPCSource struct {
+	metricsLevel int
+	Config       GRPCConfiguration
+	logger       *log.Entry
+	conn         *grpc.ClientConn
+}
+
+func (g *GRPCSource) GetUuid() string {
+	return g.Config.UniqueId
+}
+
+func (g *GRPCSource) UnmarshalConfig(yamlConfig []byte) error {
+	g.Config = GRPCConfiguration{}
+
+	err := yaml.UnmarshalWithOptions(yamlConfig, &g.Config, yaml.Strict())
+	if err != nil {
+		return fmt.Errorf("cannot parse %s datasource configuration: %s", dataSourceName, yaml.FormatError(err, false, false))
+	}
+
+	if g.Config.Endpoint == "" {
+		return errors.New("endpoint is required")
+	}
+
+	if g.Config.Field == "" {
+		g.Config.Field = defaultField
+	}
+
+	if g.Config.Insecure && g.Config.TLS != nil {
+		return errors.New("tls cannot be used with insecure")
+	}
+
+	if g.Config.MaxBackoff < 0 {
+		return errors.New("max_backoff must be positive")
+	}
+
+	if g.Config.MaxBackoff == 0 {
+		g.Config.MaxBackoff = defaultMaxBackoff
+	}
+
+	if g.Config.Mode == "" {
+		g.Config.Mode = configuration.TAIL_MODE
+	}
+
+	if g.Config.Mode != configuration.TAIL_MODE {
+		return fmt.Errorf("unsupported mode %s for %s datasource", g.Config.Mode, dataSourceName)
+	}
+
+	return nil
+}
+
+func (g *GRPCSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLevel int) error {
+	g.logger = logger
+	g.metricsLevel = metricsLevel
+
+	err := g.UnmarshalConfig(yamlConfig)
+	if err != nil {
+		return err
+	}
+
+	creds := insecure.NewCredentials()
+
+	if !g.Config.Insecure {
+		tlsConfig := &tls.Config{}
+
+		if g.Config.TLS != nil {
+			tlsConfig, err = g.Config.TLS.newTLSConfig()
+			if err != nil {
+				return err
+			}
+		}
+
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	// doesn't connect yet
+	g.conn, err = grpc.NewClient(g.Config.Endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return fmt.Errorf("cannot create %s client: %w", dataSourceName, err)
+	}
+
+	return nil
+}
+
+func (c *TLSConfig) newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("while loading client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CaCert != "" {
+		caCert, err := os.ReadFile(c.CaCert)
+		if err != nil {
+			return nil, fmt.Errorf("while reading CA certificate: %w", err)
+		}
+
+		caCertPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load system CA certificates: %w", err)
+		}
+
+		if caCertPool == nil {
+			caCertPool = x509.NewCertPool()
+		}
+
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no valid certificate found in %s", c.CaCert)
+		}
+
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	return tlsConfig, nil
+}
+
+func (*GRPCSource) ConfigureByDSN(string, map[string]string, *log.Entry, string) error {
+	return fmt.Errorf("%s datasource does not support command-line acquisition", dataSourceName)
+}
+
+func (g *GRPCSource) GetMode() string {
+	return g.Config.Mode
+}
+
+func (*GRPCSource) GetName() string {
+	return dataSourceName
+}
+
+func (*GRPCSource) OneShotAcquisition(_ context.Context, _ chan types.Event, _ *tomb.Tomb) error {
+	return fmt.Errorf("%s datasource does not support one-shot acquisition", dataSourceName)
+}
+
+func (*GRPCSource) CanRun() error {
+	return nil
+}
+
+func (*GRPCSource) GetMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, connected}
+}
+
+func (*GRPCSource) GetAggregMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, connected}
+}
+
+func (g *GRPCSource) Dump() any {
+	return g
+}
+
+func (g *GRPCSource) setConnected(value float64) {
+	if g.metricsLevel == configuration.METRICS_NONE {
+		return
+	}
+
+	connected.With(prometheus.Labels{"endpoint": g.Config.Endpoint}).Set(value)
+}
+
+func (g *GRPCSource) emit(msg *protobufs.LogMessage, out chan types.Event) {
+	raw := msg.GetMessage()
+	if g.Config.Field != defaultField {
+		raw = msg.GetFields()[g.Config.Field]
+	}
+
+	if raw == "" {
+		g.logger.Debugf("skipping message without %s", g.Config.Field)
+		return
+	}
+
+	ts := time.Now().UTC()
+	if msg.GetTimestamp() != 0 {
+		ts = time.Unix(0, msg.GetTimestamp()).UTC()
+	}
+
+	l := types.Line{
+		Raw:     raw,
+		Labels:  g.Config.Labels,
+		Time:    ts,
+		Src:     g.Config.Endpoint,
+		Process: true,
+		Module:  g.GetName(),
+	}
+
+	if g.metricsLevel != configuration.METRICS_NONE {
+		linesRead.With(prometheus.Labels{"endpoint": g.Config.Endpoint}).Inc()
+	}
+
+	evt := types.MakeEvent(g.Config.UseTimeMachine, types.LOG, true)
+	evt.Line = l
+
+	for name, value := range msg.GetFields() {
+		evt.Meta["grpc_"+name] = value
+	}
+
+	out <- evt
+}
+
+// subscribe reads one stream until it ends. It returns whether a message was received, to reset
+// the reconnection backoff.
+func (g *GRPCSource) subscribe(ctx context.Context, out chan types.Event) (bool, error) {
+	md := metadata.New(g.Config.Metadata)
+	if g.Config.AuthToken != "" {
+		md.Set("authorization", "Bearer "+g.Config.AuthToken)
+	}
+
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(ctx, md))
+	defer cancel()
+
+	client := protobufs.NewLogStreamClient(g.conn)
+
+	stream, err := client.Subscribe(ctx, &protobufs.SubscribeRequest{Selector: g.Config.Selector})
+	if err != nil {
+		return false, err
+	}
+
+	received := false
+
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return received, errors.New("stream closed by the server")
+		}
+
+		if err != nil {
+			return received, err
+		}
+
+		if !received {
+			received = true
+
+			g.setConnected(1)
+			g.logger.Infof("receiving logs from %s", g.Config.Endpoint)
+		}
+
+		g.emit(msg, out)
+	}
+}
+
+func (g *GRPCSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	t.Go(func() error {
+		defer trace.CatchPanic("crowdsec/acquis/grpc/live")
+		defer cancel()
+		defer g.conn.Close()
+
+		// stop a stream that is waiting for messages
+		go func() {
+			select {
+			case <-t.Dying():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		backoff := initialBackoff
+
+		for {
+			g.logger.Infof("subscribing to %s", g.Config.Endpoint)
+
+			received, err := g.subscribe(ctx, out)
+
+			g.setConnected(0)
+
+			if ctx.Err() != nil {
+				g.logger.Infof("%s datasource stopping", dataSourceName)
+				return nil
+			}
+
+			if received {
+				backoff = initialBackoff
+			}
+
+			g.logger.Warnf("stream from %s ended, reconnecting in %s: %s", g.Config.Endpoint, backoff, err)
+
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				g.logger.Infof("%s datasource stopping", dataSourceName)
+				return nil
+			}
+
+			backoff = min(backoff*2, g.Config.MaxBackoff)
+		}
+	})
+
+	return nil
+}
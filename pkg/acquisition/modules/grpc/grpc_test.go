@@ -0,0 +1,188 @@
+package grpcacquisition
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/grpc/protobufs"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		config      string
+		expectedErr string
+	}{
+		{
+			config: `
+foobar: bla
+source: grpc`,
+			expectedErr: `[2:1] unknown field "foobar"`,
+		},
+		{
+			config:      `source: grpc`,
+			expectedErr: "endpoint is required",
+		},
+		{
+			config: `
+source: grpc
+endpoint: localhost:9443
+mode: cat`,
+			expectedErr: "unsupported mode cat for grpc datasource",
+		},
+		{
+			config: `
+source: grpc
+endpoint: localhost:9443
+insecure: true
+tls:
+  insecure_skip_verify: true`,
+			expectedErr: "tls cannot be used with insecure",
+		},
+		{
+			config: `
+source: grpc
+endpoint: localhost:9443
+max_backoff: -1s`,
+			expectedErr: "max_backoff must be positive",
+		},
+		{
+			config: `
+source: grpc
+endpoint: localhost:9443
+tls:
+  ca_cert: /does/not/exist`,
+			expectedErr: "while reading CA certificate",
+		},
+		{
+			config: `
+source: grpc
+endpoint: localhost:9443
+auth_token: secret
+field: msg`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.config, func(t *testing.T) {
+			g := GRPCSource{}
+			err := g.Configure([]byte(tc.config), log.WithField("type", "grpc"), configuration.METRICS_NONE)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+// testServer sends the messages of a batch on each subscription, then closes the stream.
+type testServer struct {
+	protobufs.UnimplementedLogStreamServer
+
+	batches chan []*protobufs.LogMessage
+}
+
+func (s *testServer) Subscribe(req *protobufs.SubscribeRequest, stream grpc.ServerStreamingServer[protobufs.LogMessage]) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if got := md.Get("authorization"); len(got) != 1 || got[0] != "Bearer secret" {
+		return status.Error(codes.Unauthenticated, "bad token")
+	}
+
+	if req.GetSelector()["app"] != "test" {
+		return status.Error(codes.InvalidArgument, "bad selector")
+	}
+
+	select {
+	case batch := <-s.batches:
+		for _, msg := range batch {
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case <-stream.Context().Done():
+		return nil
+	}
+}
+
+func TestStreamingAcquisition(t *testing.T) {
+	ctx := t.Context()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &testServer{batches: make(chan []*protobufs.LogMessage, 2)}
+	server := grpc.NewServer()
+	protobufs.RegisterLogStreamServer(server, srv)
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	defer server.Stop()
+
+	g := GRPCSource{}
+	err = g.Configure([]byte(`
+source: grpc
+endpoint: `+listener.Addr().String()+`
+insecure: true
+auth_token: secret
+field: msg
+selector:
+  app: test
+labels:
+  type: test`), log.WithField("type", "grpc"), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	srv.batches <- []*protobufs.LogMessage{
+		{Message: "ignored", Timestamp: ts.UnixNano(), Fields: map[string]string{"msg": "line 1", "host": "web1"}},
+		{Message: "no field"},
+	}
+	// after a reconnection
+	srv.batches <- []*protobufs.LogMessage{
+		{Fields: map[string]string{"msg": "line 2"}},
+	}
+
+	out := make(chan types.Event)
+	tmb := tomb.Tomb{}
+
+	require.NoError(t, g.StreamingAcquisition(ctx, out, &tmb))
+
+	read := func() types.Event {
+		select {
+		case evt := <-out:
+			return evt
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for an event")
+		}
+
+		return types.Event{}
+	}
+
+	evt := read()
+	assert.Equal(t, "line 1", evt.Line.Raw)
+	assert.Equal(t, ts, evt.Line.Time)
+	assert.Equal(t, listener.Addr().String(), evt.Line.Src)
+	assert.Equal(t, "test", evt.Line.Labels["type"])
+	assert.Equal(t, "web1", evt.Meta["grpc_host"])
+
+	evt = read()
+	assert.Equal(t, "line 2", evt.Line.Raw)
+
+	// stops while waiting for messages
+	tmb.Kill(nil)
+	require.NoError(t, tmb.Wait())
+}
@@ -0,0 +1,5 @@
+package protobufs
+
+// See pkg/protobufs/generate.go for the dependencies.
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative logstream.proto
@@ -0,0 +1,124 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v3.21.12
+// source: logstream.proto
+
+package protobufs
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	LogStream_Subscribe_FullMethodName = "/logstream.LogStream/Subscribe"
+)
+
+// LogStreamClient is the client API for LogStream service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LogStreamClient interface {
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LogMessage], error)
+}
+
+type logStreamClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLogStreamClient(cc grpc.ClientConnInterface) LogStreamClient {
+	return &logStreamClient{cc}
+}
+
+func (c *logStreamClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LogMessage], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LogStream_ServiceDesc.Streams[0], LogStream_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeRequest, LogMessage]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogStream_SubscribeClient = grpc.ServerStreamingClient[LogMessage]
+
+// LogStreamServer is the server API for LogStream service.
+// All implementations must embed UnimplementedLogStreamServer
+// for forward compatibility.
+type LogStreamServer interface {
+	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[LogMessage]) error
+	mustEmbedUnimplementedLogStreamServer()
+}
+
+// UnimplementedLogStreamServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLogStreamServer struct{}
+
+func (UnimplementedLogStreamServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[LogMessage]) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedLogStreamServer) mustEmbedUnimplementedLogStreamServer() {}
+func (UnimplementedLogStreamServer) testEmbeddedByValue()                   {}
+
+// UnsafeLogStreamServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LogStreamServer will
+// result in compilation errors.
+type UnsafeLogStreamServer interface {
+	mustEmbedUnimplementedLogStreamServer()
+}
+
+func RegisterLogStreamServer(s grpc.ServiceRegistrar, srv LogStreamServer) {
+	// If the following call pancis, it indicates UnimplementedLogStreamServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&LogStream_ServiceDesc, srv)
+}
+
+func _LogStream_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LogStreamServer).Subscribe(m, &grpc.GenericServerStream[SubscribeRequest, LogMessage]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogStream_SubscribeServer = grpc.ServerStreamingServer[LogMessage]
+
+// LogStream_ServiceDesc is the grpc.ServiceDesc for LogStream service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LogStream_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "logstream.LogStream",
+	HandlerType: (*LogStreamServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _LogStream_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "logstream.proto",
+}
@@ -1,7 +1,6 @@
 package httpacquisition
 
 import (
-	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
@@ -23,6 +22,12 @@ import (
 	"github.com/crowdsecurity/go-cs-lib/trace"
 
 	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/clientip"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/connlimit"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/diskqueue"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/httpbody"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/ipfilter"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/jsonschema"
 	"github.com/crowdsecurity/crowdsec/pkg/csnet"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
@@ -37,7 +42,6 @@ var linesRead = prometheus.NewCounterVec(
 	[]string{"path", "src"})
 
 type HttpConfiguration struct {
-	// IPFilter                       []string           `yaml:"ip_filter"`
 	// ChunkSize                      *int64             `yaml:"chunk_size"`
 	ListenAddr                        string             `yaml:"listen_addr"`
 	ListenSocket                      string             `yaml:"listen_socket"`
@@ -50,6 +54,12 @@ type HttpConfiguration struct {
 	CustomHeaders                     *map[string]string `yaml:"custom_headers"`
 	MaxBodySize                       *int64             `yaml:"max_body_size"`
 	Timeout                           *time.Duration     `yaml:"timeout"`
+	MaxConnections                    int                `yaml:"max_connections"`
+	Body                              httpbody.Config    `yaml:",inline"`
+	Proxies                           clientip.Config    `yaml:",inline"`
+	Queue                             diskqueue.Config   `yaml:",inline"`
+	Schema                            jsonschema.Config  `yaml:",inline"`
+	ipfilter.Config                   `yaml:",inline"`
 	configuration.DataSourceCommonCfg `yaml:",inline"`
 }
 
@@ -70,6 +80,11 @@ type HTTPSource struct {
 	Config       HttpConfiguration
 	logger       *log.Entry
 	Server       *http.Server
+	ipFilter     *ipfilter.Filter
+	bodyDecoder  *httpbody.Decoder
+	clientIP     *clientip.Resolver
+	queue        diskqueue.Queue // with queue_dir, the events are written there before the parsers
+	schema       *jsonschema.Validator
 }
 
 func (h *HTTPSource) GetUuid() string {
@@ -144,6 +159,10 @@ func (hc *HttpConfiguration) Validate() error {
 		return errors.New("max_body_size must be positive")
 	}
 
+	if hc.MaxConnections < 0 {
+		return errors.New("max_connections must be positive")
+	}
+
 	/*
 		if hc.ChunkSize != nil && *hc.ChunkSize <= 0 {
 			return errors.New("chunk_size must be positive")
@@ -173,6 +192,30 @@ func (h *HTTPSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLeve
 		return fmt.Errorf("invalid configuration: %w", err)
 	}
 
+	h.ipFilter, err = ipfilter.New(h.Config.Config)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	h.bodyDecoder, err = httpbody.New(h.Config.Body)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	h.clientIP, err = clientip.New(h.Config.Proxies)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if err := h.Config.Queue.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	h.schema, err = jsonschema.New(h.Config.Schema)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	return nil
 }
 
@@ -197,11 +240,42 @@ func (h *HTTPSource) CanRun() error {
 }
 
 func (h *HTTPSource) GetMetrics() []prometheus.Collector {
-	return []prometheus.Collector{linesRead}
+	return []prometheus.Collector{linesRead, connlimit.Rejected, ipfilter.Rejected, httpbody.Rejected, diskqueue.Size, diskqueue.Dropped, jsonschema.Invalid}
 }
 
 func (h *HTTPSource) GetAggregMetrics() []prometheus.Collector {
-	return []prometheus.Collector{linesRead}
+	return []prometheus.Collector{linesRead, connlimit.Rejected, ipfilter.Rejected, httpbody.Rejected, diskqueue.Size, diskqueue.Dropped, jsonschema.Invalid}
+}
+
+// filterSources applies allowed_sources and denied_sources to a listener, the rejected
+// connections are closed without a response.
+func (h *HTTPSource) filterSources(listener net.Listener) net.Listener {
+	return ipfilter.Listen(listener, h.ipFilter, func(conn net.Conn) {
+		h.logger.Debugf("rejecting connection from %s: source not allowed", conn.RemoteAddr())
+
+		if h.metricsLevel != configuration.METRICS_NONE {
+			ipfilter.Rejected.With(prometheus.Labels{"datasource": dataSourceName}).Inc()
+		}
+	})
+}
+
+// limitConnections applies max_connections to a listener. Over plain HTTP, the rejected
+// clients get a 503 response; over TLS, the connection is closed before the handshake.
+func (h *HTTPSource) limitConnections(listener net.Listener, addr string) net.Listener {
+	return connlimit.Listen(listener, h.Config.MaxConnections, func(conn net.Conn) {
+		h.logger.Debugf("rejecting connection from %s: max_connections reached", conn.RemoteAddr())
+
+		if h.metricsLevel != configuration.METRICS_NONE {
+			connlimit.Rejected.With(prometheus.Labels{"datasource": dataSourceName, "addr": addr}).Inc()
+		}
+
+		if h.Config.TLS != nil {
+			return
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_, _ = conn.Write([]byte("HTTP/1.1 503 Service Unavailable\r\nConnection: close\r\nContent-Length: 0\r\n\r\n"))
+	})
 }
 
 func (h *HTTPSource) Dump() interface{} {
@@ -268,17 +342,24 @@ func authorizeRequest(r *http.Request, hc *HttpConfiguration) error {
 	return nil
 }
 
-func (h *HTTPSource) processRequest(w http.ResponseWriter, r *http.Request, hc *HttpConfiguration, out chan types.Event) error {
+// checkBodySize applies max_body_size to the body as sent, before decompression.
+func checkBodySize(w http.ResponseWriter, r *http.Request, hc *HttpConfiguration) error {
 	if hc.MaxBodySize != nil && r.ContentLength > *hc.MaxBodySize {
 		w.WriteHeader(http.StatusRequestEntityTooLarge)
 		return fmt.Errorf("body size exceeds max body size: %d > %d", r.ContentLength, *hc.MaxBodySize)
 	}
 
-	srcHost, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
+	return nil
+}
+
+func (h *HTTPSource) processRequest(w http.ResponseWriter, r *http.Request, hc *HttpConfiguration, out chan types.Event) error {
+	if _, _, err := net.SplitHostPort(r.RemoteAddr); err != nil {
 		return err
 	}
 
+	// the peer address, or the client behind a trusted proxy
+	srcHost := h.clientIP.ClientIP(r)
+
 	defer r.Body.Close()
 
 	if h.logger.Logger.IsLevelEnabled(log.TraceLevel) {
@@ -291,18 +372,8 @@ func (h *HTTPSource) processRequest(w http.ResponseWriter, r *http.Request, hc *
 		}
 	}
 
-	reader := r.Body
-
-	if r.Header.Get("Content-Encoding") == "gzip" {
-		reader, err = gzip.NewReader(r.Body)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return fmt.Errorf("failed to create gzip reader: %w", err)
-		}
-		defer reader.Close()
-	}
-
-	decoder := json.NewDecoder(reader)
+	// the body has already been decompressed, if needed
+	decoder := json.NewDecoder(r.Body)
 
 	for {
 		var message json.RawMessage
@@ -317,6 +388,17 @@ func (h *HTTPSource) processRequest(w http.ResponseWriter, r *http.Request, hc *
 			return fmt.Errorf("failed to decode: %w", err)
 		}
 
+		// the lines of the body before the invalid one were already sent
+		if err := h.schema.Validate(message); err != nil {
+			if h.metricsLevel != configuration.METRICS_NONE {
+				jsonschema.Invalid.With(prometheus.Labels{"datasource": dataSourceName}).Inc()
+			}
+
+			w.WriteHeader(http.StatusBadRequest)
+
+			return fmt.Errorf("invalid event: %w", err)
+		}
+
 		line := types.Line{
 			Raw:     string(message),
 			Src:     srcHost,
@@ -332,6 +414,7 @@ func (h *HTTPSource) processRequest(w http.ResponseWriter, r *http.Request, hc *
 
 		evt := types.MakeEvent(h.Config.UseTimeMachine, types.LOG, true)
 		evt.Line = line
+		evt.Meta["http_client_ip"] = srcHost
 
 		if h.metricsLevel == configuration.METRICS_AGGREGATE {
 			linesRead.With(prometheus.Labels{"path": hc.Path, "src": ""}).Inc()
@@ -340,13 +423,54 @@ func (h *HTTPSource) processRequest(w http.ResponseWriter, r *http.Request, hc *
 		}
 
 		h.logger.Tracef("line to send: %+v", line)
-		out <- evt
+
+		if h.queue == nil {
+			out <- evt
+			continue
+		}
+
+		// the client retries, the lines of the body that were already queued are sent again
+		if err := h.queue.Push(evt); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return fmt.Errorf("failed to queue event: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// rejectBody is called by the decompression middleware for the rejected requests.
+func (h *HTTPSource) rejectBody(r *http.Request, reason string) {
+	h.logger.Errorf("rejecting request from '%s': %s", r.RemoteAddr, reason)
+
+	if h.metricsLevel != configuration.METRICS_NONE {
+		httpbody.Rejected.With(prometheus.Labels{"datasource": dataSourceName, "reason": reason}).Inc()
+	}
+}
+
 func (h *HTTPSource) RunServer(out chan types.Event, t *tomb.Tomb) error {
+	push := httpbody.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := h.processRequest(w, r, &h.Config, out)
+		if err != nil {
+			h.logger.Errorf("failed to process request from '%s': %s", r.RemoteAddr, err)
+			return
+		}
+
+		if h.Config.CustomHeaders != nil {
+			for key, value := range *h.Config.CustomHeaders {
+				w.Header().Set(key, value)
+			}
+		}
+
+		if h.Config.CustomStatusCode != nil {
+			w.WriteHeader(*h.Config.CustomStatusCode)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+
+		w.Write([]byte("OK"))
+	}), h.bodyDecoder, h.rejectBody)
+
 	mux := http.NewServeMux()
 	mux.HandleFunc(h.Config.Path, func(w http.ResponseWriter, r *http.Request) {
 		if err := authorizeRequest(r, &h.Config); err != nil {
@@ -374,25 +498,12 @@ func (h *HTTPSource) RunServer(out chan types.Event, t *tomb.Tomb) error {
 			r.RemoteAddr = "127.0.0.1:65535"
 		}
 
-		err := h.processRequest(w, r, &h.Config, out)
-		if err != nil {
+		if err := checkBodySize(w, r, &h.Config); err != nil {
 			h.logger.Errorf("failed to process request from '%s': %s", r.RemoteAddr, err)
 			return
 		}
 
-		if h.Config.CustomHeaders != nil {
-			for key, value := range *h.Config.CustomHeaders {
-				w.Header().Set(key, value)
-			}
-		}
-
-		if h.Config.CustomStatusCode != nil {
-			w.WriteHeader(*h.Config.CustomStatusCode)
-		} else {
-			w.WriteHeader(http.StatusOK)
-		}
-
-		w.Write([]byte("OK"))
+		push.ServeHTTP(w, r)
 	})
 
 	h.Server = &http.Server{
@@ -431,6 +542,7 @@ func (h *HTTPSource) RunServer(out chan types.Event, t *tomb.Tomb) error {
 		if err != nil {
 			return csnet.WrapSockErr(err, h.Config.ListenSocket)
 		}
+		listener = h.limitConnections(h.filterSources(listener), h.Config.ListenSocket)
 		if h.Config.TLS != nil {
 			err := h.Server.ServeTLS(listener, h.Config.TLS.ServerCert, h.Config.TLS.ServerKey)
 			if err != nil && err != http.ErrServerClosed {
@@ -453,17 +565,24 @@ func (h *HTTPSource) RunServer(out chan types.Event, t *tomb.Tomb) error {
 
 		defer trace.CatchPanic("crowdsec/acquis/http/server/tcp")
 
+		listener, err := net.Listen("tcp", h.Config.ListenAddr)
+		if err != nil {
+			return fmt.Errorf("http server failed: %w", err)
+		}
+
+		listener = h.limitConnections(h.filterSources(listener), h.Config.ListenAddr)
+
 		if h.Config.TLS != nil {
 			h.logger.Infof("start https server on %s", h.Config.ListenAddr)
 
-			err := h.Server.ListenAndServeTLS(h.Config.TLS.ServerCert, h.Config.TLS.ServerKey)
+			err := h.Server.ServeTLS(listener, h.Config.TLS.ServerCert, h.Config.TLS.ServerKey)
 			if err != nil && err != http.ErrServerClosed {
 				return fmt.Errorf("https server failed: %w", err)
 			}
 		} else {
 			h.logger.Infof("start http server on %s", h.Config.ListenAddr)
 
-			err := h.Server.ListenAndServe()
+			err := h.Server.Serve(listener)
 			if err != nil && err != http.ErrServerClosed {
 				return fmt.Errorf("http server failed: %w", err)
 			}
@@ -488,6 +607,20 @@ func (h *HTTPSource) RunServer(out chan types.Event, t *tomb.Tomb) error {
 func (h *HTTPSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
 	h.logger.Debugf("start http server on %s", h.Config.ListenAddr)
 
+	var err error
+
+	h.queue, err = diskqueue.Open(h.Config.Queue, h.logger)
+	if err != nil {
+		return err
+	}
+
+	if h.queue != nil {
+		t.Go(func() error {
+			defer trace.CatchPanic("crowdsec/acquis/http/queue")
+			return diskqueue.Forward(h.queue, out, t)
+		})
+	}
+
 	t.Go(func() error {
 		defer trace.CatchPanic("crowdsec/acquis/http/live")
 		return h.RunServer(out, t)
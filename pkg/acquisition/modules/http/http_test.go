@@ -154,6 +154,18 @@ source: http
 listen_addr: 127.0.0.1:8080
 path: /test
 auth_type: headers
+headers:
+  key: value
+content_encodings:
+  - br`,
+			expectedErr: "invalid configuration: content_encodings: unsupported encoding 'br'",
+		},
+		{
+			config: `
+source: http
+listen_addr: 127.0.0.1:8080
+path: /test
+auth_type: headers
 headers:
   key: value
 timeout: toto`,
@@ -170,6 +182,53 @@ headers:
 custom_status_code: 999`,
 			expectedErr: "invalid configuration: invalid HTTP status code",
 		},
+		{
+			config: `
+source: http
+listen_addr: 127.0.0.1:8080
+path: /test
+auth_type: headers
+headers:
+  key: value
+denied_sources:
+  - 10.0.0.0/33`,
+			expectedErr: "invalid configuration: denied_sources: invalid range '10.0.0.0/33'",
+		},
+		{
+			config: `
+source: http
+listen_addr: 127.0.0.1:8080
+path: /test
+auth_type: headers
+headers:
+  key: value
+queue_max_size: 1048576`,
+			expectedErr: "invalid configuration: queue_max_size requires queue_dir",
+		},
+		{
+			config: `
+source: http
+listen_addr: 127.0.0.1:8080
+path: /test
+auth_type: headers
+headers:
+  key: value
+trusted_proxies:
+  - 10.0.0.0/8
+  - proxy.local`,
+			expectedErr: "invalid configuration: trusted_proxies: invalid address 'proxy.local'",
+		},
+		{
+			config: `
+source: http
+listen_addr: 127.0.0.1:8080
+path: /test
+auth_type: headers
+headers:
+  key: value
+json_schema_file: /does/not/exist.json`,
+			expectedErr: "invalid configuration: json_schema_file: open /does/not/exist.json",
+		},
 	}
 
 	subLogger := log.WithFields(log.Fields{
@@ -416,6 +475,85 @@ max_body_size: 5`), 0)
 	require.NoError(t, err)
 }
 
+func TestStreamingAcquisitionMaxConnections(t *testing.T) {
+	ctx := t.Context()
+	h := &HTTPSource{}
+	_, _, tomb := SetupAndRunHTTPSource(t, h, []byte(`
+source: http
+listen_addr: 127.0.0.1:8080
+path: /test
+auth_type: headers
+headers:
+  key: test
+max_connections: 1`), 0)
+
+	time.Sleep(1 * time.Second)
+
+	// an idle connection holds the only slot
+	held, err := net.Dial("tcp", "127.0.0.1:8080")
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	get := func() int {
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/test", testHTTPServerAddr), http.NoBody)
+		require.NoError(t, err)
+
+		req.Header.Add("Key", "test")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, get())
+
+	held.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, http.StatusOK, get())
+
+	h.Server.Close()
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
+func TestStreamingAcquisitionDeniedSources(t *testing.T) {
+	ctx := t.Context()
+	h := &HTTPSource{}
+	_, _, tomb := SetupAndRunHTTPSource(t, h, []byte(`
+source: http
+listen_addr: 127.0.0.1:8080
+path: /test
+auth_type: headers
+headers:
+  key: test
+allowed_sources:
+  - 127.0.0.0/8
+denied_sources:
+  - 127.0.0.1`), 0)
+
+	time.Sleep(1 * time.Second)
+
+	client := &http.Client{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/test", testHTTPServerAddr), http.NoBody)
+	require.NoError(t, err)
+
+	req.Header.Add("Key", "test")
+
+	// the connection is closed before any response
+	_, err = client.Do(req)
+	require.Error(t, err)
+
+	h.Server.Close()
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
 func TestStreamingAcquisitionSuccess(t *testing.T) {
 	ctx := t.Context()
 	h := &HTTPSource{}
@@ -838,6 +976,188 @@ headers:
 	require.NoError(t, err)
 }
 
+func TestStreamingAcquisitionCompressedBodyLimits(t *testing.T) {
+	ctx := t.Context()
+	h := &HTTPSource{}
+	// on its own port, the server of a failed test could still be listening on 8080
+	_, _, tomb := SetupAndRunHTTPSource(t, h, []byte(`
+source: http
+listen_addr: 127.0.0.1:8081
+path: /test
+auth_type: headers
+headers:
+  key: test
+content_encodings:
+  - gzip
+max_decompressed_body_size: 100`), 0)
+
+	time.Sleep(1 * time.Second)
+
+	// well under the limit once compressed
+	var b strings.Builder
+	gz := gzip.NewWriter(&b)
+
+	_, err := gz.Write([]byte(`{"test": "` + strings.Repeat("a", 200) + `"}`))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	send := func(encoding string, body string) int {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://127.0.0.1:8081/test", strings.NewReader(body))
+		require.NoError(t, err)
+
+		req.Header.Add("Key", "test")
+		req.Header.Add("Content-Encoding", encoding)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send("gzip", b.String()))
+	assert.Equal(t, http.StatusUnsupportedMediaType, send("deflate", b.String()))
+
+	h.Server.Close()
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
+func TestStreamingAcquisitionTrustedProxies(t *testing.T) {
+	ctx := t.Context()
+	h := &HTTPSource{}
+	out, _, tomb := SetupAndRunHTTPSource(t, h, []byte(`
+source: http
+listen_addr: 127.0.0.1:8081
+path: /test
+auth_type: headers
+headers:
+  key: test
+trusted_proxies:
+  - 127.0.0.1`), 0)
+
+	time.Sleep(1 * time.Second)
+
+	// the request is answered once the event is read
+	events := make(chan types.Event, 1)
+
+	go func() {
+		events <- <-out
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://127.0.0.1:8081/test", strings.NewReader(`{"test": "test"}`))
+	require.NoError(t, err)
+
+	req.Header.Add("Key", "test")
+	req.Header.Add("X-Forwarded-For", "203.0.113.7, 192.0.2.1")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case evt := <-events:
+		// the rightmost address that is not a trusted proxy
+		assert.Equal(t, "192.0.2.1", evt.Line.Src)
+		assert.Equal(t, "192.0.2.1", evt.Meta["http_client_ip"])
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for an event")
+	}
+
+	h.Server.Close()
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
+func TestStreamingAcquisitionQueue(t *testing.T) {
+	ctx := t.Context()
+	h := &HTTPSource{}
+	out, _, tomb := SetupAndRunHTTPSource(t, h, []byte(`
+source: http
+listen_addr: 127.0.0.1:8081
+path: /test
+auth_type: headers
+headers:
+  key: test
+queue_dir: `+t.TempDir()), 0)
+
+	time.Sleep(1 * time.Second)
+
+	// the request is answered once the event is queued, before it's read
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://127.0.0.1:8081/test", strings.NewReader(`{"test": "test"}`))
+	require.NoError(t, err)
+
+	req.Header.Add("Key", "test")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case evt := <-out:
+		assert.JSONEq(t, `{"test": "test"}`, evt.Line.Raw)
+		assert.Equal(t, "127.0.0.1", evt.Meta["http_client_ip"])
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for an event")
+	}
+
+	h.Server.Close()
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
+func TestStreamingAcquisitionJSONSchema(t *testing.T) {
+	ctx := t.Context()
+
+	schemaFile := filepath.Join(t.TempDir(), "schema.json")
+	require.NoError(t, os.WriteFile(schemaFile, []byte(`{"type": "object", "required": ["test"]}`), 0o600))
+
+	h := &HTTPSource{}
+	out, _, tomb := SetupAndRunHTTPSource(t, h, []byte(`
+source: http
+listen_addr: 127.0.0.1:8081
+path: /test
+auth_type: headers
+headers:
+  key: test
+json_schema_file: `+schemaFile), 0)
+
+	time.Sleep(1 * time.Second)
+
+	post := func(body string) int {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://127.0.0.1:8081/test", strings.NewReader(body))
+		require.NoError(t, err)
+
+		req.Header.Add("Key", "test")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	errChan := make(chan error)
+
+	go assertEvents(out, []string{`{"test": "test"}`}, errChan)
+
+	assert.Equal(t, http.StatusBadRequest, post(`{"other": "test"}`))
+	assert.Equal(t, http.StatusOK, post(`{"test": "test"}`))
+
+	err := <-errChan
+	require.NoError(t, err)
+
+	h.Server.Close()
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
 func TestStreamingAcquisitionNDJson(t *testing.T) {
 	ctx := t.Context()
 	h := &HTTPSource{}
@@ -908,6 +1228,6 @@ func assertMetrics(t *testing.T, reg *prometheus.Registry, metrics []prometheus.
 	}
 
 	for _, metric := range metrics {
-		metric.(*prometheus.CounterVec).Reset()
+		metric.(interface{ Reset() }).Reset()
 	}
 }
@@ -0,0 +1,100 @@
+// Package clientip resolves the address of the clients of the HTTP push datasources. Behind a
+// reverse proxy or a load balancer, the client address is taken from the X-Forwarded-For header,
+// but only when the request comes from one of the trusted_proxies: the header is set by the
+// clients themselves otherwise.
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/ipfilter"
+)
+
+// Config is meant to be inlined in the configuration of the datasources.
+type Config struct {
+	TrustedProxies []string `yaml:"trusted_proxies"` // CIDR ranges or IP addresses
+}
+
+// Resolver is a compiled Config. A nil Resolver always uses the address of the peer.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// New compiles the list of proxies. It returns nil if it's empty.
+func New(cfg Config) (*Resolver, error) {
+	if len(cfg.TrustedProxies) == 0 {
+		return nil, nil
+	}
+
+	trusted, err := ipfilter.ParsePrefixes("trusted_proxies", cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resolver{trusted: trusted}, nil
+}
+
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// peerHost returns the host part of http.Request.RemoteAddr.
+func peerHost(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+
+	return remoteAddr
+}
+
+// ClientIP returns the address of the client of a request. When the peer is a trusted proxy,
+// the X-Forwarded-For entries are walked from the right, each one added by the proxy in front
+// of the previous one: the first entry that is not a trusted proxy is the client. The walk
+// stops at the first malformed entry, the last valid hop is used then.
+func (r *Resolver) ClientIP(req *http.Request) string {
+	peer := peerHost(req.RemoteAddr)
+
+	if r == nil {
+		return peer
+	}
+
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !r.isTrusted(addr) {
+		return peer
+	}
+
+	// the header can be repeated, the entries of the last one are the most recent
+	hops := []string{}
+
+	for _, value := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	client := peer
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+
+		client = hop.Unmap().WithZone("").String()
+
+		if !r.isTrusted(hop) {
+			break
+		}
+	}
+
+	return client
+}
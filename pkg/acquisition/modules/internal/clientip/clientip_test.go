@@ -0,0 +1,109 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+)
+
+func TestNew(t *testing.T) {
+	r, err := New(Config{})
+	require.NoError(t, err)
+	assert.Nil(t, r)
+
+	_, err = New(Config{TrustedProxies: []string{"10.0.0.0/33"}})
+	cstest.RequireErrorContains(t, err, "trusted_proxies: invalid range '10.0.0.0/33'")
+}
+
+func TestClientIP(t *testing.T) {
+	r, err := New(Config{TrustedProxies: []string{"10.0.0.0/8", "2001:db8::1"}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		resolver   *Resolver
+		remoteAddr string
+		xff        []string
+		expected   string
+	}{
+		{
+			name:       "no trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"192.0.2.1"},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "untrusted peer",
+			resolver:   r,
+			remoteAddr: "198.51.100.1:1234",
+			xff:        []string{"192.0.2.1"},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "trusted peer",
+			resolver:   r,
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"192.0.2.1"},
+			expected:   "192.0.2.1",
+		},
+		{
+			name:       "trusted peer without header",
+			resolver:   r,
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "spoofed entries on the left are ignored",
+			resolver:   r,
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"203.0.113.7, 192.0.2.1, 10.1.1.1"},
+			expected:   "192.0.2.1",
+		},
+		{
+			name:       "repeated header",
+			resolver:   r,
+			remoteAddr: "[2001:db8::1]:443",
+			xff:        []string{"203.0.113.7", "192.0.2.1,10.2.2.2"},
+			expected:   "192.0.2.1",
+		},
+		{
+			name:       "only trusted hops",
+			resolver:   r,
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"10.3.3.3, 10.2.2.2"},
+			expected:   "10.3.3.3",
+		},
+		{
+			name:       "malformed entry",
+			resolver:   r,
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"192.0.2.1, unknown, 10.2.2.2"},
+			expected:   "10.2.2.2",
+		},
+		{
+			name:       "mapped address",
+			resolver:   r,
+			remoteAddr: "[::ffff:10.0.0.1]:1234",
+			xff:        []string{"::ffff:192.0.2.1"},
+			expected:   "192.0.2.1",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", http.NoBody)
+			req.RemoteAddr = tc.remoteAddr
+
+			for _, value := range tc.xff {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			assert.Equal(t, tc.expected, tc.resolver.ClientIP(req))
+		})
+	}
+}
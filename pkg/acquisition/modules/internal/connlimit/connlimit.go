@@ -0,0 +1,81 @@
+// Package connlimit limits the number of concurrent connections accepted by the push-style
+// datasources. Connections past the limit are rejected as soon as they are accepted, the
+// established ones are not affected.
+package connlimit
+
+import (
+	"net"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Rejected counts the connections rejected because of max_connections, by datasource type
+// and listening address.
+var Rejected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_acquisition_rejected_connections_total",
+		Help: "Total connections rejected because max_connections was reached.",
+	},
+	[]string{"datasource", "addr"})
+
+type listener struct {
+	net.Listener
+	slots  chan struct{}
+	reject func(net.Conn)
+}
+
+// Listen wraps a listener to accept at most maxConns concurrent connections. The extra
+// connections are passed to reject, which can send a protocol-level error, then closed.
+// The listener is returned as-is if maxConns is 0.
+func Listen(inner net.Listener, maxConns int, reject func(net.Conn)) net.Listener {
+	if maxConns <= 0 {
+		return inner
+	}
+
+	return &listener{
+		Listener: inner,
+		slots:    make(chan struct{}, maxConns),
+		reject:   reject,
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case l.slots <- struct{}{}:
+			return &limitedConn{Conn: conn, release: func() { <-l.slots }}, nil
+		default:
+		}
+
+		if l.reject != nil {
+			l.reject(conn)
+		}
+
+		conn.Close()
+	}
+}
+
+// limitedConn frees its slot once closed.
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+
+	return err
+}
+
+// NetConn returns the underlying connection, as tls.Conn does.
+func (c *limitedConn) NetConn() net.Conn {
+	return c.Conn
+}
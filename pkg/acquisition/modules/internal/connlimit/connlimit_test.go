@@ -0,0 +1,96 @@
+package connlimit
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListen(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	rejected := make(chan struct{}, 10)
+
+	l := Listen(inner, 2, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("busy\n"))
+		rejected <- struct{}{}
+	})
+	t.Cleanup(func() { l.Close() })
+
+	accepted := make(chan net.Conn, 10)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			accepted <- conn
+		}
+	}()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		return conn
+	}
+
+	waitAccepted := func() net.Conn {
+		select {
+		case conn := <-accepted:
+			return conn
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for a connection")
+		}
+
+		return nil
+	}
+
+	dial()
+	c1 := waitAccepted()
+	dial()
+	waitAccepted()
+
+	extra := dial()
+
+	select {
+	case <-rejected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the third connection was not rejected")
+	}
+
+	body, err := io.ReadAll(extra)
+	require.NoError(t, err)
+	assert.Equal(t, "busy\n", string(body))
+
+	// closing a connection frees a slot, twice doesn't free two
+	require.NoError(t, c1.Close())
+	c1.Close()
+
+	dial()
+	waitAccepted()
+
+	dial()
+
+	select {
+	case <-rejected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the connection was not rejected")
+	}
+}
+
+func TestListenNoLimit(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { inner.Close() })
+
+	assert.Same(t, inner, Listen(inner, 0, nil))
+}
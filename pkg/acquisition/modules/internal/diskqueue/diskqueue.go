@@ -0,0 +1,565 @@
+// Package diskqueue decouples the push datasources from the pipeline: the events accepted by a
+// datasource are written to a queue on disk at the rate of its clients, and read from it by the
+// parsers at their own pace. The events survive a restart of crowdsec while they are queued.
+//
+// The queue is a directory of segment files. A segment is a sequence of records (length, crc32,
+// JSON payload); new records are appended to the last segment, which is rotated once it's larger
+// than segmentSize, and a segment is removed once all its records are read. The read position is
+// kept in a state file, saved every cursorSaveInterval events and when the queue is closed:
+// after a crash, the events read since the last save are sent again.
+package diskqueue
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/statefile"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const (
+	defaultMaxSize     = 256 << 20
+	segmentSize        = 16 << 20
+	recordHeaderSize   = 8 // length, crc32
+	maxRecordSize      = 64 << 20
+	cursorSaveInterval = 100
+	segmentExt         = ".seg"
+	cursorFile         = "cursor"
+)
+
+var (
+	ErrFull   = errors.New("queue is full")
+	ErrClosed = errors.New("queue is closed")
+)
+
+var Size = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "cs_acquisition_queue_size_bytes",
+		Help: "Size of the events waiting in the on-disk queue of a push datasource.",
+	},
+	[]string{"queue_dir"})
+
+var Dropped = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_acquisition_queue_dropped_total",
+		Help: "Total events dropped because the on-disk queue reached queue_max_size.",
+	},
+	[]string{"queue_dir"})
+
+// Config is meant to be inlined in the configuration of the push datasources.
+type Config struct {
+	QueueDir     string `yaml:"queue_dir"`      // queue the events on disk before the parsers, empty to send them directly
+	QueueMaxSize int64  `yaml:"queue_max_size"` // in bytes, the new events are dropped once it's reached
+}
+
+func (c Config) Validate() error {
+	if c.QueueMaxSize < 0 {
+		return errors.New("queue_max_size must be positive")
+	}
+
+	if c.QueueMaxSize > 0 && c.QueueDir == "" {
+		return errors.New("queue_max_size requires queue_dir")
+	}
+
+	return nil
+}
+
+// Queue holds the events between a push datasource and the pipeline.
+type Queue interface {
+	// Push stores an event. It returns ErrFull if the queue reached its max size.
+	Push(evt types.Event) error
+	// Peek returns the oldest event without removing it, it waits for one until the context
+	// is done. There is a single reader.
+	Peek(ctx context.Context) (types.Event, error)
+	// Remove removes the event returned by Peek, once it was handled.
+	Remove()
+	Close() error
+}
+
+// record is the part of the events that the datasources set.
+type record struct {
+	Line        types.Line        `json:"line"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	Unmarshaled map[string]any    `json:"unmarshaled,omitempty"`
+	Type        int               `json:"type"`
+	ExpectMode  int               `json:"expect_mode"`
+	Process     bool              `json:"process"`
+}
+
+type cursor struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// DiskQueue is the on-disk implementation of Queue. It is safe for concurrent use.
+type DiskQueue struct {
+	dir     string
+	maxSize int64
+	logger  *log.Entry
+	state   *statefile.File
+
+	mu       sync.Mutex
+	closed   bool
+	done     chan struct{}
+	notify   chan struct{} // signaled by Push
+	segments []uint64      // on disk, the last one is written
+	writer   *os.File
+	written  int64 // size of the last segment
+	reader   *os.File
+	read     cursor
+	size     int64 // of the events not read yet
+	unsaved  int   // events read since the cursor was saved
+
+	// the event returned by Peek, until Remove
+	peeked    bool
+	peekedEvt types.Event
+	peekedLen int64
+}
+
+// Open opens the queue of the configuration, it is created if needed.
+// It returns nil if queue_dir is not set.
+func Open(cfg Config, logger *log.Entry) (Queue, error) {
+	if cfg.QueueDir == "" {
+		return nil, nil
+	}
+
+	q, err := openDiskQueue(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func openDiskQueue(cfg Config, logger *log.Entry) (*DiskQueue, error) {
+	q := &DiskQueue{
+		dir:     cfg.QueueDir,
+		maxSize: cfg.QueueMaxSize,
+		logger:  logger.WithField("queue_dir", cfg.QueueDir),
+		done:    make(chan struct{}),
+		notify:  make(chan struct{}, 1),
+	}
+
+	if q.maxSize == 0 {
+		q.maxSize = defaultMaxSize
+	}
+
+	if err := os.MkdirAll(q.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create queue_dir: %w", err)
+	}
+
+	q.state = statefile.New(filepath.Join(q.dir, cursorFile), statefile.Config{}, q.logger)
+
+	var err error
+
+	q.segments, err = listSegments(q.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := q.state.Load(&q.read)
+	if err != nil {
+		return nil, err
+	}
+
+	// the segments before the cursor were read, but not removed yet
+	if found && slices.Contains(q.segments, q.read.Segment) {
+		for len(q.segments) > 0 && q.segments[0] < q.read.Segment {
+			q.removeSegment(q.segments[0])
+			q.segments = q.segments[1:]
+		}
+	} else if len(q.segments) > 0 {
+		q.read = cursor{Segment: q.segments[0]}
+	}
+
+	for _, id := range q.segments {
+		fi, err := os.Stat(q.segmentPath(id))
+		if err != nil {
+			return nil, fmt.Errorf("while reading queue: %w", err)
+		}
+
+		q.size += fi.Size()
+	}
+
+	q.size = max(q.size-q.read.Offset, 0)
+
+	// a new segment, the last one may end with a truncated record
+	if err := q.rotate(); err != nil {
+		return nil, err
+	}
+
+	if len(q.segments) == 1 {
+		q.read = cursor{Segment: q.segments[0]}
+	}
+
+	q.updateSize()
+
+	if q.size > 0 {
+		q.logger.Infof("%d bytes of events queued", q.size)
+	}
+
+	return q, nil
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("while reading queue: %w", err)
+	}
+
+	ids := []uint64{}
+
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), segmentExt)
+		if !ok {
+			continue
+		}
+
+		id, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+func (q *DiskQueue) segmentPath(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%016d%s", id, segmentExt))
+}
+
+func (q *DiskQueue) removeSegment(id uint64) {
+	if err := os.Remove(q.segmentPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		q.logger.Errorf("could not remove queue segment: %s", err)
+	}
+}
+
+func (q *DiskQueue) updateSize() {
+	Size.With(prometheus.Labels{"queue_dir": q.dir}).Set(float64(q.size))
+}
+
+// rotate starts a new segment for the writes.
+func (q *DiskQueue) rotate() error {
+	id := uint64(1)
+	if len(q.segments) > 0 {
+		id = q.segments[len(q.segments)-1] + 1
+	}
+
+	fd, err := os.OpenFile(q.segmentPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not create queue segment: %w", err)
+	}
+
+	if q.writer != nil {
+		q.writer.Close()
+	}
+
+	q.writer = fd
+	q.written = 0
+	q.segments = append(q.segments, id)
+
+	return nil
+}
+
+func (q *DiskQueue) Push(evt types.Event) error {
+	payload, err := json.Marshal(record{
+		Line:        evt.Line,
+		Meta:        evt.Meta,
+		Unmarshaled: evt.Unmarshaled,
+		Type:        evt.Type,
+		ExpectMode:  evt.ExpectMode,
+		Process:     evt.Process,
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode event: %w", err)
+	}
+
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("event is too large (%d bytes)", len(payload))
+	}
+
+	buf := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[recordHeaderSize:], payload)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+
+	if q.size+int64(len(buf)) > q.maxSize {
+		Dropped.With(prometheus.Labels{"queue_dir": q.dir}).Inc()
+		return ErrFull
+	}
+
+	if q.written >= segmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	// a single write: the readers, which hold the lock, never see a partial record
+	n, err := q.writer.Write(buf)
+	q.written += int64(n)
+
+	if err != nil {
+		// the rest of the segment can't be trusted
+		if rerr := q.rotate(); rerr != nil {
+			q.logger.Error(rerr)
+		}
+
+		return fmt.Errorf("could not write to queue: %w", err)
+	}
+
+	q.size += int64(n)
+	q.updateSize()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (q *DiskQueue) Peek(ctx context.Context) (types.Event, error) {
+	for {
+		q.mu.Lock()
+
+		if q.closed {
+			q.mu.Unlock()
+			return types.Event{}, ErrClosed
+		}
+
+		evt, ok, err := q.next()
+
+		q.mu.Unlock()
+
+		if err != nil || ok {
+			return evt, err
+		}
+
+		select {
+		case <-q.notify:
+		case <-q.done:
+		case <-ctx.Done():
+			return types.Event{}, ctx.Err()
+		}
+	}
+}
+
+// next reads the record at the cursor. It returns false if there is none.
+func (q *DiskQueue) next() (types.Event, bool, error) {
+	if q.peeked {
+		return q.peekedEvt, true, nil
+	}
+
+	for {
+		if q.reader == nil {
+			fd, err := os.Open(q.segmentPath(q.read.Segment))
+			if err != nil {
+				return types.Event{}, false, fmt.Errorf("could not open queue segment: %w", err)
+			}
+
+			q.reader = fd
+		}
+
+		last := q.read.Segment == q.segments[len(q.segments)-1]
+
+		payload, err := q.readRecord()
+		if err == nil {
+			var evt types.Event
+
+			evt, err = decodeRecord(payload)
+			if err == nil {
+				q.peeked, q.peekedEvt, q.peekedLen = true, evt, int64(recordHeaderSize+len(payload))
+				return evt, true, nil
+			}
+		}
+
+		if last && errors.Is(err, io.EOF) {
+			return types.Event{}, false, nil
+		}
+
+		if !errors.Is(err, io.EOF) {
+			// truncated by a crash, or corrupted
+			q.logger.Warningf("skipping the end of queue segment %d: %s", q.read.Segment, err)
+
+			if last {
+				if err := q.rotate(); err != nil {
+					return types.Event{}, false, err
+				}
+			}
+		}
+
+		q.nextSegment()
+	}
+}
+
+func (q *DiskQueue) readRecord() ([]byte, error) {
+	header := make([]byte, recordHeaderSize)
+
+	n, err := q.reader.ReadAt(header, q.read.Offset)
+	if n == 0 && errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+
+	if n < recordHeaderSize {
+		return nil, fmt.Errorf("truncated record header: %w", io.ErrUnexpectedEOF)
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return nil, fmt.Errorf("invalid record length %d", length)
+	}
+
+	payload := make([]byte, length)
+
+	if _, err := q.reader.ReadAt(payload, q.read.Offset+recordHeaderSize); err != nil {
+		return nil, fmt.Errorf("truncated record: %w", io.ErrUnexpectedEOF)
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	return payload, nil
+}
+
+func decodeRecord(payload []byte) (types.Event, error) {
+	r := record{}
+
+	if err := json.Unmarshal(payload, &r); err != nil {
+		return types.Event{}, fmt.Errorf("invalid record: %w", err)
+	}
+
+	evt := types.MakeEvent(false, r.Type, r.Process)
+	evt.Line = r.Line
+	evt.ExpectMode = r.ExpectMode
+
+	for k, v := range r.Meta {
+		evt.Meta[k] = v
+	}
+
+	for k, v := range r.Unmarshaled {
+		evt.Unmarshaled[k] = v
+	}
+
+	return evt, nil
+}
+
+func (q *DiskQueue) Remove() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.peeked {
+		return
+	}
+
+	q.peeked = false
+	q.peekedEvt = types.Event{}
+
+	n := q.peekedLen
+	q.read.Offset += n
+	q.size = max(q.size-n, 0)
+	q.updateSize()
+
+	q.unsaved++
+	if q.unsaved >= cursorSaveInterval {
+		q.saveCursor()
+	}
+}
+
+// nextSegment removes the segment that was read, and moves the cursor to the next one.
+func (q *DiskQueue) nextSegment() {
+	q.reader.Close()
+	q.reader = nil
+
+	if fi, err := os.Stat(q.segmentPath(q.read.Segment)); err == nil {
+		// the skipped bytes of a truncated segment
+		q.size = max(q.size-(fi.Size()-q.read.Offset), 0)
+		q.updateSize()
+	}
+
+	q.removeSegment(q.read.Segment)
+	q.segments = q.segments[1:]
+	q.read = cursor{Segment: q.segments[0]}
+	q.saveCursor()
+}
+
+func (q *DiskQueue) saveCursor() {
+	q.unsaved = 0
+
+	if err := q.state.Save(q.read); err != nil {
+		q.logger.Errorf("could not save queue cursor: %s", err)
+	}
+}
+
+// Close saves the read position. The events that are still queued are read on the next Open.
+func (q *DiskQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+
+	q.closed = true
+	close(q.done)
+
+	q.saveCursor()
+
+	if q.reader != nil {
+		q.reader.Close()
+	}
+
+	return q.writer.Close()
+}
+
+// Forward sends the events of the queue to the output until the tomb dies, then closes the queue.
+func Forward(q Queue, out chan types.Event, t *tomb.Tomb) error {
+	defer q.Close()
+
+	ctx := t.Context(context.Background())
+
+	for {
+		evt, err := q.Peek(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		select {
+		case out <- evt:
+			q.Remove()
+		case <-t.Dying():
+			// still queued, it's sent again on the next start
+			return nil
+		}
+	}
+}
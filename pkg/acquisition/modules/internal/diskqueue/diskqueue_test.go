@@ -0,0 +1,197 @@
+package diskqueue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func newEvent(raw string) types.Event {
+	evt := types.MakeEvent(true, types.LOG, true)
+	evt.Line = types.Line{Raw: raw, Src: "127.0.0.1", Module: "http", Process: true, Labels: map[string]string{"type": "test"}}
+	evt.Meta["http_client_ip"] = "127.0.0.1"
+
+	return evt
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Config{}.Validate())
+	cstest.RequireErrorContains(t, Config{QueueDir: "x", QueueMaxSize: -1}.Validate(), "queue_max_size must be positive")
+	cstest.RequireErrorContains(t, Config{QueueMaxSize: 10}.Validate(), "queue_max_size requires queue_dir")
+
+	q, err := Open(Config{}, log.NewEntry(log.New()))
+	require.NoError(t, err)
+	assert.Nil(t, q)
+}
+
+func TestPushPeek(t *testing.T) {
+	ctx := t.Context()
+	cfg := Config{QueueDir: filepath.Join(t.TempDir(), "queue")}
+	logger := log.NewEntry(log.New())
+
+	q, err := Open(cfg, logger)
+	require.NoError(t, err)
+
+	for i := range 5 {
+		require.NoError(t, q.Push(newEvent(fmt.Sprintf("line %d", i))))
+	}
+
+	// peek doesn't remove the event
+	evt, err := q.Peek(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "line 0", evt.Line.Raw)
+
+	evt, err = q.Peek(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "line 0", evt.Line.Raw)
+	assert.Equal(t, "127.0.0.1", evt.Meta["http_client_ip"])
+	assert.Equal(t, "test", evt.Line.Labels["type"])
+	assert.Equal(t, types.TIMEMACHINE, evt.ExpectMode)
+	assert.True(t, evt.Process)
+
+	q.Remove()
+
+	evt, err = q.Peek(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "line 1", evt.Line.Raw)
+	q.Remove()
+
+	require.NoError(t, q.Close())
+	require.ErrorIs(t, q.Push(newEvent("closed")), ErrClosed)
+
+	// the events that were not removed are kept
+	q, err = Open(cfg, logger)
+	require.NoError(t, err)
+
+	for i := 2; i < 5; i++ {
+		evt, err = q.Peek(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("line %d", i), evt.Line.Raw)
+		q.Remove()
+	}
+
+	// nothing left
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	_, err = q.Peek(timeoutCtx)
+	require.Error(t, err)
+
+	require.NoError(t, q.Close())
+}
+
+func TestMaxSize(t *testing.T) {
+	cfg := Config{QueueDir: t.TempDir(), QueueMaxSize: 1024}
+
+	q, err := Open(cfg, log.NewEntry(log.New()))
+	require.NoError(t, err)
+
+	defer q.Close()
+
+	pushed := 0
+
+	for range 100 {
+		err = q.Push(newEvent("some line"))
+		if err != nil {
+			break
+		}
+
+		pushed++
+	}
+
+	require.ErrorIs(t, err, ErrFull)
+	assert.Positive(t, pushed)
+	assert.Less(t, pushed, 100)
+
+	// there is room again once an event is removed
+	_, err = q.Peek(t.Context())
+	require.NoError(t, err)
+	q.Remove()
+
+	require.NoError(t, q.Push(newEvent("some line")))
+}
+
+func TestTruncatedSegment(t *testing.T) {
+	ctx := t.Context()
+	cfg := Config{QueueDir: t.TempDir()}
+	logger := log.NewEntry(log.New())
+
+	q, err := Open(cfg, logger)
+	require.NoError(t, err)
+
+	require.NoError(t, q.Push(newEvent("complete")))
+	require.NoError(t, q.Close())
+
+	// a crash in the middle of a write
+	segments, err := listSegments(cfg.QueueDir)
+	require.NoError(t, err)
+
+	fd, err := os.OpenFile(filepath.Join(cfg.QueueDir, fmt.Sprintf("%016d%s", segments[len(segments)-1], segmentExt)), os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = fd.Write([]byte{0, 0, 0, 42, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+
+	q, err = Open(cfg, logger)
+	require.NoError(t, err)
+
+	defer q.Close()
+
+	require.NoError(t, q.Push(newEvent("after restart")))
+
+	evt, err := q.Peek(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "complete", evt.Line.Raw)
+	q.Remove()
+
+	evt, err = q.Peek(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "after restart", evt.Line.Raw)
+	q.Remove()
+}
+
+func TestForward(t *testing.T) {
+	cfg := Config{QueueDir: t.TempDir()}
+
+	q, err := Open(cfg, log.NewEntry(log.New()))
+	require.NoError(t, err)
+
+	out := make(chan types.Event)
+	tmb := tomb.Tomb{}
+
+	tmb.Go(func() error {
+		return Forward(q, out, &tmb)
+	})
+
+	// the writes don't wait for the reader
+	for i := range 3 {
+		require.NoError(t, q.Push(newEvent(fmt.Sprintf("line %d", i))))
+	}
+
+	for i := range 3 {
+		select {
+		case evt := <-out:
+			assert.Equal(t, fmt.Sprintf("line %d", i), evt.Line.Raw)
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for the event")
+		}
+	}
+
+	tmb.Kill(nil)
+	require.NoError(t, tmb.Wait())
+
+	// closed by Forward
+	require.ErrorIs(t, q.Push(newEvent("closed")), ErrClosed)
+}
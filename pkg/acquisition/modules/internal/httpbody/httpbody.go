@@ -0,0 +1,174 @@
+// Package httpbody decompresses the request bodies of the HTTP push datasources according to
+// their Content-Encoding. The decompressed size is limited, so that a small compressed body
+// can't expand to an unbounded amount of memory.
+package httpbody
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultMaxDecompressedSize is used when max_decompressed_body_size is not set.
+const DefaultMaxDecompressedSize = 100 * 1024 * 1024
+
+// Rejected counts the requests rejected because of their body encoding, by datasource type and
+// reason: too_large, malformed or unsupported_encoding.
+var Rejected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_acquisition_rejected_bodies_total",
+		Help: "Total requests rejected because their body could not be decompressed or was too large once decompressed.",
+	},
+	[]string{"datasource", "reason"})
+
+var supportedEncodings = []string{"gzip", "deflate", "identity"}
+
+var errTooLarge = errors.New("decompressed body too large")
+
+// Config is meant to be inlined in the configuration of the datasources.
+type Config struct {
+	ContentEncodings        []string `yaml:"content_encodings"` // default: gzip, deflate
+	MaxDecompressedBodySize int64    `yaml:"max_decompressed_body_size"`
+}
+
+// Decoder is a validated Config.
+type Decoder struct {
+	encodings []string
+	maxSize   int64
+}
+
+// New validates the configuration.
+func New(cfg Config) (*Decoder, error) {
+	d := &Decoder{
+		encodings: supportedEncodings,
+		maxSize:   cfg.MaxDecompressedBodySize,
+	}
+
+	if len(cfg.ContentEncodings) > 0 {
+		// uncompressed bodies are always accepted
+		d.encodings = []string{"identity"}
+
+		for _, enc := range cfg.ContentEncodings {
+			enc = strings.ToLower(strings.TrimSpace(enc))
+			if !slices.Contains(supportedEncodings, enc) {
+				return nil, fmt.Errorf("content_encodings: unsupported encoding '%s'", enc)
+			}
+
+			d.encodings = append(d.encodings, enc)
+		}
+	}
+
+	if d.maxSize < 0 {
+		return nil, errors.New("max_decompressed_body_size must be positive")
+	}
+
+	if d.maxSize == 0 {
+		d.maxSize = DefaultMaxDecompressedSize
+	}
+
+	return d, nil
+}
+
+// Handler decompresses the body of the requests before passing them to next, which sees an
+// uncompressed body without a Content-Encoding header. The requests are rejected with 415 if the
+// encoding is not enabled, 400 if the body can't be decompressed and 413 if it's larger than
+// max_decompressed_body_size once decompressed. reject is called before sending the error.
+func Handler(next http.Handler, d *Decoder, reject func(r *http.Request, reason string)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if encoding == "" || encoding == "identity" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !slices.Contains(d.encodings, encoding) {
+			reject(r, "unsupported_encoding")
+			http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+
+			return
+		}
+
+		body, err := d.decompress(r.Body, encoding)
+		if err != nil {
+			if errors.Is(err, errTooLarge) {
+				reject(r, "too_large")
+				http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+
+				return
+			}
+
+			reject(r, "malformed")
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+
+			return
+		}
+
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Del("Content-Encoding")
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Decoder) decompress(body io.Reader, encoding string) ([]byte, error) {
+	var (
+		reader io.ReadCloser
+		err    error
+	)
+
+	switch encoding {
+	case "gzip":
+		reader, err = gzip.NewReader(body)
+	case "deflate":
+		reader, err = newDeflateReader(body)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, d.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > d.maxSize {
+		return nil, errTooLarge
+	}
+
+	return data, nil
+}
+
+// newDeflateReader reads a "deflate" body, which is zlib-wrapped according to the
+// specification, but sent as raw deflate by some clients.
+func newDeflateReader(body io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(body)
+
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	// zlib header: compression method 8, and a checksum multiple of 31
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+
+	return flate.NewReader(br), nil
+}
@@ -0,0 +1,152 @@
+package httpbody
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{ContentEncodings: []string{"gzip", "br"}})
+	cstest.RequireErrorContains(t, err, "content_encodings: unsupported encoding 'br'")
+
+	_, err = New(Config{MaxDecompressedBodySize: -1})
+	cstest.RequireErrorContains(t, err, "max_decompressed_body_size must be positive")
+
+	d, err := New(Config{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(DefaultMaxDecompressedSize), d.maxSize)
+}
+
+func compress(t *testing.T, encoding string, data string) []byte {
+	buf := bytes.Buffer{}
+
+	var w io.WriteCloser
+
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		var err error
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+		require.NoError(t, err)
+	}
+
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func TestHandler(t *testing.T) {
+	payload := strings.Repeat("a", 100)
+
+	tests := []struct {
+		name             string
+		config           Config
+		encoding         string
+		body             []byte
+		expectedStatus   int
+		expectedRejected string
+	}{
+		{
+			name:           "uncompressed",
+			body:           []byte(payload),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "gzip",
+			encoding:       "gzip",
+			body:           compress(t, "gzip", payload),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "deflate",
+			encoding:       "deflate",
+			body:           compress(t, "deflate", payload),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "raw deflate",
+			encoding:       "Deflate",
+			body:           compress(t, "raw-deflate", payload),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:             "too large",
+			config:           Config{MaxDecompressedBodySize: 99},
+			encoding:         "gzip",
+			body:             compress(t, "gzip", payload),
+			expectedStatus:   http.StatusRequestEntityTooLarge,
+			expectedRejected: "too_large",
+		},
+		{
+			name:             "not enabled",
+			config:           Config{ContentEncodings: []string{"gzip"}},
+			encoding:         "deflate",
+			body:             compress(t, "deflate", payload),
+			expectedStatus:   http.StatusUnsupportedMediaType,
+			expectedRejected: "unsupported_encoding",
+		},
+		{
+			name:             "unknown encoding",
+			encoding:         "br",
+			body:             []byte(payload),
+			expectedStatus:   http.StatusUnsupportedMediaType,
+			expectedRejected: "unsupported_encoding",
+		},
+		{
+			name:             "malformed",
+			encoding:         "gzip",
+			body:             []byte(payload),
+			expectedStatus:   http.StatusBadRequest,
+			expectedRejected: "malformed",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := New(tc.config)
+			require.NoError(t, err)
+
+			rejected := ""
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, payload, string(body))
+				assert.Equal(t, int64(len(payload)), r.ContentLength)
+				assert.Empty(t, r.Header.Get("Content-Encoding"))
+			})
+
+			handler := Handler(next, d, func(_ *http.Request, reason string) {
+				rejected = reason
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tc.body))
+			if tc.encoding != "" {
+				req.Header.Set("Content-Encoding", tc.encoding)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.Equal(t, tc.expectedRejected, rejected)
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// Package ipfilter restricts the remote addresses accepted by the listener datasources, with
+// lists of allowed and denied CIDR ranges. A denied range always wins over an allowed one;
+// when allowed_sources is set, the addresses outside of it are rejected.
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Rejected counts the connections or messages dropped because of their source address.
+var Rejected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_acquisition_filtered_sources_total",
+		Help: "Total connections or messages rejected by allowed_sources/denied_sources.",
+	},
+	[]string{"datasource"})
+
+// Config is meant to be inlined in the configuration of the datasources.
+type Config struct {
+	AllowedSources []string `yaml:"allowed_sources"` // CIDR ranges or IP addresses
+	DeniedSources  []string `yaml:"denied_sources"`
+}
+
+// Filter is a compiled Config. A nil Filter accepts everything.
+type Filter struct {
+	allowed []netip.Prefix
+	denied  []netip.Prefix
+}
+
+// New compiles the lists. It returns nil if both are empty.
+func New(cfg Config) (*Filter, error) {
+	if len(cfg.AllowedSources) == 0 && len(cfg.DeniedSources) == 0 {
+		return nil, nil
+	}
+
+	allowed, err := ParsePrefixes("allowed_sources", cfg.AllowedSources)
+	if err != nil {
+		return nil, err
+	}
+
+	denied, err := ParsePrefixes("denied_sources", cfg.DeniedSources)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{allowed: allowed, denied: denied}, nil
+}
+
+// ParsePrefixes parses a list of CIDR ranges or IP addresses, option is the name of the
+// setting for the error messages.
+func ParsePrefixes(option string, values []string) ([]netip.Prefix, error) {
+	ret := make([]netip.Prefix, 0, len(values))
+
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid address '%s'", option, value)
+			}
+
+			ret = append(ret, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid range '%s'", option, value)
+		}
+
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+
+		ret = append(ret, prefix.Masked())
+	}
+
+	return ret, nil
+}
+
+// Allowed reports whether an address is accepted.
+func (f *Filter) Allowed(addr netip.Addr) bool {
+	if f == nil {
+		return true
+	}
+
+	addr = addr.Unmap().WithZone("")
+
+	for _, prefix := range f.denied {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	if len(f.allowed) == 0 {
+		return true
+	}
+
+	for _, prefix := range f.allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowedHost is like Allowed, for an IP address or a host:port string as found in
+// net.Addr.String() or http.Request.RemoteAddr. Values that are not IP addresses (unix
+// sockets) are accepted.
+func (f *Filter) AllowedHost(hostport string) bool {
+	if f == nil {
+		return true
+	}
+
+	host := hostport
+
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return true
+	}
+
+	return f.Allowed(addr)
+}
+
+type listener struct {
+	net.Listener
+	filter *Filter
+	reject func(net.Conn)
+}
+
+// Listen wraps a listener to close the connections from rejected addresses as soon as they
+// are accepted, after calling reject. The listener is returned as-is if the filter is nil.
+func Listen(inner net.Listener, f *Filter, reject func(net.Conn)) net.Listener {
+	if f == nil {
+		return inner
+	}
+
+	return &listener{Listener: inner, filter: f, reject: reject}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if l.filter.AllowedHost(conn.RemoteAddr().String()) {
+			return conn, nil
+		}
+
+		if l.reject != nil {
+			l.reject(conn)
+		}
+
+		conn.Close()
+	}
+}
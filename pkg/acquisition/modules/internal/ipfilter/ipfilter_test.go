@@ -0,0 +1,104 @@
+package ipfilter
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+)
+
+func TestNew(t *testing.T) {
+	f, err := New(Config{})
+	require.NoError(t, err)
+	assert.Nil(t, f)
+	assert.True(t, f.Allowed(netip.MustParseAddr("192.0.2.1")))
+
+	_, err = New(Config{AllowedSources: []string{"10.0.0.0/33"}})
+	cstest.RequireErrorContains(t, err, "allowed_sources: invalid range '10.0.0.0/33'")
+
+	_, err = New(Config{DeniedSources: []string{"example.com"}})
+	cstest.RequireErrorContains(t, err, "denied_sources: invalid address 'example.com'")
+}
+
+func TestAllowed(t *testing.T) {
+	f, err := New(Config{
+		AllowedSources: []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.1"},
+		DeniedSources:  []string{"10.1.0.0/16", "10.2.3.4"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"10.0.0.1", true},
+		{"10.1.2.3", false}, // deny wins
+		{"10.2.3.4", false},
+		{"10.2.3.5", true},
+		{"192.0.2.1", true},
+		{"192.0.2.2", false}, // not in allowed_sources
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:10.1.0.1", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.addr, func(t *testing.T) {
+			assert.Equal(t, tc.allowed, f.Allowed(netip.MustParseAddr(tc.addr)))
+		})
+	}
+
+	assert.True(t, f.AllowedHost("10.0.0.1:514"))
+	assert.False(t, f.AllowedHost("[2001:db9::1]:443"))
+	assert.False(t, f.AllowedHost("10.1.0.1"))
+	assert.True(t, f.AllowedHost("@"))
+}
+
+func TestDeniedOnly(t *testing.T) {
+	f, err := New(Config{DeniedSources: []string{"192.0.2.0/24"}})
+	require.NoError(t, err)
+
+	assert.False(t, f.Allowed(netip.MustParseAddr("192.0.2.10")))
+	assert.True(t, f.Allowed(netip.MustParseAddr("198.51.100.1")))
+}
+
+func TestListen(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	f, err := New(Config{DeniedSources: []string{"127.0.0.0/8"}})
+	require.NoError(t, err)
+
+	rejected := make(chan string, 1)
+
+	l := Listen(inner, f, func(conn net.Conn) {
+		rejected <- conn.RemoteAddr().String()
+	})
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	assert.Equal(t, conn.LocalAddr().String(), <-rejected)
+
+	// the connection was closed by the server
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	assert.Same(t, inner, Listen(inner, nil, nil))
+}
@@ -0,0 +1,216 @@
+// Package jsonpath extracts nested fields from JSON log lines, for the datasources that
+// receive structured messages. Paths use a dotted notation with optional array indexes,
+// with or without a leading "$": ".event.client.ip", "$.records[0].message", "host".
+package jsonpath
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+var MissingFields = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_acquisition_json_path_missing_total",
+		Help: "Total events where a configured JSON path was not found.",
+	},
+	[]string{"datasource", "path"})
+
+// Config is meant to be inlined in the configuration of the datasources.
+type Config struct {
+	MessageField string            `yaml:"json_message_field"` // path of the field to use as the log line
+	MetaFields   map[string]string `yaml:"json_meta_fields"`   // metadata key -> path of the field
+}
+
+type step struct {
+	key   string
+	index int // -1 for a key lookup
+}
+
+// Path is a compiled JSON path.
+type Path struct {
+	raw   string
+	steps []step
+}
+
+// Compile parses a path expression.
+func Compile(path string) (*Path, error) {
+	p := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	p = strings.TrimPrefix(p, ".")
+
+	if p == "" {
+		return nil, fmt.Errorf("invalid json path '%s': empty path", path)
+	}
+
+	ret := &Path{raw: path}
+
+	for _, segment := range strings.Split(p, ".") {
+		key, rest, _ := strings.Cut(segment, "[")
+
+		if key == "" && rest == "" {
+			return nil, fmt.Errorf("invalid json path '%s': empty segment", path)
+		}
+
+		if key != "" {
+			ret.steps = append(ret.steps, step{key: key, index: -1})
+		}
+
+		for rest != "" {
+			idx, after, found := strings.Cut(rest, "]")
+			if !found {
+				return nil, fmt.Errorf("invalid json path '%s': missing ']'", path)
+			}
+
+			n, err := strconv.Atoi(idx)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid json path '%s': bad array index '%s'", path, idx)
+			}
+
+			ret.steps = append(ret.steps, step{index: n})
+
+			if after == "" {
+				break
+			}
+
+			if !strings.HasPrefix(after, "[") {
+				return nil, fmt.Errorf("invalid json path '%s': unexpected '%s'", path, after)
+			}
+
+			rest = after[1:]
+		}
+	}
+
+	return ret, nil
+}
+
+func (p *Path) String() string {
+	return p.raw
+}
+
+// Lookup returns the value at the path in a decoded JSON document.
+// Strings are returned as-is, other values are JSON-encoded.
+func (p *Path) Lookup(doc any) (string, bool) {
+	cur := doc
+
+	for _, s := range p.steps {
+		switch v := cur.(type) {
+		case map[string]any:
+			if s.index >= 0 {
+				return "", false
+			}
+
+			next, ok := v[s.key]
+			if !ok {
+				return "", false
+			}
+
+			cur = next
+		case []any:
+			if s.index < 0 || s.index >= len(v) {
+				return "", false
+			}
+
+			cur = v[s.index]
+		default:
+			return "", false
+		}
+	}
+
+	switch v := cur.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+
+		return string(b), true
+	}
+}
+
+// Extractor applies a Config to events.
+type Extractor struct {
+	datasource string
+	message    *Path
+	meta       map[string]*Path
+}
+
+// NewExtractor compiles the paths of the configuration. It returns nil if nothing is configured.
+func NewExtractor(cfg Config, datasource string) (*Extractor, error) {
+	if cfg.MessageField == "" && len(cfg.MetaFields) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	e := &Extractor{
+		datasource: datasource,
+		meta:       make(map[string]*Path, len(cfg.MetaFields)),
+	}
+
+	var err error
+
+	if cfg.MessageField != "" {
+		if e.message, err = Compile(cfg.MessageField); err != nil {
+			return nil, fmt.Errorf("json_message_field: %w", err)
+		}
+	}
+
+	for key, path := range cfg.MetaFields {
+		if key == "" {
+			return nil, errors.New("json_meta_fields: empty metadata key")
+		}
+
+		if e.meta[key], err = Compile(path); err != nil {
+			return nil, fmt.Errorf("json_meta_fields.%s: %w", key, err)
+		}
+	}
+
+	return e, nil
+}
+
+func (e *Extractor) missing(p *Path) {
+	MissingFields.With(prometheus.Labels{"datasource": e.datasource, "path": p.raw}).Inc()
+}
+
+// Apply extracts the fields from evt.Line.Raw. The line is replaced by the message field, and
+// the metadata fields are set: paths that are not found (or lines that are not JSON) yield an
+// empty string and increase MissingFields. A nil Extractor is a no-op.
+func (e *Extractor) Apply(evt *types.Event) {
+	if e == nil {
+		return
+	}
+
+	var doc any
+
+	if err := json.Unmarshal([]byte(evt.Line.Raw), &doc); err != nil {
+		doc = nil
+	}
+
+	for key, p := range e.meta {
+		value, ok := p.Lookup(doc)
+		if !ok {
+			e.missing(p)
+		}
+
+		evt.SetMeta(key, value)
+	}
+
+	if e.message == nil {
+		return
+	}
+
+	value, ok := e.message.Lookup(doc)
+	if !ok {
+		e.missing(e.message)
+	}
+
+	evt.Line.Raw = value
+}
@@ -0,0 +1,101 @@
+package jsonpath
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		path        string
+		expectedErr string
+	}{
+		{path: ".event.client.ip"},
+		{path: "$.records[0].message"},
+		{path: "host"},
+		{path: "a[1][2]"},
+		{path: "", expectedErr: "invalid json path '': empty path"},
+		{path: "$.", expectedErr: "invalid json path '$.': empty path"},
+		{path: "a..b", expectedErr: "invalid json path 'a..b': empty segment"},
+		{path: "a[0", expectedErr: "invalid json path 'a[0': missing ']'"},
+		{path: "a[x]", expectedErr: "invalid json path 'a[x]': bad array index 'x'"},
+		{path: "a[0]b", expectedErr: "invalid json path 'a[0]b': unexpected 'b'"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			_, err := Compile(tc.path)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	doc := map[string]any{
+		"event": map[string]any{
+			"client": map[string]any{"ip": "1.2.3.4", "port": float64(443)},
+		},
+		"records": []any{map[string]any{"message": "hello"}},
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+		found    bool
+	}{
+		{path: ".event.client.ip", expected: "1.2.3.4", found: true},
+		{path: "event.client.port", expected: "443", found: true},
+		{path: "$.records[0].message", expected: "hello", found: true},
+		{path: "event.client", expected: `{"ip":"1.2.3.4","port":443}`, found: true},
+		{path: "records[1].message"},
+		{path: "event.server.ip"},
+		{path: "event[0]"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			p, err := Compile(tc.path)
+			require.NoError(t, err)
+
+			value, found := p.Lookup(doc)
+			assert.Equal(t, tc.found, found)
+			assert.Equal(t, tc.expected, value)
+		})
+	}
+}
+
+func TestExtractor(t *testing.T) {
+	e, err := NewExtractor(Config{}, "test")
+	require.NoError(t, err)
+	assert.Nil(t, e)
+
+	// a nil extractor is a no-op
+	evt := types.Event{Line: types.Line{Raw: "foo"}}
+	e.Apply(&evt)
+	assert.Equal(t, "foo", evt.Line.Raw)
+
+	_, err = NewExtractor(Config{MetaFields: map[string]string{"ip": "a[x]"}}, "test")
+	cstest.RequireErrorContains(t, err, "json_meta_fields.ip: invalid json path 'a[x]': bad array index 'x'")
+
+	e, err = NewExtractor(Config{
+		MessageField: ".event.message",
+		MetaFields:   map[string]string{"source_ip": ".event.client.ip", "user": ".event.user"},
+	}, "test")
+	require.NoError(t, err)
+
+	evt = types.Event{Line: types.Line{Raw: `{"event": {"message": "login failed", "client": {"ip": "1.2.3.4"}}}`}}
+	e.Apply(&evt)
+	assert.Equal(t, "login failed", evt.Line.Raw)
+	assert.Equal(t, map[string]string{"source_ip": "1.2.3.4", "user": ""}, evt.Meta)
+
+	evt = types.Event{Line: types.Line{Raw: "not json"}}
+	e.Apply(&evt)
+	assert.Empty(t, evt.Line.Raw)
+	assert.Equal(t, map[string]string{"source_ip": "", "user": ""}, evt.Meta)
+}
@@ -0,0 +1,86 @@
+// Package jsonschema validates the JSON events received by the datasources against a JSON
+// Schema (draft 4), to protect the parsers from the malformed messages of semi-trusted
+// forwarders. The push datasources reject the invalid events, the pull datasources drop them.
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-openapi/spec"
+	"github.com/go-openapi/strfmt"
+	"github.com/go-openapi/validate"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Invalid counts the events that did not match the schema, by datasource type.
+var Invalid = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_acquisition_json_schema_invalid_total",
+		Help: "Total events rejected or dropped because they did not match json_schema_file.",
+	},
+	[]string{"datasource"})
+
+// Config is meant to be inlined in the configuration of the datasources.
+type Config struct {
+	JSONSchemaFile string `yaml:"json_schema_file"` // path of the JSON Schema the events must match
+}
+
+// Validator is a compiled schema. A nil Validator accepts everything.
+type Validator struct {
+	validator *validate.SchemaValidator
+}
+
+// New loads and compiles the schema, it returns nil without json_schema_file.
+func New(cfg Config) (*Validator, error) {
+	if cfg.JSONSchemaFile == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	content, err := os.ReadFile(cfg.JSONSchemaFile)
+	if err != nil {
+		return nil, fmt.Errorf("json_schema_file: %w", err)
+	}
+
+	schema := &spec.Schema{}
+	if err := json.Unmarshal(content, schema); err != nil {
+		return nil, fmt.Errorf("json_schema_file: invalid schema %s: %w", cfg.JSONSchemaFile, err)
+	}
+
+	// resolve the references now rather than with the first event, relative to the schema file
+	if err := spec.ExpandSchemaWithBasePath(schema, nil, &spec.ExpandOptions{RelativeBase: cfg.JSONSchemaFile}); err != nil {
+		return nil, fmt.Errorf("json_schema_file: invalid schema %s: %w", cfg.JSONSchemaFile, err)
+	}
+
+	return &Validator{
+		validator: validate.NewSchemaValidator(schema, nil, "$", strfmt.Default),
+	}, nil
+}
+
+// Validate returns an error if data is not a JSON document that matches the schema.
+func (v *Validator) Validate(data []byte) error {
+	if v == nil {
+		return nil
+	}
+
+	var doc any
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	result := v.validator.Validate(doc)
+	if result.IsValid() {
+		return nil
+	}
+
+	msgs := make([]string, 0, len(result.Errors))
+	for _, err := range result.Errors {
+		msgs = append(msgs, err.Error())
+	}
+
+	return errors.New("does not match the schema: " + strings.Join(msgs, ", "))
+}
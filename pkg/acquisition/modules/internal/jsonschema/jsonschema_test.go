@@ -0,0 +1,87 @@
+package jsonschema
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+)
+
+const testSchema = `{
+  "type": "object",
+  "required": ["message", "client"],
+  "properties": {
+    "message": {"type": "string"},
+    "client": {"$ref": "#/definitions/client"}
+  },
+  "definitions": {
+    "client": {
+      "type": "object",
+      "properties": {"ip": {"type": "string", "format": "ipv4"}}
+    }
+  }
+}`
+
+func writeSchema(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "schema.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name        string
+		schema      string
+		expectedErr string
+	}{
+		{name: "valid", schema: testSchema},
+		{name: "not json", schema: "type: object", expectedErr: "json_schema_file: invalid schema"},
+		{name: "bad type", schema: `{"type": 5}`, expectedErr: "json_schema_file: invalid schema"},
+		{name: "bad ref", schema: `{"$ref": "#/definitions/missing"}`, expectedErr: "json_schema_file: invalid schema"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(Config{JSONSchemaFile: writeSchema(t, tc.schema)})
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+
+	v, err := New(Config{})
+	require.NoError(t, err)
+	require.Nil(t, v)
+	require.NoError(t, v.Validate([]byte("not json")))
+
+	_, err = New(Config{JSONSchemaFile: filepath.Join(t.TempDir(), "missing.json")})
+	cstest.RequireErrorContains(t, err, "json_schema_file: open")
+}
+
+func TestValidate(t *testing.T) {
+	v, err := New(Config{JSONSchemaFile: writeSchema(t, testSchema)})
+	require.NoError(t, err)
+
+	tests := []struct {
+		data        string
+		expectedErr string
+	}{
+		{data: `{"message": "hello", "client": {"ip": "192.0.2.1"}}`},
+		{data: `{"message": "hello"}`, expectedErr: "does not match the schema: $.client in body is required"},
+		{data: `{"message": 1, "client": {}}`, expectedErr: "does not match the schema: $.message in body must be of type string"},
+		{data: `{"message": "hello", "client": {"ip": "nope"}}`, expectedErr: "does not match the schema"},
+		{data: `[1, 2]`, expectedErr: "does not match the schema"},
+		{data: `{"message"`, expectedErr: "invalid JSON"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.data, func(t *testing.T) {
+			err := v.Validate([]byte(tc.data))
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
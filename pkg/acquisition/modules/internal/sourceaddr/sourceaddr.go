@@ -0,0 +1,68 @@
+// Package sourceaddr binds the outgoing connections of the datasources to a local address, for
+// multi-homed hosts where egress filtering depends on the source IP.
+package sourceaddr
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// interfaceAddrs is replaced in tests.
+var interfaceAddrs = net.InterfaceAddrs
+
+// Resolve parses a source_address option (an IP address, without port) and checks that it is
+// assigned to a local interface. It returns nil for an empty string.
+func Resolve(sourceAddress string) (*net.TCPAddr, error) {
+	if sourceAddress == "" {
+		return nil, nil
+	}
+
+	ip, err := netip.ParseAddr(sourceAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source_address '%s': must be an IP address", sourceAddress)
+	}
+
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("while listing local addresses: %w", err)
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		local, ok := netip.AddrFromSlice(ipNet.IP)
+		if ok && local.Unmap() == ip.Unmap() {
+			return &net.TCPAddr{IP: ip.AsSlice(), Zone: ip.Zone()}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("source_address '%s' is not assigned to a local interface", sourceAddress)
+}
+
+// Dialer returns a dialer bound to the local address, or a default dialer if it is nil.
+func Dialer(local *net.TCPAddr) *net.Dialer {
+	dialer := &net.Dialer{}
+
+	if local != nil {
+		dialer.LocalAddr = local
+	}
+
+	return dialer
+}
+
+// Transport returns a clone of the default HTTP transport, with connections bound to the local
+// address if it is not nil.
+func Transport(local *net.TCPAddr) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if local != nil {
+		transport.DialContext = Dialer(local).DialContext
+	}
+
+	return transport
+}
@@ -0,0 +1,78 @@
+package sourceaddr
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+)
+
+func TestResolve(t *testing.T) {
+	interfaceAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{
+			&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+			&net.IPNet{IP: net.ParseIP("192.0.2.10"), Mask: net.CIDRMask(24, 32)},
+			&net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(64, 128)},
+		}, nil
+	}
+
+	t.Cleanup(func() { interfaceAddrs = net.InterfaceAddrs })
+
+	tests := []struct {
+		addr        string
+		expected    string
+		expectedErr string
+	}{
+		{addr: "", expected: ""},
+		{addr: "192.0.2.10", expected: "192.0.2.10:0"},
+		{addr: "2001:db8::1", expected: "[2001:db8::1]:0"},
+		{addr: "192.0.2.11", expectedErr: "source_address '192.0.2.11' is not assigned to a local interface"},
+		{addr: "192.0.2.10:1234", expectedErr: "invalid source_address '192.0.2.10:1234': must be an IP address"},
+		{addr: "eth0", expectedErr: "invalid source_address 'eth0': must be an IP address"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.addr, func(t *testing.T) {
+			local, err := Resolve(tc.addr)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+
+			if tc.expectedErr != "" {
+				return
+			}
+
+			if tc.expected == "" {
+				assert.Nil(t, local)
+				return
+			}
+
+			assert.Equal(t, tc.expected, local.String())
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var remote string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		remote = r.RemoteAddr
+	}))
+	t.Cleanup(srv.Close)
+
+	local, err := Resolve("127.0.0.1")
+	require.NoError(t, err)
+
+	client := &http.Client{Transport: Transport(local)}
+
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	host, _, err := net.SplitHostPort(remote)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+}
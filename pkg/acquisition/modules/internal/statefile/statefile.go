@@ -0,0 +1,210 @@
+// Package statefile stores the state of the datasources (offsets, cursors, checkpoints) on disk.
+// The state is JSON-encoded, optionally gzip-compressed, and prefixed by a small header with a
+// checksum so that truncated or corrupted files are detected: they are reset instead of being
+// loaded. Reads handle both compressed and uncompressed files, whatever the configuration.
+package statefile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	magic          = "CSST"
+	headerSize     = len(magic) + 1 + 4 // magic, flags, crc32 of the payload
+	flagCompressed = 1 << 0
+
+	// limit of the decoded state when no max size is configured, to protect against gzip bombs
+	defaultMaxDecodedSize = 64 << 20
+)
+
+var (
+	ErrTooLarge = errors.New("state is too large")
+	errCorrupt  = errors.New("corrupted state file")
+)
+
+// Config is meant to be inlined in the configuration of the datasources that keep a state file.
+type Config struct {
+	StateCompress bool  `yaml:"state_compress"` // gzip the state file
+	StateMaxSize  int64 `yaml:"state_max_size"` // max size of the state file in bytes, 0 for no limit
+}
+
+func (c Config) Validate() error {
+	if c.StateMaxSize < 0 {
+		return errors.New("state_max_size must be positive")
+	}
+
+	return nil
+}
+
+// File is a state file. It is not safe for concurrent use.
+type File struct {
+	path   string
+	cfg    Config
+	logger *log.Entry
+}
+
+func New(path string, cfg Config, logger *log.Entry) *File {
+	return &File{
+		path:   path,
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+func (f *File) Path() string {
+	return f.path
+}
+
+// Save encodes v and atomically replaces the state file.
+// It returns ErrTooLarge (the previous state is kept) if the result exceeds the max size.
+func (f *File) Save(v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("while encoding state: %w", err)
+	}
+
+	var flags byte
+
+	if f.cfg.StateCompress {
+		buf := bytes.Buffer{}
+		zw := gzip.NewWriter(&buf)
+
+		if _, err = zw.Write(payload); err != nil {
+			return fmt.Errorf("while compressing state: %w", err)
+		}
+
+		if err = zw.Close(); err != nil {
+			return fmt.Errorf("while compressing state: %w", err)
+		}
+
+		payload = buf.Bytes()
+		flags |= flagCompressed
+	}
+
+	size := int64(headerSize + len(payload))
+	if f.cfg.StateMaxSize > 0 && size > f.cfg.StateMaxSize {
+		return fmt.Errorf("%w: %d bytes (max %d)", ErrTooLarge, size, f.cfg.StateMaxSize)
+	}
+
+	data := make([]byte, 0, size)
+	data = append(data, magic...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(payload))
+	data = append(data, payload...)
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("while writing state file: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("while writing state file %s: %w", tmp.Name(), err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("while writing state file %s: %w", tmp.Name(), err)
+	}
+
+	if err = os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("while writing state file %s: %w", f.path, err)
+	}
+
+	return nil
+}
+
+// Load decodes the state file into v. It returns false if there is no state to load: the file
+// does not exist, or it was corrupted (in which case it is removed).
+func (f *File) Load(v any) (bool, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("while reading state file: %w", err)
+	}
+
+	payload, err := f.decode(data)
+	if err == nil {
+		err = json.Unmarshal(payload, v)
+	}
+
+	if err != nil {
+		f.logger.Warningf("resetting state file %s: %s", f.path, err)
+
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("while removing state file: %w", err)
+		}
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Remove deletes the state file, if it exists.
+func (f *File) Remove() error {
+	if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("while removing state file: %w", err)
+	}
+
+	return nil
+}
+
+func (f *File) decode(data []byte) ([]byte, error) {
+	if f.cfg.StateMaxSize > 0 && int64(len(data)) > f.cfg.StateMaxSize {
+		return nil, fmt.Errorf("%w: %d bytes (max %d)", ErrTooLarge, len(data), f.cfg.StateMaxSize)
+	}
+
+	if len(data) < headerSize || string(data[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: bad header", errCorrupt)
+	}
+
+	flags := data[len(magic)]
+	checksum := binary.BigEndian.Uint32(data[len(magic)+1 : headerSize])
+	payload := data[headerSize:]
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", errCorrupt)
+	}
+
+	if flags&flagCompressed == 0 {
+		return payload, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errCorrupt, err)
+	}
+	defer zr.Close()
+
+	limit := int64(defaultMaxDecodedSize)
+	if f.cfg.StateMaxSize > 0 {
+		limit = f.cfg.StateMaxSize
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errCorrupt, err)
+	}
+
+	if int64(len(decoded)) > limit {
+		return nil, fmt.Errorf("%w: decompressed state is larger than %d bytes", ErrTooLarge, limit)
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,156 @@
+package statefile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testState struct {
+	Offsets map[string]int64 `json:"offsets"`
+}
+
+func newState() testState {
+	return testState{Offsets: map[string]int64{
+		"/var/log/auth.log":  1234,
+		"/var/log/nginx.log": 5678,
+	}}
+}
+
+func TestSaveLoad(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "state")
+		f := New(path, Config{StateCompress: compress}, log.WithField("test", t.Name()))
+
+		var loaded testState
+
+		found, err := f.Load(&loaded)
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		require.NoError(t, f.Save(newState()))
+
+		found, err = f.Load(&loaded)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, newState(), loaded)
+
+		// reads don't depend on the current configuration
+		loaded = testState{}
+		found, err = New(path, Config{StateCompress: !compress}, f.logger).Load(&loaded)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, newState(), loaded)
+
+		require.NoError(t, f.Remove())
+		found, err = f.Load(&loaded)
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		// no error if there is nothing to remove
+		require.NoError(t, f.Remove())
+	}
+}
+
+func TestCompressionReducesSize(t *testing.T) {
+	dir := t.TempDir()
+	state := testState{Offsets: map[string]int64{}}
+
+	for i := range 100 {
+		state.Offsets[strings.Repeat("x", i)] = int64(i)
+	}
+
+	plain := New(filepath.Join(dir, "plain"), Config{}, log.WithField("test", t.Name()))
+	compressed := New(filepath.Join(dir, "compressed"), Config{StateCompress: true}, log.WithField("test", t.Name()))
+
+	require.NoError(t, plain.Save(state))
+	require.NoError(t, compressed.Save(state))
+
+	plainInfo, err := os.Stat(plain.Path())
+	require.NoError(t, err)
+
+	compressedInfo, err := os.Stat(compressed.Path())
+	require.NoError(t, err)
+
+	assert.Less(t, compressedInfo.Size(), plainInfo.Size())
+}
+
+func TestMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	f := New(path, Config{}, log.WithField("test", t.Name()))
+	require.NoError(t, f.Save(newState()))
+
+	f = New(path, Config{StateMaxSize: 20}, f.logger)
+
+	err := f.Save(newState())
+	require.ErrorIs(t, err, ErrTooLarge)
+
+	// the previous state was kept, but it is too large to be loaded
+	var loaded testState
+
+	found, err := f.Load(&loaded)
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.NoFileExists(t, path)
+}
+
+func TestCorruptedFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func([]byte) []byte
+	}{
+		{
+			name:    "truncated",
+			corrupt: func(b []byte) []byte { return b[:len(b)/2] },
+		},
+		{
+			name:    "bad header",
+			corrupt: func(b []byte) []byte { return append([]byte("XXXX"), b[4:]...) },
+		},
+		{
+			name: "flipped byte",
+			corrupt: func(b []byte) []byte {
+				b[len(b)-1] ^= 0xff
+				return b
+			},
+		},
+		{
+			name:    "empty",
+			corrupt: func([]byte) []byte { return nil },
+		},
+		{
+			name:    "legacy json",
+			corrupt: func([]byte) []byte { return []byte(`{"offsets": {}}`) },
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state")
+			f := New(path, Config{StateCompress: true}, log.WithField("test", t.Name()))
+			require.NoError(t, f.Save(newState()))
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(path, tc.corrupt(data), 0o600))
+
+			var loaded testState
+
+			found, err := f.Load(&loaded)
+			require.NoError(t, err)
+			assert.False(t, found)
+			assert.NoFileExists(t, path)
+
+			// the state can be saved again
+			require.NoError(t, f.Save(newState()))
+			found, err = f.Load(&loaded)
+			require.NoError(t, err)
+			assert.True(t, found)
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package journalctlacquisition
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// decodeJob is a line handed to the workers. With preserve_order, the event is sent back on
+// its own channel, and the channels are read in the order of the lines.
+type decodeJob struct {
+	line   string
+	result chan decodeResult // nil without preserve_order
+}
+
+type decodeResult struct {
+	evt types.Event
+	ok  bool
+}
+
+// startDecoders decodes the lines of journalctl with a pool of workers, until the context is
+// done. At most max(read_ahead, workers) lines are being decoded at once. The events channel is
+// closed once the lines channel is closed and all its lines are decoded.
+func (j *JournalCtlSource) startDecoders(ctx context.Context, lines chan string, logger *log.Entry, t *tomb.Tomb) chan types.Event {
+	inFlight := max(j.config.ReadAhead, j.config.Workers)
+
+	jobs := make(chan decodeJob, inFlight)
+	events := make(chan types.Event, inFlight)
+
+	var pending chan chan decodeResult
+
+	if *j.config.PreserveOrder {
+		pending = make(chan chan decodeResult, inFlight)
+	}
+
+	t.Go(func() error {
+		for {
+			var (
+				line string
+				ok   bool
+			)
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case line, ok = <-lines:
+			}
+
+			if !ok {
+				close(jobs)
+
+				if pending != nil {
+					close(pending)
+				}
+
+				return nil
+			}
+
+			job := decodeJob{line: line}
+
+			if pending != nil {
+				job.result = make(chan decodeResult, 1)
+
+				select {
+				case pending <- job.result:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+
+	var workers sync.WaitGroup
+
+	for range j.config.Workers {
+		workers.Add(1)
+
+		t.Go(func() error {
+			defer workers.Done()
+
+			for {
+				var (
+					job decodeJob
+					ok  bool
+				)
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case job, ok = <-jobs:
+				}
+
+				if !ok {
+					return nil
+				}
+
+				evt, ok := j.makeEvent(job.line, logger)
+
+				if job.result != nil {
+					job.result <- decodeResult{evt: evt, ok: ok}
+					continue
+				}
+
+				if !ok {
+					continue
+				}
+
+				select {
+				case events <- evt:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		})
+	}
+
+	if pending == nil {
+		go func() {
+			workers.Wait()
+			close(events)
+		}()
+	} else {
+		t.Go(func() error {
+			for {
+				var (
+					result chan decodeResult
+					ok     bool
+				)
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case result, ok = <-pending:
+				}
+
+				if !ok {
+					close(events)
+					return nil
+				}
+
+				var r decodeResult
+
+				select {
+				case r = <-result:
+				case <-ctx.Done():
+					return nil
+				}
+
+				if !r.ok {
+					continue
+				}
+
+				select {
+				case events <- r.evt:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		})
+	}
+
+	return events
+}
@@ -0,0 +1,148 @@
+package journalctlacquisition
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metaPrefix is prepended to the journal field names with capture_all_fields.
+const metaPrefix = "journald_"
+
+// journalEntry is an entry decoded from the output of journalctl -o json.
+type journalEntry struct {
+	message string
+	time    time.Time // zero if __REALTIME_TIMESTAMP is missing
+	meta    map[string]string
+}
+
+// entryCursor returns the __CURSOR field of a line from journalctl -o json, or an empty string.
+func entryCursor(line string) string {
+	var entry struct {
+		Cursor string `json:"__CURSOR"`
+	}
+
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return ""
+	}
+
+	return entry.Cursor
+}
+
+// parseEntry decodes a line from journalctl -o json.
+//
+// Values are strings, except for the fields with non-printable or non UTF-8 data which are
+// arrays of bytes, the fields with several values which are arrays, and the fields larger than
+// 4096 bytes which are null. The binary fields are skipped, except for MESSAGE. The values of a
+// multi-valued field are joined by commas.
+//
+// The field names are lowercased without their leading underscores, so _SYSTEMD_UNIT is stored
+// as journald_systemd_unit. The trusted fields (set by journald, with a leading underscore) take
+// precedence over the fields with the same name sent by the clients.
+func parseEntry(line string, maxFieldSize int) (journalEntry, error) {
+	fields := map[string]json.RawMessage{}
+
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return journalEntry{}, fmt.Errorf("invalid journal entry: %w", err)
+	}
+
+	entry := journalEntry{meta: make(map[string]string, len(fields))}
+	trusted := map[string]bool{}
+
+	for name, raw := range fields {
+		switch name {
+		case "MESSAGE":
+			entry.message = decodeMessage(raw)
+			continue
+		case "__REALTIME_TIMESTAMP":
+			entry.time = decodeTimestamp(raw)
+		}
+
+		value, ok := decodeValue(raw)
+		if !ok || len(value) > maxFieldSize {
+			continue
+		}
+
+		key := metaPrefix + strings.ToLower(strings.TrimLeft(name, "_"))
+		isTrusted := strings.HasPrefix(name, "_")
+
+		if _, exists := entry.meta[key]; exists && trusted[key] && !isTrusted {
+			continue
+		}
+
+		entry.meta[key] = value
+		trusted[key] = isTrusted
+	}
+
+	if entry.message == "" {
+		return journalEntry{}, errors.New("journal entry without MESSAGE")
+	}
+
+	return entry, nil
+}
+
+// decodeValue returns a field value as a string. It returns false for binary and null values.
+func decodeValue(raw json.RawMessage) (string, bool) {
+	if string(raw) == "null" {
+		return "", false
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, true
+	}
+
+	// several values, some may be binary
+	var values []json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil || len(values) == 0 {
+		return "", false
+	}
+
+	strs := make([]string, 0, len(values))
+
+	for _, v := range values {
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			strs = append(strs, s)
+		}
+	}
+
+	if len(strs) == 0 {
+		return "", false
+	}
+
+	return strings.Join(strs, ","), true
+}
+
+// decodeMessage returns MESSAGE as a string, even when it's sent as an array of bytes.
+func decodeMessage(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+
+	// an array of numbers, a JSON string would be decoded from base64
+	var b []byte
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+func decodeTimestamp(raw json.RawMessage) time.Time {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return time.Time{}
+	}
+
+	usec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.UnixMicro(usec).UTC()
+}
@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"net/url"
 	"os/exec"
+	"reflect"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	yaml "github.com/goccy/go-yaml"
@@ -15,6 +18,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/tomb.v2"
 
+	"github.com/crowdsecurity/go-cs-lib/ptr"
 	"github.com/crowdsecurity/go-cs-lib/trace"
 
 	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
@@ -24,6 +28,18 @@ import (
 type JournalCtlConfiguration struct {
 	configuration.DataSourceCommonCfg `yaml:",inline"`
 	Filters                           []string `yaml:"journalctl_filter"`
+	// CaptureAllFields reads the entries with -o json and stores all their fields in the
+	// event metadata. The output of journalctl is several times larger, each entry has to be
+	// decoded, and the metadata is carried by the event through the parsers and scenarios:
+	// expect a noticeably higher CPU and memory usage on busy journals.
+	CaptureAllFields bool `yaml:"capture_all_fields"`
+	MaxFieldSize     int  `yaml:"max_field_size"` // larger fields are not captured
+	// Workers decode the entries in parallel, which helps with capture_all_fields on busy
+	// journals when there are idle CPUs: on a single CPU, the pool is slower than the reader.
+	// The events keep the order of the journal unless PreserveOrder is false.
+	Workers       int   `yaml:"workers"`
+	PreserveOrder *bool `yaml:"preserve_order"`
+	ReadAhead     int   `yaml:"read_ahead"` // lines read from journalctl before they are decoded
 }
 
 type JournalCtlSource struct {
@@ -32,6 +48,9 @@ type JournalCtlSource struct {
 	logger       *log.Entry
 	src          string
 	args         []string
+
+	reloadMu sync.Mutex
+	reload   chan []string // new filters for the running journalctl, see ReloadQuery
 }
 
 const journalctlCmd string = "journalctl"
@@ -41,6 +60,14 @@ var (
 	journalctlArgstreaming = []string{"--follow", "-n", "0"}
 )
 
+// journalctlTimeFormat is the format of --since, with microseconds
+const journalctlTimeFormat = "2006-01-02 15:04:05.000000"
+
+const (
+	defaultMaxFieldSize = 4096 // what journalctl outputs without --all
+	maxJSONLineSize     = 1024 * 1024
+)
+
 var linesRead = prometheus.NewCounterVec(
 	prometheus.CounterOpts{
 		Name: "cs_journalctlsource_hits_total",
@@ -48,10 +75,47 @@ var linesRead = prometheus.NewCounterVec(
 	},
 	[]string{"source"})
 
-func readLine(scanner *bufio.Scanner, out chan string, errChan chan error) error {
+// position is the last line read from the stdout of journalctl, where the command resumes when
+// the filters are reloaded.
+type position struct {
+	mu   sync.Mutex
+	line string
+	read time.Time
+}
+
+func (p *position) set(line string) {
+	p.mu.Lock()
+	p.line = line
+	p.read = time.Now()
+	p.mu.Unlock()
+}
+
+func (p *position) get() (string, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.line, p.read
+}
+
+// readLine sends the lines of the scanner to out. For stdout (errChan is set), out is closed at
+// the end of the output, before errChan, and the lines that were sent are recorded in pos.
+func readLine(ctx context.Context, scanner *bufio.Scanner, out chan string, errChan chan error, pos *position) error {
 	for scanner.Scan() {
 		txt := scanner.Text()
-		out <- txt
+		select {
+		case out <- txt:
+		case <-ctx.Done():
+			// the command was stopped, nobody is reading anymore
+			return nil
+		}
+
+		if pos != nil {
+			pos.set(txt)
+		}
+	}
+
+	if errChan != nil {
+		close(out)
 	}
 
 	if errChan != nil && scanner.Err() != nil {
@@ -69,24 +133,44 @@ func readLine(scanner *bufio.Scanner, out chan string, errChan chan error) error
 }
 
 func (j *JournalCtlSource) runJournalCtl(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	args := j.args
+	pos := &position{read: time.Now()}
+
+	for {
+		newArgs, err := j.runJournalCtlCommand(ctx, args, pos, out, t)
+		if newArgs == nil {
+			return err
+		}
+
+		args = newArgs
+	}
+}
+
+// runJournalCtlCommand runs journalctl until the tomb dies or the filters are reloaded.
+// In the latter case, it returns the arguments for the next command.
+func (j *JournalCtlSource) runJournalCtlCommand(ctx context.Context, args []string, pos *position, out chan types.Event, t *tomb.Tomb) ([]string, error) {
 	ctx, cancel := context.WithCancel(ctx)
 
-	cmd := exec.CommandContext(ctx, journalctlCmd, j.args...)
+	// on a reload, journalctl is stopped before the readers and the decoders
+	cmdCtx, stopCmd := context.WithCancel(ctx)
+	defer stopCmd()
+
+	cmd := exec.CommandContext(cmdCtx, journalctlCmd, args...)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		cancel()
-		return fmt.Errorf("could not get journalctl stdout: %w", err)
+		return nil, fmt.Errorf("could not get journalctl stdout: %w", err)
 	}
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		cancel()
-		return fmt.Errorf("could not get journalctl stderr: %w", err)
+		return nil, fmt.Errorf("could not get journalctl stderr: %w", err)
 	}
 
 	stderrChan := make(chan string)
-	stdoutChan := make(chan string)
+	stdoutChan := make(chan string, j.config.ReadAhead)
 	errChan := make(chan error, 1)
 
 	logger := j.logger.WithField("src", j.src)
@@ -97,7 +181,7 @@ func (j *JournalCtlSource) runJournalCtl(ctx context.Context, out chan types.Eve
 	if err != nil {
 		cancel()
 		logger.Errorf("could not start journalctl command : %s", err)
-		return err
+		return nil, err
 	}
 
 	stdoutscanner := bufio.NewScanner(stdout)
@@ -105,7 +189,11 @@ func (j *JournalCtlSource) runJournalCtl(ctx context.Context, out chan types.Eve
 	if stdoutscanner == nil {
 		cancel()
 		cmd.Wait()
-		return errors.New("failed to create stdout scanner")
+		return nil, errors.New("failed to create stdout scanner")
+	}
+
+	if j.config.CaptureAllFields {
+		stdoutscanner.Buffer(nil, maxJSONLineSize)
 	}
 
 	stderrScanner := bufio.NewScanner(stderr)
@@ -113,18 +201,28 @@ func (j *JournalCtlSource) runJournalCtl(ctx context.Context, out chan types.Eve
 	if stderrScanner == nil {
 		cancel()
 		cmd.Wait()
-		return errors.New("failed to create stderr scanner")
+		return nil, errors.New("failed to create stderr scanner")
 	}
 
 	t.Go(func() error {
-		return readLine(stdoutscanner, stdoutChan, errChan)
+		return readLine(ctx, stdoutscanner, stdoutChan, errChan, pos)
 	})
 
 	t.Go(func() error {
 		// looks like journalctl closes stderr quite early, so ignore its status (but not its output)
-		return readLine(stderrScanner, stderrChan, nil)
+		return readLine(ctx, stderrScanner, stderrChan, nil, nil)
 	})
 
+	// with a single worker, the lines are decoded here
+	lines := stdoutChan
+
+	var events chan types.Event
+
+	if j.config.Workers > 1 {
+		events = j.startDecoders(ctx, stdoutChan, logger, t)
+		lines = nil
+	}
+
 	for {
 		select {
 		case <-t.Dying():
@@ -132,23 +230,36 @@ func (j *JournalCtlSource) runJournalCtl(ctx context.Context, out chan types.Eve
 			cancel()
 			cmd.Wait() // avoid zombie process
 
-			return nil
-		case stdoutLine := <-stdoutChan:
-			l := types.Line{}
-			l.Raw = stdoutLine
-			logger.Debugf("getting one line : %s", l.Raw)
-			l.Labels = j.config.Labels
-			l.Time = time.Now().UTC()
-			l.Src = j.src
-			l.Process = true
-			l.Module = j.GetName()
-
-			if j.metricsLevel != configuration.METRICS_NONE {
-				linesRead.With(prometheus.Labels{"source": j.src}).Inc()
+			return nil, nil
+		case filters := <-j.reload:
+			logger.Infof("restarting journalctl with filters %v", filters)
+			stopCmd()
+			// send what was read ahead or is being decoded, until the end of the output.
+			// Wait closes the pipes, so it must come after.
+			j.drain(stdoutChan, events, out, logger)
+			cmd.Wait() // avoid zombie process
+			cancel()
+
+			return j.buildArgs(filters, pos), nil
+		case stdoutLine, ok := <-lines:
+			if !ok {
+				// end of the output, errChan is closed next
+				lines = nil
+				continue
+			}
+
+			evt, ok := j.makeEvent(stdoutLine, logger)
+			if !ok {
+				continue
+			}
+
+			out <- evt
+		case evt, ok := <-events:
+			if !ok {
+				events = nil
+				continue
 			}
 
-			evt := types.MakeEvent(j.config.UseTimeMachine, types.LOG, true)
-			evt.Line = l
 			out <- evt
 		case stderrLine := <-stderrChan:
 			logger.Warnf("Got stderr message : %s", stderrLine)
@@ -157,6 +268,8 @@ func (j *JournalCtlSource) runJournalCtl(ctx context.Context, out chan types.Eve
 		case errScanner, ok := <-errChan:
 			if !ok {
 				logger.Debugf("errChan is closed, quitting")
+				// the end of the output: send the lines that were read ahead or are being decoded
+				j.drain(stdoutChan, events, out, logger)
 				t.Kill(nil)
 			}
 
@@ -167,6 +280,67 @@ func (j *JournalCtlSource) runJournalCtl(ctx context.Context, out chan types.Eve
 	}
 }
 
+// drain sends the remaining events once journalctl is done. Without workers, they are in the
+// read-ahead of the closed stdout channel, otherwise the decoders close the events channel when
+// they are done.
+func (j *JournalCtlSource) drain(stdoutChan chan string, events chan types.Event, out chan types.Event, logger *log.Entry) {
+	if events == nil {
+		for line := range stdoutChan {
+			if evt, ok := j.makeEvent(line, logger); ok {
+				out <- evt
+			}
+		}
+
+		return
+	}
+
+	for evt := range events {
+		out <- evt
+	}
+}
+
+// makeEvent builds the event of a line of journalctl. It returns false if the entry is skipped.
+func (j *JournalCtlSource) makeEvent(line string, logger *log.Entry) (types.Event, bool) {
+	l := types.Line{}
+	l.Raw = line
+	logger.Debugf("getting one line : %s", l.Raw)
+	l.Labels = j.config.Labels
+	l.Time = time.Now().UTC()
+	l.Src = j.src
+	l.Process = true
+	l.Module = j.GetName()
+
+	var meta map[string]string
+
+	if j.config.CaptureAllFields {
+		entry, err := parseEntry(line, j.config.MaxFieldSize)
+		if err != nil {
+			logger.Warnf("skipping journal entry: %s", err)
+			return types.Event{}, false
+		}
+
+		l.Raw = entry.message
+		if !entry.time.IsZero() {
+			l.Time = entry.time
+		}
+
+		meta = entry.meta
+	}
+
+	if j.metricsLevel != configuration.METRICS_NONE {
+		linesRead.With(prometheus.Labels{"source": j.src}).Inc()
+	}
+
+	evt := types.MakeEvent(j.config.UseTimeMachine, types.LOG, true)
+	evt.Line = l
+
+	for key, value := range meta {
+		evt.Meta[key] = value
+	}
+
+	return evt, true
+}
+
 func (j *JournalCtlSource) GetUuid() string {
 	return j.config.UniqueId
 }
@@ -191,25 +365,128 @@ func (j *JournalCtlSource) UnmarshalConfig(yamlConfig []byte) error {
 		j.config.Mode = configuration.TAIL_MODE
 	}
 
-	var args []string
-	if j.config.Mode == configuration.TAIL_MODE {
-		args = journalctlArgstreaming
-	} else {
-		args = journalctlArgsOneShot
-	}
-
 	if len(j.config.Filters) == 0 {
 		return errors.New("journalctl_filter is required")
 	}
 
-	args = append(args, j.config.Filters...)
+	if j.config.MaxFieldSize < 0 {
+		return errors.New("max_field_size must be positive")
+	}
+
+	if j.config.MaxFieldSize == 0 {
+		j.config.MaxFieldSize = defaultMaxFieldSize
+	}
+
+	if j.config.Workers < 0 {
+		return errors.New("workers must be positive")
+	}
+
+	if j.config.Workers == 0 {
+		j.config.Workers = 1
+	}
+
+	if j.config.PreserveOrder == nil {
+		j.config.PreserveOrder = ptr.Of(true)
+	}
+
+	if j.config.ReadAhead < 0 {
+		return errors.New("read_ahead must be positive")
+	}
 
-	j.args = args
+	j.args = j.buildArgs(j.config.Filters, nil)
 	j.src = "journalctl-%s" + strings.Join(j.config.Filters, ".")
 
 	return nil
 }
 
+// buildArgs returns the journalctl arguments for the mode and filters. In tail mode, the entries
+// are read after resume if it is set, otherwise only the new entries are read.
+// With capture_all_fields, journalctl resumes after the cursor of the last entry. Otherwise, it
+// resumes from the time the last line was read: the entries logged at that time may be replayed.
+func (j *JournalCtlSource) buildArgs(filters []string, resume *position) []string {
+	var args []string
+
+	switch {
+	case j.config.Mode != configuration.TAIL_MODE:
+		args = slices.Clone(journalctlArgsOneShot)
+	case resume == nil:
+		args = slices.Clone(journalctlArgstreaming)
+	default:
+		line, read := resume.get()
+
+		cursor := ""
+		if j.config.CaptureAllFields {
+			cursor = entryCursor(line)
+		}
+
+		if cursor != "" {
+			args = []string{"--follow", "--after-cursor", cursor}
+		} else {
+			args = []string{"--follow", "--since", read.Format(journalctlTimeFormat)}
+		}
+	}
+
+	if j.config.CaptureAllFields {
+		args = append(args, "-o", "json")
+	}
+
+	return append(args, filters...)
+}
+
+// ReloadQuery restarts the running journalctl with new filters. The filters are validated with
+// a first run of journalctl before the current one is stopped.
+func (j *JournalCtlSource) ReloadQuery(ctx context.Context, yamlConfig []byte) error {
+	j.reloadMu.Lock()
+	defer j.reloadMu.Unlock()
+
+	if j.config.Mode != configuration.TAIL_MODE || j.reload == nil {
+		return errors.New("the filters can only be reloaded in tail mode")
+	}
+
+	newSource := JournalCtlSource{logger: j.logger}
+	if err := newSource.UnmarshalConfig(yamlConfig); err != nil {
+		return err
+	}
+
+	newConfig := newSource.config
+	newConfig.Filters = j.config.Filters
+	newConfig.UniqueId = j.config.UniqueId
+
+	if !reflect.DeepEqual(newConfig, j.config) {
+		return errors.New("only journalctl_filter can be changed without a full reload")
+	}
+
+	if slices.Equal(newSource.config.Filters, j.config.Filters) {
+		return nil
+	}
+
+	if err := validateFilters(ctx, newSource.config.Filters); err != nil {
+		return err
+	}
+
+	select {
+	case j.reload <- newSource.config.Filters:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	j.config.Filters = newSource.config.Filters
+
+	return nil
+}
+
+// validateFilters runs journalctl without output to check that it accepts the filters.
+func validateFilters(ctx context.Context, filters []string) error {
+	args := append([]string{"-n", "0"}, filters...)
+
+	output, err := exec.CommandContext(ctx, journalctlCmd, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("journalctl rejected the filters: %s: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
 func (j *JournalCtlSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLevel int) error {
 	j.logger = logger
 	j.metricsLevel = metricsLevel
@@ -289,6 +566,10 @@ func (j *JournalCtlSource) OneShotAcquisition(ctx context.Context, out chan type
 }
 
 func (j *JournalCtlSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	j.reloadMu.Lock()
+	j.reload = make(chan []string)
+	j.reloadMu.Unlock()
+
 	t.Go(func() error {
 		defer trace.CatchPanic("crowdsec/acquis/journalctl/streaming")
 		return j.runJournalCtl(ctx, out, t)
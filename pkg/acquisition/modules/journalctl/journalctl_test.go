@@ -1,6 +1,8 @@
 package journalctlacquisition
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -38,6 +40,31 @@ source: journalctl`,
 		},
 		{
 			config: `
+source: journalctl
+journalctl_filter:
+ - _UID=42
+capture_all_fields: true
+max_field_size: -1`,
+			expectedErr: "max_field_size must be positive",
+		},
+		{
+			config: `
+source: journalctl
+journalctl_filter:
+ - _UID=42
+workers: -1`,
+			expectedErr: "workers must be positive",
+		},
+		{
+			config: `
+source: journalctl
+journalctl_filter:
+ - _UID=42
+read_ahead: -1`,
+			expectedErr: "read_ahead must be positive",
+		},
+		{
+			config: `
 mode: cat
 source: journalctl
 journalctl_filter:
@@ -280,6 +307,320 @@ journalctl_filter:
 	}
 }
 
+func TestReloadQuery(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	ctx := t.Context()
+
+	config := `
+source: journalctl
+journalctl_filter:
+ - _SYSTEMD_UNIT=ssh.service`
+
+	j := JournalCtlSource{}
+	err := j.Configure([]byte(config), log.WithField("type", "journalctl"), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	err = j.ReloadQuery(ctx, []byte(config))
+	require.EqualError(t, err, "the filters can only be reloaded in tail mode")
+
+	out := make(chan types.Event)
+	tmb := tomb.Tomb{}
+
+	require.NoError(t, j.StreamingAcquisition(ctx, out, &tmb))
+
+	read := func() int {
+		n := 0
+
+		for {
+			select {
+			case <-out:
+				n++
+			case <-time.After(500 * time.Millisecond):
+				return n
+			}
+		}
+	}
+
+	assert.Equal(t, 14, read())
+
+	err = j.ReloadQuery(ctx, []byte(config+"\nlabels:\n  type: syslog"))
+	require.EqualError(t, err, "only journalctl_filter can be changed without a full reload")
+
+	// the fake journalctl only accepts one filter
+	err = j.ReloadQuery(ctx, []byte(config+"\n - _SYSTEMD_UNIT=sshd.service"))
+	cstest.RequireErrorContains(t, err, "journalctl rejected the filters")
+
+	// unchanged filters don't restart journalctl
+	require.NoError(t, j.ReloadQuery(ctx, []byte(config)))
+	assert.Equal(t, 0, read())
+
+	err = j.ReloadQuery(ctx, []byte(`
+source: journalctl
+journalctl_filter:
+ - _SYSTEMD_UNIT=sshd.service`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"_SYSTEMD_UNIT=sshd.service"}, j.config.Filters)
+
+	// the fake journalctl ignores --since and prints everything again
+	assert.Equal(t, 14, read())
+
+	tmb.Kill(nil)
+	require.NoError(t, tmb.Wait())
+
+	output, _ := exec.Command("pgrep", "-x", "journalctl").CombinedOutput()
+	assert.Empty(t, output, "found a journalctl process after killing the tomb")
+}
+
+func TestReloadQueryReadAhead(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	tests := []struct {
+		name        string
+		extraConfig string
+		expected    int
+	}{
+		{
+			// the fake journalctl ignores --since and prints everything again
+			name:     "since",
+			expected: 28,
+		},
+		{
+			// the fake journalctl prints the entries after the cursor, there are none
+			name:        "cursor",
+			extraConfig: "\ncapture_all_fields: true",
+			expected:    2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := t.Context()
+
+			j := JournalCtlSource{}
+			err := j.Configure([]byte(`
+source: journalctl
+workers: 2
+read_ahead: 10
+journalctl_filter:
+ - _SYSTEMD_UNIT=ssh.service`+tc.extraConfig), log.WithField("type", "journalctl"), configuration.METRICS_NONE)
+			require.NoError(t, err)
+
+			out := make(chan types.Event)
+			tmb := tomb.Tomb{}
+
+			require.NoError(t, j.StreamingAcquisition(ctx, out, &tmb))
+
+			// once journalctl printed its output, the other lines are read ahead and
+			// decoded while nobody reads the events
+			select {
+			case <-out:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for the first event")
+			}
+
+			reloaded := make(chan error, 1)
+
+			go func() {
+				reloaded <- j.ReloadQuery(ctx, []byte(`
+source: journalctl
+workers: 2
+read_ahead: 10
+journalctl_filter:
+ - _SYSTEMD_UNIT=sshd.service`+tc.extraConfig))
+			}()
+
+			time.Sleep(500 * time.Millisecond)
+
+			n := 1
+
+		loop:
+			for {
+				select {
+				case <-out:
+					n++
+				case <-time.After(500 * time.Millisecond):
+					break loop
+				}
+			}
+
+			require.NoError(t, <-reloaded)
+			assert.Equal(t, tc.expected, n)
+
+			tmb.Kill(nil)
+			require.NoError(t, tmb.Wait())
+		})
+	}
+}
+
+func TestCaptureAllFields(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	j := JournalCtlSource{}
+	err := j.Configure([]byte(`
+source: journalctl
+mode: cat
+capture_all_fields: true
+journalctl_filter:
+ - _SYSTEMD_UNIT=ssh.service`), log.WithField("type", "journalctl"), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	out := make(chan types.Event, 10)
+	tmb := tomb.Tomb{}
+
+	require.NoError(t, j.OneShotAcquisition(t.Context(), out, &tmb))
+	require.Len(t, out, 2)
+
+	evt := <-out
+	assert.Equal(t, "Invalid user wqeqwe from 127.0.0.1 port 55818", evt.Line.Raw)
+	assert.Equal(t, time.UnixMicro(1606040539000000).UTC(), evt.Line.Time)
+	assert.Equal(t, "ssh.service", evt.Meta["journald_systemd_unit"])
+	assert.Equal(t, "sshd", evt.Meta["journald_syslog_identifier"])
+	// the trusted field wins
+	assert.Equal(t, "1480", evt.Meta["journald_pid"])
+	assert.Equal(t, "a,b", evt.Meta["journald_tag"])
+	assert.NotContains(t, evt.Meta, "journald_coredump")
+	assert.NotContains(t, evt.Meta, "journald_large")
+
+	// binary message
+	evt = <-out
+	assert.Equal(t, "Failed", evt.Line.Raw)
+}
+
+func TestWorkers(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	read := func(extraConfig string) []string {
+		j := JournalCtlSource{}
+		err := j.Configure([]byte(`
+source: journalctl
+mode: cat
+journalctl_filter:
+ - _SYSTEMD_UNIT=ssh.service
+`+extraConfig), log.WithField("type", "journalctl"), configuration.METRICS_NONE)
+		require.NoError(t, err)
+
+		out := make(chan types.Event, 100)
+		tmb := tomb.Tomb{}
+
+		require.NoError(t, j.OneShotAcquisition(t.Context(), out, &tmb))
+		close(out)
+
+		lines := []string{}
+		for evt := range out {
+			lines = append(lines, evt.Line.Raw)
+		}
+
+		return lines
+	}
+
+	expected := read("")
+	require.Len(t, expected, 14)
+
+	assert.Equal(t, expected, read("read_ahead: 5"))
+	assert.Equal(t, expected, read("workers: 4"))
+	assert.Equal(t, expected, read("workers: 4\nread_ahead: 16"))
+	assert.ElementsMatch(t, expected, read("workers: 4\npreserve_order: false"))
+}
+
+// BenchmarkDecoders compares the decoding of the entries of capture_all_fields by the reader
+// and by the worker pool.
+func BenchmarkDecoders(b *testing.B) {
+	line := `{"__REALTIME_TIMESTAMP": "1606040539000000", "_SYSTEMD_UNIT": "ssh.service", "_PID": "1480", ` +
+		`"_HOSTNAME": "zeroed", "_COMM": "sshd", "_EXE": "/usr/sbin/sshd", "_CMDLINE": "sshd: [accepted]", ` +
+		`"SYSLOG_IDENTIFIER": "sshd", "PRIORITY": "6", "SYSLOG_FACILITY": "4", "_TRANSPORT": "syslog", ` +
+		`"MESSAGE": "Invalid user wqeqwe from 127.0.0.1 port 55818"}`
+
+	logger := log.WithField("type", "journalctl")
+	logger.Logger.SetLevel(log.InfoLevel)
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			j := JournalCtlSource{logger: logger}
+			err := j.UnmarshalConfig([]byte(fmt.Sprintf(`
+source: journalctl
+journalctl_filter:
+ - _SYSTEMD_UNIT=ssh.service
+capture_all_fields: true
+workers: %d
+read_ahead: 256`, workers)))
+			require.NoError(b, err)
+
+			if workers == 1 {
+				for b.Loop() {
+					_, _ = j.makeEvent(line, logger)
+				}
+
+				return
+			}
+
+			ctx, cancel := context.WithCancel(b.Context())
+			defer cancel()
+
+			lines := make(chan string, 256)
+			tmb := tomb.Tomb{}
+			events := j.startDecoders(ctx, lines, logger, &tmb)
+
+			go func() {
+				for {
+					select {
+					case lines <- line:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+
+			for b.Loop() {
+				<-events
+			}
+		})
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	tests := []struct {
+		line        string
+		expected    map[string]string
+		expectedErr string
+	}{
+		{
+			line:        `{"MESSAGE": "foo"`,
+			expectedErr: "invalid journal entry: unexpected end of JSON input",
+		},
+		{
+			line:        `{"_PID": "42"}`,
+			expectedErr: "journal entry without MESSAGE",
+		},
+		{
+			line:     `{"MESSAGE": "foo", "FIELD": "bar", "BIG": "0123456789"}`,
+			expected: map[string]string{"journald_field": "bar"},
+		},
+		{
+			line:     `{"MESSAGE": "foo", "_COMM": "sshd", "COMM": "forged"}`,
+			expected: map[string]string{"journald_comm": "sshd"},
+		},
+		{
+			line:     `{"MESSAGE": "foo", "MIXED": ["a", [1, 2], "b"], "EMPTY": []}`,
+			expected: map[string]string{"journald_mixed": "a,b"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.line, func(t *testing.T) {
+			entry, err := parseEntry(tc.line, 8)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+
+			if tc.expectedErr != "" {
+				return
+			}
+
+			assert.Equal(t, "foo", entry.message)
+			assert.Equal(t, tc.expected, entry.meta)
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	if os.Getenv("USE_SYSTEM_JOURNALCTL") == "" {
 		fullPath, _ := filepath.Abs("./testdata")
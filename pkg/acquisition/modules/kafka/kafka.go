@@ -20,6 +20,9 @@ import (
 	"github.com/crowdsecurity/go-cs-lib/trace"
 
 	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/jsonpath"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/jsonschema"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/sourceaddr"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
 
@@ -32,14 +35,28 @@ var linesRead = prometheus.NewCounterVec(
 	},
 	[]string{"topic"})
 
+var decodeErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_kafkasource_decode_errors_total",
+		Help: "Total messages that were skipped because they could not be decoded",
+	},
+	[]string{"topic"})
+
+const maxRegistryRetryDelay = 30 * time.Second
+
 type KafkaConfiguration struct {
-	Brokers                           []string                `yaml:"brokers"`
-	Topic                             string                  `yaml:"topic"`
-	GroupID                           string                  `yaml:"group_id"`
-	Partition                         int                     `yaml:"partition"`
-	Timeout                           string                  `yaml:"timeout"`
-	TLS                               *TLSConfig              `yaml:"tls"`
-	BatchConfiguration                KafkaBatchConfiguration `yaml:"batch"`
+	Brokers                           []string                     `yaml:"brokers"`
+	Topic                             string                       `yaml:"topic"`
+	GroupID                           string                       `yaml:"group_id"`
+	Partition                         int                          `yaml:"partition"`
+	Timeout                           string                       `yaml:"timeout"`
+	TLS                               *TLSConfig                   `yaml:"tls"`
+	BatchConfiguration                KafkaBatchConfiguration      `yaml:"batch"`
+	SourceAddress                     string                       `yaml:"source_address"`
+	Format                            string                       `yaml:"format"` // raw, avro or json_schema
+	SchemaRegistry                    *SchemaRegistryConfiguration `yaml:"schema_registry"`
+	Schema                            jsonschema.Config            `yaml:",inline"` // messages that don't match are dropped
+	jsonpath.Config                   `yaml:",inline"`
 	configuration.DataSourceCommonCfg `yaml:",inline"`
 }
 
@@ -59,10 +76,13 @@ type KafkaBatchConfiguration struct {
 }
 
 type KafkaSource struct {
-	metricsLevel int
-	Config       KafkaConfiguration
-	logger       *log.Entry
-	Reader       *kafka.Reader
+	metricsLevel  int
+	Config        KafkaConfiguration
+	logger        *log.Entry
+	Reader        *kafka.Reader
+	jsonExtractor *jsonpath.Extractor
+	registry      *schemaRegistry
+	jsonSchema    *jsonschema.Validator
 }
 
 func (k *KafkaSource) GetUuid() string {
@@ -89,6 +109,40 @@ func (k *KafkaSource) UnmarshalConfig(yamlConfig []byte) error {
 		k.Config.Mode = configuration.TAIL_MODE
 	}
 
+	switch k.Config.Format {
+	case "":
+		k.Config.Format = formatRaw
+	case formatRaw, formatJSONSchema:
+	case formatAvro:
+		if k.Config.SchemaRegistry == nil {
+			return errors.New("schema_registry is required for the avro format")
+		}
+	default:
+		return fmt.Errorf("invalid format '%s': must be raw, avro or json_schema", k.Config.Format)
+	}
+
+	if k.Config.SchemaRegistry != nil {
+		if k.Config.Format != formatAvro {
+			return errors.New("schema_registry is only used with the avro format")
+		}
+
+		if err := k.Config.SchemaRegistry.validate(); err != nil {
+			return err
+		}
+
+		k.registry = newSchemaRegistry(*k.Config.SchemaRegistry)
+	}
+
+	k.jsonExtractor, err = jsonpath.NewExtractor(k.Config.Config, dataSourceName)
+	if err != nil {
+		return err
+	}
+
+	k.jsonSchema, err = jsonschema.New(k.Config.Schema)
+	if err != nil {
+		return err
+	}
+
 	k.logger.Debugf("successfully parsed kafka configuration : %+v", k.Config)
 
 	return err
@@ -145,11 +199,11 @@ func (*KafkaSource) CanRun() error {
 }
 
 func (*KafkaSource) GetMetrics() []prometheus.Collector {
-	return []prometheus.Collector{linesRead}
+	return []prometheus.Collector{linesRead, decodeErrors, jsonpath.MissingFields, jsonschema.Invalid}
 }
 
 func (*KafkaSource) GetAggregMetrics() []prometheus.Collector {
-	return []prometheus.Collector{linesRead}
+	return []prometheus.Collector{linesRead, decodeErrors, jsonpath.MissingFields, jsonschema.Invalid}
 }
 
 func (k *KafkaSource) Dump() any {
@@ -169,7 +223,7 @@ func (k *KafkaSource) ReadMessage(ctx context.Context, out chan types.Event) err
 
 		m, err := k.Reader.ReadMessage(ctx)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
 				return nil
 			}
 
@@ -179,8 +233,24 @@ func (k *KafkaSource) ReadMessage(ctx context.Context, out chan types.Event) err
 		}
 
 		k.logger.Tracef("got message: %s", string(m.Value))
+
+		raw, ok := k.decode(ctx, m.Value)
+		if !ok {
+			continue
+		}
+
+		if err := k.jsonSchema.Validate([]byte(raw)); err != nil {
+			k.logger.Warnf("dropping message from topic '%s': %s", k.Config.Topic, err)
+
+			if k.metricsLevel != configuration.METRICS_NONE {
+				jsonschema.Invalid.With(prometheus.Labels{"datasource": dataSourceName}).Inc()
+			}
+
+			continue
+		}
+
 		l := types.Line{
-			Raw:     string(m.Value),
+			Raw:     raw,
 			Labels:  k.Config.Labels,
 			Time:    m.Time.UTC(),
 			Src:     k.Config.Topic,
@@ -195,14 +265,49 @@ func (k *KafkaSource) ReadMessage(ctx context.Context, out chan types.Event) err
 
 		evt := types.MakeEvent(k.Config.UseTimeMachine, types.LOG, true)
 		evt.Line = l
+		k.jsonExtractor.Apply(&evt)
 		out <- evt
 	}
 }
 
+// decode returns the line of a message. If the schema registry is unavailable, it waits for it
+// unless the policy is to skip the message. It returns false if the message must be skipped.
+func (k *KafkaSource) decode(ctx context.Context, value []byte) (string, bool) {
+	delay := time.Second
+
+	for {
+		line, err := k.decodeValue(ctx, value)
+		if err == nil {
+			return line, true
+		}
+
+		if !errors.Is(err, errUnavailable) || k.Config.SchemaRegistry.OnUnavailable == registryPolicySkip {
+			k.logger.Warnf("skipping message from topic '%s': %s", k.Config.Topic, err)
+
+			if k.metricsLevel != configuration.METRICS_NONE {
+				decodeErrors.With(prometheus.Labels{"topic": k.Config.Topic}).Inc()
+			}
+
+			return "", false
+		}
+
+		k.logger.Warnf("%s, retrying in %s", err, delay)
+
+		select {
+		case <-ctx.Done():
+			return "", false
+		case <-time.After(delay):
+		}
+
+		delay = min(2*delay, maxRegistryRetryDelay)
+	}
+}
+
 func (k *KafkaSource) RunReader(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
 	k.logger.Debugf("starting %s datasource reader goroutine with configuration %+v", dataSourceName, k.Config)
 	t.Go(func() error {
-		return k.ReadMessage(ctx, out)
+		// stop waiting for the schema registry when the datasource is stopped
+		return k.ReadMessage(t.Context(ctx), out)
 	})
 	//nolint //fp
 	for {
@@ -276,6 +381,15 @@ func (kc *KafkaConfiguration) NewDialer() (*kafka.Dialer, error) {
 		DualStack: true,
 	}
 
+	localAddr, err := sourceaddr.Resolve(kc.SourceAddress)
+	if err != nil {
+		return dialer, err
+	}
+
+	if localAddr != nil {
+		dialer.LocalAddr = localAddr
+	}
+
 	if kc.TLS != nil {
 		tlsConfig, err := kc.NewTLSConfig()
 		if err != nil {
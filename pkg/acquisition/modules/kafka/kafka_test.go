@@ -53,6 +53,54 @@ timeout: aa`,
 		{
 			config: `
 source: kafka
+brokers:
+  - localhost:9092
+topic: crowdsec
+format: protobuf`,
+			expectedErr: "invalid format 'protobuf': must be raw, avro or json_schema",
+		},
+		{
+			config: `
+source: kafka
+brokers:
+  - localhost:9092
+topic: crowdsec
+format: avro`,
+			expectedErr: "schema_registry is required for the avro format",
+		},
+		{
+			config: `
+source: kafka
+brokers:
+  - localhost:9092
+topic: crowdsec
+format: avro
+schema_registry:
+  url: http://localhost:8081
+  on_unavailable: buffer`,
+			expectedErr: "invalid schema_registry.on_unavailable 'buffer': must be wait or skip",
+		},
+		{
+			config: `
+source: kafka
+brokers:
+  - localhost:9092
+topic: crowdsec
+source_address: 192.0.2.1`,
+			expectedErr: "cannot create kafka dialer: source_address '192.0.2.1' is not assigned to a local interface",
+		},
+		{
+			config: `
+source: kafka
+brokers:
+  - localhost:9092
+topic: crowdsec
+json_schema_file: /does/not/exist.json`,
+			expectedErr: "json_schema_file: open /does/not/exist.json",
+		},
+		{
+			config: `
+source: kafka
 brokers:
   - localhost:9092
 topic: crowdsec`,
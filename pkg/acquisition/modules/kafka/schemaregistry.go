@@ -0,0 +1,217 @@
+package kafkaacquisition
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hamba/avro/v2"
+)
+
+const (
+	formatRaw        = "raw"
+	formatAvro       = "avro"
+	formatJSONSchema = "json_schema"
+
+	registryPolicyWait = "wait"
+	registryPolicySkip = "skip"
+
+	// magic byte, then the schema id on 4 bytes (confluent wire format)
+	wireHeaderSize = 5
+
+	defaultRegistryTimeout = 5 * time.Second
+)
+
+type SchemaRegistryConfiguration struct {
+	URL      string        `yaml:"url"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// what to do with the messages while the registry can't be reached: wait (pause the
+	// consumption until it is back) or skip (drop them)
+	OnUnavailable string `yaml:"on_unavailable"`
+}
+
+// errUnavailable is returned when the schema registry can't be reached or fails: the message
+// may be decoded later, unlike messages with an unknown schema or invalid content.
+var errUnavailable = errors.New("schema registry unavailable")
+
+// schemaRegistry fetches the writer schemas by id, and keeps them forever: a schema id never
+// changes once registered.
+type schemaRegistry struct {
+	config SchemaRegistryConfiguration
+	client *http.Client
+
+	mu      sync.Mutex
+	schemas map[uint32]avro.Schema
+}
+
+func (c *SchemaRegistryConfiguration) validate() error {
+	if c.URL == "" {
+		return errors.New("schema_registry.url is required")
+	}
+
+	if _, err := url.ParseRequestURI(c.URL); err != nil {
+		return fmt.Errorf("invalid schema_registry.url: %w", err)
+	}
+
+	switch c.OnUnavailable {
+	case "":
+		c.OnUnavailable = registryPolicyWait
+	case registryPolicyWait, registryPolicySkip:
+	default:
+		return fmt.Errorf("invalid schema_registry.on_unavailable '%s': must be wait or skip", c.OnUnavailable)
+	}
+
+	if c.Timeout < 0 {
+		return errors.New("schema_registry.timeout must be positive")
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = defaultRegistryTimeout
+	}
+
+	return nil
+}
+
+func newSchemaRegistry(config SchemaRegistryConfiguration) *schemaRegistry {
+	return &schemaRegistry{
+		config:  config,
+		client:  &http.Client{Timeout: config.Timeout},
+		schemas: map[uint32]avro.Schema{},
+	}
+}
+
+func (r *schemaRegistry) schema(ctx context.Context, id uint32) (avro.Schema, error) {
+	r.mu.Lock()
+	schema, ok := r.schemas[id]
+	r.mu.Unlock()
+
+	if ok {
+		return schema, nil
+	}
+
+	schema, err := r.fetch(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.schemas[id] = schema
+	r.mu.Unlock()
+
+	return schema, nil
+}
+
+func (r *schemaRegistry) fetch(ctx context.Context, id uint32) (avro.Schema, error) {
+	u := strings.TrimSuffix(r.config.URL, "/") + "/schemas/ids/" + strconv.FormatUint(uint64(id), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+
+	if r.config.Username != "" || r.config.Password != "" {
+		req.SetBasicAuth(r.config.Username, r.config.Password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errUnavailable, err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errUnavailable, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("schema %d not found", id)
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return nil, fmt.Errorf("%w: HTTP %d", errUnavailable, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("while fetching schema %d: HTTP %d: %s", id, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var payload struct {
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+	}
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("while decoding schema %d: %w", id, err)
+	}
+
+	if payload.SchemaType != "" && payload.SchemaType != "AVRO" {
+		return nil, fmt.Errorf("schema %d is not an avro schema (%s)", id, payload.SchemaType)
+	}
+
+	schema, err := avro.Parse(payload.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing schema %d: %w", id, err)
+	}
+
+	return schema, nil
+}
+
+// splitWireFormat returns the schema id and the payload of a message in the confluent wire
+// format.
+func splitWireFormat(value []byte) (uint32, []byte, error) {
+	if len(value) < wireHeaderSize || value[0] != 0 {
+		return 0, nil, errors.New("not in the schema registry wire format")
+	}
+
+	return binary.BigEndian.Uint32(value[1:wireHeaderSize]), value[wireHeaderSize:], nil
+}
+
+// decodeValue converts a message to the JSON line sent to the parsers, according to format.
+func (k *KafkaSource) decodeValue(ctx context.Context, value []byte) (string, error) {
+	switch k.Config.Format {
+	case formatAvro:
+		id, payload, err := splitWireFormat(value)
+		if err != nil {
+			return "", err
+		}
+
+		schema, err := k.registry.schema(ctx, id)
+		if err != nil {
+			return "", err
+		}
+
+		var record any
+		if err := avro.Unmarshal(schema, payload, &record); err != nil {
+			return "", fmt.Errorf("while decoding avro message (schema %d): %w", id, err)
+		}
+
+		line, err := json.Marshal(record)
+		if err != nil {
+			return "", fmt.Errorf("while converting avro message to JSON: %w", err)
+		}
+
+		return string(line), nil
+	case formatJSONSchema:
+		// the schema is not needed to read a JSON payload, it is not checked
+		_, payload, err := splitWireFormat(value)
+		if err != nil {
+			return "", err
+		}
+
+		return string(payload), nil
+	default:
+		return string(value), nil
+	}
+}
@@ -0,0 +1,142 @@
+package kafkaacquisition
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hamba/avro/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+)
+
+const testSchema = `{
+  "type": "record",
+  "name": "access",
+  "fields": [
+    {"name": "message", "type": "string"},
+    {"name": "status", "type": "int"},
+    {"name": "user", "type": ["null", "string"], "default": null}
+  ]
+}`
+
+type testRecord struct {
+	Message string  `avro:"message"`
+	Status  int     `avro:"status"`
+	User    *string `avro:"user"`
+}
+
+func wireFormat(t *testing.T, id uint32, rec testRecord) []byte {
+	schema, err := avro.Parse(testSchema)
+	require.NoError(t, err)
+
+	payload, err := avro.Marshal(schema, rec)
+	require.NoError(t, err)
+
+	header := make([]byte, wireHeaderSize)
+	binary.BigEndian.PutUint32(header[1:], id)
+
+	return append(header, payload...)
+}
+
+func newFakeRegistry(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	hits := &atomic.Int32{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			fmt.Fprintf(w, `{"schema": %q}`, testSchema)
+		case "/schemas/ids/2":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, hits
+}
+
+func newAvroSource(t *testing.T, registryURL string, policy string) *KafkaSource {
+	k := &KafkaSource{logger: log.WithField("type", dataSourceName)}
+	err := k.UnmarshalConfig(fmt.Appendf(nil, `
+source: kafka
+brokers:
+  - localhost:9092
+topic: crowdsec
+format: avro
+schema_registry:
+  url: %s
+  on_unavailable: %s`, registryURL, policy))
+	require.NoError(t, err)
+
+	return k
+}
+
+func TestDecodeAvro(t *testing.T) {
+	ctx := t.Context()
+	srv, hits := newFakeRegistry(t)
+	k := newAvroSource(t, srv.URL, "skip")
+
+	user := "admin"
+
+	for range 2 {
+		line, err := k.decodeValue(ctx, wireFormat(t, 1, testRecord{Message: "GET /login", Status: 401, User: &user}))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"message": "GET /login", "status": 401, "user": "admin"}`, line)
+	}
+
+	// the schema is cached
+	assert.Equal(t, int32(1), hits.Load())
+
+	_, err := k.decodeValue(ctx, wireFormat(t, 2, testRecord{}))
+	require.ErrorIs(t, err, errUnavailable)
+
+	_, err = k.decodeValue(ctx, wireFormat(t, 3, testRecord{}))
+	require.EqualError(t, err, "schema 3 not found")
+
+	_, err = k.decodeValue(ctx, []byte(`{"message": "not avro"}`))
+	require.EqualError(t, err, "not in the schema registry wire format")
+
+	_, ok := k.decode(ctx, wireFormat(t, 2, testRecord{}))
+	assert.False(t, ok)
+}
+
+func TestDecodeWaitForRegistry(t *testing.T) {
+	srv, hits := newFakeRegistry(t)
+	k := newAvroSource(t, srv.URL, "wait")
+
+	ctx, cancel := context.WithTimeout(t.Context(), 1500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, ok := k.decode(ctx, wireFormat(t, 2, testRecord{}))
+	assert.False(t, ok)
+
+	// retried until the context was canceled
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.GreaterOrEqual(t, hits.Load(), int32(2))
+}
+
+func TestDecodeJSONSchema(t *testing.T) {
+	k := &KafkaSource{Config: KafkaConfiguration{Format: formatJSONSchema}}
+
+	value := append([]byte{0, 0, 0, 0, 7}, []byte(`{"message": "hello"}`)...)
+
+	line, err := k.decodeValue(t.Context(), value)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"message": "hello"}`, line)
+
+	_, err = k.decodeValue(t.Context(), []byte(`{"message": "hello"}`))
+	cstest.RequireErrorContains(t, err, "not in the schema registry wire format")
+}
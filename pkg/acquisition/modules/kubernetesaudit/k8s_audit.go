@@ -2,12 +2,18 @@ package kubernetesauditacquisition
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"os"
+	"slices"
 	"strings"
+	"time"
 
 	yaml "github.com/goccy/go-yaml"
 	"github.com/prometheus/client_golang/prometheus"
@@ -18,16 +24,38 @@ import (
 	"github.com/crowdsecurity/go-cs-lib/trace"
 
 	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/clientip"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/connlimit"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/httpbody"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/ipfilter"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
 
+const defaultMaxBodySize = 10 * 1024 * 1024
+
+// supportedAPIVersions are the versions of the audit API the datasource can decode. An empty
+// version is accepted for compatibility with older setups.
+var supportedAPIVersions = []string{"", "audit.k8s.io/v1", "audit.k8s.io/v1beta1"}
+
 type KubernetesAuditConfiguration struct {
-	ListenAddr                        string `yaml:"listen_addr"`
-	ListenPort                        int    `yaml:"listen_port"`
-	WebhookPath                       string `yaml:"webhook_path"`
+	ListenAddr                        string          `yaml:"listen_addr"`
+	ListenPort                        int             `yaml:"listen_port"`
+	WebhookPath                       string          `yaml:"webhook_path"`
+	TLS                               *TLSConfig      `yaml:"tls"`
+	MaxBodySize                       int64           `yaml:"max_body_size"` // once decompressed
+	MaxConnections                    int             `yaml:"max_connections"`
+	Body                              httpbody.Config `yaml:",inline"`
+	Proxies                           clientip.Config `yaml:",inline"`
+	ipfilter.Config                   `yaml:",inline"`
 	configuration.DataSourceCommonCfg `yaml:",inline"`
 }
 
+type TLSConfig struct {
+	ServerCert string `yaml:"server_cert"`
+	ServerKey  string `yaml:"server_key"`
+	CaCert     string `yaml:"ca_cert"` // if set, the API server must present a client certificate
+}
+
 type KubernetesAuditSource struct {
 	metricsLevel int
 	config       KubernetesAuditConfiguration
@@ -36,6 +64,9 @@ type KubernetesAuditSource struct {
 	server       *http.Server
 	outChan      chan types.Event
 	addr         string
+	ipFilter     *ipfilter.Filter
+	bodyDecoder  *httpbody.Decoder
+	clientIP     *clientip.Resolver
 }
 
 var eventCount = prometheus.NewCounterVec(
@@ -57,11 +88,42 @@ func (ka *KubernetesAuditSource) GetUuid() string {
 }
 
 func (ka *KubernetesAuditSource) GetMetrics() []prometheus.Collector {
-	return []prometheus.Collector{eventCount, requestCount}
+	return []prometheus.Collector{eventCount, requestCount, connlimit.Rejected, ipfilter.Rejected, httpbody.Rejected}
 }
 
 func (ka *KubernetesAuditSource) GetAggregMetrics() []prometheus.Collector {
-	return []prometheus.Collector{eventCount, requestCount}
+	return []prometheus.Collector{eventCount, requestCount, connlimit.Rejected, ipfilter.Rejected, httpbody.Rejected}
+}
+
+// filterSources applies allowed_sources and denied_sources, the rejected connections are
+// closed without a response.
+func (ka *KubernetesAuditSource) filterSources(listener net.Listener) net.Listener {
+	return ipfilter.Listen(listener, ka.ipFilter, func(conn net.Conn) {
+		ka.logger.Debugf("rejecting connection from %s: source not allowed", conn.RemoteAddr())
+
+		if ka.metricsLevel != configuration.METRICS_NONE {
+			ipfilter.Rejected.With(prometheus.Labels{"datasource": ka.GetName()}).Inc()
+		}
+	})
+}
+
+// limitConnections applies max_connections. The API server retries the batches that get a
+// 503, the connection is closed without a response over TLS.
+func (ka *KubernetesAuditSource) limitConnections(listener net.Listener) net.Listener {
+	return connlimit.Listen(listener, ka.config.MaxConnections, func(conn net.Conn) {
+		ka.logger.Debugf("rejecting connection from %s: max_connections reached", conn.RemoteAddr())
+
+		if ka.metricsLevel != configuration.METRICS_NONE {
+			connlimit.Rejected.With(prometheus.Labels{"datasource": ka.GetName(), "addr": ka.addr}).Inc()
+		}
+
+		if ka.config.TLS != nil {
+			return
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_, _ = conn.Write([]byte("HTTP/1.1 503 Service Unavailable\r\nConnection: close\r\nContent-Length: 0\r\n\r\n"))
+	})
 }
 
 func (ka *KubernetesAuditSource) UnmarshalConfig(yamlConfig []byte) error {
@@ -90,6 +152,37 @@ func (ka *KubernetesAuditSource) UnmarshalConfig(yamlConfig []byte) error {
 		ka.config.WebhookPath = "/" + ka.config.WebhookPath
 	}
 
+	if ka.config.TLS != nil && (ka.config.TLS.ServerCert == "" || ka.config.TLS.ServerKey == "") {
+		return errors.New("tls: server_cert and server_key are required")
+	}
+
+	if ka.config.MaxBodySize < 0 {
+		return errors.New("max_body_size must be positive")
+	}
+
+	if ka.config.MaxBodySize == 0 {
+		ka.config.MaxBodySize = defaultMaxBodySize
+	}
+
+	if ka.config.MaxConnections < 0 {
+		return errors.New("max_connections must be positive")
+	}
+
+	ka.ipFilter, err = ipfilter.New(ka.config.Config)
+	if err != nil {
+		return err
+	}
+
+	ka.bodyDecoder, err = httpbody.New(ka.config.Body)
+	if err != nil {
+		return err
+	}
+
+	ka.clientIP, err = clientip.New(ka.config.Proxies)
+	if err != nil {
+		return err
+	}
+
 	if ka.config.Mode == "" {
 		ka.config.Mode = configuration.TAIL_MODE
 	}
@@ -97,6 +190,27 @@ func (ka *KubernetesAuditSource) UnmarshalConfig(yamlConfig []byte) error {
 	return nil
 }
 
+func (c *TLSConfig) newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if c.CaCert != "" {
+		caCert, err := os.ReadFile(c.CaCert)
+		if err != nil {
+			return nil, fmt.Errorf("while reading CA certificate: %w", err)
+		}
+
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no valid certificate found in %s", c.CaCert)
+		}
+
+		tlsConfig.ClientCAs = caCertPool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
 func (ka *KubernetesAuditSource) Configure(config []byte, logger *log.Entry, metricsLevel int) error {
 	ka.logger = logger
 	ka.metricsLevel = metricsLevel
@@ -122,7 +236,14 @@ func (ka *KubernetesAuditSource) Configure(config []byte, logger *log.Entry, met
 	ka.server.Protocols.SetUnencryptedHTTP2(true)
 	ka.server.Protocols.SetHTTP2(true)
 
-	ka.mux.HandleFunc(ka.config.WebhookPath, ka.webhookHandler)
+	if ka.config.TLS != nil {
+		ka.server.TLSConfig, err = ka.config.TLS.newTLSConfig()
+		if err != nil {
+			return fmt.Errorf("failed to create tls config: %w", err)
+		}
+	}
+
+	ka.mux.Handle(ka.config.WebhookPath, httpbody.Handler(http.HandlerFunc(ka.webhookHandler), ka.bodyDecoder, ka.rejectBody))
 
 	return nil
 }
@@ -150,7 +271,19 @@ func (ka *KubernetesAuditSource) StreamingAcquisition(ctx context.Context, out c
 		defer trace.CatchPanic("crowdsec/acquis/k8s-audit/live")
 		ka.logger.Infof("Starting k8s-audit server on %s:%d%s", ka.config.ListenAddr, ka.config.ListenPort, ka.config.WebhookPath)
 		t.Go(func() error {
-			err := ka.server.ListenAndServe()
+			listener, err := net.Listen("tcp", ka.addr)
+			if err != nil {
+				return fmt.Errorf("k8s-audit server failed: %w", err)
+			}
+
+			listener = ka.limitConnections(ka.filterSources(listener))
+
+			if ka.config.TLS != nil {
+				err = ka.server.ServeTLS(listener, ka.config.TLS.ServerCert, ka.config.TLS.ServerKey)
+			} else {
+				err = ka.server.Serve(listener)
+			}
+
 			if err != nil && err != http.ErrServerClosed {
 				return fmt.Errorf("k8s-audit server failed: %w", err)
 			}
@@ -178,6 +311,16 @@ func (ka *KubernetesAuditSource) Dump() interface{} {
 	return ka
 }
 
+// rejectBody is called for the requests with a body that can't be decompressed, or that is larger
+// than max_decompressed_body_size once decompressed.
+func (ka *KubernetesAuditSource) rejectBody(r *http.Request, reason string) {
+	ka.logger.Warnf("rejecting request from %s: %s", r.RemoteAddr, reason)
+
+	if ka.metricsLevel != configuration.METRICS_NONE {
+		httpbody.Rejected.With(prometheus.Labels{"datasource": ka.GetName(), "reason": reason}).Inc()
+	}
+}
+
 func (ka *KubernetesAuditSource) webhookHandler(w http.ResponseWriter, r *http.Request) {
 	if ka.metricsLevel != configuration.METRICS_NONE {
 		requestCount.WithLabelValues(ka.addr).Inc()
@@ -192,8 +335,16 @@ func (ka *KubernetesAuditSource) webhookHandler(w http.ResponseWriter, r *http.R
 
 	var auditEvents audit.EventList
 
-	jsonBody, err := io.ReadAll(r.Body)
+	jsonBody, err := io.ReadAll(http.MaxBytesReader(w, r.Body, ka.config.MaxBodySize))
 	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			ka.logger.Warnf("Request body exceeds max_body_size (%d bytes)", maxBytesErr.Limit)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+
+			return
+		}
+
 		ka.logger.Errorf("Error reading request body: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 
@@ -210,7 +361,22 @@ func (ka *KubernetesAuditSource) webhookHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	remoteIP := strings.Split(r.RemoteAddr, ":")[0]
+	if !slices.Contains(supportedAPIVersions, auditEvents.APIVersion) {
+		ka.logger.Errorf("Unsupported audit API version %q", auditEvents.APIVersion)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	if auditEvents.Kind != "" && auditEvents.Kind != "EventList" {
+		ka.logger.Errorf("Unexpected kind %q, expected EventList", auditEvents.Kind)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	// the peer address, or the client behind a trusted proxy
+	remoteIP := ka.clientIP.ClientIP(r)
 
 	for idx := range auditEvents.Items {
 		if ka.metricsLevel != configuration.METRICS_NONE {
@@ -234,6 +400,26 @@ func (ka *KubernetesAuditSource) webhookHandler(w http.ResponseWriter, r *http.R
 		}
 		evt := types.MakeEvent(ka.config.UseTimeMachine, types.LOG, true)
 		evt.Line = l
+		setAuditMeta(&evt, &auditEvents.Items[idx])
+		evt.Meta["k8s_client_ip"] = remoteIP
 		ka.outChan <- evt
 	}
 }
+
+// setAuditMeta copies the fields most scenarios need from an audit event to the event metadata.
+func setAuditMeta(evt *types.Event, auditEvent *audit.Event) {
+	evt.Meta["k8s_audit_id"] = string(auditEvent.AuditID)
+	evt.Meta["k8s_verb"] = auditEvent.Verb
+	evt.Meta["k8s_user"] = auditEvent.User.Username
+	evt.Meta["k8s_source_ips"] = strings.Join(auditEvent.SourceIPs, ",")
+
+	if ref := auditEvent.ObjectRef; ref != nil {
+		resource := ref.Resource
+		if ref.Subresource != "" {
+			resource += "/" + ref.Subresource
+		}
+
+		evt.Meta["k8s_resource"] = resource
+		evt.Meta["k8s_namespace"] = ref.Namespace
+	}
+}
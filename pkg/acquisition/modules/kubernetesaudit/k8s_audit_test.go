@@ -1,6 +1,9 @@
 package kubernetesauditacquisition
 
 import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -34,12 +37,32 @@ foobar: asd.log`,
 			config:      `source: k8s-audit`,
 			expectedErr: "listen_addr cannot be empty",
 		},
+		{
+			name: "tls without key",
+			config: `source: k8s-audit
+listen_addr: 0.0.0.0
+listen_port: 9443
+webhook_path: /audit
+tls:
+  server_cert: cert.pem`,
+			expectedErr: "tls: server_cert and server_key are required",
+		},
 		{
 			name: "missing listen_port",
 			config: `source: k8s-audit
 listen_addr: 0.0.0.0`,
 			expectedErr: "listen_port cannot be empty",
 		},
+		{
+			name: "invalid allowed_sources",
+			config: `source: k8s-audit
+listen_addr: 0.0.0.0
+listen_port: 9443
+webhook_path: /audit
+allowed_sources:
+  - kube-apiserver`,
+			expectedErr: "allowed_sources: invalid address 'kube-apiserver'",
+		},
 		{
 			name: "mismatched types",
 			config: `
@@ -113,6 +136,7 @@ func TestHandler(t *testing.T) {
 		expectedStatusCode int
 		body               string
 		method             string
+		gzip               bool
 		eventCount         int
 	}{
 		{
@@ -225,6 +249,29 @@ webhook_path: /k8s-audit`,
 			method:             "POST",
 			eventCount:         0,
 		},
+		{
+			name: "unsupported_api_version",
+			config: `source: k8s-audit
+listen_addr: 127.0.0.1
+listen_port: 49234
+webhook_path: /k8s-audit`,
+			expectedStatusCode: 400,
+			body:               `{"kind": "EventList", "apiVersion": "audit.k8s.io/v2", "items": []}`,
+			method:             "POST",
+			eventCount:         0,
+		},
+		{
+			name: "body_too_large",
+			config: `source: k8s-audit
+listen_addr: 127.0.0.1
+listen_port: 49234
+webhook_path: /k8s-audit
+max_body_size: 16`,
+			expectedStatusCode: 413,
+			body:               `{"kind": "EventList", "apiVersion": "audit.k8s.io/v1", "items": []}`,
+			method:             "POST",
+			eventCount:         0,
+		},
 		{
 			name: "invalid_method",
 			config: `source: k8s-audit
@@ -235,6 +282,31 @@ webhook_path: /k8s-audit`,
 			method:             "GET",
 			eventCount:         0,
 		},
+		{
+			name: "gzip_body",
+			config: `source: k8s-audit
+listen_addr: 127.0.0.1
+listen_port: 49234
+webhook_path: /k8s-audit`,
+			expectedStatusCode: 200,
+			body:               `{"kind": "EventList", "apiVersion": "audit.k8s.io/v1", "items": [{"auditID": "1", "verb": "get"}]}`,
+			method:             "POST",
+			gzip:               true,
+			eventCount:         1,
+		},
+		{
+			name: "gzip_body_too_large",
+			config: `source: k8s-audit
+listen_addr: 127.0.0.1
+listen_port: 49234
+webhook_path: /k8s-audit
+max_decompressed_body_size: 16`,
+			expectedStatusCode: 413,
+			body:               `{"kind": "EventList", "apiVersion": "audit.k8s.io/v1", "items": []}`,
+			method:             "POST",
+			gzip:               true,
+			eventCount:         0,
+		},
 	}
 
 	subLogger := log.WithField("type", "k8s-audit")
@@ -263,13 +335,29 @@ webhook_path: /k8s-audit`,
 
 			require.NoError(t, err)
 
-			req := httptest.NewRequest(test.method, "/k8s-audit", strings.NewReader(test.body))
+			body := []byte(test.body)
+
+			if test.gzip {
+				buf := bytes.Buffer{}
+				gz := gzip.NewWriter(&buf)
+				_, err = gz.Write(body)
+				require.NoError(t, err)
+				require.NoError(t, gz.Close())
+
+				body = buf.Bytes()
+			}
+
+			req := httptest.NewRequest(test.method, "/k8s-audit", bytes.NewReader(body))
+			if test.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+
 			w := httptest.NewRecorder()
 
 			err = f.StreamingAcquisition(ctx, out, tb)
 			require.NoError(t, err)
 
-			f.webhookHandler(w, req)
+			f.mux.ServeHTTP(w, req)
 
 			res := w.Result()
 
@@ -285,3 +373,81 @@ webhook_path: /k8s-audit`,
 		})
 	}
 }
+
+func TestAuditMeta(t *testing.T) {
+	body := `{
+  "kind": "EventList",
+  "apiVersion": "audit.k8s.io/v1",
+  "items": [
+    {
+      "AuditID": "2fca7950-03b6-41fa-95cd-08c5bcec8487",
+      "Verb": "create",
+      "User": {"username": "minikube-user"},
+      "SourceIPs": ["192.168.9.212", "10.0.0.1"],
+      "ObjectRef": {"Resource": "pods", "Subresource": "exec", "Namespace": "default"}
+    }
+  ]
+}`
+
+	out := make(chan types.Event, 1)
+
+	f := KubernetesAuditSource{}
+	err := f.Configure([]byte(`source: k8s-audit
+listen_addr: 127.0.0.1
+listen_port: 49234
+webhook_path: /k8s-audit`), log.WithField("type", "k8s-audit"), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	f.outChan = out
+
+	w := httptest.NewRecorder()
+	f.webhookHandler(w, httptest.NewRequest("POST", "/k8s-audit", strings.NewReader(body)))
+	require.Equal(t, 200, w.Result().StatusCode)
+
+	evt := <-out
+	assert.Equal(t, "2fca7950-03b6-41fa-95cd-08c5bcec8487", evt.Meta["k8s_audit_id"])
+	assert.Equal(t, "create", evt.Meta["k8s_verb"])
+	assert.Equal(t, "minikube-user", evt.Meta["k8s_user"])
+	assert.Equal(t, "192.168.9.212,10.0.0.1", evt.Meta["k8s_source_ips"])
+	assert.Equal(t, "pods/exec", evt.Meta["k8s_resource"])
+	assert.Equal(t, "default", evt.Meta["k8s_namespace"])
+	assert.Equal(t, "192.0.2.1", evt.Meta["k8s_client_ip"])
+}
+
+func TestTrustedProxies(t *testing.T) {
+	body := `{"kind": "EventList", "apiVersion": "audit.k8s.io/v1", "items": [{"AuditID": "1"}]}`
+
+	out := make(chan types.Event, 2)
+
+	f := KubernetesAuditSource{}
+	err := f.Configure([]byte(`source: k8s-audit
+listen_addr: 127.0.0.1
+listen_port: 49234
+webhook_path: /k8s-audit
+trusted_proxies:
+  - 192.0.2.0/24`), log.WithField("type", "k8s-audit"), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	f.outChan = out
+
+	send := func(remoteAddr string) types.Event {
+		req := httptest.NewRequest(http.MethodPost, "/k8s-audit", strings.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "198.51.100.1, 192.0.2.10")
+
+		w := httptest.NewRecorder()
+		f.webhookHandler(w, req)
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+		return <-out
+	}
+
+	evt := send("192.0.2.1:1234")
+	assert.Equal(t, "198.51.100.1", evt.Line.Src)
+	assert.Equal(t, "198.51.100.1", evt.Meta["k8s_client_ip"])
+
+	// the header is ignored when the peer is not a trusted proxy
+	evt = send("[2001:db8::1]:1234")
+	assert.Equal(t, "2001:db8::1", evt.Line.Src)
+	assert.Equal(t, "2001:db8::1", evt.Meta["k8s_client_ip"])
+}
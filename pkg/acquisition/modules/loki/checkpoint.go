@@ -0,0 +1,152 @@
+package loki
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	tomb "gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/statefile"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/loki/internal/lokiclient"
+)
+
+// the checkpoint is saved every checkpointInterval, and when the acquisition stops
+const checkpointInterval = 10 * time.Second
+
+type checkpointPosition struct {
+	Tenant    string    `json:"tenant,omitempty"`
+	Query     string    `json:"query"`
+	Timestamp time.Time `json:"timestamp"` // of the last entry sent to the parsers
+}
+
+// checkpointState is saved in checkpoint_path while tailing.
+type checkpointState struct {
+	Positions []checkpointPosition `json:"positions"`
+}
+
+// checkpoint records the last entry read by each client of a tail, so that a restarted source
+// resumes from there instead of now. The methods are no-ops on a nil checkpoint, without
+// checkpoint_path.
+type checkpoint struct {
+	file   *statefile.File
+	logger *log.Entry
+
+	mu        sync.Mutex
+	positions map[*lokiclient.LokiClient]time.Time
+	dirty     bool // positions changed since the last save
+}
+
+func newCheckpoint(path string, logger *log.Entry) *checkpoint {
+	if path == "" {
+		return nil
+	}
+
+	return &checkpoint{
+		file:      statefile.New(path, statefile.Config{}, logger),
+		logger:    logger,
+		positions: make(map[*lokiclient.LokiClient]time.Time),
+	}
+}
+
+// load makes the clients resume from their saved position, but not from further than
+// maxCatchup in the past. A client is matched with its position by tenant and query.
+func (c *checkpoint) load(clients []*lokiclient.LokiClient, maxCatchup time.Duration, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	state := checkpointState{}
+
+	found, err := c.file.Load(&state)
+	if err != nil {
+		c.logger.Warnf("while loading checkpoint from %s: %s", c.file.Path(), err)
+		return
+	}
+
+	if !found {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	oldest := now.Add(-maxCatchup)
+
+	for _, client := range clients {
+		for _, pos := range state.Positions {
+			if pos.Tenant != client.Tenant() || pos.Query != client.Query() {
+				continue
+			}
+
+			c.positions[client] = pos.Timestamp
+
+			resumeFrom := pos.Timestamp
+			if resumeFrom.Before(oldest) {
+				client.Logger.Warnf("checkpoint %s is older than max_catchup, resuming from %s", pos.Timestamp, oldest)
+				resumeFrom = oldest
+			}
+
+			client.Logger.Infof("resuming from checkpoint %s: %s", c.file.Path(), resumeFrom)
+			client.ResumeFrom(resumeFrom)
+
+			break
+		}
+	}
+}
+
+// progress is called after each entry of a client is sent to the parsers.
+func (c *checkpoint) progress(client *lokiclient.LokiClient, ts time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ts.After(c.positions[client]) {
+		c.positions[client] = ts
+		c.dirty = true
+	}
+}
+
+func (c *checkpoint) save() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+
+	if !c.dirty {
+		c.mu.Unlock()
+		return
+	}
+
+	state := checkpointState{Positions: make([]checkpointPosition, 0, len(c.positions))}
+	for client, ts := range c.positions {
+		state.Positions = append(state.Positions, checkpointPosition{Tenant: client.Tenant(), Query: client.Query(), Timestamp: ts})
+	}
+
+	c.dirty = false
+	c.mu.Unlock()
+
+	if err := c.file.Save(state); err != nil {
+		c.logger.Errorf("while saving checkpoint to %s: %s", c.file.Path(), err)
+	}
+}
+
+// run saves the checkpoint periodically, until the acquisition stops.
+func (c *checkpoint) run(t *tomb.Tomb) error {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.save()
+		case <-t.Dying():
+			c.save()
+			return nil
+		}
+	}
+}
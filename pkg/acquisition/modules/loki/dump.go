@@ -0,0 +1,88 @@
+package loki
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+)
+
+const redacted = "<redacted>"
+
+// String identifies the source in the logs: by its name if it's set, or else by its url and
+// query. GetName is the name of the module, the same for all the loki sources.
+func (l *LokiSource) String() string {
+	if l.Config.Name != "" {
+		return l.Config.Name
+	}
+
+	name := fmt.Sprintf("loki %s", redactURL(l.Config.URL.Primary()))
+
+	switch len(l.Config.Query) {
+	case 0:
+	case 1:
+		name += " " + l.Config.Query[0]
+	default:
+		name += fmt.Sprintf(" %s (and %d more)", l.Config.Query[0], len(l.Config.Query)-1)
+	}
+
+	return name
+}
+
+// Dump returns the configuration of the source, for the diagnostics: the passwords, the tokens,
+// the OAuth2 client secret, the authorization headers and the credentials in the urls are
+// redacted.
+func (l *LokiSource) Dump() any {
+	config := l.Config
+
+	config.URL = make(URLList, len(l.Config.URL))
+	for i, u := range l.Config.URL {
+		config.URL[i] = redactURL(u)
+	}
+
+	config.ProxyURL = redactURL(l.Config.ProxyURL)
+
+	config.Auth.Password = redact(config.Auth.Password)
+	config.Auth.BearerToken = redact(config.Auth.BearerToken)
+
+	if l.Config.Auth.OAuth2 != nil {
+		oauth2 := *l.Config.Auth.OAuth2
+		oauth2.ClientSecret = redact(oauth2.ClientSecret)
+		config.Auth.OAuth2 = &oauth2
+	}
+
+	if l.Config.Headers != nil {
+		config.Headers = maps.Clone(l.Config.Headers)
+		for name, value := range config.Headers {
+			switch http.CanonicalHeaderKey(name) {
+			case "Authorization", "Proxy-Authorization":
+				config.Headers[name] = redact(value)
+			}
+		}
+	}
+
+	return config
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	return redacted
+}
+
+// redactURL hides the password of a url. The urls that can't be parsed are hidden entirely, they
+// may hold credentials.
+func redactURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redacted
+	}
+
+	return u.Redacted()
+}
@@ -0,0 +1,98 @@
+package lokiclient
+
+import (
+	"hash/fnv"
+	"maps"
+	"slices"
+	"time"
+)
+
+// pageBoundary tracks the entries read at the last timestamp of a page. Loki can return many
+// entries with the same timestamp, and a page can end in the middle of them: the next page
+// starts at this timestamp, and the entries that were already read are skipped.
+type pageBoundary struct {
+	ts       time.Time
+	hashes   map[uint64]struct{} // stream labels and line of the entries read at ts
+	backward bool                // the pages go back in time, the last timestamp is the oldest
+}
+
+func entryHash(labels map[string]string, line string) uint64 {
+	h := fnv.New64a()
+
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(labels[name]))
+		h.Write([]byte{0})
+	}
+
+	h.Write([]byte(line))
+
+	return h.Sum64()
+}
+
+// filter removes the entries of the page that were already read and moves the boundary to the
+// last timestamp of the page. It returns the number of entries of the page, before filtering,
+// and whether they all have the same timestamp.
+func (b *pageBoundary) filter(lq *LokiQueryRangeResponse) (int, bool) {
+	total := 0
+	oldest := time.Time{}
+	newest := time.Time{}
+	streams := lq.Data.Result[:0]
+
+	for _, stream := range lq.Data.Result {
+		entries := stream.Entries[:0]
+
+		for _, entry := range stream.Entries {
+			total++
+
+			if oldest.IsZero() || entry.Timestamp.Before(oldest) {
+				oldest = entry.Timestamp
+			}
+
+			if entry.Timestamp.After(newest) {
+				newest = entry.Timestamp
+			}
+
+			if entry.Timestamp.Equal(b.ts) {
+				if _, ok := b.hashes[entryHash(stream.Stream, entry.Line)]; ok {
+					continue
+				}
+			}
+
+			entries = append(entries, entry)
+		}
+
+		if len(entries) > 0 {
+			stream.Entries = entries
+			streams = append(streams, stream)
+		}
+	}
+
+	lq.Data.Result = streams
+
+	last := b.ts
+
+	switch {
+	case total == 0:
+	case b.backward:
+		last = oldest
+	default:
+		last = newest
+	}
+
+	if !last.Equal(b.ts) {
+		b.ts = last
+		b.hashes = make(map[uint64]struct{})
+	}
+
+	for _, stream := range lq.Data.Result {
+		for _, entry := range stream.Entries {
+			if entry.Timestamp.Equal(b.ts) {
+				b.hashes[entryHash(stream.Stream, entry.Line)] = struct{}{}
+			}
+		}
+	}
+
+	return total, total > 0 && oldest.Equal(newest)
+}
@@ -0,0 +1,72 @@
+package lokiclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	goversion "github.com/hashicorp/go-version"
+)
+
+// MinVersion is the oldest loki supported: the queries can use the LogQL pipelines of loki 2.
+const MinVersion = "2.0.0"
+
+// BuildInfo is the response of status/buildinfo.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	Branch    string `json:"branch"`
+	GoVersion string `json:"goVersion"`
+}
+
+// BuildInfo returns the version of loki, nil if it's not reported: the older versions, and some
+// gateways, don't serve status/buildinfo.
+func (lc *LokiClient) BuildInfo(ctx context.Context) (*BuildInfo, error) {
+	resp, err := lc.Get(ctx, lc.getURLFor("loki/api/v1/status/buildinfo", nil))
+	if err != nil {
+		return nil, fmt.Errorf("while getting the version of loki: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		lc.Logger.Debugf("loki does not report its version (HTTP %d)", resp.StatusCode)
+		return nil, nil
+	}
+
+	info := &BuildInfo{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(info); err != nil {
+		return nil, fmt.Errorf("while decoding the version of loki: %w", err)
+	}
+
+	return info, nil
+}
+
+// CheckVersion fails if loki is older than MinVersion. The versions that are not numbered, such
+// as the builds of a branch, are accepted, and so is a loki that doesn't report its version.
+func (lc *LokiClient) CheckVersion(ctx context.Context) error {
+	info, err := lc.BuildInfo(ctx)
+	if err != nil {
+		lc.Logger.Warnf("cannot check the version of loki: %s", err)
+		return nil
+	}
+
+	if info == nil {
+		return nil
+	}
+
+	version, err := goversion.NewVersion(info.Version)
+	if err != nil {
+		lc.Logger.Debugf("cannot parse the version of loki '%s': %s", info.Version, err)
+		return nil
+	}
+
+	lc.Logger.Infof("loki version %s", info.Version)
+
+	if version.LessThan(goversion.Must(goversion.NewVersion(MinVersion))) {
+		return fmt.Errorf("loki %s is too old, version %s or later is required", info.Version, MinVersion)
+	}
+
+	return nil
+}
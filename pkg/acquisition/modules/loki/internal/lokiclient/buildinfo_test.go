@@ -0,0 +1,67 @@
+package lokiclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+)
+
+func TestCheckVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		expectedErr string
+	}{
+		{
+			name:   "supported",
+			status: http.StatusOK,
+			body:   `{"version":"2.9.4","revision":"f599ebc","branch":"HEAD","goVersion":"go1.21.3"}`,
+		},
+		{
+			name:        "too old",
+			status:      http.StatusOK,
+			body:        `{"version":"1.6.1"}`,
+			expectedErr: "loki 1.6.1 is too old, version 2.0.0 or later is required",
+		},
+		{
+			name:   "build of a branch",
+			status: http.StatusOK,
+			body:   `{"version":"main-0f3b8a1"}`,
+		},
+		{
+			name:   "not reported",
+			status: http.StatusNotFound,
+			body:   "404 page not found\n",
+		},
+		{
+			name:   "not json",
+			status: http.StatusOK,
+			body:   "ready\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/loki/api/v1/status/buildinfo" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			lc := NewLokiClient(Config{LokiURL: srv.URL})
+			lc.SetTomb(&tomb.Tomb{})
+
+			cstest.RequireErrorContains(t, lc.CheckVersion(t.Context()), tc.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package lokiclient
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// endpoints are the URLs of loki: the primary and the failover ones. The requests go to the
+// current endpoint, the next one in order takes over when it cannot be reached, and the primary
+// is tried again every FailbackInterval.
+type endpoints struct {
+	urls     []string
+	prefixes []string // the URLs with LokiPrefix, to move the requests from an endpoint to another
+	failback time.Duration
+
+	mu         sync.Mutex
+	current    int
+	failedOver time.Time // when the primary was left, zero while it is used
+}
+
+func newEndpoints(config Config) *endpoints {
+	failback := config.FailbackInterval
+	if failback <= 0 {
+		failback = DefaultFailbackInterval
+	}
+
+	e := &endpoints{failback: failback}
+
+	for _, u := range append([]string{config.LokiURL}, config.FailoverURLs...) {
+		e.urls = append(e.urls, u)
+		e.prefixes = append(e.prefixes, endpointPrefix(u, config.LokiPrefix))
+	}
+
+	return e
+}
+
+func endpointPrefix(rawURL string, lokiPrefix string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	u.RawQuery = ""
+
+	u.Path, err = url.JoinPath(lokiPrefix, u.Path)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+// get returns the index of the current endpoint. The primary is tried again once the failback
+// interval has elapsed.
+func (e *endpoints) get(logger *log.Entry) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.current != 0 && time.Since(e.failedOver) >= e.failback {
+		logger.Infof("trying the primary loki %s again", e.urls[0])
+		e.current = 0
+		e.failedOver = time.Time{}
+	}
+
+	return e.current
+}
+
+// url returns the URL of the current endpoint.
+func (e *endpoints) url(logger *log.Entry) string {
+	return e.urls[e.get(logger)]
+}
+
+// failover moves to the next endpoint after a connection failure on the endpoint i. It returns
+// false on the last endpoint, the next request starts over from the primary.
+func (e *endpoints) failover(i int, err error, logger *log.Entry) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if i+1 >= len(e.urls) {
+		if e.current == i {
+			e.current = 0
+			e.failedOver = time.Time{}
+		}
+
+		return false
+	}
+
+	if e.current == i {
+		// the other requests that failed on this endpoint don't move further
+		e.current = i + 1
+		logger.Warnf("loki %s cannot be reached (%s), failing over to %s", e.urls[i], err, e.urls[i+1])
+	}
+
+	if i == 0 {
+		e.failedOver = time.Now()
+	}
+
+	return true
+}
+
+// rebase moves a request to the endpoint i. The requests that were not built for one of the
+// endpoints are not changed.
+func (e *endpoints) rebase(uri string, i int) string {
+	from := -1
+
+	for j, prefix := range e.prefixes {
+		if prefix == "" || (uri != prefix && !strings.HasPrefix(uri, prefix+"/") && !strings.HasPrefix(uri, prefix+"?")) {
+			continue
+		}
+		// a prefix can contain another one
+		if from < 0 || len(prefix) > len(e.prefixes[from]) {
+			from = j
+		}
+	}
+
+	if from < 0 || from == i {
+		return uri
+	}
+
+	return e.prefixes[i] + strings.TrimPrefix(uri, e.prefixes[from])
+}
+
+// len returns the number of endpoints.
+func (e *endpoints) len() int {
+	return len(e.urls)
+}
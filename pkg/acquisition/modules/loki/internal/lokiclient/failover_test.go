@@ -0,0 +1,104 @@
+package lokiclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailover(t *testing.T) {
+	primaryDown := atomic.Bool{}
+	primaryDown.Store(true)
+
+	newServer := func(down *atomic.Bool, requests *atomic.Int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if down != nil && down.Load() {
+				// the connection is dropped, as by a gateway that is gone
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+
+				return
+			}
+
+			assert.Equal(t, "/gateway/ready", r.URL.Path)
+			requests.Add(1)
+			_, _ = w.Write([]byte("ready\n"))
+		}))
+	}
+
+	primaryRequests := atomic.Int32{}
+	primary := newServer(&primaryDown, &primaryRequests)
+	defer primary.Close()
+
+	secondaryRequests := atomic.Int32{}
+	secondary := newServer(nil, &secondaryRequests)
+	defer secondary.Close()
+
+	lc := NewLokiClient(Config{
+		LokiURL:          primary.URL + "/gateway",
+		FailoverURLs:     []string{secondary.URL + "/gateway/"},
+		FailbackInterval: 200 * time.Millisecond,
+	})
+
+	ready := func() {
+		resp, err := lc.Get(t.Context(), lc.getURLFor("ready", nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// the request is sent again to the secondary, which is used until the failback
+	ready()
+	ready()
+	assert.Equal(t, int32(0), primaryRequests.Load())
+	assert.Equal(t, int32(2), secondaryRequests.Load())
+
+	// the primary is back, and tried again after the failback interval
+	primaryDown.Store(false)
+	ready()
+	assert.Equal(t, int32(3), secondaryRequests.Load())
+
+	time.Sleep(300 * time.Millisecond)
+	ready()
+	ready()
+	assert.Equal(t, int32(2), primaryRequests.Load())
+	assert.Equal(t, int32(3), secondaryRequests.Load())
+
+	// none of them can be reached: the error is returned, and the next request starts over
+	primary.Close()
+	secondary.Close()
+
+	_, err := lc.Get(t.Context(), lc.getURLFor("ready", nil))
+	require.Error(t, err)
+	assert.Equal(t, 0, lc.endpoints.get(lc.Logger))
+}
+
+func TestRebase(t *testing.T) {
+	e := newEndpoints(Config{
+		LokiURL:      "http://loki-a:3100",
+		FailoverURLs: []string{"https://gateway/loki/", "http://loki-a:3100/other"},
+	})
+
+	tests := []struct {
+		uri      string
+		to       int
+		expected string
+	}{
+		{"http://loki-a:3100/loki/api/v1/query_range?query=x", 1, "https://gateway/loki/loki/api/v1/query_range?query=x"},
+		{"https://gateway/loki/ready", 0, "http://loki-a:3100/ready"},
+		{"http://loki-a:3100/other/ready", 0, "http://loki-a:3100/ready"},
+		{"http://loki-a:3100/ready", 2, "http://loki-a:3100/other/ready"},
+		{"http://elsewhere/ready", 1, "http://elsewhere/ready"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, e.rebase(tc.uri, tc.to), tc.uri)
+	}
+}
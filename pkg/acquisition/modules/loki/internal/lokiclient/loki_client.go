@@ -2,20 +2,27 @@ package lokiclient
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/tomb.v2"
 
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/sourceaddr"
 	"github.com/crowdsecurity/crowdsec/pkg/apiclient/useragent"
 	"maps"
 )
@@ -26,8 +33,23 @@ type LokiClient struct {
 	config                Config
 	t                     *tomb.Tomb
 	fail_start            time.Time
+	failedAttempts        int
+	backoff               time.Duration // delay before the next request after a failure, 0 while healthy
+	throttledRetries      int           // requests in a row that loki asked to retry later
 	currentTickerInterval time.Duration
 	requestHeaders        map[string]string
+	httpClient            *http.Client
+	oauth2                *tokenSource // nil unless OAuth2 is set
+
+	healthMu     sync.Mutex
+	degradedFrom time.Time // zero while healthy or within the reconnect grace period
+
+	queryMu sync.Mutex
+	query   string // can be changed while running, see SetQuery
+
+	resumeFrom time.Time // start of the tail, zero to start now
+
+	endpoints *endpoints
 }
 
 type Config struct {
@@ -36,36 +58,208 @@ type Config struct {
 	Query      string
 	Headers    map[string]string
 
-	Username string
-	Password string
+	// tried in order when LokiURL cannot be reached, LokiURL is tried again every FailbackInterval,
+	// DefaultFailbackInterval if zero
+	FailoverURLs     []string
+	FailbackInterval time.Duration
+
+	Username     string
+	Password     string
+	PasswordFile string // read for each request, instead of Password
 
-	Since time.Duration
-	Until time.Duration
+	BearerToken     string
+	BearerTokenFile string // read for each request
+	OAuth2          *OAuth2Config
+
+	Since TimeBound
+	Until TimeBound // QueryRange without infinite only: read up to there
+	// QueryRange without infinite only: DirectionBackward reads the pages from Until back to
+	// Since, DirectionForward if empty
+	Direction string
+	// QueryRange without infinite only: the minimum delay between two pages
+	PageDelay time.Duration
 
 	FailMaxDuration time.Duration
+	// failures shorter than this are considered routine restarts: they are not reported, and
+	// FailMaxDuration only starts counting once it has elapsed
+	ReconnectGracePeriod time.Duration
+	// the delay between the requests after a failure starts at a second and doubles up to this
+	MaxBackoff time.Duration
+	// consecutive failed requests before giving up, 0 for no limit
+	MaxFailedAttempts int
+	// consecutive requests throttled by loki (429 or 503 with Retry-After) before giving up
+	MaxRetries int
 
-	DelayFor int
+	DelayFor int // seconds, the entries more recent than this are not read yet
 	Limit    int
+	// the tail starts at the time of the first request, instead of DelayFor before it or at
+	// ResumeFrom: the entries older than the start are not read
+	StartFromNow bool
+
+	OrgIDMode string
+
+	// local address of the outgoing connections, nil to let the system choose
+	LocalAddr *net.TCPAddr
+	// HTTP or SOCKS5 proxy of the HTTP requests, nil for the environment variables
+	ProxyURL *url.URL
+	// client certificate and CAs of loki, nil for the defaults
+	TLSConfig *tls.Config
+
+	// update the metrics of the client, see metrics.go
+	Metrics bool
+
+	// don't ask for gzip responses
+	DisableCompression bool
+
+	// of the requests and the websocket handshake, useragent.LokiUserAgent() if empty
+	UserAgent string
+
+	// of each HTTP request, response body included; the websocket is not concerned
+	Timeout time.Duration
+
+	// the ready check, DefaultReadyPath if empty; a response with a status 200 passes it, if its
+	// body contains ReadyExpectBody
+	ReadyPath       string
+	ReadyExpectBody string
+	// of each ready check, it's only bounded by Timeout if zero
+	ReadyTimeout time.Duration
+	// between the ready checks, DefaultReadyInterval if zero
+	ReadyInterval time.Duration
+}
+
+const (
+	OrgIDHeader = "X-Scope-OrgID"
+
+	OrgIDModeAuto     = "auto"
+	OrgIDModeRequired = "required"
+	OrgIDModeOmit     = "omit"
+
+	minBackoff        = time.Second
+	DefaultMaxBackoff = 30 * time.Second
+	DefaultMaxRetries = 5
+	DefaultTimeout    = 30 * time.Second
+	DefaultReadyPath  = "/ready"
+
+	DefaultFailbackInterval = 5 * time.Minute
+
+	DirectionForward  = "forward"
+	DirectionBackward = "backward"
+
+	DefaultReadyInterval = 500 * time.Millisecond
+	readyJitter          = 0.2
+
+	minTicker = 100 * time.Millisecond
+)
+
+// retryAfter returns the delay asked by loki in a 429 or 503 response, from its Retry-After
+// header: a number of seconds or an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(date.Sub(now), 0), true
+}
+
+// jitter spreads a delay by ±readyJitter, so that the agents started together don't poll loki in
+// lockstep.
+func jitter(d time.Duration) time.Duration {
+	spread := int64(float64(d) * readyJitter)
+	if spread <= 0 {
+		return d
+	}
+
+	return d + time.Duration(rand.Int64N(2*spread+1)-spread)
 }
 
-func updateURI(uri string, lq LokiQueryRangeResponse, infinite bool) string {
+// updateURI sets the start of the next page. It's the last timestamp of the previous page, not
+// the next one: the page can end in the middle of the entries sharing it, see pageBoundary.
+// The end is not changed if it is zero.
+func updateURI(uri string, start time.Time, end time.Time) string {
 	u, _ := url.Parse(uri)
 	queryParams := u.Query()
 
-	if len(lq.Data.Result) > 0 {
-		lastTs := lq.Data.Result[0].Entries[len(lq.Data.Result[0].Entries)-1].Timestamp
-		// +1 the last timestamp to avoid getting the same result again.
-		queryParams.Set("start", strconv.Itoa(int(lastTs.UnixNano()+1)))
+	if !start.IsZero() {
+		queryParams.Set("start", strconv.Itoa(int(start.UnixNano())))
 	}
 
-	if infinite {
-		queryParams.Set("end", strconv.Itoa(int(time.Now().UnixNano())))
+	if !end.IsZero() {
+		queryParams.Set("end", strconv.Itoa(int(end.UnixNano())))
 	}
 
 	u.RawQuery = queryParams.Encode()
 	return u.String()
 }
 
+func setURIParam(uri string, key string, value string) string {
+	u, _ := url.Parse(uri)
+	queryParams := u.Query()
+	queryParams.Set(key, value)
+	u.RawQuery = queryParams.Encode()
+	return u.String()
+}
+
+func (lc *LokiClient) currentQuery() string {
+	lc.queryMu.Lock()
+	defer lc.queryMu.Unlock()
+	return lc.query
+}
+
+// ResumeFrom makes the next tail start at a past timestamp, instead of now. The entries of this
+// timestamp are read again.
+func (lc *LokiClient) ResumeFrom(ts time.Time) {
+	lc.resumeFrom = ts
+}
+
+// Query returns the current query.
+func (lc *LokiClient) Query() string {
+	return lc.currentQuery()
+}
+
+// SetQuery changes the query of a running QueryRange, which resumes from its current position.
+func (lc *LokiClient) SetQuery(query string) {
+	lc.queryMu.Lock()
+	defer lc.queryMu.Unlock()
+	lc.query = query
+}
+
+// ValidateQuery asks loki to run a query on a small time range, and returns an error if it is rejected.
+func (lc *LokiClient) ValidateQuery(ctx context.Context, query string) error {
+	now := time.Now()
+	u := lc.getURLFor("loki/api/v1/query_range", map[string]string{
+		"query":     query,
+		"start":     strconv.Itoa(int(now.Add(-time.Minute).UnixNano())),
+		"end":       strconv.Itoa(int(now.UnixNano())),
+		"limit":     "1",
+		"direction": "forward",
+	})
+
+	resp, err := lc.Get(ctx, u)
+	if err != nil {
+		return fmt.Errorf("while validating query: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("loki rejected the query (HTTP %d: %s)", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
 func (lc *LokiClient) SetTomb(t *tomb.Tomb) {
 	lc.t = t
 }
@@ -75,21 +269,123 @@ func (lc *LokiClient) resetFailStart() {
 		log.Infof("loki is back after %s", time.Since(lc.fail_start))
 	}
 	lc.fail_start = time.Time{}
+	lc.failedAttempts = 0
+	lc.throttledRetries = 0
+	lc.setDegraded(time.Time{})
+}
+
+// retryLater is called after a failed request. It returns the error that stops the client once
+// loki is considered gone, otherwise it delays the next request: the delay starts at a second and
+// doubles up to MaxBackoff. The position of the query is kept, the next request resumes from the
+// last entry that was read.
+func (lc *LokiClient) retryLater(ticker *time.Ticker, err error) error {
+	lc.failedAttempts++
+
+	if lc.config.Metrics {
+		Reconnects.With(lc.metricLabels()).Inc()
+	}
+
+	if lc.config.MaxFailedAttempts > 0 && lc.failedAttempts >= lc.config.MaxFailedAttempts {
+		lc.Logger.Errorf("loki request failed %d times in a row, giving up", lc.failedAttempts)
+		return fmt.Errorf("giving up after %d failed attempts: %w", lc.failedAttempts, err)
+	}
+
+	if !lc.shouldRetry() {
+		return err
+	}
+
+	maxBackoff := lc.config.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+
+	lc.backoff = min(max(2*lc.backoff, minBackoff), maxBackoff)
+
+	if lc.degradedSince().IsZero() {
+		// within the reconnect grace period
+		lc.Logger.Debugf("%s, retrying in %s (attempt %d)", err, lc.backoff, lc.failedAttempts)
+	} else {
+		lc.Logger.Warnf("%s, retrying in %s (attempt %d)", err, lc.backoff, lc.failedAttempts)
+	}
+
+	lc.currentTickerInterval = lc.backoff
+	ticker.Reset(lc.backoff)
+
+	return nil
 }
 
 func (lc *LokiClient) shouldRetry() bool {
+	grace := lc.config.ReconnectGracePeriod
+
 	if lc.fail_start.IsZero() {
-		lc.Logger.Warningf("loki is not available, will retry for %s", lc.config.FailMaxDuration)
 		lc.fail_start = time.Now()
+		if grace > 0 {
+			lc.Logger.Infof("loki is not available, reconnecting (grace period %s)", grace)
+			return true
+		}
+	}
+
+	failure := time.Since(lc.fail_start)
+	if failure < grace {
 		return true
 	}
-	if time.Since(lc.fail_start) > lc.config.FailMaxDuration {
-		lc.Logger.Errorf("loki didn't manage to recover after %s, giving up", lc.config.FailMaxDuration)
+
+	if lc.degradedSince().IsZero() {
+		lc.Logger.Warningf("loki is not available, will retry for %s", lc.config.FailMaxDuration)
+		lc.setDegraded(time.Now())
+	}
+
+	if failure > grace+lc.config.FailMaxDuration {
+		lc.Logger.Errorf("loki didn't manage to recover after %s, giving up", failure.Round(time.Second))
 		return false
 	}
 	return true
 }
 
+func (lc *LokiClient) setDegraded(from time.Time) {
+	lc.healthMu.Lock()
+	defer lc.healthMu.Unlock()
+	lc.degradedFrom = from
+}
+
+func (lc *LokiClient) degradedSince() time.Time {
+	lc.healthMu.Lock()
+	defer lc.healthMu.Unlock()
+	return lc.degradedFrom
+}
+
+// Degraded is true when loki has been unreachable for longer than the reconnect grace period.
+func (lc *LokiClient) Degraded() (bool, string) {
+	since := lc.degradedSince()
+	if since.IsZero() {
+		return false, ""
+	}
+	return true, "loki is unreachable since " + since.Format(time.RFC3339)
+}
+
+// throttle delays the next request by the Retry-After of a throttled response. Loki is up, so it
+// does not count as a failure, but it returns an error once loki has asked to retry later more
+// than MaxRetries times in a row.
+func (lc *LokiClient) throttle(ticker *time.Ticker, statusCode int, wait time.Duration) error {
+	maxRetries := lc.config.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DefaultMaxRetries
+	}
+
+	lc.throttledRetries++
+
+	if lc.throttledRetries > maxRetries {
+		return fmt.Errorf("loki is still throttling the requests (HTTP %d) after %d retries", statusCode, maxRetries)
+	}
+
+	lc.Logger.WithField("retry", lc.throttledRetries).Warnf("loki is throttling the requests (HTTP %d), retrying in %s", statusCode, wait)
+
+	lc.currentTickerInterval = max(wait, minTicker)
+	ticker.Reset(lc.currentTickerInterval)
+
+	return nil
+}
+
 func (lc *LokiClient) increaseTicker(ticker *time.Ticker) {
 	maxTicker := 10 * time.Second
 	if lc.currentTickerInterval < maxTicker {
@@ -102,17 +398,19 @@ func (lc *LokiClient) increaseTicker(ticker *time.Ticker) {
 }
 
 func (lc *LokiClient) decreaseTicker(ticker *time.Ticker) {
-	minTicker := 100 * time.Millisecond
 	if lc.currentTickerInterval != minTicker {
 		lc.currentTickerInterval = minTicker
 		ticker.Reset(lc.currentTickerInterval)
 	}
 }
 
-func (lc *LokiClient) queryRange(ctx context.Context, uri string, c chan *LokiQueryRangeResponse, infinite bool) error {
-	lc.currentTickerInterval = 100 * time.Millisecond
+func (lc *LokiClient) queryRange(ctx context.Context, uri string, from time.Time, c chan *LokiQueryRangeResponse, infinite bool) error {
+	lc.currentTickerInterval = minTicker
 	ticker := time.NewTicker(lc.currentTickerInterval)
 	defer ticker.Stop()
+	query := lc.currentQuery()
+	backward := lc.backward(infinite)
+	boundary := pageBoundary{backward: backward}
 	for {
 		select {
 		case <-ctx.Done():
@@ -120,64 +418,157 @@ func (lc *LokiClient) queryRange(ctx context.Context, uri string, c chan *LokiQu
 		case <-lc.t.Dying():
 			return lc.t.Err()
 		case <-ticker.C:
+			if q := lc.currentQuery(); q != query {
+				// keep the start timestamp: the new query continues where the old one stopped
+				lc.Logger.Infof("switching to query %s", q)
+				query = q
+				uri = setURIParam(uri, "query", query)
+			}
+			requestStart := time.Now()
 			resp, err := lc.Get(ctx, uri)
+			if lc.config.Metrics {
+				QueryDuration.With(lc.metricLabels()).Observe(time.Since(requestStart).Seconds())
+			}
 			if err != nil {
-				if ok := lc.shouldRetry(); !ok {
-					return fmt.Errorf("error querying range: %w", err)
+				if ctx.Err() != nil {
+					// the request was aborted, not failed
+					return ctx.Err()
+				}
+				if err := lc.retryLater(ticker, fmt.Errorf("error querying range: %w", err)); err != nil {
+					return err
 				}
-				lc.increaseTicker(ticker)
 				continue
 			}
 
 			if resp.StatusCode != http.StatusOK {
-				lc.Logger.Warnf("bad HTTP response code for query range: %d", resp.StatusCode)
 				body, _ := io.ReadAll(resp.Body)
 				resp.Body.Close()
-				if ok := lc.shouldRetry(); !ok {
-					return fmt.Errorf("bad HTTP response code: %d: %s: %w", resp.StatusCode, string(body), err)
+				if wait, ok := retryAfter(resp, time.Now()); ok {
+					if err := lc.throttle(ticker, resp.StatusCode, wait); err != nil {
+						return err
+					}
+					continue
+				}
+				lc.Logger.Warnf("bad HTTP response code for query range: %d", resp.StatusCode)
+				if err := orgIDError(resp.StatusCode, body, lc.hasOrgID()); err != nil {
+					return err
+				}
+				if err := limitError(resp.StatusCode, body, lc.config.Limit); err != nil {
+					return err
+				}
+				if err := lc.retryLater(ticker, fmt.Errorf("bad HTTP response code: %d: %s", resp.StatusCode, string(body))); err != nil {
+					return err
 				}
-				lc.increaseTicker(ticker)
 				continue
 			}
 
 			var lq LokiQueryRangeResponse
 			if err := json.NewDecoder(resp.Body).Decode(&lq); err != nil {
 				resp.Body.Close()
-				if ok := lc.shouldRetry(); !ok {
-					return fmt.Errorf("error decoding Loki response: %w", err)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if lc.config.Metrics {
+					ParseErrors.With(lc.metricLabels()).Inc()
+				}
+				if err := lc.retryLater(ticker, fmt.Errorf("error decoding Loki response: %w", err)); err != nil {
+					return err
 				}
-				lc.increaseTicker(ticker)
 				continue
 			}
 			resp.Body.Close()
+			if lq.Data.ResultType != "" && lq.Data.ResultType != ResultTypeStreams {
+				return fmt.Errorf("loki returned a %s instead of log streams: metric queries are not supported, only log queries can be acquired", lq.Data.ResultType)
+			}
 			lc.Logger.Tracef("Got response: %+v", lq)
-			c <- &lq
+			malformed := lc.skipMalformed(lq.Data.Result)
+			total, sameTimestamp := boundary.filter(&lq)
+			if total == 0 && malformed >= lc.config.Limit {
+				// the next page would be the same one
+				return fmt.Errorf("the %d entries of the page are malformed, cannot read further", malformed)
+			}
+			// the page may not be read anymore
+			select {
+			case c <- &lq:
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-lc.t.Dying():
+				return lc.t.Err()
+			}
+			recovered := lc.backoff > 0 || lc.throttledRetries > 0
 			lc.resetFailStart()
-			if !infinite && (len(lq.Data.Result) == 0 || len(lq.Data.Result[0].Entries) < lc.config.Limit) {
-				lc.Logger.Infof("Got less than %d results (%d), stopping", lc.config.Limit, len(lq.Data.Result))
+			if recovered {
+				lc.backoff = 0
+				lc.decreaseTicker(ticker)
+			}
+			// the page is full if loki returned limit entries, even when some are malformed
+			if !infinite && total+malformed < lc.config.Limit {
+				lc.Logger.Infof("Got less than %d results (%d), stopping", lc.config.Limit, total)
 				close(c)
 				return nil
 			}
-			if len(lq.Data.Result) > 0 {
-				lc.Logger.Debugf("(timer:%v) %d results / %d entries result[0] (uri:%s)", lc.currentTickerInterval, len(lq.Data.Result), len(lq.Data.Result[0].Entries), uri)
-			} else {
-				lc.Logger.Debugf("(timer:%v) no results (uri:%s)", lc.currentTickerInterval, uri)
-			}
+			lc.Logger.Debugf("(timer:%v) %d entries (uri:%s)", lc.currentTickerInterval, total, uri)
 			if infinite {
-				if len(lq.Data.Result) > 0 { //as long as we get results, we keep lowest ticker
+				if total > 0 { //as long as we get results, we keep lowest ticker
 					lc.decreaseTicker(ticker)
 				} else {
 					lc.increaseTicker(ticker)
 				}
 			}
 
-			uri = updateURI(uri, lq, infinite)
+			if !infinite && lc.config.PageDelay > lc.currentTickerInterval {
+				// the next page is requested after the delay, or after the context is done
+				lc.currentTickerInterval = lc.config.PageDelay
+				ticker.Reset(lc.currentTickerInterval)
+			}
+
+			if backward {
+				// the end is excluded: the next page ends just after the boundary, to read the
+				// other entries of its timestamp
+				end := boundary.ts.Add(time.Nanosecond)
+				if sameTimestamp && total >= lc.config.Limit {
+					lc.Logger.Warnf("more than %d entries have the timestamp %s, skipping the others: increase limit", lc.config.Limit, boundary.ts)
+					end = boundary.ts
+				}
+				uri = updateURI(uri, time.Time{}, end)
+				continue
+			}
+
+			start := boundary.ts
+			if sameTimestamp && total >= lc.config.Limit {
+				// the next page would be the same one
+				lc.Logger.Warnf("more than %d entries have the timestamp %s, skipping the others: increase limit", lc.config.Limit, start)
+				start = start.Add(time.Nanosecond)
+			}
+			end := time.Time{}
+			if infinite {
+				end = lc.tailEnd()
+				if start.IsZero() {
+					start = from
+				}
+				if !end.After(start) {
+					// with StartFromNow, the range is empty until DelayFor has elapsed
+					end = start.Add(time.Nanosecond)
+				}
+			}
+			uri = updateURI(uri, start, end)
 		}
 	}
 }
 
+// backward tells whether the pages are read from the end of the range: only without infinite.
+func (lc *LokiClient) backward(infinite bool) bool {
+	return !infinite && lc.config.Direction == DirectionBackward
+}
+
+// tailEnd is the end of the range read while tailing: the entries more recent than DelayFor are
+// read by the next requests, once the late entries had time to arrive.
+func (lc *LokiClient) tailEnd() time.Time {
+	return time.Now().Add(-time.Duration(lc.config.DelayFor) * time.Second)
+}
+
 func (lc *LokiClient) getURLFor(endpoint string, params map[string]string) string {
-	u, err := url.Parse(lc.config.LokiURL)
+	u, err := url.Parse(lc.endpoints.url(lc.Logger))
 	if err != nil {
 		return ""
 	}
@@ -203,10 +594,21 @@ func (lc *LokiClient) getURLFor(endpoint string, params map[string]string) strin
 	return u.String()
 }
 
+// Ready polls loki until it is ready, every ReadyInterval with some jitter. A throttled response
+// delays the next check by its Retry-After, the checks are bounded by the deadline of the context.
 func (lc *LokiClient) Ready(ctx context.Context) error {
-	tick := time.NewTicker(500 * time.Millisecond)
-	url := lc.getURLFor("ready", nil)
+	readyInterval := lc.config.ReadyInterval
+	if readyInterval <= 0 {
+		readyInterval = DefaultReadyInterval
+	}
+	tick := time.NewTicker(jitter(readyInterval))
+	readyPath := lc.config.ReadyPath
+	if readyPath == "" {
+		readyPath = DefaultReadyPath
+	}
+	url := lc.getURLFor(readyPath, nil)
 	lc.Logger.Debugf("Using url: %s for ready check", url)
+	retries := 0
 	for {
 		select {
 		case <-ctx.Done():
@@ -216,36 +618,76 @@ func (lc *LokiClient) Ready(ctx context.Context) error {
 			tick.Stop()
 			return lc.t.Err()
 		case <-tick.C:
+			tick.Reset(jitter(readyInterval))
 			lc.Logger.Debug("Checking if Loki is ready")
-			resp, err := lc.Get(ctx, url)
+			resp, body, err := lc.checkReady(ctx, url)
 			if err != nil {
+				if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+					// loki is up, but slow to answer
+					lc.Logger.Warnf("Loki did not answer the ready check within %s", lc.config.ReadyTimeout)
+					continue
+				}
 				lc.Logger.Warnf("Error checking if Loki is ready: %s", err)
 				continue
 			}
-			_ = resp.Body.Close()
+			if wait, ok := retryAfter(resp, time.Now()); ok {
+				retries++
+				lc.Logger.WithField("retry", retries).Warnf("loki is throttling the ready check (HTTP %d), retrying in %s", resp.StatusCode, wait)
+				tick.Reset(max(wait, readyInterval))
+				continue
+			}
 			if resp.StatusCode != http.StatusOK {
 				lc.Logger.Debugf("Loki is not ready, status code: %d", resp.StatusCode)
 				continue
 			}
+			if !strings.Contains(string(body), lc.config.ReadyExpectBody) {
+				lc.Logger.Debugf("Loki is not ready, unexpected body: %s", strings.TrimSpace(string(body)))
+				continue
+			}
 			lc.Logger.Info("Loki is ready")
 			return nil
 		}
 	}
 }
 
+// checkReady sends a ready check, bounded by ReadyTimeout, and reads the body of the response.
+func (lc *LokiClient) checkReady(ctx context.Context, url string) (*http.Response, []byte, error) {
+	if lc.config.ReadyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, lc.config.ReadyTimeout)
+		defer cancel()
+	}
+
+	resp, err := lc.Get(ctx, url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp, body, nil
+}
+
 func (lc *LokiClient) Tail(ctx context.Context) (chan *LokiResponse, error) {
 	responseChan := make(chan *LokiResponse)
-	dialer := &websocket.Dialer{}
-	u := lc.getURLFor("loki/api/v1/tail", map[string]string{
+	dialer := &websocket.Dialer{TLSClientConfig: lc.config.TLSConfig}
+	if lc.config.LocalAddr != nil {
+		dialer.NetDialContext = sourceaddr.Dialer(lc.config.LocalAddr).DialContext
+	}
+	params := map[string]string{
 		"limit":     strconv.Itoa(lc.config.Limit),
-		"start":     strconv.Itoa(int(time.Now().Add(-lc.config.Since).UnixNano())),
-		"query":     lc.config.Query,
+		"start":     strconv.Itoa(int(lc.config.Since.Time(time.Now()).UnixNano())),
+		"query":     lc.currentQuery(),
 		"delay_for": strconv.Itoa(lc.config.DelayFor),
-	})
+	}
 
-	lc.Logger.Debugf("Since: %s (%s)", lc.config.Since, time.Now().Add(-lc.config.Since))
+	lc.Logger.Debugf("Since: %s (%s)", lc.config.Since, lc.config.Since.Time(time.Now()))
 
-	if lc.config.Username != "" || lc.config.Password != "" {
+	if (lc.config.Username != "" || lc.config.Password != "") && lc.config.PasswordFile == "" {
 		dialer.Proxy = func(req *http.Request) (*url.URL, error) {
 			req.SetBasicAuth(lc.config.Username, lc.config.Password)
 			return nil, nil
@@ -256,23 +698,46 @@ func (lc *LokiClient) Tail(ctx context.Context) (chan *LokiResponse, error) {
 	for k, v := range lc.requestHeaders {
 		requestHeader.Add(k, v)
 	}
-	lc.Logger.Infof("Connecting to %s", u)
+	if err := lc.setAuthorization(ctx, requestHeader); err != nil {
+		return responseChan, err
+	}
 
-	conn, _, err := dialer.Dial(u, requestHeader)
-	if err != nil {
-		lc.Logger.Errorf("Error connecting to websocket, err: %s", err)
-		return responseChan, errors.New("error connecting to websocket")
+	var conn *websocket.Conn
+
+	for attempt := 1; ; attempt++ {
+		i := lc.endpoints.get(lc.Logger)
+		u := lc.getURLFor("loki/api/v1/tail", params)
+		lc.Logger.Infof("Connecting to %s", u)
+
+		var err error
+
+		conn, _, err = dialer.Dial(u, requestHeader)
+		if err == nil {
+			break
+		}
+
+		if !lc.endpoints.failover(i, err, lc.Logger) || attempt >= lc.endpoints.len() {
+			lc.Logger.Errorf("Error connecting to websocket, err: %s", err)
+			return responseChan, errors.New("error connecting to websocket")
+		}
 	}
 
 	lc.t.Go(func() error {
+		defer conn.Close()
 		for {
 			jsonResponse := &LokiResponse{}
 
-			err = conn.ReadJSON(jsonResponse)
+			err := conn.ReadJSON(jsonResponse)
 			if err != nil {
+				select {
+				case <-lc.t.Dying():
+					return nil
+				default:
+				}
 				lc.Logger.Errorf("Error reading from websocket: %s", err)
 				return fmt.Errorf("websocket error: %w", err)
 			}
+			lc.skipMalformed(jsonResponse.Streams)
 
 			responseChan <- jsonResponse
 		}
@@ -282,43 +747,306 @@ func (lc *LokiClient) Tail(ctx context.Context) (chan *LokiResponse, error) {
 }
 
 func (lc *LokiClient) QueryRange(ctx context.Context, infinite bool) chan *LokiQueryRangeResponse {
+	now := time.Now()
+	start := lc.config.Since.Time(now)
+	end := lc.config.Until.Time(now)
+
+	if infinite {
+		if !lc.config.StartFromNow {
+			start = start.Add(-time.Duration(lc.config.DelayFor) * time.Second)
+			if !lc.resumeFrom.IsZero() {
+				start = lc.resumeFrom
+			}
+		}
+		end = start.Add(time.Nanosecond)
+	}
+
+	direction := DirectionForward
+	if lc.backward(infinite) {
+		direction = DirectionBackward
+	}
+
 	url := lc.getURLFor("loki/api/v1/query_range", map[string]string{
-		"query":     lc.config.Query,
-		"start":     strconv.Itoa(int(time.Now().Add(-lc.config.Since).UnixNano())),
-		"end":       strconv.Itoa(int(time.Now().UnixNano())),
+		"query":     lc.currentQuery(),
+		"start":     strconv.Itoa(int(start.UnixNano())),
+		"end":       strconv.Itoa(int(end.UnixNano())),
 		"limit":     strconv.Itoa(lc.config.Limit),
-		"direction": "forward",
+		"direction": direction,
 	})
 
 	c := make(chan *LokiQueryRangeResponse)
 
-	lc.Logger.Debugf("Since: %s (%s)", lc.config.Since, time.Now().Add(-lc.config.Since))
+	lc.Logger.Debugf("Since: %s (%s)", lc.config.Since, lc.config.Since.Time(time.Now()))
 
 	lc.Logger.Infof("Connecting to %s", url)
 	lc.t.Go(func() error {
-		return lc.queryRange(ctx, url, c, infinite)
+		return lc.queryRange(ctx, url, start, c, infinite)
 	})
 	return c
 }
 
+// ReadPasswordFile returns the password stored in a file, without its trailing newline: the
+// other spaces are part of the password.
+func ReadPasswordFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read password: %w", err)
+	}
+
+	password := strings.TrimRight(string(content), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password file %s is empty", path)
+	}
+
+	return password, nil
+}
+
+func basicAuth(username string, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// ReadBearerToken returns the token stored in a file, without the surrounding whitespace.
+func ReadBearerToken(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read bearer token: %w", err)
+	}
+
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("bearer token file %s is empty", path)
+	}
+
+	return token, nil
+}
+
+// setAuthorization sets the bearer token read from BearerTokenFile, or obtained with OAuth2, or
+// the basic credentials with the password read from PasswordFile. The other credentials are in
+// the request headers.
+func (lc *LokiClient) setAuthorization(ctx context.Context, header http.Header) error {
+	var (
+		token string
+		err   error
+	)
+
+	switch {
+	case lc.oauth2 != nil:
+		token, err = lc.oauth2.Token(ctx)
+	case lc.config.BearerTokenFile != "":
+		token, err = ReadBearerToken(lc.config.BearerTokenFile)
+	case lc.config.PasswordFile != "":
+		password, err := ReadPasswordFile(lc.config.PasswordFile)
+		if err != nil {
+			return err
+		}
+
+		header.Set("Authorization", basicAuth(lc.config.Username, password))
+
+		return nil
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	header.Set("Authorization", "Bearer "+token)
+
+	return nil
+}
+
+// Authenticate gets the first OAuth2 token, to report the credentials errors with the
+// configuration. It is a no-op without OAuth2.
+func (lc *LokiClient) Authenticate(ctx context.Context) error {
+	if lc.oauth2 == nil {
+		return nil
+	}
+
+	_, err := lc.oauth2.Token(ctx)
+
+	return err
+}
+
 // Create a wrapper for http.Get to be able to set headers and auth
 func (lc *LokiClient) Get(ctx context.Context, url string) (*http.Response, error) {
+	return lc.get(ctx, url, lc.requestHeaders)
+}
+
+// get sends the request to the current endpoint, and to the next ones if it cannot be reached.
+func (lc *LokiClient) get(ctx context.Context, url string, headers map[string]string) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		i := lc.endpoints.get(lc.Logger)
+		resp, err := lc.getAuthenticated(ctx, lc.endpoints.rebase(url, i), headers)
+		if err == nil || ctx.Err() != nil || !lc.endpoints.failover(i, err, lc.Logger) || attempt >= lc.endpoints.len() {
+			return resp, err
+		}
+	}
+}
+
+func (lc *LokiClient) getAuthenticated(ctx context.Context, url string, headers map[string]string) (*http.Response, error) {
+	resp, err := lc.doGet(ctx, url, headers)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || lc.oauth2 == nil {
+		return resp, err
+	}
+
+	// the token may have been revoked before its expiry: try again once with a new one
+	resp.Body.Close()
+	lc.Logger.Debug("loki rejected the oauth2 token, refreshing it")
+	lc.oauth2.invalidate()
+
+	return lc.doGet(ctx, url, headers)
+}
+
+func (lc *LokiClient) doGet(ctx context.Context, url string, headers map[string]string) (*http.Response, error) {
 	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
 	if err != nil {
 		return nil, err
 	}
-	for k, v := range lc.requestHeaders {
+	for k, v := range headers {
 		request.Header.Add(k, v)
 	}
-	return http.DefaultClient.Do(request)
+	if err := lc.setAuthorization(ctx, request.Header); err != nil {
+		return nil, err
+	}
+	return lc.httpClient.Do(request)
+}
+
+// orgIDKey returns the configured header key for the org id, whatever its case.
+func (lc *LokiClient) orgIDKey() string {
+	for k := range lc.requestHeaders {
+		if strings.EqualFold(k, OrgIDHeader) {
+			return k
+		}
+	}
+	return ""
+}
+
+func (lc *LokiClient) hasOrgID() bool {
+	return lc.orgIDKey() != ""
+}
+
+// orgIDError turns a tenant-related rejection from Loki into an actionable error.
+// It returns nil if the response doesn't look related to the org id.
+func orgIDError(statusCode int, body []byte, sent bool) error {
+	if statusCode != http.StatusUnauthorized && statusCode != http.StatusBadRequest && statusCode != http.StatusForbidden {
+		return nil
+	}
+	msg := strings.ToLower(string(body))
+	if !strings.Contains(msg, "org id") && !strings.Contains(msg, "orgid") && !strings.Contains(msg, "tenant") {
+		return nil
+	}
+	if sent {
+		return fmt.Errorf("loki rejected the %s header (HTTP %d: %s), try orgid_mode: omit or auto", OrgIDHeader, statusCode, strings.TrimSpace(string(body)))
+	}
+	return fmt.Errorf("loki requires a %s header (HTTP %d: %s), set it in headers", OrgIDHeader, statusCode, strings.TrimSpace(string(body)))
+}
+
+// limitError turns the rejection of a limit above max_entries_limit_per_query into an actionable
+// error: retrying would not help. It returns nil if the response is about something else.
+func limitError(statusCode int, body []byte, limit int) error {
+	if statusCode != http.StatusBadRequest || !strings.Contains(strings.ToLower(string(body)), "max entries limit") {
+		return nil
+	}
+	return fmt.Errorf("loki rejected the limit of %d entries per request (%s), lower limit or raise max_entries_limit_per_query in loki", limit, strings.TrimSpace(string(body)))
+}
+
+// probeStatus performs a cheap request against the labels endpoint and returns the status code and body.
+func (lc *LokiClient) probeStatus(ctx context.Context, headers map[string]string) (int, []byte, error) {
+	resp, err := lc.get(ctx, lc.getURLFor("loki/api/v1/labels", nil), headers)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, body, nil
+}
+
+// DetectOrgID probes Loki to find out whether the X-Scope-OrgID header is accepted,
+// and stops sending it if the server only works without it. It is a no-op unless
+// the org id mode is "auto".
+func (lc *LokiClient) DetectOrgID(ctx context.Context) error {
+	if lc.config.OrgIDMode != OrgIDModeAuto {
+		return nil
+	}
+
+	status, body, err := lc.probeStatus(ctx, lc.requestHeaders)
+	if err != nil {
+		return fmt.Errorf("while probing loki for org id support: %w", err)
+	}
+
+	if status == http.StatusOK {
+		lc.Logger.Debugf("loki accepted the request (%s header sent: %t)", OrgIDHeader, lc.hasOrgID())
+		return nil
+	}
+
+	key := lc.orgIDKey()
+	if key == "" {
+		if err := orgIDError(status, body, false); err != nil {
+			return err
+		}
+		return fmt.Errorf("while probing loki for org id support: unexpected HTTP status %d", status)
+	}
+
+	withoutOrgID := make(map[string]string, len(lc.requestHeaders))
+	for k, v := range lc.requestHeaders {
+		if k != key {
+			withoutOrgID[k] = v
+		}
+	}
+
+	retryStatus, _, err := lc.probeStatus(ctx, withoutOrgID)
+	if err != nil {
+		return fmt.Errorf("while probing loki for org id support: %w", err)
+	}
+
+	if retryStatus != http.StatusOK {
+		return fmt.Errorf("loki rejected the request with (HTTP %d) and without (HTTP %d) the %s header", status, retryStatus, OrgIDHeader)
+	}
+
+	lc.Logger.Infof("loki rejected the %s header (HTTP %d) but accepts requests without it, not sending it anymore", OrgIDHeader, status)
+	lc.requestHeaders = withoutOrgID
+
+	return nil
 }
 
 func NewLokiClient(config Config) *LokiClient {
 	headers := make(map[string]string)
 	maps.Copy(headers, config.Headers)
-	if config.Username != "" || config.Password != "" {
-		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(config.Username+":"+config.Password))
+	if config.OrgIDMode == OrgIDModeOmit {
+		for k := range headers {
+			if strings.EqualFold(k, OrgIDHeader) {
+				delete(headers, k)
+			}
+		}
+	}
+	switch {
+	case config.BearerToken != "":
+		headers["Authorization"] = "Bearer " + config.BearerToken
+	case config.BearerTokenFile != "" || config.OAuth2 != nil || config.PasswordFile != "":
+		// set for each request, see setAuthorization
+	case config.Username != "" || config.Password != "":
+		headers["Authorization"] = basicAuth(config.Username, config.Password)
+	}
+	headers["User-Agent"] = config.UserAgent
+	if config.UserAgent == "" {
+		headers["User-Agent"] = useragent.LokiUserAgent()
+	}
+	// the transport sends Accept-Encoding: gzip and decompresses the responses, unless disabled
+	httpClient := &http.Client{Timeout: config.Timeout}
+	if config.LocalAddr != nil || config.TLSConfig != nil || config.DisableCompression || config.ProxyURL != nil {
+		transport := sourceaddr.Transport(config.LocalAddr)
+		transport.TLSClientConfig = config.TLSConfig
+		transport.DisableCompression = config.DisableCompression
+		if config.ProxyURL != nil {
+			transport.Proxy = http.ProxyURL(config.ProxyURL)
+		}
+		httpClient.Transport = transport
+	}
+	lc := &LokiClient{Logger: log.WithField("component", "lokiclient"), config: config, requestHeaders: headers, httpClient: httpClient, query: config.Query, endpoints: newEndpoints(config)}
+	if config.OAuth2 != nil {
+		// the token endpoint is reached the same way as loki, with the same certificates
+		lc.oauth2 = newTokenSource(*config.OAuth2, httpClient)
 	}
-	headers["User-Agent"] = useragent.Default()
-	return &LokiClient{Logger: log.WithField("component", "lokiclient"), config: config, requestHeaders: headers}
+	return lc
 }
@@ -0,0 +1,796 @@
+package lokiclient
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tomb.v2"
+)
+
+func TestDetectOrgID(t *testing.T) {
+	tests := []struct {
+		name        string
+		headers     map[string]string
+		handler     http.HandlerFunc
+		expectedErr string
+		expectOrgID bool
+	}{
+		{
+			name:    "header accepted",
+			headers: map[string]string{"x-scope-orgid": "1234"},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+			expectOrgID: true,
+		},
+		{
+			name:    "header rejected by single tenant gateway",
+			headers: map[string]string{"x-scope-orgid": "1234"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get(OrgIDHeader) != "" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			},
+			expectOrgID: false,
+		},
+		{
+			name:    "header missing on multi-tenant loki",
+			headers: map[string]string{},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte("no org id"))
+			},
+			expectedErr: "loki requires a X-Scope-OrgID header (HTTP 401: no org id), set it in headers",
+		},
+		{
+			name:    "always rejected",
+			headers: map[string]string{"x-scope-orgid": "1234"},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			},
+			expectedErr: "loki rejected the request with (HTTP 403) and without (HTTP 403) the X-Scope-OrgID header",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(tc.handler)
+			defer srv.Close()
+
+			lc := NewLokiClient(Config{
+				LokiURL:   srv.URL,
+				Headers:   tc.headers,
+				OrgIDMode: OrgIDModeAuto,
+			})
+
+			err := lc.DetectOrgID(t.Context())
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectOrgID, lc.hasOrgID())
+		})
+	}
+}
+
+func TestOmitOrgID(t *testing.T) {
+	lc := NewLokiClient(Config{
+		Headers:   map[string]string{"X-Scope-OrgId": "1234", "foo": "bar"},
+		OrgIDMode: OrgIDModeOmit,
+	})
+
+	assert.False(t, lc.hasOrgID())
+	assert.Equal(t, "bar", lc.requestHeaders["foo"])
+}
+
+func TestBearerToken(t *testing.T) {
+	authorization := ""
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	get := func(lc *LokiClient) string {
+		resp, err := lc.Get(t.Context(), srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		return authorization
+	}
+
+	assert.Equal(t, "Bearer secret", get(NewLokiClient(Config{LokiURL: srv.URL, BearerToken: "secret"})))
+
+	// the file is read for each request
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("first\n"), 0o600))
+
+	lc := NewLokiClient(Config{LokiURL: srv.URL, BearerTokenFile: tokenFile})
+	assert.Equal(t, "Bearer first", get(lc))
+
+	require.NoError(t, os.WriteFile(tokenFile, []byte("second\n"), 0o600))
+	assert.Equal(t, "Bearer second", get(lc))
+
+	require.NoError(t, os.WriteFile(tokenFile, []byte("\n"), 0o600))
+	_, err := lc.Get(t.Context(), srv.URL)
+	require.EqualError(t, err, "bearer token file "+tokenFile+" is empty")
+}
+
+func TestPasswordFile(t *testing.T) {
+	authorization := make(chan string, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		authorization <- username + ":" + password
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	// the file is read for each request, only its trailing newline is removed
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte(" first \n"), 0o600))
+
+	lc := NewLokiClient(Config{LokiURL: srv.URL, Username: "crowdsec", PasswordFile: passwordFile})
+
+	for _, expected := range []string{"crowdsec: first ", "crowdsec:second"} {
+		resp, err := lc.Get(t.Context(), srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, expected, <-authorization)
+
+		require.NoError(t, os.WriteFile(passwordFile, []byte("second\r\n"), 0o600))
+	}
+
+	require.NoError(t, os.WriteFile(passwordFile, []byte("\n"), 0o600))
+	_, err := lc.Get(t.Context(), srv.URL)
+	require.EqualError(t, err, "password file "+passwordFile+" is empty")
+}
+
+func TestReconnectGracePeriod(t *testing.T) {
+	lc := NewLokiClient(Config{
+		FailMaxDuration:      100 * time.Millisecond,
+		ReconnectGracePeriod: 100 * time.Millisecond,
+	})
+
+	// within the grace period, failures are not reported
+	assert.True(t, lc.shouldRetry())
+	degraded, _ := lc.Degraded()
+	assert.False(t, degraded)
+
+	time.Sleep(120 * time.Millisecond)
+
+	assert.True(t, lc.shouldRetry())
+	degraded, reason := lc.Degraded()
+	assert.True(t, degraded)
+	assert.Contains(t, reason, "loki is unreachable since")
+
+	// max_failure_duration starts counting after the grace period
+	time.Sleep(120 * time.Millisecond)
+	assert.False(t, lc.shouldRetry())
+
+	lc.resetFailStart()
+	degraded, _ = lc.Degraded()
+	assert.False(t, degraded)
+
+	// a short outage is never reported
+	assert.True(t, lc.shouldRetry())
+	lc.resetFailStart()
+	assert.True(t, lc.shouldRetry())
+	degraded, _ = lc.Degraded()
+	assert.False(t, degraded)
+}
+
+func TestRetryLater(t *testing.T) {
+	lc := NewLokiClient(Config{
+		FailMaxDuration:   time.Hour,
+		MaxBackoff:        5 * time.Second,
+		MaxFailedAttempts: 6,
+	})
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	cause := errors.New("connection refused")
+
+	// starts at a second, doubles up to max_backoff
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		require.NoError(t, lc.retryLater(ticker, cause))
+		assert.Equal(t, expected, lc.backoff)
+	}
+
+	err := lc.retryLater(ticker, cause)
+	require.EqualError(t, err, "giving up after 6 failed attempts: connection refused")
+	require.ErrorIs(t, err, cause)
+
+	// a successful request resets the count
+	lc.resetFailStart()
+	require.NoError(t, lc.retryLater(ticker, cause))
+	assert.Equal(t, 1, lc.failedAttempts)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		statusCode int
+		header     string
+		expected   time.Duration
+		expectedOK bool
+	}{
+		{name: "seconds", statusCode: http.StatusTooManyRequests, header: "7", expected: 7 * time.Second, expectedOK: true},
+		{name: "date", statusCode: http.StatusServiceUnavailable, header: "Fri, 01 Mar 2024 12:00:30 GMT", expected: 30 * time.Second, expectedOK: true},
+		{name: "past date", statusCode: http.StatusTooManyRequests, header: "Fri, 01 Mar 2024 11:00:00 GMT", expected: 0, expectedOK: true},
+		{name: "no header", statusCode: http.StatusTooManyRequests},
+		{name: "invalid header", statusCode: http.StatusTooManyRequests, header: "soon"},
+		{name: "other status", statusCode: http.StatusBadGateway, header: "7"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tc.statusCode, Header: http.Header{}}
+			if tc.header != "" {
+				resp.Header.Set("Retry-After", tc.header)
+			}
+
+			wait, ok := retryAfter(resp, now)
+			assert.Equal(t, tc.expectedOK, ok)
+			assert.Equal(t, tc.expected, wait)
+		})
+	}
+}
+
+func TestThrottledQueryRange(t *testing.T) {
+	requests := atomic.Int32{}
+	throttled := atomic.Int32{}
+	throttled.Store(2)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) <= throttled.Load() {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+	}))
+	defer srv.Close()
+
+	lc := NewLokiClient(Config{LokiURL: srv.URL, Query: `{job="a"}`, Limit: 100, MaxRetries: 2})
+	tmb := &tomb.Tomb{}
+	lc.SetTomb(tmb)
+
+	// the throttled requests do not stop the acquisition
+	for range lc.QueryRange(t.Context(), false) {
+	}
+	require.NoError(t, tmb.Wait())
+	assert.Equal(t, int32(3), requests.Load())
+
+	// until loki keeps throttling for more than max_retries requests
+	requests.Store(0)
+	throttled.Store(10)
+	tmb = &tomb.Tomb{}
+	lc.SetTomb(tmb)
+	lc.QueryRange(t.Context(), false)
+
+	require.EqualError(t, tmb.Wait(), "loki is still throttling the requests (HTTP 429) after 2 retries")
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestLimitRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("max entries limit per query exceeded, limit > max_entries_limit (10000 > 5000)\n"))
+	}))
+	defer srv.Close()
+
+	lc := NewLokiClient(Config{LokiURL: srv.URL, Query: `{job="a"}`, Limit: 10000, FailMaxDuration: time.Hour})
+	tmb := &tomb.Tomb{}
+	lc.SetTomb(tmb)
+	lc.QueryRange(t.Context(), false)
+
+	// not retried
+	require.EqualError(t, tmb.Wait(), "loki rejected the limit of 10000 entries per request (max entries limit per query exceeded, limit > max_entries_limit (10000 > 5000)), lower limit or raise max_entries_limit_per_query in loki")
+}
+
+func TestDelayFor(t *testing.T) {
+	ends := make(chan time.Time, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		end, _ := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+		ends <- time.Unix(0, end)
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+	}))
+	defer srv.Close()
+
+	lc := NewLokiClient(Config{LokiURL: srv.URL, Query: `{job="a"}`, Limit: 100, DelayFor: 30})
+	tmb := &tomb.Tomb{}
+	lc.SetTomb(tmb)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	c := lc.QueryRange(ctx, true)
+	go func() {
+		for range c {
+		}
+	}()
+
+	// the entries of the last 30 seconds are not read yet
+	for range 2 {
+		assert.WithinDuration(t, time.Now().Add(-30*time.Second), <-ends, 5*time.Second)
+	}
+
+	tmb.Kill(nil)
+	_ = tmb.Wait()
+}
+
+func TestStartFromNow(t *testing.T) {
+	type queryRange struct{ start, end time.Time }
+
+	requests := make(chan queryRange, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+		requests <- queryRange{time.Unix(0, start), time.Unix(0, end)}
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+	}))
+	defer srv.Close()
+
+	lc := NewLokiClient(Config{LokiURL: srv.URL, Query: `{job="a"}`, Limit: 100, DelayFor: 30, StartFromNow: true})
+	lc.ResumeFrom(time.Now().Add(-time.Hour))
+	tmb := &tomb.Tomb{}
+	lc.SetTomb(tmb)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	started := time.Now()
+
+	c := lc.QueryRange(ctx, true)
+	go func() {
+		for range c {
+		}
+	}()
+
+	// nothing older than the start is read, and the range stays valid during delay_for
+	for range 3 {
+		r := <-requests
+		assert.WithinDuration(t, started, r.start, time.Second)
+		assert.True(t, r.end.After(r.start), "end %s is not after start %s", r.end, r.start)
+	}
+
+	tmb.Kill(nil)
+	_ = tmb.Wait()
+}
+
+func TestPageBoundary(t *testing.T) {
+	ts := time.Unix(0, 1000)
+	page := func(entries ...Entry) *LokiQueryRangeResponse {
+		return &LokiQueryRangeResponse{Data: Data{Result: []Stream{{Stream: map[string]string{"job": "a"}, Entries: entries}}}}
+	}
+
+	b := pageBoundary{}
+
+	lq := page(Entry{Timestamp: ts.Add(-1), Line: "a"}, Entry{Timestamp: ts, Line: "b"}, Entry{Timestamp: ts, Line: "c"})
+	total, sameTimestamp := b.filter(lq)
+	assert.Equal(t, 3, total)
+	assert.False(t, sameTimestamp)
+	assert.Len(t, lq.Data.Result[0].Entries, 3)
+	assert.Equal(t, ts, b.ts)
+
+	// the next page starts at ts: only the new entries are kept
+	lq = page(Entry{Timestamp: ts, Line: "b"}, Entry{Timestamp: ts, Line: "c"}, Entry{Timestamp: ts, Line: "d"})
+	total, sameTimestamp = b.filter(lq)
+	assert.Equal(t, 3, total)
+	assert.True(t, sameTimestamp)
+	require.Len(t, lq.Data.Result, 1)
+	assert.Equal(t, []Entry{{Timestamp: ts, Line: "d"}}, lq.Data.Result[0].Entries)
+
+	// nothing new
+	lq = page(Entry{Timestamp: ts, Line: "b"}, Entry{Timestamp: ts, Line: "d"})
+	total, _ = b.filter(lq)
+	assert.Equal(t, 2, total)
+	assert.Empty(t, lq.Data.Result)
+
+	// the same line in another stream is another entry
+	lq = &LokiQueryRangeResponse{Data: Data{Result: []Stream{{Stream: map[string]string{"job": "b"}, Entries: []Entry{{Timestamp: ts, Line: "b"}}}}}}
+	b.filter(lq)
+	assert.Len(t, lq.Data.Result, 1)
+
+	// backward, the boundary is the oldest timestamp
+	b = pageBoundary{backward: true}
+
+	lq = page(Entry{Timestamp: ts.Add(1), Line: "a"}, Entry{Timestamp: ts, Line: "b"})
+	b.filter(lq)
+	assert.Equal(t, ts, b.ts)
+
+	lq = page(Entry{Timestamp: ts, Line: "b"}, Entry{Timestamp: ts.Add(-1), Line: "c"})
+	b.filter(lq)
+	assert.Equal(t, []Entry{{Timestamp: ts.Add(-1), Line: "c"}}, lq.Data.Result[0].Entries)
+	assert.Equal(t, ts.Add(-1), b.ts)
+}
+
+func TestDirection(t *testing.T) {
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	at := func(i int) time.Time { return base.Add(time.Duration(i) * time.Second) }
+
+	// newest first, as loki sorts them backward
+	entries := []Entry{
+		{Timestamp: at(5), Line: "5"},
+		{Timestamp: at(4), Line: "4"},
+		{Timestamp: at(3), Line: "3b"},
+		{Timestamp: at(3), Line: "3a"},
+		{Timestamp: at(2), Line: "2"},
+		{Timestamp: at(1), Line: "1"},
+	}
+
+	directions := make(chan string, 100)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		directions <- query.Get("direction")
+
+		start, _ := strconv.ParseInt(query.Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(query.Get("end"), 10, 64)
+		limit, _ := strconv.Atoi(query.Get("limit"))
+
+		page := []Entry{}
+		for _, entry := range entries {
+			if ts := entry.Timestamp.UnixNano(); ts >= start && ts < end && len(page) < limit {
+				page = append(page, entry)
+			}
+		}
+
+		_ = json.NewEncoder(w).Encode(LokiQueryRangeResponse{Status: "success", Data: Data{ResultType: ResultTypeStreams, Result: []Stream{{Stream: map[string]string{"job": "a"}, Entries: page}}}})
+	}))
+	defer srv.Close()
+
+	lc := NewLokiClient(Config{LokiURL: srv.URL, Query: `{job="a"}`, Limit: 2, Since: TimeBound{Ago: 2 * time.Hour}, Direction: DirectionBackward})
+	tmb := &tomb.Tomb{}
+	lc.SetTomb(tmb)
+
+	lines := []string{}
+	for lq := range lc.QueryRange(t.Context(), false) {
+		for _, stream := range lq.Data.Result {
+			for _, entry := range stream.Entries {
+				lines = append(lines, entry.Line)
+			}
+		}
+	}
+
+	require.NoError(t, tmb.Wait())
+	assert.Equal(t, []string{"5", "4", "3b", "3a", "2", "1"}, lines)
+	assert.Equal(t, DirectionBackward, <-directions)
+}
+
+func TestCompression(t *testing.T) {
+	ts := time.Now().Add(-time.Minute).UnixNano()
+	body := `{"status":"success","data":{"resultType":"streams","result":[{"stream":{"job":"a"},"values":[["` + strconv.Itoa(int(ts)) + `","hello"]]}]}}`
+
+	gzipped := atomic.Bool{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gzipped.Store(strings.Contains(r.Header.Get("Accept-Encoding"), "gzip"))
+		if !gzipped.Load() {
+			_, _ = w.Write([]byte(body))
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		_, _ = gz.Write([]byte(body))
+		_ = gz.Close()
+	}))
+	defer srv.Close()
+
+	for _, disabled := range []bool{false, true} {
+		lc := NewLokiClient(Config{LokiURL: srv.URL, Query: `{job="a"}`, Limit: 100, DisableCompression: disabled})
+		tmb := &tomb.Tomb{}
+		lc.SetTomb(tmb)
+
+		lines := []string{}
+		for resp := range lc.QueryRange(t.Context(), false) {
+			for _, stream := range resp.Data.Result {
+				for _, entry := range stream.Entries {
+					lines = append(lines, entry.Line)
+				}
+			}
+		}
+
+		require.NoError(t, tmb.Wait())
+		assert.Equal(t, []string{"hello"}, lines)
+		assert.Equal(t, !disabled, gzipped.Load())
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	lc := NewLokiClient(Config{LokiURL: srv.URL, Timeout: 100 * time.Millisecond})
+
+	start := time.Now()
+	_, err := lc.Get(t.Context(), srv.URL)
+	require.ErrorContains(t, err, "Client.Timeout exceeded")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestReadyCheck(t *testing.T) {
+	requests := atomic.Int32{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gateway/ready" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// a proxy in front of a loki that is down
+		if requests.Add(1) < 3 {
+			_, _ = w.Write([]byte("upstream unavailable\n"))
+			return
+		}
+
+		_, _ = w.Write([]byte("ready\n"))
+	}))
+	defer srv.Close()
+
+	lc := NewLokiClient(Config{LokiURL: srv.URL, ReadyPath: "/gateway/ready", ReadyExpectBody: "ready"})
+	lc.SetTomb(&tomb.Tomb{})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, lc.Ready(ctx))
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestURLPath(t *testing.T) {
+	paths := make(chan string, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+
+		if r.URL.Path == "/loki/ready" {
+			_, _ = w.Write([]byte("ready\n"))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+	}))
+	defer srv.Close()
+
+	// a loki behind a reverse proxy, on a subpath
+	lc := NewLokiClient(Config{LokiURL: srv.URL + "/loki", Query: `{job="a"}`, Limit: 100})
+	tmb := &tomb.Tomb{}
+	lc.SetTomb(tmb)
+
+	require.NoError(t, lc.Ready(t.Context()))
+	assert.Equal(t, "/loki/ready", <-paths)
+
+	for range lc.QueryRange(t.Context(), false) {
+	}
+
+	require.NoError(t, tmb.Wait())
+	assert.Equal(t, "/loki/loki/api/v1/query_range", <-paths)
+
+	tail, err := url.Parse(lc.getURLFor("loki/api/v1/tail", nil))
+	require.NoError(t, err)
+	assert.Equal(t, "ws", tail.Scheme)
+	assert.Equal(t, "/loki/loki/api/v1/tail", tail.Path)
+}
+
+func TestReadyTimeout(t *testing.T) {
+	requests := atomic.Int32{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// loki is still starting, the first check hangs
+		if requests.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+
+			return
+		}
+
+		_, _ = w.Write([]byte("ready\n"))
+	}))
+	defer srv.Close()
+
+	lc := NewLokiClient(Config{
+		LokiURL:       srv.URL,
+		ReadyTimeout:  100 * time.Millisecond,
+		ReadyInterval: 50 * time.Millisecond,
+	})
+	lc.SetTomb(&tomb.Tomb{})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 2*time.Second)
+	defer cancel()
+
+	// the hanging check doesn't use the whole budget
+	start := time.Now()
+	require.NoError(t, lc.Ready(ctx))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestJitter(t *testing.T) {
+	seen := map[time.Duration]bool{}
+
+	for range 1000 {
+		d := jitter(time.Second)
+		assert.GreaterOrEqual(t, d, 800*time.Millisecond)
+		assert.LessOrEqual(t, d, 1200*time.Millisecond)
+		seen[d] = true
+	}
+
+	assert.Greater(t, len(seen), 1)
+	assert.Equal(t, time.Duration(0), jitter(0))
+}
+
+func TestProxy(t *testing.T) {
+	requests := make(chan string, 10)
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Method + " " + r.Host
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	lc := NewLokiClient(Config{LokiURL: "http://loki.invalid:3100", ProxyURL: proxyURL})
+	lc.SetTomb(&tomb.Tomb{})
+
+	resp, err := lc.Get(t.Context(), "http://loki.invalid:3100/ready")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "GET loki.invalid:3100", <-requests)
+}
+
+func TestCancel(t *testing.T) {
+	page := `{"status":"success","data":{"resultType":"streams","result":[{"stream":{"job":"a"},"values":[["1700000000000000000","line"]]}]}}`
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		read    bool
+	}{
+		{
+			name: "in-flight request",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(10 * time.Second):
+				}
+			},
+		},
+		{
+			name: "page not read",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(page))
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(tc.handler)
+			defer srv.Close()
+
+			lc := NewLokiClient(Config{LokiURL: srv.URL, Query: `{job="a"}`, Limit: 1})
+			tmb := &tomb.Tomb{}
+			lc.SetTomb(tmb)
+
+			ctx, cancel := context.WithCancel(t.Context())
+			lc.QueryRange(ctx, false)
+
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+
+			select {
+			case <-tmb.Dead():
+				require.ErrorIs(t, tmb.Err(), context.Canceled)
+			case <-time.After(time.Second):
+				t.Fatal("the query was not aborted")
+			}
+		})
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	userAgents := make(chan string, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.URL.Path + " " + r.UserAgent()
+		_, _ = w.Write([]byte("ready\n"))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		userAgent string
+		expected  string
+	}{
+		{userAgent: "", expected: "crowdsec-loki/"},
+		{userAgent: "agent-42", expected: "agent-42"},
+	} {
+		lc := NewLokiClient(Config{LokiURL: srv.URL, Query: `{job="a"}`, UserAgent: tc.userAgent})
+		lc.SetTomb(&tomb.Tomb{})
+
+		require.NoError(t, lc.Ready(t.Context()))
+		assert.True(t, strings.HasPrefix(<-userAgents, "/ready "+tc.expected))
+	}
+}
+
+func TestPageDelay(t *testing.T) {
+	ts := atomic.Int64{}
+	ts.Store(time.Now().Add(-time.Minute).UnixNano())
+	requests := make(chan time.Time, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- time.Now()
+
+		// a full page each time, at a later timestamp
+		entry := Entry{Timestamp: time.Unix(0, ts.Add(int64(time.Second))), Line: "line"}
+		_ = json.NewEncoder(w).Encode(LokiQueryRangeResponse{Status: "success", Data: Data{ResultType: ResultTypeStreams, Result: []Stream{{Stream: map[string]string{"job": "a"}, Entries: []Entry{entry}}}}})
+	}))
+	defer srv.Close()
+
+	queryRange := func(ctx context.Context, pageDelay time.Duration) (chan *LokiQueryRangeResponse, *tomb.Tomb) {
+		lc := NewLokiClient(Config{LokiURL: srv.URL, Query: `{job="a"}`, Limit: 1, Since: TimeBound{Ago: time.Hour}, PageDelay: pageDelay})
+		tmb := &tomb.Tomb{}
+		lc.SetTomb(tmb)
+
+		return lc.QueryRange(ctx, false), tmb
+	}
+
+	c, tmb := queryRange(t.Context(), 300*time.Millisecond)
+
+	<-c
+	first := <-requests
+	<-c
+	assert.GreaterOrEqual(t, <-requests, first.Add(300*time.Millisecond))
+
+	tmb.Kill(nil)
+	_ = tmb.Wait()
+
+	// the delay is cut short when the context is cancelled
+	ctx, cancel := context.WithCancel(t.Context())
+	c, tmb = queryRange(ctx, time.Minute)
+
+	<-c
+	cancel()
+
+	select {
+	case <-tmb.Dead():
+		require.ErrorIs(t, tmb.Err(), context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("the delay was not cancelled")
+	}
+}
@@ -0,0 +1,79 @@
+package lokiclient
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The metrics of the client, by loki url and tenant. They are only updated when Config.Metrics
+// is set.
+
+var QueryDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "cs_lokisource_query_duration_seconds",
+		Help:    "Duration of the query_range requests to loki.",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	},
+	[]string{"source", "tenant"})
+
+var ParseErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_lokisource_parse_errors_total",
+		Help: "Total loki responses that could not be decoded.",
+	},
+	[]string{"source", "tenant"})
+
+var MalformedEntries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_lokisource_malformed_entries_total",
+		Help: "Total entries skipped because they could not be decoded.",
+	},
+	[]string{"source", "tenant"})
+
+var Reconnects = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_lokisource_reconnects_total",
+		Help: "Total requests retried after a failure to reach loki.",
+	},
+	[]string{"source", "tenant"})
+
+// Tenant returns the org id sent in the headers, empty if there is none.
+func Tenant(headers map[string]string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, OrgIDHeader) {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// Tenant returns the org id of the client, as configured.
+func (lc *LokiClient) Tenant() string {
+	return Tenant(lc.config.Headers)
+}
+
+// skipMalformed logs the entries of the streams that could not be decoded, and returns their
+// number.
+func (lc *LokiClient) skipMalformed(streams []Stream) int {
+	malformed := 0
+
+	for _, stream := range streams {
+		for _, err := range stream.Malformed {
+			lc.Logger.Debugf("skipping a malformed entry of the stream %v: %s", stream.Stream, err)
+		}
+
+		malformed += len(stream.Malformed)
+	}
+
+	if malformed > 0 && lc.config.Metrics {
+		MalformedEntries.With(lc.metricLabels()).Add(float64(malformed))
+	}
+
+	return malformed
+}
+
+func (lc *LokiClient) metricLabels() prometheus.Labels {
+	return prometheus.Labels{"source": lc.config.LokiURL, "tenant": lc.Tenant()}
+}
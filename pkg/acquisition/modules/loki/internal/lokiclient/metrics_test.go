@@ -0,0 +1,91 @@
+package lokiclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tomb.v2"
+)
+
+func TestMetrics(t *testing.T) {
+	newServer := func() *httptest.Server {
+		requests := atomic.Int32{}
+
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if requests.Add(1) == 1 {
+				_, _ = w.Write([]byte("not json"))
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+		}))
+	}
+
+	queryRange := func(config Config) {
+		lc := NewLokiClient(config)
+		tmb := &tomb.Tomb{}
+		lc.SetTomb(tmb)
+
+		c := lc.QueryRange(t.Context(), false)
+		for range c {
+		}
+
+		require.NoError(t, tmb.Wait())
+	}
+
+	srv := newServer()
+	defer srv.Close()
+
+	queryRange(Config{
+		LokiURL: srv.URL, Headers: map[string]string{"x-scope-orgid": "team-a"},
+		Query: `{job="a"}`, Limit: 100, FailMaxDuration: time.Minute, Metrics: true,
+	})
+
+	labels := prometheus.Labels{"source": srv.URL, "tenant": "team-a"}
+	assert.InDelta(t, 1, testutil.ToFloat64(ParseErrors.With(labels)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(Reconnects.With(labels)), 0)
+	assert.Equal(t, 1, testutil.CollectAndCount(QueryDuration))
+
+	// no metrics unless enabled
+	disabled := newServer()
+	defer disabled.Close()
+
+	queryRange(Config{LokiURL: disabled.URL, Query: `{job="a"}`, Limit: 100, FailMaxDuration: time.Minute})
+
+	assert.Equal(t, 1, testutil.CollectAndCount(ParseErrors))
+	assert.Equal(t, 1, testutil.CollectAndCount(Reconnects))
+	assert.Equal(t, 1, testutil.CollectAndCount(QueryDuration))
+}
+
+func TestMalformedEntries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[` +
+			`{"stream":{"job":"a"},"values":[["1700000000000000001","first"],["bad","line"],["1700000000000000002","second"]]}]}}`))
+	}))
+	defer srv.Close()
+
+	lc := NewLokiClient(Config{LokiURL: srv.URL, Query: `{job="a"}`, Limit: 100, FailMaxDuration: time.Minute, Metrics: true})
+	tmb := &tomb.Tomb{}
+	lc.SetTomb(tmb)
+
+	lines := []string{}
+
+	for resp := range lc.QueryRange(t.Context(), false) {
+		for _, stream := range resp.Data.Result {
+			for _, entry := range stream.Entries {
+				lines = append(lines, entry.Line)
+			}
+		}
+	}
+
+	require.NoError(t, tmb.Wait())
+	assert.Equal(t, []string{"first", "second"}, lines)
+	assert.InDelta(t, 1, testutil.ToFloat64(MalformedEntries.With(prometheus.Labels{"source": srv.URL, "tenant": ""})), 0)
+}
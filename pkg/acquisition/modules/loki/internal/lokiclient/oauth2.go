@@ -0,0 +1,129 @@
+package lokiclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a token is refreshed when it expires in less than this
+const tokenExpiryDelta = 30 * time.Second
+
+type OAuth2Config struct {
+	ClientID     string
+	ClientSecret string
+	TokenURL     string
+	Scopes       []string
+}
+
+// tokenSource gets the access tokens of the OAuth2 client credentials grant, and keeps them
+// until they are about to expire.
+type tokenSource struct {
+	config     OAuth2Config
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time // zero if the token does not expire
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func newTokenSource(config OAuth2Config, httpClient *http.Client) *tokenSource {
+	return &tokenSource{config: config, httpClient: httpClient}
+}
+
+func (ts *tokenSource) valid(now time.Time) bool {
+	return ts.token != "" && (ts.expiry.IsZero() || now.Add(tokenExpiryDelta).Before(ts.expiry))
+}
+
+// Token returns the current access token, getting a new one if needed.
+func (ts *tokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.valid(time.Now()) {
+		return ts.token, nil
+	}
+
+	token, err := ts.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	ts.token = token.AccessToken
+	ts.expiry = time.Time{}
+
+	if token.ExpiresIn > 0 {
+		ts.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+
+	return ts.token, nil
+}
+
+// invalidate drops the current token, after it was rejected.
+func (ts *tokenSource) invalidate() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.token = ""
+}
+
+func (ts *tokenSource) fetch(ctx context.Context) (*tokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+
+	if len(ts.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(ts.config.Scopes, " "))
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("while requesting oauth2 token: %w", err)
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(ts.config.ClientID), url.QueryEscape(ts.config.ClientSecret))
+
+	resp, err := ts.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("while requesting oauth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("while reading oauth2 token: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oauth2 token endpoint returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	token := tokenResponse{}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("while decoding oauth2 token: %w", err)
+	}
+
+	if token.AccessToken == "" {
+		return nil, errors.New("oauth2 token endpoint returned no access_token")
+	}
+
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return nil, fmt.Errorf("unsupported oauth2 token type '%s'", token.TokenType)
+	}
+
+	return &token, nil
+}
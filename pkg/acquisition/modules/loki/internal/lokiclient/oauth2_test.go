@@ -0,0 +1,84 @@
+package lokiclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOAuth2(t *testing.T) {
+	issued := atomic.Int32{}
+	expiresIn := atomic.Int32{}
+	expiresIn.Store(3600)
+
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if clientID != "crowdsec" || clientSecret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+
+			return
+		}
+
+		assert.Equal(t, "client_credentials", r.FormValue("grant_type"))
+		assert.Equal(t, "logs:read logs:tail", r.FormValue("scope"))
+
+		n := issued.Add(1)
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, n, expiresIn.Load())
+	}))
+	defer idp.Close()
+
+	authorization := atomic.Value{}
+	revoked := atomic.Value{}
+	revoked.Store("")
+
+	loki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization.Store(r.Header.Get("Authorization"))
+
+		if r.Header.Get("Authorization") == revoked.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer loki.Close()
+
+	oauth2 := OAuth2Config{ClientID: "crowdsec", ClientSecret: "s3cret", TokenURL: idp.URL, Scopes: []string{"logs:read", "logs:tail"}}
+
+	lc := NewLokiClient(Config{LokiURL: loki.URL, OAuth2: &oauth2})
+	require.NoError(t, lc.Authenticate(t.Context()))
+
+	get := func() {
+		resp, err := lc.Get(t.Context(), loki.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// the token is kept until it expires
+	get()
+	get()
+	assert.Equal(t, "Bearer token-1", authorization.Load())
+	assert.Equal(t, int32(1), issued.Load())
+
+	// a rejected token is replaced
+	revoked.Store("Bearer token-1")
+	get()
+	assert.Equal(t, "Bearer token-2", authorization.Load())
+
+	// a token about to expire is refreshed
+	lc.oauth2.expiry = time.Now().Add(tokenExpiryDelta / 2)
+	get()
+	assert.Equal(t, "Bearer token-3", authorization.Load())
+
+	oauth2.ClientSecret = "wrong"
+	lc = NewLokiClient(Config{LokiURL: loki.URL, OAuth2: &oauth2})
+	require.EqualError(t, lc.Authenticate(t.Context()), `oauth2 token endpoint returned HTTP 401: {"error":"invalid_client"}`)
+}
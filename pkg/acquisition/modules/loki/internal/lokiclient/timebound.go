@@ -0,0 +1,64 @@
+package lokiclient
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeBound is a bound of the time range of a query: a duration before now, or an absolute
+// timestamp when At is set.
+type TimeBound struct {
+	Ago time.Duration
+	At  time.Time
+}
+
+// ParseTimeBound reads a duration ("72h") or, failing that, an RFC 3339 timestamp.
+func ParseTimeBound(s string) (TimeBound, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return TimeBound{Ago: d}, nil
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return TimeBound{}, fmt.Errorf("'%s' is neither a duration nor an RFC 3339 timestamp", s)
+	}
+
+	return TimeBound{At: at}, nil
+}
+
+func (b *TimeBound) UnmarshalYAML(unmarshal func(any) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	bound, err := ParseTimeBound(s)
+	if err != nil {
+		return err
+	}
+
+	*b = bound
+
+	return nil
+}
+
+func (b TimeBound) IsZero() bool {
+	return b.Ago == 0 && b.At.IsZero()
+}
+
+// Time returns the bound as an absolute time.
+func (b TimeBound) Time(now time.Time) time.Time {
+	if !b.At.IsZero() {
+		return b.At
+	}
+
+	return now.Add(-b.Ago)
+}
+
+func (b TimeBound) String() string {
+	if !b.At.IsZero() {
+		return b.At.Format(time.RFC3339Nano)
+	}
+
+	return b.Ago.String()
+}
@@ -0,0 +1,30 @@
+package lokiclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	b, err := ParseTimeBound("72h")
+	require.NoError(t, err)
+	assert.Equal(t, TimeBound{Ago: 72 * time.Hour}, b)
+	assert.Equal(t, time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC), b.Time(now))
+	assert.Equal(t, "72h0m0s", b.String())
+
+	b, err = ParseTimeBound("2024-03-01T12:00:00.5+02:00")
+	require.NoError(t, err)
+	assert.True(t, b.Time(now).Equal(time.Date(2024, 3, 1, 10, 0, 0, 500000000, time.UTC)))
+	assert.Equal(t, "2024-03-01T12:00:00.5+02:00", b.String())
+
+	_, err = ParseTimeBound("2024-03-01")
+	require.EqualError(t, err, "'2024-03-01' is neither a duration nor an RFC 3339 timestamp")
+
+	assert.True(t, TimeBound{}.IsZero())
+	assert.Equal(t, now, TimeBound{}.Time(now))
+}
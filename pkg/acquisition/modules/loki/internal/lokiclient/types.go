@@ -2,33 +2,112 @@ package lokiclient
 
 import (
 	"encoding/json"
+	"fmt"
 	"strconv"
 	"time"
 )
 
+// Entry is a [timestamp, line] value of a stream. Loki 3 can add a third element with the
+// structured metadata of the entry: either the metadata itself, or with the categorize-labels
+// encoding flag, an object with structuredMetadata and parsed.
 type Entry struct {
 	Timestamp time.Time
 	Line      string
+	Metadata  map[string]string // structured metadata, nil if there is none
+}
+
+type categorizedLabels struct {
+	StructuredMetadata map[string]string `json:"structuredMetadata"`
 }
 
 func (e *Entry) UnmarshalJSON(b []byte) error {
-	var values []string
+	var values []json.RawMessage
 	err := json.Unmarshal(b, &values)
 	if err != nil {
 		return err
 	}
-	t, err := strconv.Atoi(values[0])
+	if len(values) < 2 || len(values) > 3 {
+		return fmt.Errorf("expected [timestamp, line] or [timestamp, line, metadata], got %d elements", len(values))
+	}
+	var ts, line string
+	if err := json.Unmarshal(values[0], &ts); err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+	if err := json.Unmarshal(values[1], &line); err != nil {
+		return fmt.Errorf("invalid line: %w", err)
+	}
+	// nanoseconds since the epoch, as a string: they don't fit in a float64
+	t, err := strconv.ParseInt(ts, 10, 64)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid timestamp '%s': %w", ts, err)
+	}
+	e.Timestamp = time.Unix(0, t)
+	e.Line = line
+	e.Metadata = nil
+	if len(values) == 3 {
+		e.Metadata, err = decodeMetadata(values[2])
+		if err != nil {
+			return fmt.Errorf("invalid structured metadata: %w", err)
+		}
 	}
-	e.Timestamp = time.Unix(0, int64(t))
-	e.Line = values[1]
 	return nil
 }
 
+func decodeMetadata(b json.RawMessage) (map[string]string, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	if _, ok := fields["structuredMetadata"]; ok {
+		categorized := categorizedLabels{}
+		if err := json.Unmarshal(b, &categorized); err != nil {
+			return nil, err
+		}
+		return categorized.StructuredMetadata, nil
+	}
+	metadata := map[string]string{}
+	if err := json.Unmarshal(b, &metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func (e Entry) MarshalJSON() ([]byte, error) {
+	values := []any{strconv.FormatInt(e.Timestamp.UnixNano(), 10), e.Line}
+	if e.Metadata != nil {
+		values = append(values, e.Metadata)
+	}
+	return json.Marshal(values)
+}
+
 type Stream struct {
-	Stream  map[string]string `json:"stream"`
-	Entries []Entry           `json:"values"`
+	Stream    map[string]string `json:"stream"`
+	Entries   []Entry           `json:"values"`
+	Malformed []error           `json:"-"` // why the entries that could not be decoded were skipped
+}
+
+// UnmarshalJSON skips the values that are not valid entries, instead of failing the whole
+// response: the stream itself, and its list of values, must still be valid.
+func (s *Stream) UnmarshalJSON(b []byte) error {
+	var stream struct {
+		Stream map[string]string `json:"stream"`
+		Values []json.RawMessage `json:"values"`
+	}
+	if err := json.Unmarshal(b, &stream); err != nil {
+		return err
+	}
+	s.Stream = stream.Stream
+	s.Entries = make([]Entry, 0, len(stream.Values))
+	s.Malformed = nil
+	for _, value := range stream.Values {
+		entry := Entry{}
+		if err := json.Unmarshal(value, &entry); err != nil {
+			s.Malformed = append(s.Malformed, fmt.Errorf("%s: %w", value, err))
+			continue
+		}
+		s.Entries = append(s.Entries, entry)
+	}
+	return nil
 }
 
 type DroppedEntry struct {
@@ -53,3 +132,27 @@ type Data struct {
 	Result     []Stream    `json:"result"` // Warning, just stream value is handled
 	Stats      interface{} `json:"stats"`  // Stats is boring, just ignore it
 }
+
+// ResultTypeStreams is the result type of the log queries, the metric queries return a matrix
+// or a vector.
+const ResultTypeStreams = "streams"
+
+// UnmarshalJSON decodes the result of the log queries only: the result of the other types is
+// dropped, it has a different shape.
+func (d *Data) UnmarshalJSON(b []byte) error {
+	var data struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+		Stats      interface{}     `json:"stats"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	d.ResultType = data.ResultType
+	d.Stats = data.Stats
+	d.Result = nil
+	if (d.ResultType != "" && d.ResultType != ResultTypeStreams) || len(data.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data.Result, &d.Result)
+}
@@ -0,0 +1,119 @@
+package lokiclient
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+)
+
+func TestEntryUnmarshal(t *testing.T) {
+	ts := time.Unix(0, 1700000000123456789)
+
+	tests := []struct {
+		name        string
+		json        string
+		expected    Entry
+		expectedErr string
+	}{
+		{
+			name:     "timestamp and line",
+			json:     `["1700000000123456789","hello"]`,
+			expected: Entry{Timestamp: ts, Line: "hello"},
+		},
+		{
+			name:     "structured metadata",
+			json:     `["1700000000123456789","hello",{"trace_id":"abc"}]`,
+			expected: Entry{Timestamp: ts, Line: "hello", Metadata: map[string]string{"trace_id": "abc"}},
+		},
+		{
+			name:     "categorized labels",
+			json:     `["1700000000123456789","hello",{"structuredMetadata":{"trace_id":"abc"},"parsed":{"level":"info"}}]`,
+			expected: Entry{Timestamp: ts, Line: "hello", Metadata: map[string]string{"trace_id": "abc"}},
+		},
+		{
+			name:        "missing line",
+			json:        `["1700000000123456789"]`,
+			expectedErr: "expected [timestamp, line] or [timestamp, line, metadata], got 1 elements",
+		},
+		{
+			name:        "bad timestamp",
+			json:        `["1.7e18","hello"]`,
+			expectedErr: "invalid timestamp '1.7e18'",
+		},
+		{
+			name:        "bad metadata",
+			json:        `["1700000000123456789","hello",["abc"]]`,
+			expectedErr: "invalid structured metadata",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			entry := Entry{}
+			err := json.Unmarshal([]byte(tc.json), &entry)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+
+			if tc.expectedErr != "" {
+				return
+			}
+
+			assert.True(t, tc.expected.Timestamp.Equal(entry.Timestamp))
+			assert.Equal(t, tc.expected.Line, entry.Line)
+			assert.Equal(t, tc.expected.Metadata, entry.Metadata)
+		})
+	}
+}
+
+func TestEntryMarshal(t *testing.T) {
+	entries := []Entry{
+		{Timestamp: time.Unix(0, 1700000000123456789), Line: "hello"},
+		{Timestamp: time.Unix(0, 1700000000123456789), Line: "hello", Metadata: map[string]string{"trace_id": "abc"}},
+	}
+
+	b, err := json.Marshal(entries)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[["1700000000123456789","hello"],["1700000000123456789","hello",{"trace_id":"abc"}]]`, string(b))
+
+	// a tail frame
+	frame := LokiResponse{}
+	require.NoError(t, json.Unmarshal([]byte(`{"streams":[{"stream":{"job":"a"},"values":`+string(b)+`}]}`), &frame))
+	require.Len(t, frame.Streams, 1)
+	assert.Equal(t, map[string]string{"trace_id": "abc"}, frame.Streams[0].Entries[1].Metadata)
+}
+
+func TestDataUnmarshal(t *testing.T) {
+	data := Data{}
+	err := json.Unmarshal([]byte(`{"resultType":"streams","result":[{"stream":{"job":"a"},"values":[["1700000000123456789","hello"]]}]}`), &data)
+	require.NoError(t, err)
+	require.Len(t, data.Result, 1)
+	assert.Equal(t, "hello", data.Result[0].Entries[0].Line)
+
+	// the samples of a metric query are not entries
+	err = json.Unmarshal([]byte(`{"resultType":"matrix","result":[{"metric":{"job":"a"},"values":[[1700000000.123,"3"]]}]}`), &data)
+	require.NoError(t, err)
+	assert.Equal(t, "matrix", data.ResultType)
+	assert.Empty(t, data.Result)
+}
+
+func TestStreamUnmarshal(t *testing.T) {
+	stream := Stream{}
+	err := json.Unmarshal([]byte(`{"stream":{"job":"a"},"values":[["1700000000123456789","hello"],["1700000000123456789"],{"ts":"1"},["1700000000123456790","world",{"trace_id":"abc"}]]}`), &stream)
+	require.NoError(t, err)
+	require.Len(t, stream.Entries, 2)
+	assert.Equal(t, "hello", stream.Entries[0].Line)
+	assert.Equal(t, "world", stream.Entries[1].Line)
+	require.Len(t, stream.Malformed, 2)
+	cstest.RequireErrorContains(t, stream.Malformed[0], `["1700000000123456789"]: expected [timestamp, line]`)
+
+	// the envelope is still strict
+	err = json.Unmarshal([]byte(`{"stream":{"job":"a"},"values":{"ts":"1"}}`), &stream)
+	require.Error(t, err)
+
+	err = json.Unmarshal([]byte(`{"stream":{"job":"a"},"values":[["1700000000123456789","hello"]`), &stream)
+	require.Error(t, err)
+}
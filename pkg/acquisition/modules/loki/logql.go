@@ -0,0 +1,216 @@
+package loki
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func checkQueries(queries []string) error {
+	for _, query := range queries {
+		if err := checkLogQL(query); err != nil {
+			return fmt.Errorf("invalid query '%s': %w", strings.TrimRight(query, " \t\r\n"), err)
+		}
+	}
+
+	return nil
+}
+
+// checkLogQL is a lightweight syntax check of a query: the brackets and the strings are closed,
+// there is a stream selector and its matchers are valid, and it is not a metric query. It reports the obvious mistakes when
+// the source is configured, with their position; loki still validates the query when it runs it.
+func checkLogQL(query string) error {
+	var (
+		open      []int // positions of the unclosed brackets
+		selectors int
+	)
+
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; c {
+		case '"', '`':
+			end, err := skipString(query, i)
+			if err != nil {
+				return err
+			}
+
+			i = end
+		case '#':
+			// a comment, up to the end of the line
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case '{':
+			end, err := checkSelector(query, i)
+			if err != nil {
+				return err
+			}
+
+			selectors++
+			i = end
+		case '(', '[':
+			open = append(open, i)
+		case ')', ']':
+			opening := byte('(')
+			if c == ']' {
+				opening = '['
+			}
+
+			if len(open) == 0 || query[open[len(open)-1]] != opening {
+				return logqlError(i, "unexpected '%c'", c)
+			}
+
+			open = open[:len(open)-1]
+		case '}':
+			return logqlError(i, "unexpected '}'")
+		case '!':
+			if i+1 >= len(query) || (query[i+1] != '=' && query[i+1] != '~') {
+				return logqlError(i, "invalid operator '!', expected != or !~")
+			}
+
+			i++
+		case '~':
+			// the operators !~ are skipped above
+			if i == 0 || (query[i-1] != '|' && query[i-1] != '=') {
+				return logqlError(i, "invalid operator '~', expected |~, =~ or !~")
+			}
+		}
+	}
+
+	if len(open) > 0 {
+		pos := open[len(open)-1]
+		return logqlError(pos, "unclosed '%c'", query[pos])
+	}
+
+	if selectors == 0 {
+		return errors.New(`no stream selector, such as {job="varlogs"}`)
+	}
+
+	// the metric queries start with a function or an aggregation, and return samples instead of
+	// log lines
+	if query[skipComments(query, 0)] != '{' {
+		return errors.New("metric queries are not supported, only log queries starting with a stream selector can be acquired")
+	}
+
+	return nil
+}
+
+// checkSelector checks the matchers of the stream selector opened at start, and returns the
+// position of its closing brace.
+func checkSelector(query string, start int) (int, error) {
+	i := skipSpaces(query, start+1)
+
+	for matchers := 0; ; matchers++ {
+		if i >= len(query) {
+			return 0, logqlError(start, "unclosed '{'")
+		}
+
+		if query[i] == '}' {
+			if matchers == 0 {
+				return 0, logqlError(start, "empty stream selector")
+			}
+
+			return i, nil
+		}
+
+		end := i
+		for end < len(query) && isLabelChar(query[end], end == i) {
+			end++
+		}
+
+		if end == i {
+			return 0, logqlError(i, "expected a label name")
+		}
+
+		i = skipSpaces(query, end)
+
+		op := ""
+
+		for _, candidate := range []string{"!=", "=~", "!~", "="} {
+			if strings.HasPrefix(query[i:], candidate) {
+				op = candidate
+				break
+			}
+		}
+
+		if op == "" || strings.HasPrefix(query[i:], "==") {
+			return 0, logqlError(i, "expected one of =, !=, =~ or !~")
+		}
+
+		i = skipSpaces(query, i+len(op))
+
+		if i >= len(query) || (query[i] != '"' && query[i] != '`') {
+			return 0, logqlError(i, "expected a quoted value")
+		}
+
+		end, err := skipString(query, i)
+		if err != nil {
+			return 0, err
+		}
+
+		i = skipSpaces(query, end+1)
+
+		if i < len(query) && query[i] == ',' {
+			i = skipSpaces(query, i+1)
+			continue
+		}
+
+		if i < len(query) && query[i] != '}' {
+			return 0, logqlError(i, "expected ',' or '}'")
+		}
+	}
+}
+
+// skipString returns the position of the quote closing the string opened at start. The double
+// quoted strings can contain escaped quotes, the backquoted ones are raw.
+func skipString(query string, start int) (int, error) {
+	quote := query[start]
+
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case quote:
+			return i, nil
+		}
+	}
+
+	return 0, logqlError(start, "unterminated string")
+}
+
+// skipComments returns the position of the first character that is neither a space nor in a
+// comment.
+func skipComments(query string, i int) int {
+	for i = skipSpaces(query, i); i < len(query) && query[i] == '#'; i = skipSpaces(query, i) {
+		for i < len(query) && query[i] != '\n' {
+			i++
+		}
+	}
+
+	return i
+}
+
+func skipSpaces(query string, i int) int {
+	for i < len(query) && strings.IndexByte(" \t\r\n", query[i]) >= 0 {
+		i++
+	}
+
+	return i
+}
+
+func isLabelChar(c byte, first bool) bool {
+	switch {
+	case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		return true
+	case '0' <= c && c <= '9':
+		return !first
+	}
+
+	return false
+}
+
+// logqlError reports an error at a byte offset of the query, counted from 1.
+func logqlError(pos int, format string, args ...any) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, args...), pos+1)
+}
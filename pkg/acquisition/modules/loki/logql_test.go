@@ -0,0 +1,45 @@
+package loki
+
+import (
+	"testing"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+)
+
+func TestCheckLogQL(t *testing.T) {
+	tests := []struct {
+		query       string
+		expectedErr string
+	}{
+		{query: `{job="varlogs"}`},
+		{query: `{job="varlogs", host=~"web-.*", level!="debug",env!~"dev|test"}`},
+		{query: "{job=`varlogs`} |= `error` != \"timeout\" |~ \"\\\"quoted\\\"\" !~ `retry`"},
+		{query: `{job="varlogs"} | json | level="error" | line_format "{{.msg}}"`},
+		{query: "# failed logins\n{job=\"varlogs\"} |= \"failed\""},
+		{query: `sum by (host) (count_over_time({job="varlogs"} |= "failed" [5m])) > 10`, expectedErr: "metric queries are not supported"},
+		{query: `rate({job="varlogs"}[5m])`, expectedErr: "metric queries are not supported"},
+		{query: "{job=\"varlogs\"} # the {comment} is ignored\n| logfmt"},
+		{query: `{job="varlogs"}` + "\n"},
+		{query: `{job="varlogs"`, expectedErr: "unclosed '{' at position 1"},
+		{query: `job="varlogs"`, expectedErr: "no stream selector"},
+		{query: `{}`, expectedErr: "empty stream selector at position 1"},
+		{query: `{job="varlogs"}}`, expectedErr: "unexpected '}' at position 16"},
+		{query: `{job=="varlogs"}`, expectedErr: "expected one of =, !=, =~ or !~ at position 5"},
+		{query: `{job~"varlogs"}`, expectedErr: "expected one of =, !=, =~ or !~ at position 5"},
+		{query: `{job=varlogs}`, expectedErr: "expected a quoted value at position 6"},
+		{query: `{job="varlogs" host="a"}`, expectedErr: "expected ',' or '}' at position 16"},
+		{query: `{1job="varlogs"}`, expectedErr: "expected a label name at position 2"},
+		{query: `{job="varlogs}`, expectedErr: "unterminated string at position 6"},
+		{query: `{job="varlogs"} |= "error`, expectedErr: "unterminated string at position 20"},
+		{query: `{job="varlogs"} ! "error"`, expectedErr: "invalid operator '!', expected != or !~ at position 17"},
+		{query: `{job="varlogs"} ~ "error"`, expectedErr: "invalid operator '~', expected |~, =~ or !~ at position 17"},
+		{query: `rate({job="varlogs"}[5m]`, expectedErr: "unclosed '(' at position 5"},
+		{query: `rate({job="varlogs"}[5m)`, expectedErr: "unexpected ')' at position 24"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			cstest.RequireErrorContains(t, checkLogQL(tc.query), tc.expectedErr)
+		})
+	}
+}
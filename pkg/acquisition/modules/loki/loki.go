@@ -6,11 +6,21 @@ https://grafana.com/docs/loki/latest/api/#get-lokiapiv1tail
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"net"
 	"net/url"
+	"os"
+	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	yaml "github.com/goccy/go-yaml"
@@ -19,7 +29,10 @@ import (
 	tomb "gopkg.in/tomb.v2"
 
 	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/jsonpath"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/sourceaddr"
 	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/loki/internal/lokiclient"
+	"github.com/crowdsecurity/crowdsec/pkg/time/rate"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
 
@@ -28,6 +41,11 @@ const (
 	readyLoop    int           = 3
 	readySleep   time.Duration = 10 * time.Second
 	lokiLimit    int           = 100
+	authTimeout  time.Duration = 10 * time.Second
+	maxDelayFor  time.Duration = time.Minute
+	maxCatchup   time.Duration = time.Hour
+	labelPrefix  string        = "loki_"
+	startFromNow string        = "now"
 )
 
 var linesRead = prometheus.NewCounterVec(
@@ -35,44 +53,348 @@ var linesRead = prometheus.NewCounterVec(
 		Name: "cs_lokisource_hits_total",
 		Help: "Total lines that were read.",
 	},
+	[]string{"source", "tenant"})
+
+var bytesRead = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_lokisource_read_bytes_total",
+		Help: "Total bytes of the lines that were read.",
+	},
+	[]string{"source", "tenant"})
+
+var droppedEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_lokisource_dropped_events_total",
+		Help: "Total events dropped because the buffer was full.",
+	},
+	[]string{"source", "tenant"})
+
+var skippedLines = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_lokisource_skipped_lines_total",
+		Help: "Total empty lines that were not sent to the parsers, with skip_empty_lines.",
+	},
+	[]string{"source", "tenant"})
+
+var throttledLines = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_lokisource_throttled_lines_total",
+		Help: "Total lines delayed to stay under max_lines_per_second.",
+	},
+	[]string{"source", "tenant"})
+
+var throttleDroppedLines = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_lokisource_throttle_dropped_lines_total",
+		Help: "Total lines dropped to stay under max_lines_per_second, with drop_on_throttle.",
+	},
+	[]string{"source", "tenant"})
+
+var backfillWait = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_lokisource_backfill_wait_seconds_total",
+		Help: "Total time the one-shot acquisition waited to stay under backfill_rate.",
+	},
 	[]string{"source"})
 
 type LokiAuthConfiguration struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Username        string                   `yaml:"username"`
+	Password        string                   `yaml:"password"`
+	PasswordFile    string                   `yaml:"password_file"` // read again for each request, to follow the rotations of the password
+	BearerToken     string                   `yaml:"bearer_token"`
+	BearerTokenFile string                   `yaml:"bearer_token_file"` // read again for each request, to follow the rotations of the token
+	OAuth2          *LokiOAuth2Configuration `yaml:"oauth2"`
+}
+
+// LokiOAuth2Configuration gets the bearer token with the OAuth2 client credentials grant.
+type LokiOAuth2Configuration struct {
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	TokenURL     string   `yaml:"token_url"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+func (o *LokiOAuth2Configuration) validate() error {
+	if o.ClientID == "" || o.ClientSecret == "" {
+		return errors.New("auth: oauth2 client_id and client_secret are mandatory")
+	}
+
+	u, err := url.Parse(o.TokenURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("auth: invalid oauth2 token_url '%s'", o.TokenURL)
+	}
+
+	return nil
+}
+
+func (o *LokiOAuth2Configuration) clientConfig() *lokiclient.OAuth2Config {
+	if o == nil {
+		return nil
+	}
+
+	return &lokiclient.OAuth2Config{
+		ClientID:     o.ClientID,
+		ClientSecret: o.ClientSecret,
+		TokenURL:     o.TokenURL,
+		Scopes:       o.Scopes,
+	}
+}
+
+func (a *LokiAuthConfiguration) validate() error {
+	bearer := a.BearerToken != "" || a.BearerTokenFile != ""
+	basic := a.Username != "" || a.Password != "" || a.PasswordFile != ""
+
+	if bearer && basic {
+		return errors.New("auth: username/password and bearer token cannot be used together")
+	}
+
+	if a.BearerToken != "" && a.BearerTokenFile != "" {
+		return errors.New("auth: bearer_token and bearer_token_file are mutually exclusive")
+	}
+
+	if a.Password != "" && a.PasswordFile != "" {
+		return errors.New("auth: password and password_file are mutually exclusive")
+	}
+
+	if a.OAuth2 != nil {
+		if bearer || basic {
+			return errors.New("auth: oauth2 cannot be used with username/password or a bearer token")
+		}
+
+		if err := a.OAuth2.validate(); err != nil {
+			return err
+		}
+	}
+
+	if a.BearerTokenFile != "" {
+		if _, err := lokiclient.ReadBearerToken(a.BearerTokenFile); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if a.PasswordFile != "" {
+		if _, err := lokiclient.ReadPasswordFile(a.PasswordFile); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	return nil
 }
 
 type LokiConfiguration struct {
-	URL                               string                `yaml:"url"`    // Loki url
-	Prefix                            string                `yaml:"prefix"` // Loki prefix
-	Query                             string                `yaml:"query"`  // LogQL query
-	Limit                             int                   `yaml:"limit"`  // Limit of logs to read
-	DelayFor                          time.Duration         `yaml:"delay_for"`
-	Since                             time.Duration         `yaml:"since"`
+	URL                               URLList               `yaml:"url"`            // Loki url, or a list of them tried in order when the previous ones cannot be reached
+	Prefix                            string                `yaml:"prefix"`         // Loki prefix
+	Query                             QueryList             `yaml:"query"`          // LogQL query, or a list of them
+	Limit                             int                   `yaml:"limit"`          // Limit of logs to read
+	DelayFor                          time.Duration         `yaml:"delay_for"`      // tail mode: wait for the late entries before reading, 0 for no delay
+	Since                             lokiclient.TimeBound  `yaml:"since"`          // cat mode only: a duration before now or an RFC 3339 timestamp
+	Until                             lokiclient.TimeBound  `yaml:"until"`          // cat mode only: stop there, same format as since
+	Direction                         string                `yaml:"direction"`      // cat mode only: forward reads from since to until, backward from until to since; default is forward
 	Headers                           map[string]string     `yaml:"headers"`        // HTTP headers for talking to Loki
-	WaitForReady                      time.Duration         `yaml:"wait_for_ready"` // Retry interval, default is 10 seconds
+	Tenants                           []string              `yaml:"tenants"`        // Org ids to read from, each query is run for each of them
+	WaitForReady                      time.Duration         `yaml:"wait_for_ready"` // Max total duration of the ready checks, default is 10 seconds
 	Auth                              LokiAuthConfiguration `yaml:"auth"`
-	MaxFailureDuration                time.Duration         `yaml:"max_failure_duration"` // Max duration of failure before stopping the source
-	NoReadyCheck                      bool                  `yaml:"no_ready_check"`       // Bypass /ready check before starting
+	TLS                               *LokiTLSConfiguration `yaml:"tls"`
+	MaxFailureDuration                time.Duration         `yaml:"max_failure_duration"`   // Max duration of failure before stopping the source
+	ReconnectGracePeriod              time.Duration         `yaml:"reconnect_grace_period"` // Failures shorter than this are not reported
+	MaxBackoff                        time.Duration         `yaml:"max_backoff"`            // Max delay between the retries after a failure, default is 30 seconds
+	MaxFailedAttempts                 int                   `yaml:"max_failed_attempts"`    // Failed requests in a row before stopping the source, 0 for no limit
+	MaxRetries                        int                   `yaml:"max_retries"`            // Requests in a row throttled by loki (429 or 503) before stopping the source, default is 5
+	NoReadyCheck                      bool                  `yaml:"no_ready_check"`         // Bypass /ready check before starting
+	ReadyPath                         string                `yaml:"ready_path"`             // Path of the ready check, default is /ready
+	ReadyExpectBody                   string                `yaml:"ready_expect_body"`      // The ready check only passes if the body contains this
+	ReadyTimeout                      time.Duration         `yaml:"ready_timeout"`          // Timeout of each ready check, default is the timeout of the requests
+	ReadyPollInterval                 time.Duration         `yaml:"ready_poll_interval"`    // Delay between the ready checks, with ±20% of jitter, default is 500 milliseconds
+	OrgIDMode                         string                `yaml:"orgid_mode"`             // How to handle the X-Scope-OrgID header: auto, required or omit
+	SourceAddress                     string                `yaml:"source_address"`         // Local IP of the connections to loki
+	ProxyURL                          string                `yaml:"proxy_url"`              // HTTP or SOCKS5 proxy to reach loki, default is from the environment
+	CheckpointPath                    string                `yaml:"checkpoint_path"`        // tail mode only: file to resume from the last entry read after a restart
+	StartFrom                         string                `yaml:"start_from"`             // tail mode only: now to skip the entries older than the start, which are read within delay_for by default
+	MaxCatchup                        time.Duration         `yaml:"max_catchup"`            // tail mode only: max age of the entries read when resuming, default is 1 hour
+	BackfillRate                      float64               `yaml:"backfill_rate"`          // cat mode only: max events per second, 0 for no limit
+	MaxLinesPerSecond                 float64               `yaml:"max_lines_per_second"`   // Max lines sent to the parsers per second, the reads wait above it; 0 for no limit
+	DropOnThrottle                    bool                  `yaml:"drop_on_throttle"`       // Drop the lines above max_lines_per_second instead of waiting
+	PageDelay                         time.Duration         `yaml:"page_delay"`             // cat mode only: min delay between the requests of two pages, 0 for no delay
+	BufferSize                        int                   `yaml:"buffer_size"`            // tail mode only: events kept while the output is stalled, dropped once full; 0 to wait for the output
+	Compression                       *bool                 `yaml:"compression"`            // Ask loki for compressed responses, default is true
+	Timeout                           time.Duration         `yaml:"timeout"`                // Timeout of the HTTP requests to loki, not of the websocket, default is 30 seconds
+	FailbackInterval                  time.Duration         `yaml:"failback_interval"`      // Delay before trying the first url again after a failover, default is 5 minutes
+	LabelPrefix                       string                `yaml:"label_prefix"`           // Prefix of the stream labels in the event metadata, default is loki_
+	JSONDecode                        bool                  `yaml:"json_decode"`            // Decode the JSON lines in evt.Unmarshaled, the raw line is kept
+	LineTransform                     *LokiLineTransform    `yaml:"line_transform"`         // Regexp replacement applied to the lines before the parsers
+	SkipEmptyLines                    bool                  `yaml:"skip_empty_lines"`       // Don't send the lines that are empty or only spaces, tabs and newlines, after line_transform
+	UseTimestamp                      bool                  `yaml:"use_timestamp"`          // Use the timestamp of the entries as the time of the events
+	UserAgent                         string                `yaml:"user_agent"`             // User-Agent of the requests, default is crowdsec-loki/<version>
+	jsonpath.Config                   `yaml:",inline"`
 	configuration.DataSourceCommonCfg `yaml:",inline"`
 }
 
+// QueryList is a LogQL query, or a list of queries read by the same source.
+type QueryList []string
+
+func (q *QueryList) UnmarshalYAML(unmarshal func(any) error) error {
+	queries, err := unmarshalStringOrList(unmarshal)
+	if err != nil {
+		return err
+	}
+
+	*q = queries
+
+	return nil
+}
+
+// URLList is the url of loki, or a list of them: the first one is the primary, the others are
+// the failover ones.
+type URLList []string
+
+func (u *URLList) UnmarshalYAML(unmarshal func(any) error) error {
+	urls, err := unmarshalStringOrList(unmarshal)
+	if err != nil {
+		return err
+	}
+
+	*u = urls
+
+	return nil
+}
+
+// Primary returns the first url, or an empty string.
+func (u URLList) Primary() string {
+	if len(u) == 0 {
+		return ""
+	}
+
+	return u[0]
+}
+
+// Failover returns the urls after the primary.
+func (u URLList) Failover() []string {
+	if len(u) < 2 {
+		return nil
+	}
+
+	return u[1:]
+}
+
+func unmarshalStringOrList(unmarshal func(any) error) ([]string, error) {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		return []string{s}, nil
+	}
+
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+type LokiTLSConfiguration struct {
+	CertFile           string `yaml:"cert_file"` // client certificate, for mutual TLS
+	KeyFile            string `yaml:"key_file"`
+	CaCertFile         string `yaml:"ca_cert_file"`         // added to the system CAs to verify loki
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // don't verify the certificate of loki, for self-signed ones
+}
+
+// newTLSConfig loads the certificates. They are read each time the datasource is configured, so
+// a restart picks up the renewed files.
+func (c *LokiTLSConfiguration) newTLSConfig() (*tls.Config, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("tls: cert_file and key_file must be set together")
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: while loading client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CaCertFile != "" {
+		caCert, err := os.ReadFile(c.CaCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: while reading CA certificate: %w", err)
+		}
+
+		caCertPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("tls: unable to load system CA certificates: %w", err)
+		}
+
+		if caCertPool == nil {
+			caCertPool = x509.NewCertPool()
+		}
+
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("tls: no valid certificate found in %s", c.CaCertFile)
+		}
+
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	return tlsConfig, nil
+}
+
 type LokiSource struct {
 	metricsLevel int
 	Config       LokiConfiguration
 
-	Client *lokiclient.LokiClient
+	Client  *lokiclient.LokiClient   // the client of the first query
+	clients []*lokiclient.LokiClient // a client for each query
 
 	logger        *log.Entry
 	lokiWebsocket string
+	jsonExtractor *jsonpath.Extractor
+	lineTransform *lineTransform // nil unless line_transform is set
+	localAddr     *net.TCPAddr
+	tlsConfig     *tls.Config
+	proxyURL      *url.URL
+	checkpoint    *checkpoint // nil unless checkpoint_path is set
+
+	backfillLimiter *rate.Limiter // nil unless backfill_rate is set
+	lineLimiter     *rate.Limiter // nil unless max_lines_per_second is set
+
+	buffer   chan types.Event // nil unless buffer_size is set
+	dropping atomic.Bool      // the buffer is full, the events are dropped
+
+	reloadMu sync.Mutex
+}
+
+// Degraded reports whether loki has been unreachable for longer than reconnect_grace_period.
+func (l *LokiSource) Degraded() (bool, string) {
+	for _, client := range l.clients {
+		if degraded, reason := client.Degraded(); degraded {
+			return degraded, reason
+		}
+	}
+
+	return false, ""
 }
 
 func (l *LokiSource) GetMetrics() []prometheus.Collector {
-	return []prometheus.Collector{linesRead}
+	return []prometheus.Collector{
+		linesRead, bytesRead, droppedEvents, skippedLines, throttledLines, throttleDroppedLines, backfillWait, jsonpath.MissingFields,
+		lokiclient.QueryDuration, lokiclient.ParseErrors, lokiclient.MalformedEntries, lokiclient.Reconnects,
+	}
 }
 
 func (l *LokiSource) GetAggregMetrics() []prometheus.Collector {
-	return []prometheus.Collector{linesRead}
+	return []prometheus.Collector{
+		linesRead, bytesRead, droppedEvents, skippedLines, throttledLines, throttleDroppedLines, backfillWait, jsonpath.MissingFields,
+		lokiclient.QueryDuration, lokiclient.ParseErrors, lokiclient.MalformedEntries, lokiclient.Reconnects,
+	}
 }
 
 func (l *LokiSource) UnmarshalConfig(yamlConfig []byte) error {
@@ -81,16 +403,32 @@ func (l *LokiSource) UnmarshalConfig(yamlConfig []byte) error {
 		return fmt.Errorf("cannot parse loki acquisition configuration: %s", yaml.FormatError(err, false, false))
 	}
 
-	if l.Config.Query == "" {
+	if len(l.Config.Query) == 0 || slices.Contains(l.Config.Query, "") {
 		return errors.New("loki query is mandatory")
 	}
 
+	if err := checkQueries(l.Config.Query); err != nil {
+		return err
+	}
+
 	if l.Config.WaitForReady == 0 {
 		l.Config.WaitForReady = 10 * time.Second
 	}
 
-	if l.Config.DelayFor < 0*time.Second || l.Config.DelayFor > 5*time.Second {
-		return errors.New("delay_for should be a value between 1s and 5s")
+	if l.Config.ReadyPath == "" {
+		l.Config.ReadyPath = lokiclient.DefaultReadyPath
+	}
+
+	if !strings.HasPrefix(l.Config.ReadyPath, "/") {
+		return errors.New("ready_path must start with /")
+	}
+
+	if err := l.validateReadyCheck(); err != nil {
+		return err
+	}
+
+	if err := validateDelayFor(l.Config.DelayFor); err != nil {
+		return err
 	}
 
 	if l.Config.Mode == "" {
@@ -104,160 +442,873 @@ func (l *LokiSource) UnmarshalConfig(yamlConfig []byte) error {
 		l.Config.Prefix += "/"
 	}
 
-	if l.Config.Limit == 0 {
-		l.Config.Limit = lokiLimit
-	}
+	if l.Config.Limit < 0 {
+		return errors.New("limit must be positive")
+	}
+
+	if l.Config.Limit == 0 {
+		l.Config.Limit = lokiLimit
+	}
+
+	if l.Config.LabelPrefix == "" {
+		l.Config.LabelPrefix = labelPrefix
+	}
+
+	if l.Config.Mode == configuration.TAIL_MODE {
+		l.logger.Infof("Resetting since")
+		l.Config.Since = lokiclient.TimeBound{}
+	}
+
+	if err := l.validateUntil(); err != nil {
+		return err
+	}
+
+	if err := l.setDirection(); err != nil {
+		return err
+	}
+
+	if err := l.validatePageDelay(); err != nil {
+		return err
+	}
+
+	if l.Config.MaxFailureDuration == 0 {
+		l.Config.MaxFailureDuration = 30 * time.Second
+	}
+
+	if l.Config.ReconnectGracePeriod < 0 {
+		return errors.New("reconnect_grace_period must be positive")
+	}
+
+	if l.Config.MaxBackoff < 0 {
+		return errors.New("max_backoff must be positive")
+	}
+
+	if l.Config.MaxBackoff == 0 {
+		l.Config.MaxBackoff = lokiclient.DefaultMaxBackoff
+	}
+
+	if l.Config.MaxFailedAttempts < 0 {
+		return errors.New("max_failed_attempts must be positive")
+	}
+
+	if err := l.setMaxRetries(); err != nil {
+		return err
+	}
+
+	if err := l.setTimeout(); err != nil {
+		return err
+	}
+
+	if len(l.Config.URL) > 1 && slices.Contains(l.Config.URL, "") {
+		return errors.New("url: empty url in the list")
+	}
+
+	if l.Config.FailbackInterval < 0 {
+		return errors.New("failback_interval must be positive")
+	}
+
+	if l.Config.BufferSize < 0 {
+		return errors.New("buffer_size must be positive")
+	}
+
+	if l.Config.BufferSize > 0 && l.Config.Mode != configuration.TAIL_MODE {
+		return errors.New("buffer_size is only supported in tail mode")
+	}
+
+	if err := l.validateTenants(); err != nil {
+		return err
+	}
+
+	if err := validateOrgIDMode(l.Config.OrgIDMode, l.Config.Headers, l.Config.Tenants); err != nil {
+		return err
+	}
+
+	if err := l.Config.Auth.validate(); err != nil {
+		return err
+	}
+
+	if err := l.setBackfillRate(); err != nil {
+		return err
+	}
+
+	if err := l.setMaxLinesPerSecond(); err != nil {
+		return err
+	}
+
+	l.jsonExtractor, err = jsonpath.NewExtractor(l.Config.Config, l.GetName())
+	if err != nil {
+		return err
+	}
+
+	l.lineTransform, err = l.Config.LineTransform.compile()
+	if err != nil {
+		return err
+	}
+
+	l.localAddr, err = sourceaddr.Resolve(l.Config.SourceAddress)
+	if err != nil {
+		return err
+	}
+
+	l.tlsConfig, err = l.Config.TLS.newTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	if l.tlsConfig != nil && l.tlsConfig.InsecureSkipVerify {
+		l.logger.Warn("tls: the certificate of loki is not verified")
+	}
+
+	l.proxyURL, err = parseProxyURL(l.Config.ProxyURL)
+	if err != nil {
+		return err
+	}
+
+	if err := l.setCheckpoint(); err != nil {
+		return err
+	}
+
+	if err := l.validateStartFrom(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (l *LokiSource) validateStartFrom() error {
+	switch l.Config.StartFrom {
+	case "":
+	case startFromNow:
+		if l.Config.Mode != configuration.TAIL_MODE {
+			return errors.New("start_from is only supported in tail mode")
+		}
+
+		if l.Config.CheckpointPath != "" {
+			return errors.New("start_from: now and checkpoint_path are mutually exclusive")
+		}
+	default:
+		return fmt.Errorf("invalid start_from '%s': must be now", l.Config.StartFrom)
+	}
+
+	return nil
+}
+
+func (l *LokiSource) setCheckpoint() error {
+	if l.Config.MaxCatchup < 0 {
+		return errors.New("max_catchup must be positive")
+	}
+
+	if l.Config.CheckpointPath == "" {
+		return nil
+	}
+
+	if l.Config.Mode != configuration.TAIL_MODE {
+		return errors.New("checkpoint_path is only supported in tail mode")
+	}
+
+	if l.Config.MaxCatchup == 0 {
+		l.Config.MaxCatchup = maxCatchup
+	}
+
+	l.checkpoint = newCheckpoint(l.Config.CheckpointPath, l.logger)
+
+	return nil
+}
+
+// parseProxyURL checks the proxy_url option, it returns nil if it is not set.
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	if proxyURL == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy_url '%s': the scheme must be http, https, socks5 or socks5h", proxyURL)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy_url '%s': missing host", proxyURL)
+	}
+
+	return u, nil
+}
+
+// setBackfillRate creates the limiter pacing the one-shot acquisition. The burst allows a tenth
+// of a second worth of events, to not wait between each of them at high rates.
+func (l *LokiSource) setBackfillRate() error {
+	if l.Config.BackfillRate < 0 {
+		return errors.New("backfill_rate must be positive")
+	}
+
+	if l.Config.BackfillRate == 0 {
+		l.backfillLimiter = nil
+		return nil
+	}
+
+	if l.Config.Mode != configuration.CAT_MODE {
+		return errors.New("backfill_rate is only supported in cat mode")
+	}
+
+	l.backfillLimiter = rate.NewLimiter(rate.Limit(l.Config.BackfillRate), max(int(l.Config.BackfillRate/10), 1))
+
+	return nil
+}
+
+func (l *LokiSource) setMaxLinesPerSecond() error {
+	if l.Config.MaxLinesPerSecond < 0 {
+		return errors.New("max_lines_per_second must be positive")
+	}
+
+	if l.Config.MaxLinesPerSecond == 0 {
+		if l.Config.DropOnThrottle {
+			return errors.New("drop_on_throttle requires max_lines_per_second")
+		}
+
+		l.lineLimiter = nil
+
+		return nil
+	}
+
+	if l.Config.BackfillRate > 0 {
+		return errors.New("backfill_rate and max_lines_per_second are mutually exclusive")
+	}
+
+	l.lineLimiter = rate.NewLimiter(rate.Limit(l.Config.MaxLinesPerSecond), max(int(l.Config.MaxLinesPerSecond/10), 1))
+
+	return nil
+}
+
+func (l *LokiSource) validateUntil() error {
+	if l.Config.Until.IsZero() {
+		return nil
+	}
+
+	if l.Config.Until.Ago < 0 {
+		return errors.New("until must be positive")
+	}
+
+	if l.Config.Mode != configuration.CAT_MODE {
+		return errors.New("until is only supported in cat mode")
+	}
+
+	now := time.Now()
+	if !l.Config.Until.Time(now).After(l.Config.Since.Time(now)) {
+		return fmt.Errorf("until (%s) must be more recent than since (%s)", l.Config.Until, l.Config.Since)
+	}
+
+	return nil
+}
+
+func (l *LokiSource) validatePageDelay() error {
+	if l.Config.PageDelay < 0 {
+		return errors.New("page_delay must be positive")
+	}
+
+	if l.Config.PageDelay > 0 && l.Config.Mode != configuration.CAT_MODE {
+		return errors.New("page_delay is only supported in cat mode")
+	}
+
+	return nil
+}
+
+func (l *LokiSource) validateReadyCheck() error {
+	if l.Config.ReadyTimeout < 0 {
+		return errors.New("ready_timeout must be positive")
+	}
+
+	if l.Config.ReadyPollInterval < 0 {
+		return errors.New("ready_poll_interval must be positive")
+	}
+
+	if l.Config.ReadyTimeout > 0 && l.Config.ReadyTimeout > l.Config.WaitForReady {
+		return fmt.Errorf("ready_timeout (%s) must not be longer than wait_for_ready (%s)", l.Config.ReadyTimeout, l.Config.WaitForReady)
+	}
+
+	return nil
+}
+
+func (l *LokiSource) setDirection() error {
+	switch l.Config.Direction {
+	case "":
+		l.Config.Direction = lokiclient.DirectionForward
+	case lokiclient.DirectionForward:
+	case lokiclient.DirectionBackward:
+		if l.Config.Mode != configuration.CAT_MODE {
+			return errors.New("direction backward is only supported in cat mode")
+		}
+	default:
+		return fmt.Errorf("invalid direction '%s': must be forward or backward", l.Config.Direction)
+	}
+
+	return nil
+}
+
+// compression tells whether to ask loki for compressed responses: it's the default, some proxies
+// mishandle it.
+func (l *LokiSource) compression() bool {
+	return l.Config.Compression == nil || *l.Config.Compression
+}
+
+func (l *LokiSource) setMaxRetries() error {
+	if l.Config.MaxRetries < 0 {
+		return errors.New("max_retries must be positive")
+	}
+
+	if l.Config.MaxRetries == 0 {
+		l.Config.MaxRetries = lokiclient.DefaultMaxRetries
+	}
+
+	return nil
+}
+
+func (l *LokiSource) setTimeout() error {
+	if l.Config.Timeout < 0 {
+		return errors.New("timeout must be positive")
+	}
+
+	if l.Config.Timeout == 0 {
+		l.Config.Timeout = lokiclient.DefaultTimeout
+	}
+
+	return nil
+}
+
+func validateDelayFor(delayFor time.Duration) error {
+	if delayFor < 0 || delayFor > maxDelayFor {
+		return fmt.Errorf("delay_for should be a value between 0s and %s", maxDelayFor)
+	}
+
+	return nil
+}
+
+func (l *LokiSource) validateTenants() error {
+	if len(l.Config.Tenants) == 0 {
+		return nil
+	}
+
+	if lokiclient.Tenant(l.Config.Headers) != "" {
+		return fmt.Errorf("tenants and the %s header cannot be used together", lokiclient.OrgIDHeader)
+	}
+
+	if slices.Contains(l.Config.Tenants, "") {
+		return errors.New("tenants: empty tenant id")
+	}
+
+	return nil
+}
+
+func validateOrgIDMode(mode string, headers map[string]string, tenants []string) error {
+	switch mode {
+	case "", lokiclient.OrgIDModeAuto:
+		return nil
+	case lokiclient.OrgIDModeOmit:
+		if len(tenants) > 0 {
+			return errors.New("orgid_mode is 'omit' but tenants are configured")
+		}
+		return nil
+	case lokiclient.OrgIDModeRequired:
+		if len(tenants) > 0 {
+			return nil
+		}
+		for k := range headers {
+			if strings.EqualFold(k, lokiclient.OrgIDHeader) {
+				return nil
+			}
+		}
+		return fmt.Errorf("orgid_mode is '%s' but no %s header is configured", mode, lokiclient.OrgIDHeader)
+	default:
+		return fmt.Errorf("invalid orgid_mode '%s': must be one of auto, required or omit", mode)
+	}
+}
+
+func (l *LokiSource) Configure(config []byte, logger *log.Entry, metricsLevel int) error {
+	l.Config = LokiConfiguration{}
+	l.logger = logger
+	l.metricsLevel = metricsLevel
+	err := l.UnmarshalConfig(config)
+	if err != nil {
+		return err
+	}
+
+	l.logger.Infof("Since value: %s", l.Config.Since.String())
+
+	clientConfig := lokiclient.Config{
+		LokiURL:         l.Config.URL.Primary(),
+		FailoverURLs:    l.Config.URL.Failover(),
+		Headers:         l.Config.Headers,
+		Limit:           l.Config.Limit,
+		Since:           l.Config.Since,
+		Until:           l.Config.Until,
+		Direction:       l.Config.Direction,
+		PageDelay:       l.Config.PageDelay,
+		DelayFor:        int(l.Config.DelayFor / time.Second),
+		Username:        l.Config.Auth.Username,
+		Password:        l.Config.Auth.Password,
+		PasswordFile:    l.Config.Auth.PasswordFile,
+		BearerToken:     l.Config.Auth.BearerToken,
+		BearerTokenFile: l.Config.Auth.BearerTokenFile,
+		OAuth2:          l.Config.Auth.OAuth2.clientConfig(),
+		FailMaxDuration: l.Config.MaxFailureDuration,
+		OrgIDMode:       l.Config.OrgIDMode,
+		LocalAddr:       l.localAddr,
+		ProxyURL:        l.proxyURL,
+		TLSConfig:       l.tlsConfig,
+		ReadyPath:       l.Config.ReadyPath,
+		ReadyExpectBody: l.Config.ReadyExpectBody,
+		ReadyTimeout:    l.Config.ReadyTimeout,
+		ReadyInterval:   l.Config.ReadyPollInterval,
+		Metrics:         l.metricsLevel != configuration.METRICS_NONE,
+
+		DisableCompression: !l.compression(),
+		Timeout:            l.Config.Timeout,
+		UserAgent:          l.Config.UserAgent,
+		FailbackInterval:   l.Config.FailbackInterval,
+		StartFromNow:       l.Config.StartFrom == startFromNow,
+
+		ReconnectGracePeriod: l.Config.ReconnectGracePeriod,
+		MaxBackoff:           l.Config.MaxBackoff,
+		MaxFailedAttempts:    l.Config.MaxFailedAttempts,
+		MaxRetries:           l.Config.MaxRetries,
+	}
+
+	l.newClients(clientConfig, logger)
+
+	authCtx, cancel := context.WithTimeout(context.Background(), authTimeout)
+	defer cancel()
+
+	if err := l.Client.Authenticate(authCtx); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+
+	return nil
+}
+
+func (l *LokiSource) ConfigureByDSN(dsn string, labels map[string]string, logger *log.Entry, uuid string) error {
+	l.logger = logger
+	l.Config = LokiConfiguration{}
+	l.Config.Mode = configuration.CAT_MODE
+	l.Config.Labels = labels
+	l.Config.UniqueId = uuid
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return fmt.Errorf("while parsing dsn '%s': %w", dsn, err)
+	}
+	if u.Scheme != "loki" {
+		return fmt.Errorf("invalid DSN %s for loki source, must start with loki://", dsn)
+	}
+	if u.Host == "" {
+		return errors.New("empty loki host")
+	}
+	scheme := "http"
+
+	params, err := dsnParams(dsn)
+	if err != nil {
+		return err
+	}
+	if q := params.Get("ssl"); q != "" {
+		scheme = "https"
+	}
+	if q := params.Get("insecure_skip_verify"); q != "" {
+		insecure, err := strconv.ParseBool(q)
+		if err != nil {
+			return fmt.Errorf("invalid insecure_skip_verify in dsn: %w", err)
+		}
+		l.Config.TLS = &LokiTLSConfiguration{InsecureSkipVerify: insecure}
+		l.tlsConfig, err = l.Config.TLS.newTLSConfig()
+		if err != nil {
+			return err
+		}
+	}
+	if q := params["query"]; len(q) > 0 {
+		if err := checkQueries(q); err != nil {
+			return err
+		}
+		l.Config.Query = q
+	}
+	if w := params.Get("wait_for_ready"); w != "" {
+		l.Config.WaitForReady, err = time.ParseDuration(w)
+		if err != nil {
+			return err
+		}
+	} else {
+		l.Config.WaitForReady = 10 * time.Second
+	}
+
+	if readyTimeout := params.Get("ready_timeout"); readyTimeout != "" {
+		l.Config.ReadyTimeout, err = time.ParseDuration(readyTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid ready_timeout in dsn: %w", err)
+		}
+	}
+
+	if readyPollInterval := params.Get("ready_poll_interval"); readyPollInterval != "" {
+		l.Config.ReadyPollInterval, err = time.ParseDuration(readyPollInterval)
+		if err != nil {
+			return fmt.Errorf("invalid ready_poll_interval in dsn: %w", err)
+		}
+	}
+
+	if err := l.validateReadyCheck(); err != nil {
+		return err
+	}
+
+	if d := params.Get("delay_for"); d != "" {
+		l.Config.DelayFor, err = time.ParseDuration(d)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %w", err)
+		}
+		if err := validateDelayFor(l.Config.DelayFor); err != nil {
+			return err
+		}
+	} else {
+		l.Config.DelayFor = 0 * time.Second
+	}
+
+	if s := params.Get("since"); s != "" {
+		l.Config.Since, err = lokiclient.ParseTimeBound(s)
+		if err != nil {
+			return fmt.Errorf("invalid since in dsn: %w", err)
+		}
+	}
+
+	if u := params.Get("until"); u != "" {
+		l.Config.Until, err = lokiclient.ParseTimeBound(u)
+		if err != nil {
+			return fmt.Errorf("invalid until in dsn: %w", err)
+		}
+	}
+
+	if err := l.validateUntil(); err != nil {
+		return err
+	}
+
+	l.Config.Direction = params.Get("direction")
+	if err := l.setDirection(); err != nil {
+		return err
+	}
+
+	if pageDelay := params.Get("page_delay"); pageDelay != "" {
+		l.Config.PageDelay, err = time.ParseDuration(pageDelay)
+		if err != nil {
+			return fmt.Errorf("invalid page_delay in dsn: %w", err)
+		}
+	}
+
+	if err := l.validatePageDelay(); err != nil {
+		return err
+	}
+
+	if max_failure_duration := params.Get("max_failure_duration"); max_failure_duration != "" {
+		duration, err := time.ParseDuration(max_failure_duration)
+		if err != nil {
+			return fmt.Errorf("invalid max_failure_duration in dsn: %w", err)
+		}
+		l.Config.MaxFailureDuration = duration
+	} else {
+		l.Config.MaxFailureDuration = 5 * time.Second // for OneShot mode it doesn't make sense to have longer duration
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		limit, err := strconv.Atoi(limit)
+		if err != nil {
+			return fmt.Errorf("invalid limit in dsn: %w", err)
+		}
+		if limit <= 0 {
+			return errors.New("invalid limit in dsn: must be positive")
+		}
+		l.Config.Limit = limit
+	} else {
+		l.Config.Limit = 5000 // max limit allowed by loki
+	}
+
+	if logLevel := params.Get("log_level"); logLevel != "" {
+		level, err := log.ParseLevel(logLevel)
+		if err != nil {
+			return fmt.Errorf("invalid log_level in dsn: %w", err)
+		}
+		l.Config.LogLevel = &level
+		l.logger.Logger.SetLevel(level)
+	}
+
+	if noReadyCheck := params.Get("no_ready_check"); noReadyCheck != "" {
+		noReadyCheck, err := strconv.ParseBool(noReadyCheck)
+		if err != nil {
+			return fmt.Errorf("invalid no_ready_check in dsn: %w", err)
+		}
+		l.Config.NoReadyCheck = noReadyCheck
+	}
+
+	if orgIDMode := params.Get("orgid_mode"); orgIDMode != "" {
+		l.Config.OrgIDMode = orgIDMode
+	}
+
+	l.Config.Headers, err = dsnHeaders(params)
+	if err != nil {
+		return err
+	}
+
+	if useTimestamp := params.Get("use_timestamp"); useTimestamp != "" {
+		l.Config.UseTimestamp, err = strconv.ParseBool(useTimestamp)
+		if err != nil {
+			return fmt.Errorf("invalid use_timestamp in dsn: %w", err)
+		}
+	}
+
+	if jsonDecode := params.Get("json_decode"); jsonDecode != "" {
+		l.Config.JSONDecode, err = strconv.ParseBool(jsonDecode)
+		if err != nil {
+			return fmt.Errorf("invalid json_decode in dsn: %w", err)
+		}
+	}
+
+	if skipEmptyLines := params.Get("skip_empty_lines"); skipEmptyLines != "" {
+		l.Config.SkipEmptyLines, err = strconv.ParseBool(skipEmptyLines)
+		if err != nil {
+			return fmt.Errorf("invalid skip_empty_lines in dsn, must be true to skip the lines that are empty or only whitespace: %w", err)
+		}
+	}
+
+	l.Config.LabelPrefix = labelPrefix
+	if prefix := params.Get("label_prefix"); prefix != "" {
+		l.Config.LabelPrefix = prefix
+	}
+
+	if compression := params.Get("compression"); compression != "" {
+		compression, err := strconv.ParseBool(compression)
+		if err != nil {
+			return fmt.Errorf("invalid compression in dsn: %w", err)
+		}
+		l.Config.Compression = &compression
+	}
+
+	if maxRetries := params.Get("max_retries"); maxRetries != "" {
+		l.Config.MaxRetries, err = strconv.Atoi(maxRetries)
+		if err != nil {
+			return fmt.Errorf("invalid max_retries in dsn: %w", err)
+		}
+	}
+
+	if err := l.setMaxRetries(); err != nil {
+		return err
+	}
+
+	if timeout := params.Get("timeout"); timeout != "" {
+		l.Config.Timeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout in dsn: %w", err)
+		}
+	}
+
+	if err := l.setTimeout(); err != nil {
+		return err
+	}
+
+	if backfillRate := params.Get("backfill_rate"); backfillRate != "" {
+		l.Config.BackfillRate, err = strconv.ParseFloat(backfillRate, 64)
+		if err != nil {
+			return fmt.Errorf("invalid backfill_rate in dsn: %w", err)
+		}
+	}
+
+	if err := l.setBackfillRate(); err != nil {
+		return err
+	}
+
+	if maxLines := params.Get("max_lines_per_second"); maxLines != "" {
+		l.Config.MaxLinesPerSecond, err = strconv.ParseFloat(maxLines, 64)
+		if err != nil {
+			return fmt.Errorf("invalid max_lines_per_second in dsn: %w", err)
+		}
+	}
+
+	if dropOnThrottle := params.Get("drop_on_throttle"); dropOnThrottle != "" {
+		l.Config.DropOnThrottle, err = strconv.ParseBool(dropOnThrottle)
+		if err != nil {
+			return fmt.Errorf("invalid drop_on_throttle in dsn: %w", err)
+		}
+	}
+
+	if err := l.setMaxLinesPerSecond(); err != nil {
+		return err
+	}
+
+	if err := validateOrgIDMode(l.Config.OrgIDMode, l.Config.Headers, nil); err != nil {
+		return err
+	}
+
+	// the path is kept, for a loki behind a reverse proxy on a subpath
+	// the host is kept as is, with the brackets of the IPv6 addresses, and their zone escaped again
+	l.Config.URL = URLList{(&url.URL{Scheme: scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/")}).String()}
+	if u.User != nil {
+		l.Config.Auth.Username = u.User.Username()
+		l.Config.Auth.Password, _ = u.User.Password()
+	}
+
+	clientConfig := lokiclient.Config{
+		LokiURL:   l.Config.URL.Primary(),
+		Headers:   l.Config.Headers,
+		Limit:     l.Config.Limit,
+		Since:     l.Config.Since,
+		Until:     l.Config.Until,
+		Direction: l.Config.Direction,
+		PageDelay: l.Config.PageDelay,
+		Username:  l.Config.Auth.Username,
+		Password:  l.Config.Auth.Password,
+		DelayFor:  int(l.Config.DelayFor / time.Second),
+		OrgIDMode: l.Config.OrgIDMode,
 
-	if l.Config.Mode == configuration.TAIL_MODE {
-		l.logger.Infof("Resetting since")
-		l.Config.Since = 0
-	}
+		TLSConfig: l.tlsConfig,
 
-	if l.Config.MaxFailureDuration == 0 {
-		l.Config.MaxFailureDuration = 30 * time.Second
+		MaxRetries:         l.Config.MaxRetries,
+		DisableCompression: !l.compression(),
+		Timeout:            l.Config.Timeout,
 	}
 
+	l.newClients(clientConfig, logger)
+
 	return nil
 }
 
-func (l *LokiSource) Configure(config []byte, logger *log.Entry, metricsLevel int) error {
-	l.Config = LokiConfiguration{}
-	l.logger = logger
-	l.metricsLevel = metricsLevel
-	err := l.UnmarshalConfig(config)
-	if err != nil {
-		return err
+// newClients creates a client for each query, and for each tenant if there are several: they
+// run independently, with their own position and retries. The clients are ordered by tenant,
+// then by query.
+func (l *LokiSource) newClients(clientConfig lokiclient.Config, logger *log.Entry) {
+	queries := l.Config.Query
+	if len(queries) == 0 {
+		queries = QueryList{""}
 	}
 
-	l.logger.Infof("Since value: %s", l.Config.Since.String())
+	tenants := l.Config.Tenants
+	if len(tenants) == 0 {
+		tenants = []string{""}
+	}
 
-	clientConfig := lokiclient.Config{
-		LokiURL:         l.Config.URL,
-		Headers:         l.Config.Headers,
-		Limit:           l.Config.Limit,
-		Query:           l.Config.Query,
-		Since:           l.Config.Since,
-		Username:        l.Config.Auth.Username,
-		Password:        l.Config.Auth.Password,
-		FailMaxDuration: l.Config.MaxFailureDuration,
+	headers := clientConfig.Headers
+
+	l.clients = make([]*lokiclient.LokiClient, 0, len(tenants)*len(queries))
+
+	for _, tenant := range tenants {
+		clientConfig.Headers = headers
+		if tenant != "" {
+			clientConfig.Headers = maps.Clone(headers)
+			if clientConfig.Headers == nil {
+				clientConfig.Headers = map[string]string{}
+			}
+			clientConfig.Headers[lokiclient.OrgIDHeader] = tenant
+		}
+
+		for _, query := range queries {
+			clientConfig.Query = query
+			client := lokiclient.NewLokiClient(clientConfig)
+
+			client.Logger = logger.WithFields(log.Fields{"component": "lokiclient", "source": l.Config.URL.Primary()})
+			if len(queries) > 1 {
+				client.Logger = client.Logger.WithField("query", query)
+			}
+			if tenant != "" {
+				client.Logger = client.Logger.WithField("tenant", tenant)
+			}
+
+			l.clients = append(l.clients, client)
+		}
 	}
 
-	l.Client = lokiclient.NewLokiClient(clientConfig)
-	l.Client.Logger = logger.WithFields(log.Fields{"component": "lokiclient", "source": l.Config.URL})
-	return nil
+	l.Client = l.clients[0]
 }
 
-func (l *LokiSource) ConfigureByDSN(dsn string, labels map[string]string, logger *log.Entry, uuid string) error {
-	l.logger = logger
-	l.Config = LokiConfiguration{}
-	l.Config.Mode = configuration.CAT_MODE
-	l.Config.Labels = labels
-	l.Config.UniqueId = uuid
+// dsnParams decodes the parameters of a DSN once, without the form encoding: a + is kept, it's
+// common in the regexes of the queries, and the spaces can be left as is or written %20. A # is
+// part of the last parameter, as in the line filters and the comments of the queries, instead
+// of starting a fragment.
+func dsnParams(dsn string) (url.Values, error) {
+	_, rawQuery, _ := strings.Cut(dsn, "?")
 
-	u, err := url.Parse(dsn)
-	if err != nil {
-		return fmt.Errorf("while parsing dsn '%s': %w", dsn, err)
-	}
-	if u.Scheme != "loki" {
-		return fmt.Errorf("invalid DSN %s for loki source, must start with loki://", dsn)
-	}
-	if u.Host == "" {
-		return errors.New("empty loki host")
-	}
-	scheme := "http"
+	params := url.Values{}
 
-	params := u.Query()
-	if q := params.Get("ssl"); q != "" {
-		scheme = "https"
-	}
-	if q := params.Get("query"); q != "" {
-		l.Config.Query = q
-	}
-	if w := params.Get("wait_for_ready"); w != "" {
-		l.Config.WaitForReady, err = time.ParseDuration(w)
-		if err != nil {
-			return err
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
 		}
-	} else {
-		l.Config.WaitForReady = 10 * time.Second
-	}
 
-	if d := params.Get("delay_for"); d != "" {
-		l.Config.DelayFor, err = time.ParseDuration(d)
+		rawName, rawValue, _ := strings.Cut(param, "=")
+
+		name, err := url.PathUnescape(rawName)
 		if err != nil {
-			return fmt.Errorf("invalid duration: %w", err)
+			return nil, fmt.Errorf("invalid parameter '%s' in dsn: %w", rawName, err)
 		}
-		if l.Config.DelayFor < 0*time.Second || l.Config.DelayFor > 5*time.Second {
-			return errors.New("delay_for should be a value between 1s and 5s")
-		}
-	} else {
-		l.Config.DelayFor = 0 * time.Second
-	}
 
-	if s := params.Get("since"); s != "" {
-		l.Config.Since, err = time.ParseDuration(s)
+		value, err := url.PathUnescape(rawValue)
 		if err != nil {
-			return fmt.Errorf("invalid since in dsn: %w", err)
+			return nil, fmt.Errorf("invalid %s in dsn, a %% must be written %%25: %w", name, err)
 		}
+
+		params.Add(name, value)
 	}
 
-	if max_failure_duration := params.Get("max_failure_duration"); max_failure_duration != "" {
-		duration, err := time.ParseDuration(max_failure_duration)
-		if err != nil {
-			return fmt.Errorf("invalid max_failure_duration in dsn: %w", err)
+	return params, nil
+}
+
+// dsnHeaders reads the HTTP headers of a DSN, given as header.Name=value or header=Name:value.
+func dsnHeaders(params url.Values) (map[string]string, error) {
+	headers := map[string]string{}
+
+	for _, param := range slices.Sorted(maps.Keys(params)) {
+		if name, ok := strings.CutPrefix(param, "header."); ok {
+			if name == "" {
+				return nil, errors.New("invalid header in dsn: empty header name")
+			}
+			headers[name] = params.Get(param)
 		}
-		l.Config.MaxFailureDuration = duration
-	} else {
-		l.Config.MaxFailureDuration = 5 * time.Second // for OneShot mode it doesn't make sense to have longer duration
 	}
 
-	if limit := params.Get("limit"); limit != "" {
-		limit, err := strconv.Atoi(limit)
-		if err != nil {
-			return fmt.Errorf("invalid limit in dsn: %w", err)
+	for _, header := range params["header"] {
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header in dsn: '%s' must be Name:value", header)
 		}
-		l.Config.Limit = limit
-	} else {
-		l.Config.Limit = 5000 // max limit allowed by loki
+		headers[name] = strings.TrimSpace(value)
 	}
 
-	if logLevel := params.Get("log_level"); logLevel != "" {
-		level, err := log.ParseLevel(logLevel)
-		if err != nil {
-			return fmt.Errorf("invalid log_level in dsn: %w", err)
-		}
-		l.Config.LogLevel = &level
-		l.logger.Logger.SetLevel(level)
+	if len(headers) == 0 {
+		return nil, nil
 	}
 
-	if noReadyCheck := params.Get("no_ready_check"); noReadyCheck != "" {
-		noReadyCheck, err := strconv.ParseBool(noReadyCheck)
+	return headers, nil
+}
+
+// start prepares the clients: loki must be ready, and each client checks the org id header.
+func (l *LokiSource) start(ctx context.Context, t *tomb.Tomb) error {
+	for _, client := range l.clients {
+		client.SetTomb(t)
+	}
+
+	if !l.Config.NoReadyCheck {
+		readyCtx, readyCancel := context.WithTimeout(ctx, l.Config.WaitForReady)
+		defer readyCancel()
+		err := l.Client.Ready(readyCtx)
 		if err != nil {
-			return fmt.Errorf("invalid no_ready_check in dsn: %w", err)
+			return fmt.Errorf("loki is not ready: %w", err)
 		}
-		l.Config.NoReadyCheck = noReadyCheck
-	}
 
-	l.Config.URL = fmt.Sprintf("%s://%s", scheme, u.Host)
-	if u.User != nil {
-		l.Config.Auth.Username = u.User.Username()
-		l.Config.Auth.Password, _ = u.User.Password()
+		if err := l.Client.CheckVersion(ctx); err != nil {
+			return err
+		}
 	}
 
-	clientConfig := lokiclient.Config{
-		LokiURL:  l.Config.URL,
-		Headers:  l.Config.Headers,
-		Limit:    l.Config.Limit,
-		Query:    l.Config.Query,
-		Since:    l.Config.Since,
-		Username: l.Config.Auth.Username,
-		Password: l.Config.Auth.Password,
-		DelayFor: int(l.Config.DelayFor / time.Second),
+	for _, client := range l.clients {
+		if err := client.DetectOrgID(ctx); err != nil {
+			return err
+		}
 	}
 
-	l.Client = lokiclient.NewLokiClient(clientConfig)
-	l.Client.Logger = logger.WithFields(log.Fields{"component": "lokiclient", "source": l.Config.URL})
-
 	return nil
 }
 
@@ -269,29 +1320,56 @@ func (l *LokiSource) GetName() string {
 	return "loki"
 }
 
-// OneShotAcquisition reads a set of file and returns when done
+// OneShotAcquisition reads the range of each query in turn, and returns when done
 func (l *LokiSource) OneShotAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
 	l.logger.Debug("Loki one shot acquisition")
-	l.Client.SetTomb(t)
 
-	if !l.Config.NoReadyCheck {
-		readyCtx, readyCancel := context.WithTimeout(ctx, l.Config.WaitForReady)
-		defer readyCancel()
-		err := l.Client.Ready(readyCtx)
-		if err != nil {
-			return fmt.Errorf("loki is not ready: %w", err)
-		}
+	if err := l.start(ctx, t); err != nil {
+		return err
 	}
 
 	lokiCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	c := l.Client.QueryRange(lokiCtx, false)
 
-	for {
+	go func() {
+		// stop waiting for backfill_rate
 		select {
 		case <-t.Dying():
+			cancel()
+		case <-lokiCtx.Done():
+		}
+	}()
+
+	for _, client := range l.clients {
+		if err := l.readRange(lokiCtx, client, out); err != nil {
+			return err
+		}
+
+		if lokiCtx.Err() != nil {
 			l.logger.Debug("Loki one shot acquisition stopped")
 			return nil
+		}
+	}
+
+	return nil
+}
+
+// readRange sends the entries of the query of a client to the parsers, until the end of the
+// range. Each query has its own tomb: the one of the acquisition cannot start new goroutines
+// once the previous query is done.
+func (l *LokiSource) readRange(ctx context.Context, client *lokiclient.LokiClient, out chan types.Event) error {
+	queryTomb, queryCtx := tomb.WithContext(ctx)
+	client.SetTomb(queryTomb)
+
+	c := client.QueryRange(queryCtx, false)
+
+	for {
+		select {
+		case <-queryTomb.Dying():
+			if ctx.Err() != nil {
+				return nil
+			}
+			return queryTomb.Err()
 		case resp, ok := <-c:
 			if !ok {
 				l.logger.Info("Loki acquisition done, chan closed")
@@ -299,63 +1377,282 @@ func (l *LokiSource) OneShotAcquisition(ctx context.Context, out chan types.Even
 			}
 			for _, stream := range resp.Data.Result {
 				for _, entry := range stream.Entries {
-					l.readOneEntry(entry, l.Config.Labels, out)
+					if !l.pace(ctx) {
+						return nil
+					}
+					if err := l.readEntry(ctx, entry, stream.Stream, client, out); err != nil {
+						return nil
+					}
 				}
 			}
 		}
 	}
 }
 
-func (l *LokiSource) readOneEntry(entry lokiclient.Entry, labels map[string]string, out chan types.Event) {
+// pace waits until the next event can be sent without exceeding backfill_rate.
+// It returns false if the acquisition is stopped meanwhile.
+func (l *LokiSource) pace(ctx context.Context) bool {
+	if l.backfillLimiter == nil {
+		return true
+	}
+
+	start := time.Now()
+
+	if err := l.backfillLimiter.Wait(ctx); err != nil {
+		return false
+	}
+
+	if l.metricsLevel != configuration.METRICS_NONE {
+		backfillWait.With(prometheus.Labels{"source": l.Config.URL.Primary()}).Add(time.Since(start).Seconds())
+	}
+
+	return true
+}
+
+// readEntry sends an entry to the parsers under max_lines_per_second: above it, the reads wait,
+// or with drop_on_throttle, the entry is dropped. It returns the error of the context if the
+// acquisition is stopped while waiting.
+func (l *LokiSource) readEntry(ctx context.Context, entry lokiclient.Entry, streamLabels map[string]string, client *lokiclient.LokiClient, out chan types.Event) error {
+	if l.lineLimiter != nil && !l.lineLimiter.Allow() {
+		labels := prometheus.Labels{"source": l.Config.URL.Primary(), "tenant": client.Tenant()}
+
+		if l.Config.DropOnThrottle {
+			if l.metricsLevel != configuration.METRICS_NONE {
+				throttleDroppedLines.With(labels).Inc()
+			}
+			// the entry is read, the checkpoint goes past it
+			l.checkpoint.progress(client, entry.Timestamp)
+			return nil
+		}
+
+		if l.metricsLevel != configuration.METRICS_NONE {
+			throttledLines.With(labels).Inc()
+		}
+
+		if err := l.lineLimiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	l.readOneEntry(entry, streamLabels, client, out)
+
+	return nil
+}
+
+// readOneEntry sends an entry to the parsers. The labels of its stream are set in the metadata,
+// prefixed with label_prefix: a query can return streams with different label sets. So is the
+// structured metadata of the entry. The query that returned the entry is set in loki_query,
+// whatever the prefix, and its tenant in loki_tenant if tenants are configured. They are set
+// first: with the default prefix, a stream label named query or tenant takes their place, another
+// label_prefix keeps both.
+func (l *LokiSource) readOneEntry(entry lokiclient.Entry, streamLabels map[string]string, client *lokiclient.LokiClient, out chan types.Event) {
 	ll := types.Line{}
-	ll.Raw = entry.Line
+	ll.Raw = l.lineTransform.apply(entry.Line, l.logger)
 	ll.Time = entry.Timestamp
-	ll.Src = l.Config.URL
-	ll.Labels = labels
+	ll.Src = l.Config.URL.Primary()
+	ll.Labels = l.Config.Labels
 	ll.Process = true
 	ll.Module = l.GetName()
 
 	if l.metricsLevel != configuration.METRICS_NONE {
-		linesRead.With(prometheus.Labels{"source": l.Config.URL}).Inc()
+		labels := prometheus.Labels{"source": l.Config.URL.Primary(), "tenant": client.Tenant()}
+		linesRead.With(labels).Inc()
+		bytesRead.With(labels).Add(float64(len(entry.Line)))
+	}
+	if l.Config.SkipEmptyLines && strings.TrimSpace(ll.Raw) == "" {
+		if l.metricsLevel != configuration.METRICS_NONE {
+			skippedLines.With(prometheus.Labels{"source": l.Config.URL.Primary(), "tenant": client.Tenant()}).Inc()
+		}
+		// the line is read, the checkpoint goes past it
+		l.checkpoint.progress(client, entry.Timestamp)
+		return
 	}
 	evt := types.MakeEvent(l.Config.UseTimeMachine, types.LOG, true)
 	evt.Line = ll
-	out <- evt
+	if l.Config.UseTimestamp {
+		// the time the buckets use, unless a parser finds one in the line
+		evt.Time = entry.Timestamp
+		evt.MarshaledTime = entry.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	evt.Meta["loki_query"] = client.Query()
+	if len(l.Config.Tenants) > 0 {
+		evt.Meta["loki_tenant"] = client.Tenant()
+	}
+	for name, value := range streamLabels {
+		evt.Meta[l.Config.LabelPrefix+name] = value
+	}
+	for name, value := range entry.Metadata {
+		evt.Meta[l.Config.LabelPrefix+name] = value
+	}
+	if l.Config.JSONDecode {
+		l.decodeJSON(&evt)
+	}
+	l.jsonExtractor.Apply(&evt)
+	l.send(evt, client, out)
+	l.checkpoint.progress(client, entry.Timestamp)
 }
 
-func (l *LokiSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
-	l.Client.SetTomb(t)
+// send passes an event to the output. With buffer_size, the reads go on while the output is
+// stalled: the events wait in the buffer, and they are dropped once it is full.
+func (l *LokiSource) send(evt types.Event, client *lokiclient.LokiClient, out chan types.Event) {
+	if l.buffer == nil {
+		out <- evt
+		return
+	}
 
-	if !l.Config.NoReadyCheck {
-		readyCtx, readyCancel := context.WithTimeout(ctx, l.Config.WaitForReady)
-		defer readyCancel()
-		err := l.Client.Ready(readyCtx)
-		if err != nil {
-			return fmt.Errorf("loki is not ready: %w", err)
+	select {
+	case l.buffer <- evt:
+		if l.dropping.Swap(false) {
+			l.logger.Info("the output caught up, the events are buffered again")
+		}
+	default:
+		if !l.dropping.Swap(true) {
+			l.logger.Warnf("the output is stalled and the buffer of %d events is full, dropping events", l.Config.BufferSize)
+		}
+
+		if l.metricsLevel != configuration.METRICS_NONE {
+			droppedEvents.With(prometheus.Labels{"source": l.Config.URL.Primary(), "tenant": client.Tenant()}).Inc()
 		}
 	}
-	ll := l.logger.WithField("websocket_url", l.lokiWebsocket)
-	t.Go(func() error {
-		ctx, cancel := context.WithCancel(ctx)
-		defer cancel()
-		respChan := l.Client.QueryRange(ctx, true)
-		for {
+}
+
+// forward sends the buffered events to the output.
+func (l *LokiSource) forward(out chan types.Event, t *tomb.Tomb) error {
+	for {
+		select {
+		case evt := <-l.buffer:
 			select {
-			case resp, ok := <-respChan:
-				if !ok {
-					ll.Warnf("loki channel closed")
-					return errors.New("loki channel closed")
-				}
-				for _, stream := range resp.Data.Result {
-					for _, entry := range stream.Entries {
-						l.readOneEntry(entry, l.Config.Labels, out)
-					}
-				}
+			case out <- evt:
 			case <-t.Dying():
 				return nil
 			}
+		case <-t.Dying():
+			return nil
+		}
+	}
+}
+
+// decodeJSON sets the top-level keys of a JSON line in evt.Unmarshaled. The other lines are sent
+// as they are.
+func (l *LokiSource) decodeJSON(evt *types.Event) {
+	fields := map[string]any{}
+	if err := json.Unmarshal([]byte(evt.Line.Raw), &fields); err != nil {
+		l.logger.Debugf("line is not a JSON object, sending it raw: %s", err)
+		return
+	}
+
+	maps.Copy(evt.Unmarshaled, fields)
+}
+
+// StreamingAcquisition tails each query, their entries are sent to the same channel. With a
+// checkpoint, each query resumes from the last entry read before the restart.
+func (l *LokiSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	if err := l.start(ctx, t); err != nil {
+		return err
+	}
+
+	if l.checkpoint != nil {
+		l.checkpoint.load(l.clients, l.Config.MaxCatchup, time.Now())
+		t.Go(func() error {
+			return l.checkpoint.run(t)
+		})
+	}
+
+	if l.Config.BufferSize > 0 {
+		l.buffer = make(chan types.Event, l.Config.BufferSize)
+		t.Go(func() error {
+			return l.forward(out, t)
+		})
+	}
+
+	ll := l.logger.WithField("websocket_url", l.lokiWebsocket)
+	for _, client := range l.clients {
+		t.Go(func() error {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			respChan := client.QueryRange(ctx, true)
+			// the wait of max_lines_per_second also ends with the tomb
+			throttleCtx := t.Context(ctx)
+			for {
+				select {
+				case resp, ok := <-respChan:
+					if !ok {
+						ll.Warnf("loki channel closed")
+						return errors.New("loki channel closed")
+					}
+					for _, stream := range resp.Data.Result {
+						for _, entry := range stream.Entries {
+							if err := l.readEntry(throttleCtx, entry, stream.Stream, client, out); err != nil {
+								return nil
+							}
+						}
+					}
+				case <-t.Dying():
+					return nil
+				}
+			}
+		})
+	}
+	return nil
+}
+
+// ReloadQuery switches a running source to new queries, each resuming from the timestamp of the
+// last entry it read. The queries are validated against loki, for each tenant, before being used.
+// The number of queries cannot change.
+func (l *LokiSource) ReloadQuery(ctx context.Context, yamlConfig []byte) error {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+
+	if l.Config.Mode != configuration.TAIL_MODE {
+		return errors.New("the query can only be reloaded in tail mode")
+	}
+
+	newSource := LokiSource{logger: l.logger}
+	if err := newSource.UnmarshalConfig(yamlConfig); err != nil {
+		return err
+	}
+
+	newConfig := newSource.Config
+	newConfig.Query = l.Config.Query
+	newConfig.UniqueId = l.Config.UniqueId
+
+	if !reflect.DeepEqual(newConfig, l.Config) {
+		return errors.New("only the query can be changed without a full reload")
+	}
+
+	if slices.Equal(newSource.Config.Query, l.Config.Query) {
+		return nil
+	}
+
+	n := len(l.Config.Query)
+	if len(newSource.Config.Query) != n {
+		return errors.New("the number of queries can only be changed with a full reload")
+	}
+
+	// the clients are ordered by tenant, then by query
+	for i, client := range l.clients {
+		query := newSource.Config.Query[i%n]
+		if query == l.Config.Query[i%n] {
+			continue
+		}
+
+		if err := client.ValidateQuery(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	for i, client := range l.clients {
+		query := newSource.Config.Query[i%n]
+		if query == l.Config.Query[i%n] {
+			continue
 		}
-	})
+
+		client.Logger.Infof("reloading query: %s", query)
+		client.SetQuery(query)
+	}
+
+	l.Config.Query = newSource.Config.Query
+
 	return nil
 }
 
@@ -367,10 +1664,6 @@ func (l *LokiSource) GetUuid() string {
 	return l.Config.UniqueId
 }
 
-func (l *LokiSource) Dump() interface{} {
-	return l
-}
-
 // SupportedModes returns the supported modes by the acquisition module
 func (l *LokiSource) SupportedModes() []string {
 	return []string{configuration.TAIL_MODE, configuration.CAT_MODE}
@@ -0,0 +1,61 @@
+package loki
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LokiLineTransform rewrites the lines before they are sent to the parsers, such as to strip the
+// prefix added by the container runtime.
+type LokiLineTransform struct {
+	Regexp  string `yaml:"regexp"`
+	Replace string `yaml:"replace"` // can refer to the groups of the regexp, as $1 or ${name}
+}
+
+// lineTransform is a compiled line_transform. The methods are no-ops on a nil lineTransform,
+// without line_transform.
+type lineTransform struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+func (c *LokiLineTransform) compile() (*lineTransform, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	if c.Regexp == "" {
+		return nil, errors.New("line_transform: regexp is mandatory")
+	}
+
+	re, err := regexp.Compile(c.Regexp)
+	if err != nil {
+		return nil, fmt.Errorf("line_transform: invalid regexp: %w", err)
+	}
+
+	return &lineTransform{re: re, replace: c.Replace}, nil
+}
+
+// apply returns the transformed line. The lines that don't match, or that would be emptied, are
+// sent unchanged.
+func (t *lineTransform) apply(line string, logger *log.Entry) string {
+	if t == nil {
+		return line
+	}
+
+	if !t.re.MatchString(line) {
+		logger.Debugf("line_transform doesn't match, sending the line unchanged: %s", line)
+		return line
+	}
+
+	transformed := t.re.ReplaceAllString(line, t.replace)
+	if transformed == "" {
+		logger.Debugf("line_transform empties the line, sending it unchanged: %s", line)
+		return line
+	}
+
+	return transformed
+}
@@ -0,0 +1,380 @@
+package mqttacquisition
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	yaml "github.com/goccy/go-yaml"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/trace"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const (
+	dataSourceName    = "mqtt"
+	defaultBufferSize = 1024
+	defaultTimeout    = 10 * time.Second
+)
+
+var linesRead = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_mqttsource_hits_total",
+		Help: "Total lines that were read from topic",
+	},
+	[]string{"topic"})
+
+var connected = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "cs_mqttsource_connected",
+		Help: "Whether the datasource is connected to the broker (1) or not (0).",
+	},
+	[]string{"broker"})
+
+type MQTTConfiguration struct {
+	Brokers      []string      `yaml:"brokers"`
+	Topics       []string      `yaml:"topics"`
+	ClientID     string        `yaml:"client_id"`
+	Username     string        `yaml:"username"`
+	Password     string        `yaml:"password"`
+	QoS          byte          `yaml:"qos"`
+	CleanSession *bool         `yaml:"clean_session"`
+	Timeout      time.Duration `yaml:"timeout"`
+	BufferSize   int           `yaml:"buffer_size"` // Max messages received but not yet sent to the parsers
+	TLS          *TLSConfig    `yaml:"tls"`
+
+	configuration.DataSourceCommonCfg `yaml:",inline"`
+}
+
+type TLSConfig struct {
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	ClientCert         string `yaml:"client_cert"`
+	ClientKey          string `yaml:"client_key"`
+	CaCert             string `yaml:"ca_cert"`
+}
+
+type MQTTSource struct {
+	metricsLevel int
+	Config       MQTTConfiguration
+	logger       *log.Entry
+	clientOpts   *mqtt.ClientOptions
+	messages     chan mqtt.Message
+
+	// the broker of the last connection attempt, as written in the configuration, for the
+	// connected metric
+	brokerMu sync.Mutex
+	broker   string
+}
+
+func (m *MQTTSource) GetUuid() string {
+	return m.Config.UniqueId
+}
+
+func (m *MQTTSource) UnmarshalConfig(yamlConfig []byte) error {
+	m.Config = MQTTConfiguration{}
+
+	err := yaml.UnmarshalWithOptions(yamlConfig, &m.Config, yaml.Strict())
+	if err != nil {
+		return fmt.Errorf("cannot parse %s datasource configuration: %s", dataSourceName, yaml.FormatError(err, false, false))
+	}
+
+	if len(m.Config.Brokers) == 0 {
+		return errors.New("at least one broker is required")
+	}
+
+	if len(m.Config.Topics) == 0 {
+		return errors.New("at least one topic is required")
+	}
+
+	if m.Config.QoS > 2 {
+		return fmt.Errorf("invalid qos %d: must be 0, 1 or 2", m.Config.QoS)
+	}
+
+	if m.Config.CleanSession == nil {
+		cleanSession := true
+		m.Config.CleanSession = &cleanSession
+	}
+
+	if !*m.Config.CleanSession && m.Config.ClientID == "" {
+		return errors.New("client_id is required for persistent sessions (clean_session: false)")
+	}
+
+	if m.Config.Timeout == 0 {
+		m.Config.Timeout = defaultTimeout
+	}
+
+	if m.Config.BufferSize < 0 {
+		return errors.New("buffer_size must be positive")
+	}
+
+	if m.Config.BufferSize == 0 {
+		m.Config.BufferSize = defaultBufferSize
+	}
+
+	if m.Config.Mode == "" {
+		m.Config.Mode = configuration.TAIL_MODE
+	}
+
+	if m.Config.Mode != configuration.TAIL_MODE {
+		return fmt.Errorf("unsupported mode %s for %s datasource", m.Config.Mode, dataSourceName)
+	}
+
+	return nil
+}
+
+func (m *MQTTSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLevel int) error {
+	m.logger = logger
+	m.metricsLevel = metricsLevel
+
+	err := m.UnmarshalConfig(yamlConfig)
+	if err != nil {
+		return err
+	}
+
+	m.clientOpts, err = m.newClientOptions()
+	if err != nil {
+		return fmt.Errorf("cannot create %s client: %w", dataSourceName, err)
+	}
+
+	return nil
+}
+
+func (c *TLSConfig) newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("while loading client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CaCert != "" {
+		caCert, err := os.ReadFile(c.CaCert)
+		if err != nil {
+			return nil, fmt.Errorf("while reading CA certificate: %w", err)
+		}
+
+		caCertPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load system CA certificates: %w", err)
+		}
+
+		if caCertPool == nil {
+			caCertPool = x509.NewCertPool()
+		}
+
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no valid certificate found in %s", c.CaCert)
+		}
+
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	return tlsConfig, nil
+}
+
+func (m *MQTTSource) newClientOptions() (*mqtt.ClientOptions, error) {
+	opts := mqtt.NewClientOptions()
+
+	// the servers are in the order of the brokers, with their scheme added
+	brokers := make(map[string]string, len(m.Config.Brokers))
+
+	for i, broker := range m.Config.Brokers {
+		opts.AddBroker(broker)
+		brokers[opts.Servers[i].String()] = broker
+	}
+
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		m.brokerMu.Lock()
+		m.broker = brokers[broker.String()]
+		m.brokerMu.Unlock()
+
+		return tlsCfg
+	})
+
+	opts.SetClientID(m.Config.ClientID)
+	opts.SetUsername(m.Config.Username)
+	opts.SetPassword(m.Config.Password)
+	opts.SetCleanSession(*m.Config.CleanSession)
+	opts.SetConnectTimeout(m.Config.Timeout)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	// messages are acknowledged once they are sent to the parsers
+	opts.SetAutoAckDisabled(true)
+
+	if m.Config.TLS != nil {
+		tlsConfig, err := m.Config.TLS.newTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	return opts, nil
+}
+
+func (*MQTTSource) ConfigureByDSN(string, map[string]string, *log.Entry, string) error {
+	return fmt.Errorf("%s datasource does not support command-line acquisition", dataSourceName)
+}
+
+func (m *MQTTSource) GetMode() string {
+	return m.Config.Mode
+}
+
+func (*MQTTSource) GetName() string {
+	return dataSourceName
+}
+
+func (*MQTTSource) OneShotAcquisition(_ context.Context, _ chan types.Event, _ *tomb.Tomb) error {
+	return fmt.Errorf("%s datasource does not support one-shot acquisition", dataSourceName)
+}
+
+func (*MQTTSource) CanRun() error {
+	return nil
+}
+
+func (*MQTTSource) GetMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, connected}
+}
+
+func (*MQTTSource) GetAggregMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, connected}
+}
+
+func (m *MQTTSource) Dump() any {
+	return m
+}
+
+// setConnected updates the connected metric of the broker of the last connection attempt.
+func (m *MQTTSource) setConnected(value float64) {
+	if m.metricsLevel == configuration.METRICS_NONE {
+		return
+	}
+
+	m.brokerMu.Lock()
+	broker := m.broker
+	m.brokerMu.Unlock()
+
+	if broker == "" {
+		return
+	}
+
+	connected.With(prometheus.Labels{"broker": broker}).Set(value)
+}
+
+// subscribe is called on every (re)connection, the subscriptions are not kept by the broker
+// for clean sessions.
+func (m *MQTTSource) subscribe(client mqtt.Client, t *tomb.Tomb) {
+	m.setConnected(1)
+
+	filters := make(map[string]byte, len(m.Config.Topics))
+	for _, topic := range m.Config.Topics {
+		filters[topic] = m.Config.QoS
+	}
+
+	token := client.SubscribeMultiple(filters, func(_ mqtt.Client, msg mqtt.Message) {
+		// blocks when the buffer is full, which stops reading from the broker
+		select {
+		case m.messages <- msg:
+		case <-t.Dying():
+		}
+	})
+
+	if !token.WaitTimeout(m.Config.Timeout) {
+		m.logger.Errorf("timeout while subscribing to %v", m.Config.Topics)
+		return
+	}
+
+	if err := token.Error(); err != nil {
+		m.logger.Errorf("while subscribing to %v: %s", m.Config.Topics, err)
+		return
+	}
+
+	m.logger.Infof("subscribed to %v", m.Config.Topics)
+}
+
+// emit sends a message to the parsers, then acknowledges it. A message that could not be sent
+// before the datasource is stopped is not acknowledged, the broker delivers it again.
+func (m *MQTTSource) emit(msg mqtt.Message, out chan types.Event, t *tomb.Tomb) {
+	l := types.Line{
+		Raw:     string(msg.Payload()),
+		Labels:  m.Config.Labels,
+		Time:    time.Now().UTC(),
+		Src:     msg.Topic(),
+		Process: true,
+		Module:  m.GetName(),
+	}
+
+	if m.metricsLevel != configuration.METRICS_NONE {
+		linesRead.With(prometheus.Labels{"topic": msg.Topic()}).Inc()
+	}
+
+	evt := types.MakeEvent(m.Config.UseTimeMachine, types.LOG, true)
+	evt.Line = l
+	evt.Meta["mqtt_topic"] = msg.Topic()
+
+	select {
+	case out <- evt:
+	case <-t.Dying():
+		return
+	}
+
+	msg.Ack()
+}
+
+func (m *MQTTSource) StreamingAcquisition(_ context.Context, out chan types.Event, t *tomb.Tomb) error {
+	m.messages = make(chan mqtt.Message, m.Config.BufferSize)
+
+	m.clientOpts.SetOnConnectHandler(func(client mqtt.Client) {
+		m.subscribe(client, t)
+	})
+
+	m.clientOpts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		m.setConnected(0)
+		m.logger.Warnf("connection to broker lost, reconnecting: %s", err)
+	})
+
+	client := mqtt.NewClient(m.clientOpts)
+
+	m.logger.Infof("connecting to brokers %v", m.Config.Brokers)
+
+	// with ConnectRetry, the token only completes once connected, don't wait for it
+	client.Connect()
+
+	t.Go(func() error {
+		defer trace.CatchPanic("crowdsec/acquis/mqtt/live")
+
+		for {
+			select {
+			case <-t.Dying():
+				m.logger.Infof("%s datasource stopping", dataSourceName)
+				client.Disconnect(250)
+				m.setConnected(0)
+
+				return nil
+			case msg := <-m.messages:
+				m.emit(msg, out, t)
+			}
+		}
+	})
+
+	return nil
+}
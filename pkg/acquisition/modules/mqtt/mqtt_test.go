@@ -0,0 +1,174 @@
+package mqttacquisition
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		config      string
+		expectedErr string
+	}{
+		{
+			config: `
+foobar: bla
+source: mqtt`,
+			expectedErr: `[2:1] unknown field "foobar"`,
+		},
+		{
+			config:      `source: mqtt`,
+			expectedErr: "at least one broker is required",
+		},
+		{
+			config: `
+source: mqtt
+brokers:
+  - tcp://localhost:1883`,
+			expectedErr: "at least one topic is required",
+		},
+		{
+			config: `
+source: mqtt
+brokers:
+  - tcp://localhost:1883
+topics:
+  - logs/#
+qos: 3`,
+			expectedErr: "invalid qos 3: must be 0, 1 or 2",
+		},
+		{
+			config: `
+source: mqtt
+brokers:
+  - tcp://localhost:1883
+topics:
+  - logs/#
+clean_session: false`,
+			expectedErr: "client_id is required for persistent sessions (clean_session: false)",
+		},
+		{
+			config: `
+source: mqtt
+mode: cat
+brokers:
+  - tcp://localhost:1883
+topics:
+  - logs/#`,
+			expectedErr: "unsupported mode cat for mqtt datasource",
+		},
+		{
+			config: `
+source: mqtt
+brokers:
+  - tcp://localhost:1883
+topics:
+  - logs/#
+tls:
+  ca_cert: /does/not/exist`,
+			expectedErr: "while reading CA certificate",
+		},
+		{
+			config: `
+source: mqtt
+brokers:
+  - tcp://localhost:1883
+topics:
+  - logs/#
+  - devices/+/syslog
+client_id: crowdsec
+clean_session: false
+qos: 1`,
+		},
+	}
+
+	subLogger := log.WithField("type", "mqtt")
+
+	for _, test := range tests {
+		m := MQTTSource{}
+		err := m.Configure([]byte(test.config), subLogger, configuration.METRICS_NONE)
+		cstest.AssertErrorContains(t, err, test.expectedErr)
+	}
+}
+
+type fakeMessage struct {
+	topic   string
+	payload []byte
+	acked   bool
+}
+
+func (f *fakeMessage) Duplicate() bool   { return false }
+func (f *fakeMessage) Qos() byte         { return 1 }
+func (f *fakeMessage) Retained() bool    { return false }
+func (f *fakeMessage) Topic() string     { return f.topic }
+func (f *fakeMessage) MessageID() uint16 { return 1 }
+func (f *fakeMessage) Payload() []byte   { return f.payload }
+func (f *fakeMessage) Ack()              { f.acked = true }
+
+func TestEmit(t *testing.T) {
+	m := MQTTSource{}
+	err := m.Configure([]byte(`
+source: mqtt
+brokers:
+  - tcp://localhost:1883
+topics:
+  - devices/#
+labels:
+  type: syslog`), log.WithField("type", "mqtt"), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	out := make(chan types.Event, 1)
+	msg := &fakeMessage{topic: "devices/gw1/syslog", payload: []byte("hello world")}
+
+	tmb := tomb.Tomb{}
+
+	m.emit(msg, out, &tmb)
+
+	evt := <-out
+	assert.Equal(t, "hello world", evt.Line.Raw)
+	assert.Equal(t, "devices/gw1/syslog", evt.Line.Src)
+	assert.Equal(t, "devices/gw1/syslog", evt.Meta["mqtt_topic"])
+	assert.Equal(t, "syslog", evt.Line.Labels["type"])
+	assert.True(t, msg.acked)
+
+	// stopped while nobody reads the events: the broker delivers the message again
+	msg = &fakeMessage{topic: "devices/gw1/syslog", payload: []byte("hello again")}
+	out <- evt
+	tmb.Kill(nil)
+
+	m.emit(msg, out, &tmb)
+	assert.False(t, msg.acked)
+}
+
+func TestConnectedBroker(t *testing.T) {
+	m := MQTTSource{}
+	err := m.Configure([]byte(`
+source: mqtt
+brokers:
+  - localhost:1883
+  - tcp://backup:1883
+topics:
+  - devices/#`), log.WithField("type", "mqtt"), configuration.METRICS_FULL)
+	require.NoError(t, err)
+
+	// with a single connected broker
+	m.clientOpts.OnConnectAttempt(m.clientOpts.Servers[1], nil)
+	m.setConnected(1)
+
+	assert.InDelta(t, 0, testutil.ToFloat64(connected.With(prometheus.Labels{"broker": "localhost:1883"})), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(connected.With(prometheus.Labels{"broker": "tcp://backup:1883"})), 0)
+
+	m.setConnected(0)
+	assert.InDelta(t, 0, testutil.ToFloat64(connected.With(prometheus.Labels{"broker": "tcp://backup:1883"})), 0)
+}
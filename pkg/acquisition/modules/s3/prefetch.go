@@ -0,0 +1,197 @@
+package s3acquisition
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+)
+
+const (
+	PrefetchBufferMemory = "memory"
+	PrefetchBufferDisk   = "disk"
+
+	defaultPrefetchMaxSize = 64 << 20
+)
+
+var prefetchDepth = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "cs_s3_prefetch_queue_depth",
+		Help: "Number of objects downloaded, or being downloaded, ahead of the one being read, per queue.",
+	},
+	[]string{"queue"},
+)
+
+// prefetchedObject is downloaded while the previous objects are read.
+type prefetchedObject struct {
+	S3Object
+	done chan struct{}
+	body io.ReadCloser // the content of the object, once done, unless err is set
+	err  error
+}
+
+// tempFile is removed when it's closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.File.Close()
+
+	if rmErr := os.Remove(f.Name()); rmErr != nil && err == nil {
+		err = rmErr
+	}
+
+	return err
+}
+
+func (s *S3Source) setPrefetchDepth(delta float64) {
+	if s.MetricsLevel == configuration.METRICS_NONE {
+		return
+	}
+
+	prefetchDepth.WithLabelValues(s.Config.SQSName).Add(delta)
+}
+
+// download buffers the whole object, in memory or in a temporary file according to
+// prefetch_buffer. With the memory buffer, the objects larger than prefetch_max_size
+// are written to a temporary file too.
+func (s *S3Source) download(obj *prefetchedObject) {
+	defer close(obj.done)
+
+	output, err := s.s3Client.GetObjectWithContext(s.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    aws.String(obj.Key),
+	})
+	if err != nil {
+		obj.err = fmt.Errorf("failed to get object %s/%s: %w", obj.Bucket, obj.Key, err)
+		return
+	}
+	defer output.Body.Close()
+
+	var body io.Reader = output.Body
+
+	maxSize := s.Config.PrefetchMaxSize
+
+	if s.Config.PrefetchBuffer == PrefetchBufferMemory && (output.ContentLength == nil || *output.ContentLength <= maxSize) {
+		content, err := io.ReadAll(io.LimitReader(output.Body, maxSize+1))
+		if err != nil {
+			obj.err = fmt.Errorf("failed to download object %s/%s: %w", obj.Bucket, obj.Key, err)
+			return
+		}
+
+		if int64(len(content)) <= maxSize {
+			obj.body = io.NopCloser(bytes.NewReader(content))
+			return
+		}
+
+		// without a content length, the object is found too large while reading it
+		body = io.MultiReader(bytes.NewReader(content), output.Body)
+	}
+
+	if s.Config.PrefetchBuffer == PrefetchBufferMemory {
+		s.logger.Debugf("object %s/%s is larger than prefetch_max_size, prefetching it to disk", obj.Bucket, obj.Key)
+	}
+
+	fd, err := os.CreateTemp(s.Config.PrefetchDir, "crowdsec-s3-*")
+	if err != nil {
+		obj.err = fmt.Errorf("failed to create prefetch file: %w", err)
+		return
+	}
+
+	f := tempFile{fd}
+
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		obj.err = fmt.Errorf("failed to download object %s/%s: %w", obj.Bucket, obj.Key, err)
+
+		return
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		obj.err = fmt.Errorf("failed to rewind prefetch file: %w", err)
+
+		return
+	}
+
+	obj.body = f
+}
+
+// prefetchManager replaces readManager with prefetch: the objects notified on the queue are
+// downloaded up to prefetch ahead of the one being read, and read in the order of the
+// notifications.
+func (s *S3Source) prefetchManager() {
+	logger := s.logger.WithField("method", "prefetchManager")
+
+	queue := make(chan *prefetchedObject, s.Config.Prefetch)
+
+	s.t.Go(func() error {
+		s.readPrefetched(queue)
+		return nil
+	})
+
+	defer close(queue)
+
+	for {
+		select {
+		case <-s.t.Dying():
+			logger.Infof("Shutting down S3 prefetch manager")
+			s.cancel()
+
+			return
+		case s3Object := <-s.readerChan:
+			obj := &prefetchedObject{S3Object: s3Object, done: make(chan struct{})}
+
+			s.setPrefetchDepth(1)
+
+			select {
+			case queue <- obj:
+			case <-s.t.Dying():
+				logger.Infof("Shutting down S3 prefetch manager")
+				s.setPrefetchDepth(-1)
+				s.cancel()
+
+				return
+			}
+
+			logger.Debugf("Prefetching file %s/%s", obj.Bucket, obj.Key)
+
+			go s.download(obj)
+		}
+	}
+}
+
+func (s *S3Source) readPrefetched(queue chan *prefetchedObject) {
+	logger := s.logger.WithField("method", "readPrefetched")
+
+	for obj := range queue {
+		s.setPrefetchDepth(-1)
+
+		<-obj.done
+
+		if obj.err != nil {
+			logger.Errorf("Error while reading file: %s", obj.err)
+			continue
+		}
+
+		// remove the downloads of the objects that won't be read
+		if s.t.Alive() {
+			logger.Debugf("Reading file %s/%s", obj.Bucket, obj.Key)
+
+			if err := s.readObject(obj.Bucket, obj.Key, obj.body); err != nil {
+				logger.Errorf("Error while reading file: %s", err)
+			}
+		}
+
+		if err := obj.body.Close(); err != nil {
+			logger.Errorf("Error while removing prefetched file: %s", err)
+		}
+	}
+}
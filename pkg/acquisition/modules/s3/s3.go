@@ -33,17 +33,23 @@ import (
 
 type S3Configuration struct {
 	configuration.DataSourceCommonCfg `yaml:",inline"`
-	AwsProfile                        *string `yaml:"aws_profile"`
-	AwsRegion                         string  `yaml:"aws_region"`
-	AwsEndpoint                       string  `yaml:"aws_endpoint"`
-	BucketName                        string  `yaml:"bucket_name"`
-	Prefix                            string  `yaml:"prefix"`
-	Key                               string  `yaml:"-"` // Only for DSN acquisition
-	PollingMethod                     string  `yaml:"polling_method"`
-	PollingInterval                   int     `yaml:"polling_interval"`
-	SQSName                           string  `yaml:"sqs_name"`
-	SQSFormat                         string  `yaml:"sqs_format"`
-	MaxBufferSize                     int     `yaml:"max_buffer_size"`
+	AwsProfile                        *string       `yaml:"aws_profile"`
+	AwsRegion                         string        `yaml:"aws_region"`
+	AwsEndpoint                       string        `yaml:"aws_endpoint"`
+	BucketName                        string        `yaml:"bucket_name"`
+	Prefix                            string        `yaml:"prefix"`
+	Key                               string        `yaml:"-"` // Only for DSN acquisition
+	PollingMethod                     string        `yaml:"polling_method"`
+	PollingInterval                   int           `yaml:"polling_interval"`
+	SQSName                           string        `yaml:"sqs_name"`
+	SQSFormat                         string        `yaml:"sqs_format"`
+	MaxBufferSize                     int           `yaml:"max_buffer_size"`
+	MinObjectAge                      time.Duration `yaml:"min_object_age"`       // skip the objects modified more recently, they may still be uploading
+	MaxObjectsPerPoll                 int           `yaml:"max_objects_per_poll"` // list only: the oldest new objects are read, the others on the next polls
+	Prefetch                          int           `yaml:"prefetch"`             // sqs only: number of objects downloaded ahead of the one being read
+	PrefetchBuffer                    string        `yaml:"prefetch_buffer"`      // memory (default) or disk
+	PrefetchDir                       string        `yaml:"prefetch_dir"`         // for the files of the prefetch, default: the temporary directory
+	PrefetchMaxSize                   int64         `yaml:"prefetch_max_size"`    // memory buffer only: larger objects are prefetched to disk, default is 64MiB
 }
 
 type S3Source struct {
@@ -123,6 +129,22 @@ var objectsRead = prometheus.NewCounterVec(
 	[]string{"bucket"},
 )
 
+var deferredObjects = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_s3_deferred_objects_total",
+		Help: "Number of times a new object was left for the next poll because of max_objects_per_poll, per bucket.",
+	},
+	[]string{"bucket"},
+)
+
+var freshObjects = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_s3_fresh_objects_skipped_total",
+		Help: "Number of times an object was skipped because it was more recent than min_object_age, per bucket.",
+	},
+	[]string{"bucket"},
+)
+
 var sqsMessagesReceived = prometheus.NewCounterVec(
 	prometheus.CounterOpts{
 		Name: "cs_s3_sqs_messages_total",
@@ -236,6 +258,40 @@ func (s *S3Source) getBucketContent() ([]*s3.Object, error) {
 	return bucketObjects, nil
 }
 
+// isFresh returns true if the object was modified less than min_object_age ago.
+func (s *S3Source) isFresh(object *s3.Object, now time.Time) bool {
+	if s.Config.MinObjectAge == 0 || !object.LastModified.After(now.Add(-s.Config.MinObjectAge)) {
+		return false
+	}
+	s.logger.Debugf("Skipping object %s, modified less than %s ago", *object.Key, s.Config.MinObjectAge)
+	if s.MetricsLevel != configuration.METRICS_NONE {
+		freshObjects.WithLabelValues(s.Config.BucketName).Inc()
+	}
+	return true
+}
+
+// limitObjects keeps the oldest max_objects_per_poll of the new objects, sorted from the newest
+// to the oldest. The objects modified at the same time as the last one kept are kept as well,
+// since the next poll only looks at the objects modified after it.
+func (s *S3Source) limitObjects(objects []*s3.Object) []*s3.Object {
+	if s.Config.MaxObjectsPerPoll == 0 || len(objects) <= s.Config.MaxObjectsPerPoll {
+		return objects
+	}
+
+	cut := len(objects) - s.Config.MaxObjectsPerPoll
+	for cut > 0 && objects[cut-1].LastModified.Equal(*objects[cut].LastModified) {
+		cut--
+	}
+
+	s.logger.Debugf("%d new objects left for the next poll (max_objects_per_poll: %d)", cut, s.Config.MaxObjectsPerPoll)
+
+	if s.MetricsLevel != configuration.METRICS_NONE {
+		deferredObjects.WithLabelValues(s.Config.BucketName).Add(float64(cut))
+	}
+
+	return objects[cut:]
+}
+
 func (s *S3Source) listPoll() error {
 	logger := s.logger.WithField("method", "listPoll")
 	ticker := time.NewTicker(time.Duration(s.Config.PollingInterval) * time.Second)
@@ -249,7 +305,7 @@ func (s *S3Source) listPoll() error {
 			s.cancel()
 			return nil
 		case <-ticker.C:
-			newObject := false
+			newestObjectDate := lastObjectDate
 			bucketObjects, err := s.getBucketContent()
 			if err != nil {
 				logger.Errorf("Error while getting bucket content: %s", err)
@@ -258,20 +314,29 @@ func (s *S3Source) listPoll() error {
 			if bucketObjects == nil {
 				continue
 			}
+			now := time.Now()
+			newObjects := make([]*s3.Object, 0)
 			for i := len(bucketObjects) - 1; i >= 0; i-- {
 				if !bucketObjects[i].LastModified.After(lastObjectDate) {
 					break
 				}
-				newObject = true
-				logger.Debugf("Found new object %s", *bucketObjects[i].Key)
+				// picked up by a later poll, once complete
+				if s.isFresh(bucketObjects[i], now) {
+					continue
+				}
+				newObjects = append(newObjects, bucketObjects[i])
+			}
+			for _, object := range s.limitObjects(newObjects) {
+				if object.LastModified.After(newestObjectDate) {
+					newestObjectDate = *object.LastModified
+				}
+				logger.Debugf("Found new object %s", *object.Key)
 				s.readerChan <- S3Object{
 					Bucket: s.Config.BucketName,
-					Key:    *bucketObjects[i].Key,
+					Key:    *object.Key,
 				}
 			}
-			if newObject {
-				lastObjectDate = *bucketObjects[len(bucketObjects)-1].LastModified
-			}
+			lastObjectDate = newestObjectDate
 		}
 	}
 }
@@ -408,14 +473,6 @@ func (s *S3Source) sqsPoll() error {
 
 func (s *S3Source) readFile(bucket string, key string) error {
 	// TODO: Handle SSE-C
-	var scanner *bufio.Scanner
-
-	logger := s.logger.WithFields(log.Fields{
-		"method": "readFile",
-		"bucket": bucket,
-		"key":    key,
-	})
-
 	output, err := s.s3Client.GetObjectWithContext(s.ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
@@ -425,24 +482,37 @@ func (s *S3Source) readFile(bucket string, key string) error {
 	}
 	defer output.Body.Close()
 
+	return s.readObject(bucket, key, output.Body)
+}
+
+// readObject sends the lines of the content of an object.
+func (s *S3Source) readObject(bucket string, key string, body io.Reader) error {
+	var scanner *bufio.Scanner
+
+	logger := s.logger.WithFields(log.Fields{
+		"method": "readObject",
+		"bucket": bucket,
+		"key":    key,
+	})
+
 	if strings.HasSuffix(key, ".gz") {
 		// This *might* be a gzipped file, but sometimes the SDK will decompress the data for us (it's not clear when it happens, only had the issue with cloudtrail logs)
 		header := make([]byte, 2)
-		_, err := output.Body.Read(header)
+		_, err := body.Read(header)
 		if err != nil {
 			return fmt.Errorf("failed to read header of object %s/%s: %w", bucket, key, err)
 		}
 		if header[0] == 0x1f && header[1] == 0x8b {
-			gz, err := gzip.NewReader(io.MultiReader(bytes.NewReader(header), output.Body))
+			gz, err := gzip.NewReader(io.MultiReader(bytes.NewReader(header), body))
 			if err != nil {
 				return fmt.Errorf("failed to create gzip reader for object %s/%s: %w", bucket, key, err)
 			}
 			scanner = bufio.NewScanner(gz)
 		} else {
-			scanner = bufio.NewScanner(io.MultiReader(bytes.NewReader(header), output.Body))
+			scanner = bufio.NewScanner(io.MultiReader(bytes.NewReader(header), body))
 		}
 	} else {
-		scanner = bufio.NewScanner(output.Body)
+		scanner = bufio.NewScanner(body)
 	}
 	if s.Config.MaxBufferSize > 0 {
 		s.logger.Infof("Setting max buffer size to %d", s.Config.MaxBufferSize)
@@ -490,11 +560,11 @@ func (s *S3Source) GetUuid() string {
 }
 
 func (s *S3Source) GetMetrics() []prometheus.Collector {
-	return []prometheus.Collector{linesRead, objectsRead, sqsMessagesReceived}
+	return []prometheus.Collector{linesRead, objectsRead, freshObjects, deferredObjects, sqsMessagesReceived, prefetchDepth}
 }
 
 func (s *S3Source) GetAggregMetrics() []prometheus.Collector {
-	return []prometheus.Collector{linesRead, objectsRead, sqsMessagesReceived}
+	return []prometheus.Collector{linesRead, objectsRead, freshObjects, deferredObjects, sqsMessagesReceived, prefetchDepth}
 }
 
 func (s *S3Source) UnmarshalConfig(yamlConfig []byte) error {
@@ -538,6 +608,51 @@ func (s *S3Source) UnmarshalConfig(yamlConfig []byte) error {
 		return fmt.Errorf("invalid sqs_format %s, must be empty, %s, %s or %s", s.Config.SQSFormat, SQSFormatEventBridge, SQSFormatS3Notification, SQSFormatSNS)
 	}
 
+	if s.Config.MinObjectAge < 0 {
+		return errors.New("min_object_age must be positive")
+	}
+
+	// the notifications are sent once the upload is complete
+	if s.Config.MinObjectAge > 0 && s.Config.PollingMethod == PollMethodSQS {
+		return errors.New("min_object_age is not supported with the sqs polling method")
+	}
+
+	if s.Config.MaxObjectsPerPoll < 0 {
+		return errors.New("max_objects_per_poll must be positive")
+	}
+
+	if s.Config.MaxObjectsPerPoll > 0 && s.Config.PollingMethod != PollMethodList {
+		return errors.New("max_objects_per_poll is only supported with the list polling method")
+	}
+
+	if s.Config.Prefetch < 0 {
+		return errors.New("prefetch must be positive")
+	}
+
+	if s.Config.Prefetch > 0 && s.Config.PollingMethod != PollMethodSQS {
+		return errors.New("prefetch is only supported with the sqs polling method")
+	}
+
+	switch s.Config.PrefetchBuffer {
+	case "":
+		s.Config.PrefetchBuffer = PrefetchBufferMemory
+	case PrefetchBufferMemory, PrefetchBufferDisk:
+	default:
+		return fmt.Errorf("invalid prefetch_buffer %s, must be %s or %s", s.Config.PrefetchBuffer, PrefetchBufferMemory, PrefetchBufferDisk)
+	}
+
+	if s.Config.PrefetchMaxSize < 0 {
+		return errors.New("prefetch_max_size must be positive")
+	}
+
+	if s.Config.PrefetchMaxSize > 0 && s.Config.PrefetchBuffer != PrefetchBufferMemory {
+		return errors.New("prefetch_max_size requires the memory prefetch_buffer")
+	}
+
+	if s.Config.PrefetchMaxSize == 0 {
+		s.Config.PrefetchMaxSize = defaultPrefetchMaxSize
+	}
+
 	return nil
 }
 
@@ -623,6 +738,18 @@ func (s *S3Source) ConfigureByDSN(dsn string, labels map[string]string, logger *
 				}
 				s.logger.Debugf("Setting max buffer size to %d", maxBufferSize)
 				s.Config.MaxBufferSize = maxBufferSize
+			case "min_object_age":
+				if len(value) != 1 {
+					return errors.New("expected zero or one value for 'min_object_age'")
+				}
+				minObjectAge, err := time.ParseDuration(value[0])
+				if err != nil {
+					return fmt.Errorf("invalid value for 'min_object_age': %w", err)
+				}
+				if minObjectAge < 0 {
+					return errors.New("min_object_age must be positive")
+				}
+				s.Config.MinObjectAge = minObjectAge
 			default:
 				return fmt.Errorf("unknown parameter %s", key)
 			}
@@ -684,7 +811,11 @@ func (s *S3Source) OneShotAcquisition(ctx context.Context, out chan types.Event,
 		if err != nil {
 			return err
 		}
+		now := time.Now()
 		for _, object := range objects {
+			if s.isFresh(object, now) {
+				continue
+			}
 			err := s.readFile(s.Config.BucketName, *object.Key)
 			if err != nil {
 				return err
@@ -702,7 +833,11 @@ func (s *S3Source) StreamingAcquisition(ctx context.Context, out chan types.Even
 	s.ctx, s.cancel = context.WithCancel(ctx)
 	s.logger.Infof("starting acquisition of %s/%s", s.Config.BucketName, s.Config.Prefix)
 	t.Go(func() error {
-		s.readManager()
+		if s.Config.Prefetch > 0 {
+			s.prefetchManager()
+		} else {
+			s.readManager()
+		}
 		return nil
 	})
 	if s.Config.PollingMethod == PollMethodSQS {
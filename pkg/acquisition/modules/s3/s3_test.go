@@ -3,6 +3,9 @@ package s3acquisition
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -16,6 +19,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/tomb.v2"
 
 	"github.com/crowdsecurity/go-cs-lib/cstest"
@@ -73,6 +77,88 @@ sqs_name: foobar
 `,
 			expectedErr: "bucket_name and sqs_name are mutually exclusive",
 		},
+		{
+			name: "min_object_age with sqs",
+			config: `
+source: s3
+polling_method: sqs
+sqs_name: foobar
+min_object_age: 1m
+`,
+			expectedErr: "min_object_age is not supported with the sqs polling method",
+		},
+		{
+			name: "max_objects_per_poll with sqs",
+			config: `
+source: s3
+polling_method: sqs
+sqs_name: foobar
+max_objects_per_poll: 10
+`,
+			expectedErr: "max_objects_per_poll is only supported with the list polling method",
+		},
+		{
+			name: "negative max_objects_per_poll",
+			config: `
+source: s3
+bucket_name: foobar
+max_objects_per_poll: -1
+`,
+			expectedErr: "max_objects_per_poll must be positive",
+		},
+		{
+			name: "prefetch without sqs",
+			config: `
+source: s3
+bucket_name: foobar
+prefetch: 4
+`,
+			expectedErr: "prefetch is only supported with the sqs polling method",
+		},
+		{
+			name: "negative prefetch",
+			config: `
+source: s3
+polling_method: sqs
+sqs_name: foobar
+prefetch: -1
+`,
+			expectedErr: "prefetch must be positive",
+		},
+		{
+			name: "invalid prefetch_buffer",
+			config: `
+source: s3
+polling_method: sqs
+sqs_name: foobar
+prefetch: 4
+prefetch_buffer: swap
+`,
+			expectedErr: "invalid prefetch_buffer swap, must be memory or disk",
+		},
+		{
+			name: "negative prefetch_max_size",
+			config: `
+source: s3
+polling_method: sqs
+sqs_name: foobar
+prefetch: 4
+prefetch_max_size: -1
+`,
+			expectedErr: "prefetch_max_size must be positive",
+		},
+		{
+			name: "prefetch_max_size with disk buffer",
+			config: `
+source: s3
+polling_method: sqs
+sqs_name: foobar
+prefetch: 4
+prefetch_buffer: disk
+prefetch_max_size: 1024
+`,
+			expectedErr: "prefetch_max_size requires the memory prefetch_buffer",
+		},
 	}
 
 	for _, test := range tests {
@@ -150,6 +236,16 @@ var mockListOutput map[string][]*s3.Object = map[string][]*s3.Object{
 			LastModified: aws.Time(time.Now().Add(time.Hour)),
 		},
 	},
+	"bucket_fresh": {
+		{
+			Key:          aws.String("complete.log"),
+			LastModified: aws.Time(time.Now().Add(-time.Hour)),
+		},
+		{
+			Key:          aws.String("uploading.log"),
+			LastModified: aws.Time(time.Now()),
+		},
+	},
 }
 
 func (m mockS3Client) ListObjectsV2WithContext(ctx context.Context, input *s3.ListObjectsV2Input, options ...request.Option) (*s3.ListObjectsV2Output, error) {
@@ -262,6 +358,13 @@ func TestDSNAcquis(t *testing.T) {
 			expectedPrefix:     "prefix/",
 			expectedCount:      4,
 		},
+		{
+			name:               "min object age",
+			dsn:                "s3://bucket_fresh/?min_object_age=5m",
+			expectedBucketName: "bucket_fresh",
+			expectedPrefix:     "",
+			expectedCount:      2,
+		},
 	}
 
 	for _, test := range tests {
@@ -332,6 +435,17 @@ prefix: foo/
 `,
 			expectedCount: 4,
 		},
+		{
+			name: "min object age",
+			config: `
+source: s3
+bucket_name: bucket_no_prefix
+polling_method: list
+polling_interval: 1
+min_object_age: 1m
+`,
+			expectedCount: 0,
+		},
 	}
 
 	for _, test := range tests {
@@ -476,3 +590,143 @@ sqs_name: test
 		})
 	}
 }
+
+func TestSQSPrefetch(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+
+	for _, buffer := range []string{PrefetchBufferMemory, PrefetchBufferDisk} {
+		t.Run(buffer, func(t *testing.T) {
+			config := `
+source: s3
+polling_method: sqs
+sqs_name: test
+prefetch: 2
+prefetch_buffer: ` + buffer + `
+`
+			if buffer == PrefetchBufferDisk {
+				config += "prefetch_dir: " + dir + "\n"
+			}
+
+			f := S3Source{}
+			err := f.Configure([]byte(config), log.NewEntry(log.New()), configuration.METRICS_NONE)
+			require.NoError(t, err)
+
+			counter := int32(0)
+			f.s3Client = mockS3Client{}
+			f.sqsClient = mockSQSClient{counter: &counter}
+
+			out := make(chan types.Event)
+			tb := tomb.Tomb{}
+
+			err = f.StreamingAcquisition(ctx, out, &tb)
+			require.NoError(t, err)
+
+			var lines []string
+
+			for range 2 {
+				select {
+				case evt := <-out:
+					lines = append(lines, evt.Line.Raw)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("timeout, got %d lines", len(lines))
+				}
+			}
+
+			tb.Kill(nil)
+			require.NoError(t, tb.Wait())
+
+			assert.Equal(t, []string{"foo", "bar"}, lines)
+
+			// the prefetched files are removed once read
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+}
+
+func TestPrefetchMaxSize(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, tc := range []struct {
+		maxSize int
+		onDisk  bool
+	}{
+		{maxSize: 7, onDisk: false},
+		{maxSize: 4, onDisk: true},
+	} {
+		t.Run(strconv.Itoa(tc.maxSize), func(t *testing.T) {
+			f := S3Source{}
+			err := f.Configure([]byte(fmt.Sprintf(`
+source: s3
+polling_method: sqs
+sqs_name: test
+prefetch: 2
+prefetch_dir: %s
+prefetch_max_size: %d
+`, dir, tc.maxSize)), log.NewEntry(log.New()), configuration.METRICS_NONE)
+			require.NoError(t, err)
+
+			f.ctx = t.Context()
+			f.s3Client = mockS3Client{}
+
+			// the object is "foo\nbar", 7 bytes
+			obj := &prefetchedObject{S3Object: S3Object{Bucket: "my_bucket", Key: "foo.log"}, done: make(chan struct{})}
+			f.download(obj)
+			require.NoError(t, obj.err)
+
+			_, onDisk := obj.body.(tempFile)
+			assert.Equal(t, tc.onDisk, onDisk)
+
+			content, err := io.ReadAll(obj.body)
+			require.NoError(t, err)
+			assert.Equal(t, "foo\nbar", string(content))
+
+			require.NoError(t, obj.body.Close())
+
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+}
+
+func TestLimitObjects(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// newest first, as found by listPoll
+	objects := []*s3.Object{
+		{Key: aws.String("e"), LastModified: aws.Time(base.Add(4 * time.Second))},
+		{Key: aws.String("d"), LastModified: aws.Time(base.Add(3 * time.Second))},
+		{Key: aws.String("c"), LastModified: aws.Time(base.Add(2 * time.Second))},
+		{Key: aws.String("b"), LastModified: aws.Time(base.Add(2 * time.Second))},
+		{Key: aws.String("a"), LastModified: aws.Time(base)},
+	}
+
+	keys := func(objects []*s3.Object) []string {
+		ret := []string{}
+		for _, object := range objects {
+			ret = append(ret, *object.Key)
+		}
+
+		return ret
+	}
+
+	for _, tc := range []struct {
+		max      int
+		expected []string
+	}{
+		{max: 0, expected: []string{"e", "d", "c", "b", "a"}},
+		{max: 1, expected: []string{"a"}},
+		// b and c have the same date, the next poll would skip c
+		{max: 2, expected: []string{"c", "b", "a"}},
+		{max: 4, expected: []string{"d", "c", "b", "a"}},
+		{max: 10, expected: []string{"e", "d", "c", "b", "a"}},
+	} {
+		s := S3Source{logger: log.NewEntry(log.New())}
+		s.Config.MaxObjectsPerPoll = tc.max
+
+		assert.Equal(t, tc.expected, keys(s.limitObjects(objects)), "max_objects_per_poll: %d", tc.max)
+	}
+}
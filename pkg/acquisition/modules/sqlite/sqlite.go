@@ -0,0 +1,667 @@
+package sqliteacquisition
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/trace"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/statefile"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const (
+	dataSourceName      = "sqlite"
+	defaultIDColumn     = "id"
+	defaultBatchSize    = 1000
+	defaultPollInterval = time.Second
+	defaultBusyTimeout  = 5 * time.Second
+	defaultMaxRetries   = 5
+	retryBackoff        = 200 * time.Millisecond
+	maxRetryBackoff     = 5 * time.Second
+
+	// what to do with the rows once they are sent
+	processedKeep   = "keep"
+	processedDelete = "delete"
+	processedMark   = "mark"
+)
+
+var linesRead = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_sqlitesource_hits_total",
+		Help: "Total rows that were read from the table",
+	},
+	[]string{"db_path", "table"})
+
+var busyRetries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_sqlitesource_busy_retries_total",
+		Help: "Total queries retried because the database was locked",
+	},
+	[]string{"db_path", "table"})
+
+type SqliteConfiguration struct {
+	DBPath          string        `yaml:"db_path"`
+	Table           string        `yaml:"table"`
+	IDColumn        string        `yaml:"id_column"`        // integer column increasing with the inserts, usually the rowid alias
+	MessageColumn   string        `yaml:"message_column"`   // column sent as the log line
+	MetaColumns     []string      `yaml:"meta_columns"`     // columns set as sqlite_<column> metadata, default: all the others
+	OnProcessed     string        `yaml:"on_processed"`     // keep, delete or mark the rows once sent
+	ProcessedColumn string        `yaml:"processed_column"` // set to 1 with on_processed: mark, the rows where it's not 0 are skipped
+	BatchSize       int           `yaml:"batch_size"`
+	PollInterval    time.Duration `yaml:"poll_interval"`
+	BusyTimeout     time.Duration `yaml:"busy_timeout"` // how long sqlite waits for a lock before failing a query
+	MaxRetries      *int          `yaml:"max_retries"`  // retries of a query failing because of a lock
+	StateFile       string        `yaml:"state_file"`   // keeps the last id read across restarts
+
+	statefile.Config                  `yaml:",inline"`
+	configuration.DataSourceCommonCfg `yaml:",inline"`
+}
+
+type SqliteSource struct {
+	metricsLevel int
+	Config       SqliteConfiguration
+
+	logger *log.Entry
+	db     *sql.DB
+	state  *statefile.File
+
+	selectQuery    string
+	processedQuery string
+}
+
+// sqliteState is persisted in the state file.
+type sqliteState struct {
+	LastID int64 `json:"last_id"`
+}
+
+type row struct {
+	id      int64
+	message *string
+	meta    map[string]string
+}
+
+func (s *SqliteSource) GetUuid() string {
+	return s.Config.UniqueId
+}
+
+func (s *SqliteSource) UnmarshalConfig(yamlConfig []byte) error {
+	s.Config = SqliteConfiguration{}
+
+	err := yaml.UnmarshalWithOptions(yamlConfig, &s.Config, yaml.Strict())
+	if err != nil {
+		return fmt.Errorf("cannot parse %s datasource configuration: %s", dataSourceName, yaml.FormatError(err, false, false))
+	}
+
+	if s.Config.Mode == "" {
+		s.Config.Mode = configuration.TAIL_MODE
+	}
+
+	return s.validate()
+}
+
+func (s *SqliteSource) validate() error {
+	if s.Config.DBPath == "" {
+		return errors.New("db_path is mandatory")
+	}
+
+	if s.Config.Table == "" {
+		return errors.New("table is mandatory")
+	}
+
+	if s.Config.MessageColumn == "" {
+		return errors.New("message_column is mandatory")
+	}
+
+	if s.Config.Mode != configuration.TAIL_MODE && s.Config.Mode != configuration.CAT_MODE {
+		return fmt.Errorf("unsupported mode %s for %s datasource", s.Config.Mode, dataSourceName)
+	}
+
+	if s.Config.IDColumn == "" {
+		s.Config.IDColumn = defaultIDColumn
+	}
+
+	switch s.Config.OnProcessed {
+	case "":
+		s.Config.OnProcessed = processedKeep
+	case processedKeep, processedDelete:
+	case processedMark:
+		if s.Config.ProcessedColumn == "" {
+			return errors.New("processed_column is mandatory with on_processed: mark")
+		}
+	default:
+		return fmt.Errorf("invalid on_processed '%s', must be one of keep, delete, mark", s.Config.OnProcessed)
+	}
+
+	if s.Config.ProcessedColumn != "" && s.Config.OnProcessed != processedMark {
+		return errors.New("processed_column can only be used with on_processed: mark")
+	}
+
+	if s.Config.BatchSize < 0 {
+		return errors.New("batch_size must be positive")
+	}
+
+	if s.Config.BatchSize == 0 {
+		s.Config.BatchSize = defaultBatchSize
+	}
+
+	if s.Config.PollInterval < 0 {
+		return errors.New("poll_interval must be positive")
+	}
+
+	if s.Config.PollInterval == 0 {
+		s.Config.PollInterval = defaultPollInterval
+	}
+
+	if s.Config.BusyTimeout < 0 {
+		return errors.New("busy_timeout must be positive")
+	}
+
+	if s.Config.BusyTimeout == 0 {
+		s.Config.BusyTimeout = defaultBusyTimeout
+	}
+
+	if s.Config.MaxRetries == nil {
+		retries := defaultMaxRetries
+		s.Config.MaxRetries = &retries
+	}
+
+	if *s.Config.MaxRetries < 0 {
+		return errors.New("max_retries must be positive")
+	}
+
+	if err := s.Config.Config.Validate(); err != nil {
+		return err
+	}
+
+	s.buildQueries()
+
+	return nil
+}
+
+// quoteIdentifier quotes a table or column name from the configuration.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (s *SqliteSource) buildQueries() {
+	table := quoteIdentifier(s.Config.Table)
+	id := quoteIdentifier(s.Config.IDColumn)
+
+	unprocessed := ""
+
+	switch s.Config.OnProcessed {
+	case processedDelete:
+		s.processedQuery = fmt.Sprintf("DELETE FROM %s WHERE %s > ? AND %s <= ?", table, id, id)
+	case processedMark:
+		processed := quoteIdentifier(s.Config.ProcessedColumn)
+		unprocessed = fmt.Sprintf(" AND COALESCE(%s, 0) = 0", processed)
+		s.processedQuery = fmt.Sprintf("UPDATE %s SET %s = 1 WHERE %s > ? AND %s <= ?", table, processed, id, id)
+	}
+
+	s.selectQuery = fmt.Sprintf("SELECT * FROM %s WHERE %s > ?%s ORDER BY %s LIMIT ?", table, id, unprocessed, id)
+}
+
+// dsn opens the database read-only, unless the rows must be deleted or marked. It is never
+// created, it belongs to the tool writing the rows.
+func (s *SqliteSource) dsn() string {
+	params := url.Values{}
+	params.Set("_busy_timeout", strconv.FormatInt(s.Config.BusyTimeout.Milliseconds(), 10))
+
+	if s.Config.OnProcessed == processedKeep {
+		params.Set("mode", "ro")
+	} else {
+		params.Set("mode", "rw")
+	}
+
+	return "file:" + s.Config.DBPath + "?" + params.Encode()
+}
+
+func (s *SqliteSource) open() error {
+	db, err := sql.Open("sqlite3", s.dsn())
+	if err != nil {
+		return fmt.Errorf("while opening %s: %w", s.Config.DBPath, err)
+	}
+
+	// a single connection, to avoid competing with the writer for the locks
+	db.SetMaxOpenConns(1)
+
+	s.db = db
+
+	if s.Config.StateFile != "" {
+		s.state = statefile.New(s.Config.StateFile, s.Config.Config, s.logger)
+	}
+
+	return nil
+}
+
+func (s *SqliteSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLevel int) error {
+	s.logger = logger
+	s.metricsLevel = metricsLevel
+
+	if err := s.UnmarshalConfig(yamlConfig); err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+// ConfigureByDSN handles sqlite:///path/to/db?table=...&message_column=...
+func (s *SqliteSource) ConfigureByDSN(dsn string, labels map[string]string, logger *log.Entry, uuid string) error {
+	s.logger = logger
+	s.Config = SqliteConfiguration{}
+	s.Config.Mode = configuration.CAT_MODE
+	s.Config.Labels = labels
+	s.Config.UniqueId = uuid
+
+	if !strings.HasPrefix(dsn, dataSourceName+"://") {
+		return fmt.Errorf("invalid DSN %s for %s source, must start with %s://", dsn, dataSourceName, dataSourceName)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return fmt.Errorf("while parsing dsn '%s': %w", dsn, err)
+	}
+
+	if u.Path == "" {
+		return errors.New("empty database path")
+	}
+
+	s.Config.DBPath = u.Path
+
+	params := u.Query()
+	s.Config.Table = params.Get("table")
+	s.Config.IDColumn = params.Get("id_column")
+	s.Config.MessageColumn = params.Get("message_column")
+	s.Config.OnProcessed = params.Get("on_processed")
+	s.Config.ProcessedColumn = params.Get("processed_column")
+
+	if batchSize := params.Get("batch_size"); batchSize != "" {
+		if s.Config.BatchSize, err = strconv.Atoi(batchSize); err != nil {
+			return fmt.Errorf("invalid batch_size in dsn: %w", err)
+		}
+	}
+
+	if logLevel := params.Get("log_level"); logLevel != "" {
+		level, err := log.ParseLevel(logLevel)
+		if err != nil {
+			return fmt.Errorf("invalid log_level in dsn: %w", err)
+		}
+
+		s.Config.LogLevel = &level
+		s.logger.Logger.SetLevel(level)
+	}
+
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+func (s *SqliteSource) GetMode() string {
+	return s.Config.Mode
+}
+
+func (*SqliteSource) GetName() string {
+	return dataSourceName
+}
+
+func (*SqliteSource) CanRun() error {
+	return nil
+}
+
+func (*SqliteSource) GetMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, busyRetries}
+}
+
+func (*SqliteSource) GetAggregMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, busyRetries}
+}
+
+func (s *SqliteSource) Dump() any {
+	return s
+}
+
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// withRetry runs fn again when the database is locked by the writer. The driver already waits
+// for busy_timeout before failing, the retries cover the writers holding the lock longer.
+func (s *SqliteSource) withRetry(ctx context.Context, fn func() error) error {
+	backoff := retryBackoff
+
+	for retry := 0; ; retry++ {
+		err := fn()
+		if err == nil || !isBusy(err) {
+			return err
+		}
+
+		if retry >= *s.Config.MaxRetries {
+			return fmt.Errorf("database still locked after %d retries: %w", retry, err)
+		}
+
+		if s.metricsLevel != configuration.METRICS_NONE {
+			busyRetries.With(prometheus.Labels{"db_path": s.Config.DBPath, "table": s.Config.Table}).Inc()
+		}
+
+		s.logger.Debugf("database is locked, retry %d of %d in %s", retry+1, *s.Config.MaxRetries, backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+// columnString converts a column value to a string, false for NULL.
+func columnString(v any) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case []byte:
+		return string(v), true
+	case string:
+		return v, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// readBatch returns the rows after lastID, up to batch_size.
+func (s *SqliteSource) readBatch(ctx context.Context, lastID int64) ([]row, error) {
+	rows, err := s.db.QueryContext(ctx, s.selectQuery, lastID, s.Config.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	idIndex := slices.Index(columns, s.Config.IDColumn)
+	if idIndex < 0 {
+		return nil, fmt.Errorf("column %s not found in table %s", s.Config.IDColumn, s.Config.Table)
+	}
+
+	if !slices.Contains(columns, s.Config.MessageColumn) {
+		return nil, fmt.Errorf("column %s not found in table %s", s.Config.MessageColumn, s.Config.Table)
+	}
+
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	ret := []row{}
+
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		id, ok := values[idIndex].(int64)
+		if !ok {
+			return nil, fmt.Errorf("column %s must be an integer, got %T", s.Config.IDColumn, values[idIndex])
+		}
+
+		r := row{id: id, meta: map[string]string{}}
+
+		for i, column := range columns {
+			value, ok := columnString(values[i])
+
+			switch {
+			case column == s.Config.MessageColumn:
+				if ok {
+					r.message = &value
+				}
+			case !ok:
+			case len(s.Config.MetaColumns) == 0 || slices.Contains(s.Config.MetaColumns, column):
+				r.meta["sqlite_"+column] = value
+			}
+		}
+
+		ret = append(ret, r)
+	}
+
+	return ret, rows.Err()
+}
+
+func (s *SqliteSource) emit(r row, out chan types.Event) {
+	if r.message == nil {
+		s.logger.Debugf("row %d has a NULL %s, skipping", r.id, s.Config.MessageColumn)
+		return
+	}
+
+	l := types.Line{
+		Raw:     *r.message,
+		Labels:  s.Config.Labels,
+		Time:    time.Now().UTC(),
+		Src:     s.Config.DBPath,
+		Process: true,
+		Module:  s.GetName(),
+	}
+
+	if s.metricsLevel != configuration.METRICS_NONE {
+		linesRead.With(prometheus.Labels{"db_path": s.Config.DBPath, "table": s.Config.Table}).Inc()
+	}
+
+	evt := types.MakeEvent(s.Config.UseTimeMachine, types.LOG, true)
+	evt.Line = l
+	evt.Meta["sqlite_table"] = s.Config.Table
+
+	for key, value := range r.meta {
+		evt.Meta[key] = value
+	}
+
+	out <- evt
+}
+
+// poll sends the rows after lastID, batch by batch, deletes or marks them, and returns the id of
+// the last one. The rows are only deleted or marked once sent.
+func (s *SqliteSource) poll(ctx context.Context, lastID int64, out chan types.Event) (int64, error) {
+	for {
+		var batch []row
+
+		err := s.withRetry(ctx, func() error {
+			var err error
+
+			batch, err = s.readBatch(ctx, lastID)
+
+			return err
+		})
+		if err != nil {
+			return lastID, fmt.Errorf("while reading %s: %w", s.Config.Table, err)
+		}
+
+		if len(batch) == 0 {
+			return lastID, nil
+		}
+
+		for _, r := range batch {
+			s.emit(r, out)
+		}
+
+		batchLast := batch[len(batch)-1].id
+
+		if s.processedQuery != "" {
+			err := s.withRetry(ctx, func() error {
+				_, err := s.db.ExecContext(ctx, s.processedQuery, lastID, batchLast)
+				return err
+			})
+			if err != nil {
+				// the rows are read again if the state is not saved
+				return lastID, fmt.Errorf("while updating processed rows of %s: %w", s.Config.Table, err)
+			}
+		}
+
+		lastID = batchLast
+
+		s.saveState(lastID)
+
+		if len(batch) < s.Config.BatchSize {
+			return lastID, nil
+		}
+	}
+}
+
+func (s *SqliteSource) saveState(lastID int64) {
+	if s.state == nil {
+		return
+	}
+
+	if err := s.state.Save(sqliteState{LastID: lastID}); err != nil {
+		s.logger.Errorf("while saving state to %s: %s", s.state.Path(), err)
+	}
+}
+
+// startID returns the id after which the rows are read: the one saved in the state file, or the
+// current last row when tailing a table whose rows are kept, to not read the whole history.
+func (s *SqliteSource) startID(ctx context.Context) (int64, error) {
+	if s.state != nil {
+		state := sqliteState{}
+
+		found, err := s.state.Load(&state)
+		if err != nil {
+			s.logger.Warnf("while loading state from %s: %s", s.state.Path(), err)
+		}
+
+		if found {
+			return state.LastID, nil
+		}
+	}
+
+	if s.Config.Mode != configuration.TAIL_MODE || s.Config.OnProcessed != processedKeep {
+		return 0, nil
+	}
+
+	var lastID sql.NullInt64
+
+	query := fmt.Sprintf("SELECT MAX(%s) FROM %s", quoteIdentifier(s.Config.IDColumn), quoteIdentifier(s.Config.Table))
+
+	err := s.withRetry(ctx, func() error {
+		return s.db.QueryRowContext(ctx, query).Scan(&lastID)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("while reading the last id of %s: %w", s.Config.Table, err)
+	}
+
+	return lastID.Int64, nil
+}
+
+// tombContext is canceled when the tomb is dying.
+func tombContext(ctx context.Context, t *tomb.Tomb) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		select {
+		case <-t.Dying():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// OneShotAcquisition reads the rows present in the table, and returns when done.
+func (s *SqliteSource) OneShotAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	defer s.db.Close()
+
+	ctx, cancel := tombContext(ctx, t)
+	defer cancel()
+
+	lastID, err := s.startID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.poll(ctx, lastID, out); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		return err
+	}
+
+	s.logger.Infof("%s acquisition done", dataSourceName)
+
+	return nil
+}
+
+// StreamingAcquisition polls the table for the rows with an id greater than the last one read.
+func (s *SqliteSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	ctx, cancel := tombContext(ctx, t)
+
+	t.Go(func() error {
+		defer trace.CatchPanic("crowdsec/acquis/sqlite/live")
+		defer cancel()
+		defer s.db.Close()
+
+		ticker := time.NewTicker(s.Config.PollInterval)
+		defer ticker.Stop()
+
+		started := false
+
+		var (
+			lastID int64
+			err    error
+		)
+
+		for {
+			if !started {
+				// the table may not exist yet
+				lastID, err = s.startID(ctx)
+				started = err == nil
+			}
+
+			if started {
+				lastID, err = s.poll(ctx, lastID, out)
+			}
+
+			if err != nil && ctx.Err() == nil {
+				s.logger.Errorf("%s", err)
+			}
+
+			select {
+			case <-t.Dying():
+				s.logger.Infof("%s datasource stopping", dataSourceName)
+				return nil
+			case <-ticker.C:
+			}
+		}
+	})
+
+	return nil
+}
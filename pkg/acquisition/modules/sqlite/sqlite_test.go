@@ -0,0 +1,384 @@
+package sqliteacquisition
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		config      string
+		expectedErr string
+	}{
+		{
+			config:      `foobar: asd`,
+			expectedErr: `cannot parse sqlite datasource configuration: [1:1] unknown field "foobar"`,
+		},
+		{
+			config: `
+source: sqlite
+table: events
+message_column: message`,
+			expectedErr: "db_path is mandatory",
+		},
+		{
+			config: `
+source: sqlite
+db_path: /tmp/queue.db
+message_column: message`,
+			expectedErr: "table is mandatory",
+		},
+		{
+			config: `
+source: sqlite
+db_path: /tmp/queue.db
+table: events`,
+			expectedErr: "message_column is mandatory",
+		},
+		{
+			config: `
+source: sqlite
+db_path: /tmp/queue.db
+table: events
+message_column: message
+on_processed: archive`,
+			expectedErr: "invalid on_processed 'archive', must be one of keep, delete, mark",
+		},
+		{
+			config: `
+source: sqlite
+db_path: /tmp/queue.db
+table: events
+message_column: message
+on_processed: mark`,
+			expectedErr: "processed_column is mandatory with on_processed: mark",
+		},
+		{
+			config: `
+source: sqlite
+db_path: /tmp/queue.db
+table: events
+message_column: message
+processed_column: done`,
+			expectedErr: "processed_column can only be used with on_processed: mark",
+		},
+		{
+			config: `
+source: sqlite
+db_path: /tmp/queue.db
+table: events
+message_column: message
+max_retries: -1`,
+			expectedErr: "max_retries must be positive",
+		},
+		{
+			config: `
+source: sqlite
+db_path: /tmp/queue.db
+table: events
+id_column: seq
+message_column: message
+meta_columns: [host]
+on_processed: mark
+processed_column: done
+batch_size: 10
+poll_interval: 5s
+busy_timeout: 1s
+max_retries: 0
+state_file: /tmp/queue.state
+state_compress: true`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.config, func(t *testing.T) {
+			s := SqliteSource{}
+			err := s.Configure([]byte(tc.config), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestConfigureByDSN(t *testing.T) {
+	tests := []struct {
+		dsn         string
+		expectedErr string
+	}{
+		{
+			dsn:         "file:///tmp/queue.db",
+			expectedErr: "invalid DSN file:///tmp/queue.db for sqlite source, must start with sqlite://",
+		},
+		{
+			dsn:         "sqlite:///tmp/queue.db?message_column=message",
+			expectedErr: "table is mandatory",
+		},
+		{
+			dsn:         "sqlite:///tmp/queue.db?table=events&message_column=message&batch_size=foo",
+			expectedErr: "invalid batch_size in dsn",
+		},
+		{
+			dsn: "sqlite:///tmp/queue.db?table=events&message_column=message&on_processed=delete&log_level=debug",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.dsn, func(t *testing.T) {
+			s := SqliteSource{}
+			err := s.ConfigureByDSN(tc.dsn, map[string]string{"type": "test"}, log.WithField("type", dataSourceName), "")
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func newQueue(t *testing.T, rows int) (string, *sql.DB) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE events (id INTEGER PRIMARY KEY, message TEXT, host TEXT, done INTEGER DEFAULT 0)`)
+	require.NoError(t, err)
+
+	insert(t, db, 1, rows)
+
+	return path, db
+}
+
+func insert(t *testing.T, db *sql.DB, from int, to int) {
+	for i := from; i <= to; i++ {
+		_, err := db.Exec(`INSERT INTO events (message, host) VALUES (?, ?)`, fmt.Sprintf("line %d", i), "web")
+		require.NoError(t, err)
+	}
+}
+
+func count(t *testing.T, db *sql.DB, query string) int {
+	var n int
+
+	require.NoError(t, db.QueryRow(query).Scan(&n))
+
+	return n
+}
+
+func oneShot(t *testing.T, config string) []types.Event {
+	s := SqliteSource{}
+	err := s.Configure([]byte(config), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	out := make(chan types.Event, 100)
+	tmb := tomb.Tomb{}
+
+	require.NoError(t, s.OneShotAcquisition(t.Context(), out, &tmb))
+	close(out)
+
+	events := []types.Event{}
+	for evt := range out {
+		events = append(events, evt)
+	}
+
+	return events
+}
+
+func lines(events []types.Event) []string {
+	ret := []string{}
+	for _, evt := range events {
+		ret = append(ret, evt.Line.Raw)
+	}
+
+	return ret
+}
+
+func TestOneShotAcquisition(t *testing.T) {
+	path, db := newQueue(t, 5)
+
+	events := oneShot(t, fmt.Sprintf(`
+source: sqlite
+mode: cat
+db_path: %s
+table: events
+message_column: message
+batch_size: 2
+labels:
+  type: test`, path))
+
+	assert.Equal(t, []string{"line 1", "line 2", "line 3", "line 4", "line 5"}, lines(events))
+	assert.Equal(t, "web", events[0].Meta["sqlite_host"])
+	assert.Equal(t, "1", events[0].Meta["sqlite_id"])
+	assert.Equal(t, "events", events[0].Meta["sqlite_table"])
+	assert.Equal(t, "test", events[0].Line.Labels["type"])
+	assert.NotContains(t, events[0].Meta, "sqlite_message")
+
+	// the rows are kept
+	assert.Equal(t, 5, count(t, db, "SELECT COUNT(*) FROM events"))
+}
+
+func TestOnProcessed(t *testing.T) {
+	t.Run("delete", func(t *testing.T) {
+		path, db := newQueue(t, 3)
+
+		config := fmt.Sprintf(`
+source: sqlite
+mode: cat
+db_path: %s
+table: events
+message_column: message
+meta_columns: [host]
+on_processed: delete
+batch_size: 2`, path)
+
+		events := oneShot(t, config)
+		assert.Equal(t, []string{"line 1", "line 2", "line 3"}, lines(events))
+		assert.NotContains(t, events[0].Meta, "sqlite_id")
+		assert.Equal(t, 0, count(t, db, "SELECT COUNT(*) FROM events"))
+
+		insert(t, db, 4, 4)
+		assert.Equal(t, []string{"line 4"}, lines(oneShot(t, config)))
+	})
+
+	t.Run("mark", func(t *testing.T) {
+		path, db := newQueue(t, 3)
+
+		config := fmt.Sprintf(`
+source: sqlite
+mode: cat
+db_path: %s
+table: events
+message_column: message
+on_processed: mark
+processed_column: done`, path)
+
+		assert.Equal(t, []string{"line 1", "line 2", "line 3"}, lines(oneShot(t, config)))
+		assert.Equal(t, 3, count(t, db, "SELECT COUNT(*) FROM events WHERE done = 1"))
+
+		insert(t, db, 4, 4)
+		assert.Equal(t, []string{"line 4"}, lines(oneShot(t, config)))
+	})
+}
+
+func TestStateFile(t *testing.T) {
+	path, db := newQueue(t, 2)
+
+	config := fmt.Sprintf(`
+source: sqlite
+mode: cat
+db_path: %s
+table: events
+message_column: message
+state_file: %s`, path, filepath.Join(t.TempDir(), "sqlite.state"))
+
+	assert.Equal(t, []string{"line 1", "line 2"}, lines(oneShot(t, config)))
+
+	insert(t, db, 3, 3)
+	assert.Equal(t, []string{"line 3"}, lines(oneShot(t, config)))
+}
+
+func TestLockedDatabase(t *testing.T) {
+	path, db := newQueue(t, 2)
+
+	config := `
+source: sqlite
+mode: cat
+db_path: %s
+table: events
+message_column: message
+on_processed: delete
+busy_timeout: 10ms
+max_retries: %d`
+
+	// the writer holds the lock for a while
+	lock := func(d time.Duration) {
+		conn, err := db.Conn(t.Context())
+		require.NoError(t, err)
+
+		_, err = conn.ExecContext(t.Context(), "BEGIN EXCLUSIVE")
+		require.NoError(t, err)
+
+		go func() {
+			defer conn.Close()
+
+			time.Sleep(d)
+
+			_, err := conn.ExecContext(t.Context(), "COMMIT")
+			assert.NoError(t, err)
+		}()
+	}
+
+	lock(500 * time.Millisecond)
+
+	s := SqliteSource{}
+	err := s.Configure([]byte(fmt.Sprintf(config, path, 0)), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	tmb := tomb.Tomb{}
+	err = s.OneShotAcquisition(t.Context(), make(chan types.Event, 10), &tmb)
+	cstest.RequireErrorContains(t, err, "database still locked after 0 retries: database is locked")
+
+	time.Sleep(time.Second)
+	lock(500 * time.Millisecond)
+
+	assert.Equal(t, []string{"line 1", "line 2"}, lines(oneShot(t, fmt.Sprintf(config, path, 10))))
+	assert.Equal(t, 0, count(t, db, "SELECT COUNT(*) FROM events"))
+}
+
+func TestStreamingAcquisition(t *testing.T) {
+	path, db := newQueue(t, 2)
+
+	s := SqliteSource{}
+	err := s.Configure([]byte(fmt.Sprintf(`
+source: sqlite
+db_path: %s
+table: events
+message_column: message
+batch_size: 2
+poll_interval: 100ms`, path)), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	out := make(chan types.Event, 10)
+	tmb := tomb.Tomb{}
+
+	require.NoError(t, s.StreamingAcquisition(t.Context(), out, &tmb))
+
+	read := func(n int) []string {
+		lines := []string{}
+
+		for range n {
+			select {
+			case evt := <-out:
+				lines = append(lines, evt.Line.Raw)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("timeout, got %v", lines)
+			}
+		}
+
+		return lines
+	}
+
+	// wait for the first poll, the existing rows are skipped
+	time.Sleep(300 * time.Millisecond)
+
+	insert(t, db, 3, 5)
+
+	assert.Equal(t, []string{"line 3", "line 4", "line 5"}, read(3))
+
+	select {
+	case evt := <-out:
+		t.Fatalf("unexpected event %s", evt.Line.Raw)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	tmb.Kill(nil)
+	require.NoError(t, tmb.Wait())
+}
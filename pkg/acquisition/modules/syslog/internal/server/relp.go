@@ -0,0 +1,410 @@
+package syslogserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/tomb.v2"
+)
+
+// RELP (Reliable Event Logging Protocol), as sent by rsyslog's omrelp.
+// A frame is "TXNR SP COMMAND SP DATALEN [SP DATA] LF"; the server answers each command with a
+// "rsp" frame bearing the same transaction number.
+
+const (
+	relpMaxTxnrLen    = 9
+	relpMaxCommandLen = 32
+	relpMaxDatalenLen = 9
+	relpVersion       = "0"
+)
+
+var errMalformedFrame = errors.New("malformed RELP frame")
+
+// MalformedFrames counts the RELP connections closed because of an invalid frame.
+var MalformedFrames = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "cs_syslogsource_relp_malformed_frames_total",
+		Help: "Total RELP connections closed because of a malformed frame.",
+	})
+
+// ActiveConnections is the number of open RELP connections.
+var ActiveConnections = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "cs_syslogsource_relp_active_connections",
+		Help: "Number of open RELP connections.",
+	})
+
+type relpFrame struct {
+	txnr    int
+	command string
+	data    []byte
+}
+
+// RELPServer receives syslog messages over RELP. A message is acknowledged to the client when
+// the Ack function of the SyslogMessage is called, after it was sent to the parsers: the client
+// sends the messages that were not acknowledged, or were rejected, again.
+type RELPServer struct {
+	channel       chan SyslogMessage
+	listener      net.Listener
+	Logger        *log.Entry
+	MaxMessageLen int
+	// Wrap is applied to the listener, to filter the connections
+	Wrap func(net.Listener) net.Listener
+	// a connection is closed if no frame is received for ReadTimeout, or if a response can't
+	// be written in WriteTimeout; no timeout if zero
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// period of the TCP keepalive probes, the system default if zero; disabled if negative
+	KeepAlive time.Duration
+
+	t     tomb.Tomb
+	mu    sync.Mutex
+	conns map[*relpConn]struct{}
+	wg    sync.WaitGroup
+}
+
+func (s *RELPServer) Listen(listenAddr string, port int) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(listenAddr, strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("could not listen on port %d: %w", port, err)
+	}
+	s.Logger.Debugf("listening on %s:%d (RELP)", listenAddr, port)
+	if s.Wrap != nil {
+		listener = s.Wrap(listener)
+	}
+	s.listener = listener
+	s.conns = map[*relpConn]struct{}{}
+	return nil
+}
+
+func (s *RELPServer) SetChannel(c chan SyslogMessage) {
+	s.channel = c
+}
+
+func (s *RELPServer) StartServer() *tomb.Tomb {
+	s.t.Go(func() error {
+		s.t.Go(func() error {
+			<-s.t.Dying()
+			s.Logger.Info("RELP server tomb is dying")
+			return s.KillServer()
+		})
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				if s.t.Alive() {
+					s.Logger.Errorf("error while accepting connection: %s", err)
+					return err
+				}
+				return nil
+			}
+			s.setKeepAlive(conn)
+			c := &relpConn{conn: conn, writeTimeout: s.WriteTimeout}
+			ActiveConnections.Inc()
+			s.mu.Lock()
+			s.conns[c] = struct{}{}
+			s.mu.Unlock()
+			s.wg.Add(1)
+			go s.handleConn(c)
+		}
+	})
+	return &s.t
+}
+
+// setKeepAlive detects the half-open connections, of the clients that went away without
+// closing them.
+func (s *RELPServer) setKeepAlive(conn net.Conn) {
+	// the connection may be wrapped by the listener
+	if wrapped, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = wrapped.NetConn()
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	if s.KeepAlive < 0 {
+		_ = tcpConn.SetKeepAlive(false)
+		return
+	}
+
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		s.Logger.Debugf("could not enable TCP keepalive: %s", err)
+		return
+	}
+
+	if s.KeepAlive > 0 {
+		_ = tcpConn.SetKeepAlivePeriod(s.KeepAlive)
+	}
+}
+
+// KillServer closes the listener and the connections, telling the clients to reconnect later.
+// The messages that were not acknowledged yet will be sent again.
+func (s *RELPServer) KillServer() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.serverClose()
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	close(s.channel)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("could not close RELP listener: %w", err)
+	}
+	return nil
+}
+
+// relpConn is a client connection. The responses are written by the connection goroutine
+// (open, close) and by the acknowledgments of the messages.
+type relpConn struct {
+	conn         net.Conn
+	writeTimeout time.Duration
+	writeMu      sync.Mutex
+	pending      sync.WaitGroup // messages waiting for their acknowledgment
+}
+
+// setWriteDeadline bounds the next write, for a client that does not read the responses.
+func (c *relpConn) setWriteDeadline() {
+	if c.writeTimeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+}
+
+func (c *relpConn) respond(txnr int, data string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.setWriteDeadline()
+	var err error
+	if data == "" {
+		_, err = fmt.Fprintf(c.conn, "%d rsp 0\n", txnr)
+	} else {
+		_, err = fmt.Fprintf(c.conn, "%d rsp %d %s\n", txnr, len(data), data)
+	}
+	return err
+}
+
+// serverClose tells the client to close the connection, and to reconnect later.
+func (c *relpConn) serverClose() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.setWriteDeadline()
+	_, _ = c.conn.Write([]byte("0 serverclose 0\n"))
+}
+
+func (s *RELPServer) handleConn(c *relpConn) {
+	conn := c.conn
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		conn.Close()
+		ActiveConnections.Dec()
+	}()
+
+	logger := s.Logger.WithField("client", conn.RemoteAddr().String())
+	client := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+
+	r := bufio.NewReader(conn)
+	opened := false
+
+	for {
+		if s.ReadTimeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+		}
+
+		frame, err := readRELPFrame(r, s.MaxMessageLen)
+		if err != nil {
+			if errors.Is(err, errMalformedFrame) {
+				logger.Errorf("closing connection: %s", err)
+				MalformedFrames.Inc()
+				c.serverClose()
+			} else if errors.Is(err, os.ErrDeadlineExceeded) {
+				// the client reconnects when it has messages to send
+				logger.Debugf("closing connection: no frame received for %s", s.ReadTimeout)
+				c.serverClose()
+			} else if !errors.Is(err, io.EOF) && s.t.Alive() {
+				logger.Debugf("closing connection: %s", err)
+			}
+			return
+		}
+
+		switch {
+		case frame.command == "open":
+			if !slices.Contains(strings.Split(offer(frame.data, "commands"), ","), "syslog") {
+				_ = c.respond(frame.txnr, "500 the syslog command is required")
+				return
+			}
+			opened = true
+			_ = c.respond(frame.txnr, "200 OK\nrelp_version="+relpVersion+"\nrelp_software=crowdsec\ncommands=syslog")
+		case !opened:
+			logger.Errorf("closing connection: %s command before open", frame.command)
+			_ = c.respond(frame.txnr, "500 session not open")
+			return
+		case frame.command == "syslog":
+			txnr := frame.txnr
+			c.pending.Add(1)
+			msg := SyslogMessage{
+				Message: frame.data,
+				Client:  client,
+				Addr:    conn.RemoteAddr(),
+				Ack: func(err error) {
+					defer c.pending.Done()
+					rsp := "200 OK"
+					if err != nil {
+						// the client sends it again
+						rsp = "500 " + err.Error()
+					}
+					if err := c.respond(txnr, rsp); err != nil {
+						logger.Debugf("could not acknowledge message %d: %s", txnr, err)
+					}
+				},
+			}
+			select {
+			case s.channel <- msg:
+			case <-s.t.Dying():
+				return
+			}
+		case frame.command == "close":
+			// the messages received before are acknowledged first
+			done := make(chan struct{})
+			go func() {
+				c.pending.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-s.t.Dying():
+				return
+			}
+			_ = c.respond(frame.txnr, "")
+			return
+		default:
+			_ = c.respond(frame.txnr, "500 unknown command "+frame.command)
+		}
+	}
+}
+
+// offer returns the value of an offer (key=value lines) of the open command.
+func offer(data []byte, key string) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// readToken reads up to maxLen bytes until a delimiter, which is returned along with the token.
+func readToken(r *bufio.Reader, maxLen int, delims string) (string, byte, error) {
+	token := make([]byte, 0, maxLen)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if len(token) > 0 && errors.Is(err, io.EOF) {
+				return "", 0, fmt.Errorf("%w: truncated frame", errMalformedFrame)
+			}
+			return "", 0, err
+		}
+		if strings.IndexByte(delims, b) >= 0 {
+			return string(token), b, nil
+		}
+		if len(token) == maxLen {
+			return "", 0, fmt.Errorf("%w: field too long", errMalformedFrame)
+		}
+		token = append(token, b)
+	}
+}
+
+func parseNumber(s string) (int, bool) {
+	if s == "" {
+		return 0, false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
+
+func readRELPFrame(r *bufio.Reader, maxDataLen int) (relpFrame, error) {
+	frame := relpFrame{}
+
+	token, _, err := readToken(r, relpMaxTxnrLen, " ")
+	if err != nil {
+		return frame, err
+	}
+	txnr, ok := parseNumber(token)
+	if !ok || txnr == 0 {
+		return frame, fmt.Errorf("%w: invalid transaction number '%s'", errMalformedFrame, token)
+	}
+	frame.txnr = txnr
+
+	token, _, err = readToken(r, relpMaxCommandLen, " ")
+	if err != nil {
+		return frame, wrapTruncated(err)
+	}
+	if token == "" {
+		return frame, fmt.Errorf("%w: empty command", errMalformedFrame)
+	}
+	frame.command = token
+
+	token, delim, err := readToken(r, relpMaxDatalenLen, " \n")
+	if err != nil {
+		return frame, wrapTruncated(err)
+	}
+	datalen, ok := parseNumber(token)
+	if !ok {
+		return frame, fmt.Errorf("%w: invalid data length '%s'", errMalformedFrame, token)
+	}
+	if datalen > maxDataLen {
+		return frame, fmt.Errorf("%w: data length %d exceeds %d", errMalformedFrame, datalen, maxDataLen)
+	}
+	if delim == '\n' {
+		if datalen != 0 {
+			return frame, fmt.Errorf("%w: missing data", errMalformedFrame)
+		}
+		return frame, nil
+	}
+
+	frame.data = make([]byte, datalen)
+	if _, err := io.ReadFull(r, frame.data); err != nil {
+		return frame, wrapTruncated(err)
+	}
+
+	trailer, err := r.ReadByte()
+	if err != nil {
+		return frame, wrapTruncated(err)
+	}
+	if trailer != '\n' {
+		return frame, fmt.Errorf("%w: missing trailer", errMalformedFrame)
+	}
+
+	return frame, nil
+}
+
+// wrapTruncated reports the end of the connection in the middle of a frame as malformed.
+func wrapTruncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: truncated frame", errMalformedFrame)
+	}
+	return err
+}
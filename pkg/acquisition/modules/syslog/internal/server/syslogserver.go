@@ -22,6 +22,8 @@ type SyslogServer struct {
 type SyslogMessage struct {
 	Message []byte
 	Client  string
+	Addr    net.Addr        // address of the client, including the port
+	Ack     func(err error) // RELP: acknowledges the message to the client once handled, or rejects it on error. nil for UDP
 }
 
 func (s *SyslogServer) Listen(listenAddr string, port int) error {
@@ -70,7 +72,7 @@ func (s *SyslogServer) StartServer() *tomb.Tomb {
 					return err
 				}
 				if err == nil {
-					s.channel <- SyslogMessage{Message: b[:n], Client: strings.Split(addr.String(), ":")[0]}
+					s.channel <- SyslogMessage{Message: b[:n], Client: strings.Split(addr.String(), ":")[0], Addr: addr}
 				}
 				err = s.udpConn.SetReadDeadline(time.Now().UTC().Add(100 * time.Millisecond))
 				if err != nil {
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,6 +18,9 @@ import (
 	"github.com/crowdsecurity/go-cs-lib/trace"
 
 	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/connlimit"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/diskqueue"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/ipfilter"
 	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/syslog/internal/parser/rfc3164"
 	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/syslog/internal/parser/rfc5424"
 	syslogserver "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/syslog/internal/server"
@@ -24,20 +28,44 @@ import (
 )
 
 type SyslogConfiguration struct {
-	Proto                             string `yaml:"protocol,omitempty"`
-	Port                              int    `yaml:"listen_port,omitempty"`
-	Addr                              string `yaml:"listen_addr,omitempty"`
-	MaxMessageLen                     int    `yaml:"max_message_len,omitempty"`
-	DisableRFCParser                  bool   `yaml:"disable_rfc_parser,omitempty"` // if true, we don't try to be smart and just remove the PRI
+	Proto                             string        `yaml:"protocol,omitempty"` // udp or relp
+	Port                              int           `yaml:"listen_port,omitempty"`
+	Addr                              string        `yaml:"listen_addr,omitempty"`
+	MaxMessageLen                     int           `yaml:"max_message_len,omitempty"`
+	DisableRFCParser                  bool          `yaml:"disable_rfc_parser,omitempty"` // if true, we don't try to be smart and just remove the PRI
+	ReadTimeout                       time.Duration `yaml:"read_timeout,omitempty"`       // relp only: idle connections are closed after this delay
+	WriteTimeout                      time.Duration `yaml:"write_timeout,omitempty"`      // relp only: connections are closed when a response can't be written in this delay
+	TCPKeepAlive                      time.Duration `yaml:"tcp_keepalive,omitempty"`      // relp only: period of the keepalive probes, the system default if zero, disabled if negative
+	MaxConnections                    int           `yaml:"max_connections,omitempty"`    // relp only: the extra connections are closed
+	ipfilter.Config                   `yaml:",inline"`
+	Queue                             diskqueue.Config `yaml:",inline"`
 	configuration.DataSourceCommonCfg `yaml:",inline"`
 }
 
+const (
+	protoUDP  = "udp"
+	protoRELP = "relp"
+
+	defaultRELPMaxMessageLen = 128 * 1024 // as rsyslog's omrelp
+	defaultRELPReadTimeout   = 10 * time.Minute
+	defaultRELPWriteTimeout  = time.Minute
+)
+
+// messageServer receives the messages over UDP or RELP.
+type messageServer interface {
+	Listen(listenAddr string, port int) error
+	SetChannel(c chan syslogserver.SyslogMessage)
+	StartServer() *tomb.Tomb
+}
+
 type SyslogSource struct {
 	metricsLevel int
 	config       SyslogConfiguration
 	logger       *log.Entry
-	server       *syslogserver.SyslogServer
+	server       messageServer
 	serverTomb   *tomb.Tomb
+	ipFilter     *ipfilter.Filter
+	queue        diskqueue.Queue // with queue_dir, the messages are written there before the parsers
 }
 
 var linesReceived = prometheus.NewCounterVec(
@@ -75,11 +103,11 @@ func (s *SyslogSource) CanRun() error {
 }
 
 func (s *SyslogSource) GetMetrics() []prometheus.Collector {
-	return []prometheus.Collector{linesReceived, linesParsed}
+	return []prometheus.Collector{linesReceived, linesParsed, ipfilter.Rejected, connlimit.Rejected, syslogserver.MalformedFrames, syslogserver.ActiveConnections, diskqueue.Size, diskqueue.Dropped}
 }
 
 func (s *SyslogSource) GetAggregMetrics() []prometheus.Collector {
-	return []prometheus.Collector{linesReceived, linesParsed}
+	return []prometheus.Collector{linesReceived, linesParsed, ipfilter.Rejected, connlimit.Rejected, syslogserver.MalformedFrames, syslogserver.ActiveConnections, diskqueue.Size, diskqueue.Dropped}
 }
 
 func (s *SyslogSource) ConfigureByDSN(dsn string, labels map[string]string, logger *log.Entry, uuid string) error {
@@ -113,8 +141,21 @@ func (s *SyslogSource) UnmarshalConfig(yamlConfig []byte) error {
 	if s.config.Port == 0 {
 		s.config.Port = 514
 	}
+	switch s.config.Proto {
+	case "":
+		s.config.Proto = protoUDP
+	case protoUDP, protoRELP:
+	default:
+		return fmt.Errorf("invalid protocol %s, must be udp or relp", s.config.Proto)
+	}
 	if s.config.MaxMessageLen == 0 {
 		s.config.MaxMessageLen = 2048
+		if s.config.Proto == protoRELP {
+			s.config.MaxMessageLen = defaultRELPMaxMessageLen
+		}
+	}
+	if err := s.setRELPOptions(); err != nil {
+		return err
 	}
 	if !validatePort(s.config.Port) {
 		return fmt.Errorf("invalid port %d", s.config.Port)
@@ -123,6 +164,41 @@ func (s *SyslogSource) UnmarshalConfig(yamlConfig []byte) error {
 		return fmt.Errorf("invalid listen IP %s", s.config.Addr)
 	}
 
+	s.ipFilter, err = ipfilter.New(s.config.Config)
+	if err != nil {
+		return err
+	}
+
+	if err := s.config.Queue.Validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// setRELPOptions validates the options of the RELP connections, and sets the default timeouts.
+func (s *SyslogSource) setRELPOptions() error {
+	if s.config.Proto != protoRELP {
+		if s.config.ReadTimeout != 0 || s.config.WriteTimeout != 0 || s.config.TCPKeepAlive != 0 || s.config.MaxConnections != 0 {
+			return errors.New("read_timeout, write_timeout, tcp_keepalive and max_connections are only supported with protocol relp")
+		}
+		return nil
+	}
+	if s.config.MaxConnections < 0 {
+		return errors.New("max_connections must be positive")
+	}
+	if s.config.ReadTimeout < 0 {
+		return errors.New("read_timeout must be positive")
+	}
+	if s.config.WriteTimeout < 0 {
+		return errors.New("write_timeout must be positive")
+	}
+	if s.config.ReadTimeout == 0 {
+		s.config.ReadTimeout = defaultRELPReadTimeout
+	}
+	if s.config.WriteTimeout == 0 {
+		s.config.WriteTimeout = defaultRELPWriteTimeout
+	}
 	return nil
 }
 
@@ -140,12 +216,51 @@ func (s *SyslogSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLe
 
 func (s *SyslogSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
 	c := make(chan syslogserver.SyslogMessage)
-	s.server = &syslogserver.SyslogServer{Logger: s.logger.WithField("syslog", "internal"), MaxMessageLen: s.config.MaxMessageLen}
+	if s.config.Proto == protoRELP {
+		s.server = &syslogserver.RELPServer{
+			Logger:        s.logger.WithField("syslog", "internal"),
+			MaxMessageLen: s.config.MaxMessageLen,
+			ReadTimeout:   s.config.ReadTimeout,
+			WriteTimeout:  s.config.WriteTimeout,
+			KeepAlive:     s.config.TCPKeepAlive,
+			Wrap: func(listener net.Listener) net.Listener {
+				listener = ipfilter.Listen(listener, s.ipFilter, func(conn net.Conn) {
+					s.logger.Debugf("rejecting connection from %s: source not allowed", conn.RemoteAddr())
+					if s.metricsLevel != configuration.METRICS_NONE {
+						ipfilter.Rejected.With(prometheus.Labels{"datasource": s.GetName()}).Inc()
+					}
+				})
+				// the connections of the rejected sources don't count
+				addr := net.JoinHostPort(s.config.Addr, strconv.Itoa(s.config.Port))
+				return connlimit.Listen(listener, s.config.MaxConnections, func(conn net.Conn) {
+					s.logger.Debugf("rejecting connection from %s: max_connections reached", conn.RemoteAddr())
+					if s.metricsLevel != configuration.METRICS_NONE {
+						connlimit.Rejected.With(prometheus.Labels{"datasource": s.GetName(), "addr": addr}).Inc()
+					}
+					// the client reconnects later
+					_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+					_, _ = conn.Write([]byte("0 serverclose 0\n"))
+				})
+			},
+		}
+	} else {
+		s.server = &syslogserver.SyslogServer{Logger: s.logger.WithField("syslog", "internal"), MaxMessageLen: s.config.MaxMessageLen}
+	}
 	s.server.SetChannel(c)
 	err := s.server.Listen(s.config.Addr, s.config.Port)
 	if err != nil {
 		return fmt.Errorf("could not start syslog server: %w", err)
 	}
+	s.queue, err = diskqueue.Open(s.config.Queue, s.logger)
+	if err != nil {
+		return err
+	}
+	if s.queue != nil {
+		t.Go(func() error {
+			defer trace.CatchPanic("crowdsec/acquis/syslog/queue")
+			return diskqueue.Forward(s.queue, out, t)
+		})
+	}
 	s.serverTomb = s.server.StartServer()
 	t.Go(func() error {
 		defer trace.CatchPanic("crowdsec/acquis/syslog/live")
@@ -188,7 +303,7 @@ func (s *SyslogSource) parseLine(syslogLine syslogserver.SyslogMessage) string {
 	var line string
 
 	logger := s.logger.WithField("client", syslogLine.Client)
-	logger.Tracef("raw: %s", syslogLine)
+	logger.Tracef("raw: %s", syslogLine.Message)
 	if s.metricsLevel != configuration.METRICS_NONE {
 		linesReceived.With(prometheus.Labels{"source": syslogLine.Client}).Inc()
 	}
@@ -257,24 +372,57 @@ func (s *SyslogSource) handleSyslogMsg(out chan types.Event, t *tomb.Tomb, c cha
 		case <-s.serverTomb.Dead():
 			s.logger.Info("Syslog server has exited")
 			return nil
-		case syslogLine := <-c:
-			line := s.parseLine(syslogLine)
-			if line == "" {
+		case syslogLine, ok := <-c:
+			if !ok {
+				// closed by the server, which is exiting
+				c = nil
 				continue
 			}
+			err := s.handleMessage(syslogLine, out)
+			if err != nil {
+				s.logger.Errorf("dropping message from %s: %s", syslogLine.Client, err)
+			}
+			// RELP: acknowledged once sent to the parsers or dropped, and rejected if it could not be queued
+			if syslogLine.Ack != nil {
+				syslogLine.Ack(err)
+			}
+		}
+	}
+}
+
+func (s *SyslogSource) handleMessage(syslogLine syslogserver.SyslogMessage, out chan types.Event) error {
+	if syslogLine.Addr != nil && !s.ipFilter.AllowedHost(syslogLine.Addr.String()) {
+		s.logger.Debugf("rejecting message from %s: source not allowed", syslogLine.Client)
 
-			var ts time.Time
-
-			l := types.Line{}
-			l.Raw = line
-			l.Module = s.GetName()
-			l.Labels = s.config.Labels
-			l.Time = ts
-			l.Src = syslogLine.Client
-			l.Process = true
-			evt := types.MakeEvent(s.config.UseTimeMachine, types.LOG, true)
-			evt.Line = l
-			out <- evt
+		if s.metricsLevel != configuration.METRICS_NONE {
+			ipfilter.Rejected.With(prometheus.Labels{"datasource": s.GetName()}).Inc()
 		}
+
+		return nil
+	}
+
+	line := s.parseLine(syslogLine)
+	if line == "" {
+		return nil
+	}
+
+	var ts time.Time
+
+	l := types.Line{}
+	l.Raw = line
+	l.Module = s.GetName()
+	l.Labels = s.config.Labels
+	l.Time = ts
+	l.Src = syslogLine.Client
+	l.Process = true
+	evt := types.MakeEvent(s.config.UseTimeMachine, types.LOG, true)
+	evt.Line = l
+
+	if s.queue != nil {
+		return s.queue.Push(evt)
 	}
+
+	out <- evt
+
+	return nil
 }
@@ -1,12 +1,17 @@
 package syslogacquisition
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"net"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -15,6 +20,8 @@ import (
 	"github.com/crowdsecurity/go-cs-lib/cstest"
 
 	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/connlimit"
+	syslogserver "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/syslog/internal/server"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
 
@@ -51,6 +58,62 @@ source: syslog
 listen_addr: 10.0.0`,
 			expectedErr: "invalid listen IP 10.0.0",
 		},
+		{
+			config: `
+source: syslog
+allowed_sources:
+  - 10.0.0.0/8
+  - fe80::/200`,
+			expectedErr: "allowed_sources: invalid range 'fe80::/200'",
+		},
+		{
+			config: `
+source: syslog
+protocol: tcp`,
+			expectedErr: "invalid protocol tcp, must be udp or relp",
+		},
+		{
+			config: `
+source: syslog
+protocol: relp`,
+			expectedErr: "",
+		},
+		{
+			config: `
+source: syslog
+read_timeout: 1m`,
+			expectedErr: "read_timeout, write_timeout, tcp_keepalive and max_connections are only supported with protocol relp",
+		},
+		{
+			config: `
+source: syslog
+protocol: relp
+max_connections: -1`,
+			expectedErr: "max_connections must be positive",
+		},
+		{
+			config: `
+source: syslog
+protocol: relp
+write_timeout: -1s`,
+			expectedErr: "write_timeout must be positive",
+		},
+		{
+			config: `
+source: syslog
+protocol: relp
+read_timeout: 1m
+write_timeout: 10s
+tcp_keepalive: -1s
+max_connections: 100`,
+			expectedErr: "",
+		},
+		{
+			config: `
+source: syslog
+queue_max_size: 1048576`,
+			expectedErr: "queue_max_size requires queue_dir",
+		},
 	}
 
 	subLogger := log.WithField("type", "syslog")
@@ -122,6 +185,17 @@ listen_addr: 127.0.0.1`,
 				`<13>May 18 12:37:56 mantis sshd`,
 			},
 		},
+		{
+			name: "denied source",
+			config: `source: syslog
+listen_port: 4242
+listen_addr: 127.0.0.1
+denied_sources:
+  - 127.0.0.0/8`,
+			logs: []string{
+				`<13>May 18 12:37:56 mantis sshd[49340]: blabla2`,
+			},
+		},
 		{
 			name: "RFC3164 - no parsing",
 			config: `source: syslog
@@ -195,3 +269,293 @@ disable_rfc_parser: true`,
 		})
 	}
 }
+
+// readRELPResponse reads a response frame, and returns its transaction number and its data.
+func readRELPResponse(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+
+	var (
+		txnr, command string
+		datalen       int
+	)
+
+	_, err := fmt.Fscanf(r, "%s %s %d", &txnr, &command, &datalen)
+	require.NoError(t, err)
+
+	sep, err := r.ReadByte()
+	require.NoError(t, err)
+
+	if sep == '\n' {
+		require.Zero(t, datalen)
+		return txnr + " " + command, ""
+	}
+
+	data := make([]byte, datalen)
+	_, err = io.ReadFull(r, data)
+	require.NoError(t, err)
+
+	trailer, err := r.ReadByte()
+	require.NoError(t, err)
+	require.Equal(t, byte('\n'), trailer)
+
+	return txnr + " " + command, string(data)
+}
+
+func TestRELP(t *testing.T) {
+	ctx := t.Context()
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`
+source: syslog
+protocol: relp
+listen_port: 4243
+listen_addr: 127.0.0.1`), subLogger, configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	tmb := tomb.Tomb{}
+	out := make(chan types.Event)
+	err = s.StreamingAcquisition(ctx, out, &tmb)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:4243")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	offers := "relp_version=0\nrelp_software=test\ncommands=syslog"
+	_, err = fmt.Fprintf(conn, "1 open %d %s\n", len(offers), offers)
+	require.NoError(t, err)
+
+	header, data := readRELPResponse(t, r)
+	assert.Equal(t, "1 rsp", header)
+	assert.True(t, strings.HasPrefix(data, "200 OK\n"), data)
+	assert.Contains(t, data, "commands=syslog")
+
+	msg := "<13>May 18 12:37:56 mantis sshd[42]: blabla"
+	_, err = fmt.Fprintf(conn, "2 syslog %d %s\n", len(msg), msg)
+	require.NoError(t, err)
+
+	// not acknowledged before the event is read
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+	_, err = r.Peek(1)
+	require.Error(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Time{}))
+
+	select {
+	case evt := <-out:
+		assert.Equal(t, "May 18 12:37:56 mantis sshd[42]: blabla", evt.Line.Raw)
+		assert.Equal(t, "127.0.0.1", evt.Line.Src)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for the event")
+	}
+
+	header, data = readRELPResponse(t, r)
+	assert.Equal(t, "2 rsp", header)
+	assert.Equal(t, "200 OK", data)
+
+	_, err = fmt.Fprint(conn, "3 close 0\n")
+	require.NoError(t, err)
+
+	header, data = readRELPResponse(t, r)
+	assert.Equal(t, "3 rsp", header)
+	assert.Empty(t, data)
+
+	// a malformed frame closes the connection
+	conn2, err := net.Dial("tcp", "127.0.0.1:4243")
+	require.NoError(t, err)
+	defer conn2.Close()
+
+	r2 := bufio.NewReader(conn2)
+
+	_, err = fmt.Fprintf(conn2, "1 open %d %s\n", len(offers), offers)
+	require.NoError(t, err)
+	header, _ = readRELPResponse(t, r2)
+	assert.Equal(t, "1 rsp", header)
+
+	_, err = fmt.Fprint(conn2, "x syslog 3 abc\n")
+	require.NoError(t, err)
+
+	header, data = readRELPResponse(t, r2)
+	assert.Equal(t, "0 serverclose", header)
+	assert.Empty(t, data)
+
+	_, err = r2.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+
+	tmb.Kill(nil)
+	require.NoError(t, tmb.Wait())
+}
+
+func TestRELPReadTimeout(t *testing.T) {
+	ctx := t.Context()
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`
+source: syslog
+protocol: relp
+listen_port: 4245
+listen_addr: 127.0.0.1
+read_timeout: 300ms`), subLogger, configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	tmb := tomb.Tomb{}
+	out := make(chan types.Event)
+	err = s.StreamingAcquisition(ctx, out, &tmb)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:4245")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	offers := "relp_version=0\nrelp_software=test\ncommands=syslog"
+	_, err = fmt.Fprintf(conn, "1 open %d %s\n", len(offers), offers)
+	require.NoError(t, err)
+
+	header, _ := readRELPResponse(t, r)
+	assert.Equal(t, "1 rsp", header)
+	assert.InDelta(t, 1, testutil.ToFloat64(syslogserver.ActiveConnections), 0)
+
+	// an idle connection is closed, the client reconnects when it has messages to send
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	header, data := readRELPResponse(t, r)
+	assert.Equal(t, "0 serverclose", header)
+	assert.Empty(t, data)
+
+	_, err = r.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(syslogserver.ActiveConnections) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	tmb.Kill(nil)
+	require.NoError(t, tmb.Wait())
+}
+
+func TestRELPMaxConnections(t *testing.T) {
+	ctx := t.Context()
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`
+source: syslog
+protocol: relp
+listen_port: 4246
+listen_addr: 127.0.0.1
+max_connections: 1`), subLogger, configuration.METRICS_FULL)
+	require.NoError(t, err)
+
+	tmb := tomb.Tomb{}
+	out := make(chan types.Event)
+	err = s.StreamingAcquisition(ctx, out, &tmb)
+	require.NoError(t, err)
+
+	offers := "relp_version=0\nrelp_software=test\ncommands=syslog"
+
+	open := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", "127.0.0.1:4246")
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		_, err = fmt.Fprintf(conn, "1 open %d %s\n", len(offers), offers)
+		require.NoError(t, err)
+
+		return conn, bufio.NewReader(conn)
+	}
+
+	conn, r := open()
+	header, _ := readRELPResponse(t, r)
+	assert.Equal(t, "1 rsp", header)
+
+	rejected := connlimit.Rejected.With(prometheus.Labels{"datasource": "syslog", "addr": "127.0.0.1:4246"})
+	before := testutil.ToFloat64(rejected)
+
+	// the extra connection is told to reconnect later
+	_, r2 := open()
+	header, data := readRELPResponse(t, r2)
+	assert.Equal(t, "0 serverclose", header)
+	assert.Empty(t, data)
+
+	// closed without reading the open frame, this may be a reset
+	_, err = r2.ReadByte()
+	require.Error(t, err)
+	assert.InDelta(t, before+1, testutil.ToFloat64(rejected), 0)
+
+	// the slot is freed once the connection is closed
+	_, err = fmt.Fprint(conn, "2 close 0\n")
+	require.NoError(t, err)
+
+	header, _ = readRELPResponse(t, r)
+	assert.Equal(t, "2 rsp", header)
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(syslogserver.ActiveConnections) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	_, r3 := open()
+	header, _ = readRELPResponse(t, r3)
+	assert.Equal(t, "1 rsp", header)
+
+	tmb.Kill(nil)
+	require.NoError(t, tmb.Wait())
+}
+
+func TestRELPQueue(t *testing.T) {
+	ctx := t.Context()
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`
+source: syslog
+protocol: relp
+listen_port: 4244
+listen_addr: 127.0.0.1
+queue_dir: `+t.TempDir()), subLogger, configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	tmb := tomb.Tomb{}
+	out := make(chan types.Event)
+	err = s.StreamingAcquisition(ctx, out, &tmb)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:4244")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	offers := "relp_version=0\nrelp_software=test\ncommands=syslog"
+	_, err = fmt.Fprintf(conn, "1 open %d %s\n", len(offers), offers)
+	require.NoError(t, err)
+
+	header, _ := readRELPResponse(t, r)
+	assert.Equal(t, "1 rsp", header)
+
+	msg := "<13>May 18 12:37:56 mantis sshd[42]: blabla"
+	_, err = fmt.Fprintf(conn, "2 syslog %d %s\n", len(msg), msg)
+	require.NoError(t, err)
+
+	// acknowledged once queued, before the event is read
+	header, data := readRELPResponse(t, r)
+	assert.Equal(t, "2 rsp", header)
+	assert.Equal(t, "200 OK", data)
+
+	select {
+	case evt := <-out:
+		assert.Equal(t, "May 18 12:37:56 mantis sshd[42]: blabla", evt.Line.Raw)
+		assert.Equal(t, "127.0.0.1", evt.Line.Src)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for the event")
+	}
+
+	tmb.Kill(nil)
+	require.NoError(t, tmb.Wait())
+}
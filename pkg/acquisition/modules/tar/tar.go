@@ -0,0 +1,330 @@
+package taracquisition
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const dataSourceName = "tar"
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+var linesRead = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_tarsource_hits_total",
+		Help: "Total lines that were read from the files of an archive",
+	},
+	[]string{"source"})
+
+var skippedEntries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_tarsource_skipped_entries_total",
+		Help: "Total files of an archive that could not be read",
+	},
+	[]string{"source"})
+
+type TarConfiguration struct {
+	Filename      string `yaml:"filename"`        // path of the archive, .tar or gzip-compressed .tar
+	Glob          string `yaml:"glob"`            // files of the archive to read, default: all of them
+	MaxBufferSize int    `yaml:"max_buffer_size"` // longest line, default: 64KB
+
+	configuration.DataSourceCommonCfg `yaml:",inline"`
+}
+
+type TarSource struct {
+	metricsLevel int
+	Config       TarConfiguration
+	logger       *log.Entry
+}
+
+func (s *TarSource) GetUuid() string {
+	return s.Config.UniqueId
+}
+
+func (s *TarSource) UnmarshalConfig(yamlConfig []byte) error {
+	s.Config = TarConfiguration{}
+
+	err := yaml.UnmarshalWithOptions(yamlConfig, &s.Config, yaml.Strict())
+	if err != nil {
+		return fmt.Errorf("cannot parse %s datasource configuration: %s", dataSourceName, yaml.FormatError(err, false, false))
+	}
+
+	if s.Config.Mode == "" {
+		s.Config.Mode = configuration.CAT_MODE
+	}
+
+	return s.validate()
+}
+
+func (s *TarSource) validate() error {
+	if s.Config.Filename == "" {
+		return errors.New("filename is mandatory")
+	}
+
+	if s.Config.Mode != configuration.CAT_MODE {
+		return fmt.Errorf("unsupported mode %s for %s datasource", s.Config.Mode, dataSourceName)
+	}
+
+	if s.Config.Glob != "" {
+		if _, err := path.Match(s.Config.Glob, ""); err != nil {
+			return fmt.Errorf("invalid glob %s: %w", s.Config.Glob, err)
+		}
+	}
+
+	if s.Config.MaxBufferSize < 0 {
+		return errors.New("max_buffer_size must be positive")
+	}
+
+	return nil
+}
+
+func (s *TarSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLevel int) error {
+	s.logger = logger
+	s.metricsLevel = metricsLevel
+
+	return s.UnmarshalConfig(yamlConfig)
+}
+
+// ConfigureByDSN handles tar:///path/to/archive.tar.gz?glob=...
+func (s *TarSource) ConfigureByDSN(dsn string, labels map[string]string, logger *log.Entry, uuid string) error {
+	s.logger = logger
+	s.Config = TarConfiguration{}
+	s.Config.Mode = configuration.CAT_MODE
+	s.Config.Labels = labels
+	s.Config.UniqueId = uuid
+
+	if !strings.HasPrefix(dsn, dataSourceName+"://") {
+		return fmt.Errorf("invalid DSN %s for %s source, must start with %s://", dsn, dataSourceName, dataSourceName)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return fmt.Errorf("while parsing dsn '%s': %w", dsn, err)
+	}
+
+	if u.Path == "" {
+		return errors.New("empty archive path")
+	}
+
+	s.Config.Filename = u.Path
+
+	params := u.Query()
+	s.Config.Glob = params.Get("glob")
+
+	if maxBufferSize := params.Get("max_buffer_size"); maxBufferSize != "" {
+		if s.Config.MaxBufferSize, err = strconv.Atoi(maxBufferSize); err != nil {
+			return fmt.Errorf("invalid max_buffer_size in dsn: %w", err)
+		}
+	}
+
+	if logLevel := params.Get("log_level"); logLevel != "" {
+		level, err := log.ParseLevel(logLevel)
+		if err != nil {
+			return fmt.Errorf("invalid log_level in dsn: %w", err)
+		}
+
+		s.Config.LogLevel = &level
+		s.logger.Logger.SetLevel(level)
+	}
+
+	return s.validate()
+}
+
+func (s *TarSource) GetMode() string {
+	return s.Config.Mode
+}
+
+func (*TarSource) GetName() string {
+	return dataSourceName
+}
+
+func (*TarSource) CanRun() error {
+	return nil
+}
+
+func (*TarSource) GetMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, skippedEntries}
+}
+
+func (*TarSource) GetAggregMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, skippedEntries}
+}
+
+func (s *TarSource) Dump() any {
+	return s
+}
+
+// match tells if a file of the archive must be read. A glob without a slash matches the base
+// name of the files in any directory, like *.log, otherwise the whole path, like var/log/*.log.
+func (s *TarSource) match(name string) bool {
+	if s.Config.Glob == "" {
+		return true
+	}
+
+	name = strings.TrimPrefix(name, "./")
+
+	if !strings.Contains(s.Config.Glob, "/") {
+		name = path.Base(name)
+	}
+
+	// the pattern was checked in validate()
+	matched, _ := path.Match(s.Config.Glob, name)
+
+	return matched
+}
+
+// decompress returns the content of r, gunzipped if it starts with the gzip header.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.Peek(len(gzipMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if !bytes.Equal(header, gzipMagic) {
+		return br, nil
+	}
+
+	return gzip.NewReader(br)
+}
+
+// readEntry sends the lines of a file of the archive. The gzip-compressed files, like the rotated
+// logs, are decompressed.
+func (s *TarSource) readEntry(ctx context.Context, name string, r io.Reader, out chan types.Event) error {
+	content, err := decompress(r)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(content)
+
+	if s.Config.MaxBufferSize > 0 {
+		buf := make([]byte, 0, 64*1024)
+		scanner.Buffer(buf, s.Config.MaxBufferSize)
+	}
+
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if scanner.Text() == "" {
+			continue
+		}
+
+		if s.metricsLevel != configuration.METRICS_NONE {
+			linesRead.With(prometheus.Labels{"source": s.Config.Filename}).Inc()
+		}
+
+		evt := types.MakeEvent(true, types.LOG, true)
+		evt.Line = types.Line{
+			Raw:     scanner.Text(),
+			Time:    time.Now().UTC(),
+			Src:     s.Config.Filename,
+			Labels:  s.Config.Labels,
+			Process: true,
+			Module:  s.GetName(),
+		}
+		evt.Meta["tar_path"] = name
+
+		select {
+		case out <- evt:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+// OneShotAcquisition reads the matching files of the archive, in the order they are stored.
+// The files that can't be read are skipped with a warning. The archive can't be read past a
+// corrupt header, the files before it are still sent.
+func (s *TarSource) OneShotAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-t.Dying():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	fd, err := os.Open(s.Config.Filename)
+	if err != nil {
+		return fmt.Errorf("failed opening %s: %w", s.Config.Filename, err)
+	}
+	defer fd.Close()
+
+	archive, err := decompress(fd)
+	if err != nil {
+		return fmt.Errorf("failed to read gz %s: %w", s.Config.Filename, err)
+	}
+
+	tr := tar.NewReader(archive)
+	files := 0
+
+	for ctx.Err() == nil {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			if files == 0 {
+				return fmt.Errorf("failed to read archive %s: %w", s.Config.Filename, err)
+			}
+
+			s.logger.Warnf("archive %s is corrupt, the files after %d read ones are skipped: %s", s.Config.Filename, files, err)
+
+			break
+		}
+
+		if hdr.Typeflag != tar.TypeReg || !s.match(hdr.Name) {
+			continue
+		}
+
+		s.logger.Debugf("reading %s", hdr.Name)
+
+		files++
+
+		if err := s.readEntry(ctx, hdr.Name, tr, out); err != nil {
+			s.logger.Warnf("skipping the rest of %s: %s", hdr.Name, err)
+
+			if s.metricsLevel != configuration.METRICS_NONE {
+				skippedEntries.With(prometheus.Labels{"source": s.Config.Filename}).Inc()
+			}
+		}
+	}
+
+	s.logger.Infof("%d files read from %s", files, s.Config.Filename)
+
+	return nil
+}
+
+func (*TarSource) StreamingAcquisition(_ context.Context, _ chan types.Event, _ *tomb.Tomb) error {
+	return fmt.Errorf("%s datasource does not support streaming acquisition", dataSourceName)
+}
@@ -0,0 +1,243 @@
+package taracquisition
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		config      string
+		expectedErr string
+	}{
+		{
+			config:      `foobar: asd`,
+			expectedErr: `cannot parse tar datasource configuration: [1:1] unknown field "foobar"`,
+		},
+		{
+			config: `
+source: tar
+glob: "*.log"`,
+			expectedErr: "filename is mandatory",
+		},
+		{
+			config: `
+source: tar
+filename: /tmp/logs.tar
+mode: tail`,
+			expectedErr: "unsupported mode tail for tar datasource",
+		},
+		{
+			config: `
+source: tar
+filename: /tmp/logs.tar
+glob: "[a-"`,
+			expectedErr: "invalid glob [a-: syntax error in pattern",
+		},
+		{
+			config: `
+source: tar
+filename: /tmp/logs.tar
+max_buffer_size: -1`,
+			expectedErr: "max_buffer_size must be positive",
+		},
+		{
+			config: `
+source: tar
+filename: /tmp/logs.tar.gz
+glob: var/log/nginx/*.log
+max_buffer_size: 1048576`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.config, func(t *testing.T) {
+			s := TarSource{}
+			err := s.Configure([]byte(tc.config), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestConfigureByDSN(t *testing.T) {
+	tests := []struct {
+		dsn         string
+		expectedErr string
+	}{
+		{
+			dsn:         "file:///tmp/logs.tar",
+			expectedErr: "invalid DSN file:///tmp/logs.tar for tar source, must start with tar://",
+		},
+		{
+			dsn:         "tar://",
+			expectedErr: "empty archive path",
+		},
+		{
+			dsn:         "tar:///tmp/logs.tar?max_buffer_size=foo",
+			expectedErr: "invalid max_buffer_size in dsn",
+		},
+		{
+			dsn: "tar:///tmp/logs.tar.gz?glob=*.log&log_level=debug",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.dsn, func(t *testing.T) {
+			s := TarSource{}
+			err := s.ConfigureByDSN(tc.dsn, map[string]string{"type": "test"}, log.WithField("type", dataSourceName), "")
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+type entry struct {
+	name    string
+	content []byte
+	dir     bool
+}
+
+func gzipped(t *testing.T, content []byte) []byte {
+	buf := bytes.Buffer{}
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	return buf.Bytes()
+}
+
+func archive(t *testing.T, entries []entry) []byte {
+	buf := bytes.Buffer{}
+	tw := tar.NewWriter(&buf)
+
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if e.dir {
+			hdr = &tar.Header{Name: e.name, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write(e.content)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tw.Close())
+
+	return buf.Bytes()
+}
+
+func oneShot(t *testing.T, config string) []types.Event {
+	s := TarSource{}
+	err := s.Configure([]byte(config), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	out := make(chan types.Event, 100)
+	tmb := tomb.Tomb{}
+
+	require.NoError(t, s.OneShotAcquisition(t.Context(), out, &tmb))
+	close(out)
+
+	events := []types.Event{}
+	for evt := range out {
+		events = append(events, evt)
+	}
+
+	return events
+}
+
+func lines(events []types.Event) []string {
+	ret := []string{}
+	for _, evt := range events {
+		ret = append(ret, evt.Meta["tar_path"]+": "+evt.Line.Raw)
+	}
+
+	return ret
+}
+
+func TestOneShot(t *testing.T) {
+	dir := t.TempDir()
+
+	content := archive(t, []entry{
+		{name: "var/log/nginx/", dir: true},
+		{name: "var/log/nginx/access.log", content: []byte("GET /\nGET /login\n")},
+		{name: "var/log/nginx/access.log.1.gz", content: gzipped(t, []byte("GET /old\n"))},
+		{name: "var/log/nginx/error.log", content: []byte("error\n")},
+		{name: "var/log/auth.log", content: []byte("sshd\n\nsudo")},
+	})
+
+	plain := filepath.Join(dir, "logs.tar")
+	require.NoError(t, os.WriteFile(plain, content, 0o644))
+
+	compressed := filepath.Join(dir, "logs.tgz")
+	require.NoError(t, os.WriteFile(compressed, gzipped(t, content), 0o644))
+
+	for _, filename := range []string{plain, compressed} {
+		t.Run(filepath.Base(filename), func(t *testing.T) {
+			events := oneShot(t, "source: tar\nfilename: "+filename)
+			assert.Equal(t, []string{
+				"var/log/nginx/access.log: GET /",
+				"var/log/nginx/access.log: GET /login",
+				"var/log/nginx/access.log.1.gz: GET /old",
+				"var/log/nginx/error.log: error",
+				"var/log/auth.log: sshd",
+				"var/log/auth.log: sudo",
+			}, lines(events))
+
+			assert.Equal(t, filename, events[0].Line.Src)
+			assert.Equal(t, types.TIMEMACHINE, events[0].ExpectMode)
+		})
+	}
+
+	events := oneShot(t, "source: tar\nfilename: "+plain+"\nglob: access.log*")
+	assert.Equal(t, []string{
+		"var/log/nginx/access.log: GET /",
+		"var/log/nginx/access.log: GET /login",
+		"var/log/nginx/access.log.1.gz: GET /old",
+	}, lines(events))
+
+	events = oneShot(t, "source: tar\nfilename: "+plain+"\nglob: var/log/*.log")
+	assert.Equal(t, []string{"var/log/auth.log: sshd", "var/log/auth.log: sudo"}, lines(events))
+}
+
+func TestCorrupt(t *testing.T) {
+	dir := t.TempDir()
+
+	// a file that is not gzip-compressed, despite its header
+	content := archive(t, []entry{
+		{name: "bad.log.gz", content: append([]byte{0x1f, 0x8b}, []byte("not gzip")...)},
+		{name: "good.log", content: []byte("line 1\nline 2\n")},
+		{name: "last.log", content: []byte("never read\n")},
+	})
+
+	filename := filepath.Join(dir, "logs.tar")
+
+	// truncated in the middle of the header of the last file
+	require.NoError(t, os.WriteFile(filename, content[:len(content)-1024-512-256], 0o644))
+
+	events := oneShot(t, "source: tar\nfilename: "+filename)
+	assert.Equal(t, []string{"good.log: line 1", "good.log: line 2"}, lines(events))
+
+	notTar := filepath.Join(dir, "not.tar")
+	require.NoError(t, os.WriteFile(notTar, bytes.Repeat([]byte("x"), 1024), 0o644))
+
+	s := TarSource{}
+	err := s.Configure([]byte("source: tar\nfilename: "+notTar), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	err = s.OneShotAcquisition(t.Context(), make(chan types.Event, 10), &tomb.Tomb{})
+	cstest.RequireErrorContains(t, err, "failed to read archive "+notTar)
+}
@@ -0,0 +1,566 @@
+package vectoracquisition
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/trace"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/connlimit"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/httpbody"
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/internal/ipfilter"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const (
+	dataSourceName = "vector"
+
+	defaultPath           = "/"
+	defaultHealthPath     = "/health"
+	defaultMessageField   = "message"
+	defaultTimestampField = "timestamp"
+	defaultMaxBodySize    = 10 * 1024 * 1024
+	readHeaderTimeout     = 10 * time.Second
+)
+
+var linesRead = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_vectorsource_hits_total",
+		Help: "Total events that were received from vector.",
+	},
+	[]string{"source"})
+
+var requestsRejected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cs_vectorsource_rejected_requests_total",
+		Help: "Total requests that were rejected: malformed, too large or not authenticated.",
+	},
+	[]string{"source", "reason"})
+
+type VectorConfiguration struct {
+	ListenAddr                        string          `yaml:"listen_addr"`
+	Path                              string          `yaml:"path"`            // where vector sends the events
+	HealthPath                        string          `yaml:"health_path"`     // for healthcheck.uri in the configuration of the sink
+	AuthToken                         string          `yaml:"auth_token"`      // if set, the requests must have it as a bearer token
+	TLS                               *TLSConfig      `yaml:"tls"`             // if not set, plain HTTP
+	MessageField                      string          `yaml:"message_field"`   // the field of the events sent as the log line
+	TimestampField                    string          `yaml:"timestamp_field"` // the field of the events with their time
+	MaxBodySize                       int64           `yaml:"max_body_size"`   // before decompression
+	MaxConnections                    int             `yaml:"max_connections"`
+	Body                              httpbody.Config `yaml:",inline"`
+	ipfilter.Config                   `yaml:",inline"`
+	configuration.DataSourceCommonCfg `yaml:",inline"`
+}
+
+type TLSConfig struct {
+	ServerCert string `yaml:"server_cert"`
+	ServerKey  string `yaml:"server_key"`
+	CaCert     string `yaml:"ca_cert"` // if set, the clients must present a certificate
+}
+
+type VectorSource struct {
+	metricsLevel int
+	config       VectorConfiguration
+	logger       *log.Entry
+	tlsConfig    *tls.Config
+	ipFilter     *ipfilter.Filter
+	bodyDecoder  *httpbody.Decoder
+	server       *http.Server
+}
+
+func (v *VectorSource) GetUuid() string {
+	return v.config.UniqueId
+}
+
+func (v *VectorSource) UnmarshalConfig(yamlConfig []byte) error {
+	v.config = VectorConfiguration{}
+
+	err := yaml.UnmarshalWithOptions(yamlConfig, &v.config, yaml.Strict())
+	if err != nil {
+		return fmt.Errorf("cannot parse %s datasource configuration: %s", dataSourceName, yaml.FormatError(err, false, false))
+	}
+
+	if v.config.ListenAddr == "" {
+		return errors.New("listen_addr is mandatory")
+	}
+
+	if v.config.Path == "" {
+		v.config.Path = defaultPath
+	}
+
+	if v.config.HealthPath == "" {
+		v.config.HealthPath = defaultHealthPath
+	}
+
+	if v.config.Path[0] != '/' || v.config.HealthPath[0] != '/' {
+		return errors.New("path and health_path must start with /")
+	}
+
+	if v.config.Path == v.config.HealthPath {
+		return errors.New("path and health_path must be different")
+	}
+
+	if v.config.TLS != nil && (v.config.TLS.ServerCert == "" || v.config.TLS.ServerKey == "") {
+		return errors.New("tls: server_cert and server_key are mandatory")
+	}
+
+	if v.config.MessageField == "" {
+		v.config.MessageField = defaultMessageField
+	}
+
+	if v.config.TimestampField == "" {
+		v.config.TimestampField = defaultTimestampField
+	}
+
+	if v.config.MaxBodySize < 0 {
+		return errors.New("max_body_size must be positive")
+	}
+
+	if v.config.MaxBodySize == 0 {
+		v.config.MaxBodySize = defaultMaxBodySize
+	}
+
+	if v.config.MaxConnections < 0 {
+		return errors.New("max_connections must be positive")
+	}
+
+	v.ipFilter, err = ipfilter.New(v.config.Config)
+	if err != nil {
+		return err
+	}
+
+	v.bodyDecoder, err = httpbody.New(v.config.Body)
+	if err != nil {
+		return err
+	}
+
+	if v.config.Mode == "" {
+		v.config.Mode = configuration.TAIL_MODE
+	}
+
+	if v.config.Mode != configuration.TAIL_MODE {
+		return fmt.Errorf("unsupported mode %s for %s datasource", v.config.Mode, dataSourceName)
+	}
+
+	return nil
+}
+
+func (c *TLSConfig) newTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.ServerCert, c.ServerKey)
+	if err != nil {
+		return nil, fmt.Errorf("while loading server certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.CaCert != "" {
+		caCert, err := os.ReadFile(c.CaCert)
+		if err != nil {
+			return nil, fmt.Errorf("while reading CA certificate: %w", err)
+		}
+
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no valid certificate found in %s", c.CaCert)
+		}
+
+		tlsConfig.ClientCAs = caCertPool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+func (v *VectorSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLevel int) error {
+	v.logger = logger
+	v.metricsLevel = metricsLevel
+
+	err := v.UnmarshalConfig(yamlConfig)
+	if err != nil {
+		return err
+	}
+
+	if v.config.TLS != nil {
+		v.tlsConfig, err = v.config.TLS.newTLSConfig()
+		if err != nil {
+			return fmt.Errorf("failed to create tls config: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (*VectorSource) ConfigureByDSN(string, map[string]string, *log.Entry, string) error {
+	return fmt.Errorf("%s datasource does not support command-line acquisition", dataSourceName)
+}
+
+func (v *VectorSource) GetMode() string {
+	return v.config.Mode
+}
+
+func (*VectorSource) GetName() string {
+	return dataSourceName
+}
+
+func (*VectorSource) OneShotAcquisition(_ context.Context, _ chan types.Event, _ *tomb.Tomb) error {
+	return fmt.Errorf("%s datasource does not support one-shot acquisition", dataSourceName)
+}
+
+func (*VectorSource) CanRun() error {
+	return nil
+}
+
+func (*VectorSource) GetMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, requestsRejected, connlimit.Rejected, ipfilter.Rejected, httpbody.Rejected}
+}
+
+func (*VectorSource) GetAggregMetrics() []prometheus.Collector {
+	return []prometheus.Collector{linesRead, requestsRejected, connlimit.Rejected, ipfilter.Rejected, httpbody.Rejected}
+}
+
+func (v *VectorSource) Dump() any {
+	return v
+}
+
+// reject counts a rejected request by listen address, the client is only logged to keep the
+// cardinality of the metric bounded.
+func (v *VectorSource) reject(client string, reason string) {
+	v.logger.Warnf("rejecting request from %s: %s", client, reason)
+
+	if v.metricsLevel == configuration.METRICS_NONE {
+		return
+	}
+
+	requestsRejected.With(prometheus.Labels{"source": v.config.ListenAddr, "reason": reason}).Inc()
+}
+
+func (v *VectorSource) rejectBody(r *http.Request, reason string) {
+	v.logger.Warnf("rejecting request from %s: %s", r.RemoteAddr, reason)
+
+	if v.metricsLevel != configuration.METRICS_NONE {
+		httpbody.Rejected.With(prometheus.Labels{"datasource": dataSourceName, "reason": reason}).Inc()
+	}
+}
+
+func (v *VectorSource) authorized(r *http.Request) bool {
+	if v.config.AuthToken == "" {
+		return true
+	}
+
+	expected := "Bearer " + v.config.AuthToken
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+// decodeBody returns the events of a request. The http sink of vector sends them as a JSON
+// array with the json codec, or as one JSON object per line with newline_delimited framing.
+func decodeBody(body []byte) ([]map[string]any, error) {
+	body = bytes.TrimSpace(body)
+
+	if len(body) > 0 && body[0] == '[' {
+		var events []map[string]any
+
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+
+		if err := dec.Decode(&events); err != nil {
+			return nil, err
+		}
+
+		return events, nil
+	}
+
+	events := []map[string]any{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		event := map[string]any{}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+
+		if err := dec.Decode(&event); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		events = append(events, event)
+	}
+
+	return events, scanner.Err()
+}
+
+// fieldString converts the value of a field to a string: the numbers as they were sent, the
+// objects and arrays as JSON.
+func fieldString(value any) (string, bool) {
+	switch value := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case bool:
+		return strconv.FormatBool(value), true
+	default:
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return "", false
+		}
+
+		return string(raw), true
+	}
+}
+
+// parseTimestamp reads the timestamps of vector, RFC 3339 strings, or unix timestamps in
+// seconds.
+func parseTimestamp(value any) (time.Time, bool) {
+	switch value := value.(type) {
+	case string:
+		ts, err := time.Parse(time.RFC3339Nano, value)
+		return ts, err == nil
+	case json.Number:
+		f, err := value.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		sec, frac := math.Modf(f)
+
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), true
+	}
+
+	return time.Time{}, false
+}
+
+// makeEvent creates the event of a vector event. The message field is the log line, the
+// timestamp field its time, and the other fields are stored in the metadata as vector_<field>.
+// If the event has no message field, the whole event is the log line, as JSON.
+func (v *VectorSource) makeEvent(event map[string]any, client string) types.Event {
+	evt := types.MakeEvent(v.config.UseTimeMachine, types.LOG, true)
+	evt.Line = types.Line{
+		Labels:  v.config.Labels,
+		Src:     client,
+		Process: true,
+		Module:  dataSourceName,
+	}
+
+	if ts, ok := parseTimestamp(event[v.config.TimestampField]); ok {
+		evt.Line.Time = ts
+	} else {
+		evt.Line.Time = time.Now().UTC()
+	}
+
+	for name, value := range event {
+		if name == v.config.MessageField || name == v.config.TimestampField {
+			continue
+		}
+
+		if s, ok := fieldString(value); ok {
+			evt.Meta["vector_"+name] = s
+		}
+	}
+
+	if message, ok := fieldString(event[v.config.MessageField]); ok {
+		evt.Line.Raw = message
+		return evt
+	}
+
+	evt.Line.Raw, _ = fieldString(event)
+
+	return evt
+}
+
+// handleEvents receives a batch of events. The batch is rejected as a whole if one of the events
+// is malformed. While the datasource is stopping, vector is asked to send the rest of the batch
+// again later.
+func (v *VectorSource) handleEvents(w http.ResponseWriter, r *http.Request, out chan types.Event, t *tomb.Tomb) {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			v.reject(client, "too_large")
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+
+			return
+		}
+
+		v.logger.Debugf("cannot read request from %s: %s", client, err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+
+		return
+	}
+
+	events, err := decodeBody(body)
+	if err != nil {
+		v.logger.Debugf("malformed events from %s: %s", client, err)
+		v.reject(client, "malformed")
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+
+		return
+	}
+
+	for _, event := range events {
+		if v.metricsLevel != configuration.METRICS_NONE {
+			linesRead.With(prometheus.Labels{"source": client}).Inc()
+		}
+
+		select {
+		case out <- v.makeEvent(event, client):
+		case <-t.Dying():
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (v *VectorSource) handler(out chan types.Event, t *tomb.Tomb) http.Handler {
+	events := httpbody.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v.handleEvents(w, r, out, t)
+	}), v.bodyDecoder, v.rejectBody)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(v.config.HealthPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !t.Alive() {
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc(v.config.Path, func(w http.ResponseWriter, r *http.Request) {
+		if !v.authorized(r) {
+			client, _, _ := net.SplitHostPort(r.RemoteAddr)
+			v.reject(client, "unauthorized")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.ContentLength > v.config.MaxBodySize {
+			client, _, _ := net.SplitHostPort(r.RemoteAddr)
+			v.reject(client, "too_large")
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, v.config.MaxBodySize)
+
+		events.ServeHTTP(w, r)
+	})
+
+	return mux
+}
+
+func (v *VectorSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	lc := net.ListenConfig{}
+
+	listener, err := lc.Listen(ctx, "tcp", v.config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", v.config.ListenAddr, err)
+	}
+
+	listener = ipfilter.Listen(listener, v.ipFilter, func(conn net.Conn) {
+		v.logger.Debugf("rejecting connection from %s: source not allowed", conn.RemoteAddr())
+
+		if v.metricsLevel != configuration.METRICS_NONE {
+			ipfilter.Rejected.With(prometheus.Labels{"datasource": dataSourceName}).Inc()
+		}
+	})
+
+	listener = connlimit.Listen(listener, v.config.MaxConnections, func(conn net.Conn) {
+		v.logger.Debugf("rejecting connection from %s: max_connections reached", conn.RemoteAddr())
+
+		if v.metricsLevel != configuration.METRICS_NONE {
+			connlimit.Rejected.With(prometheus.Labels{"datasource": dataSourceName, "addr": v.config.ListenAddr}).Inc()
+		}
+	})
+
+	if v.tlsConfig != nil {
+		listener = tls.NewListener(listener, v.tlsConfig)
+	}
+
+	v.server = &http.Server{
+		Handler:           v.handler(out, t),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	v.logger.Infof("listening for vector events on %s", v.config.ListenAddr)
+
+	t.Go(func() error {
+		defer trace.CatchPanic("crowdsec/acquis/vector/server")
+
+		err := v.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("vector server failed: %w", err)
+		}
+
+		return nil
+	})
+
+	t.Go(func() error {
+		<-t.Dying()
+
+		v.logger.Infof("%s datasource stopping", dataSourceName)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := v.server.Shutdown(shutdownCtx); err != nil {
+			v.logger.Errorf("while stopping the server: %s", err)
+		}
+
+		return nil
+	})
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package vectoracquisition
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		config      string
+		expectedErr string
+	}{
+		{
+			config:      `foobar: asd`,
+			expectedErr: `cannot parse vector datasource configuration: [1:1] unknown field "foobar"`,
+		},
+		{
+			config: `
+source: vector`,
+			expectedErr: "listen_addr is mandatory",
+		},
+		{
+			config: `
+source: vector
+listen_addr: 127.0.0.1:9000
+path: events`,
+			expectedErr: "path and health_path must start with /",
+		},
+		{
+			config: `
+source: vector
+listen_addr: 127.0.0.1:9000
+path: /health`,
+			expectedErr: "path and health_path must be different",
+		},
+		{
+			config: `
+source: vector
+listen_addr: 127.0.0.1:9000
+tls:
+  server_cert: /tmp/cert.pem`,
+			expectedErr: "tls: server_cert and server_key are mandatory",
+		},
+		{
+			config: `
+source: vector
+listen_addr: 127.0.0.1:9000
+max_body_size: -1`,
+			expectedErr: "max_body_size must be positive",
+		},
+		{
+			config: `
+source: vector
+listen_addr: 127.0.0.1:9000
+content_encodings: [br]`,
+			expectedErr: "content_encodings: unsupported encoding 'br'",
+		},
+		{
+			config: `
+source: vector
+listen_addr: 127.0.0.1:9000
+mode: cat`,
+			expectedErr: "unsupported mode cat for vector datasource",
+		},
+		{
+			config: `
+source: vector
+listen_addr: 127.0.0.1:9000
+path: /vector
+health_path: /vector/health
+auth_token: secret
+message_field: msg
+timestamp_field: ts
+max_body_size: 1048576
+max_connections: 10
+allowed_sources: [10.0.0.0/8]`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.config, func(t *testing.T) {
+			v := VectorSource{}
+			err := v.Configure([]byte(tc.config), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	events, err := decodeBody([]byte(`[{"message":"a"},{"message":"b"}]`))
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	events, err = decodeBody([]byte("{\"message\":\"a\"}\n\n{\"message\":\"b\"}\n"))
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	_, err = decodeBody([]byte("{\"message\":\"a\"}\n{\"message\":"))
+	cstest.RequireErrorContains(t, err, "line 2: unexpected EOF")
+}
+
+func TestMakeEvent(t *testing.T) {
+	v := VectorSource{}
+	err := v.Configure([]byte("source: vector\nlisten_addr: 127.0.0.1:9000\nlabels:\n  type: nginx"), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	events, err := decodeBody([]byte(`{"message":"GET / 200","timestamp":"2024-03-01T12:00:00.123456Z","host":"web-1","status":200,"kubernetes":{"pod":"nginx"},"empty":null}`))
+	require.NoError(t, err)
+
+	evt := v.makeEvent(events[0], "10.0.0.1")
+	assert.Equal(t, "GET / 200", evt.Line.Raw)
+	assert.Equal(t, time.Date(2024, 3, 1, 12, 0, 0, 123456000, time.UTC), evt.Line.Time)
+	assert.Equal(t, "10.0.0.1", evt.Line.Src)
+	assert.Equal(t, "nginx", evt.Line.Labels["type"])
+	assert.Equal(t, map[string]string{
+		"vector_host":       "web-1",
+		"vector_status":     "200",
+		"vector_kubernetes": `{"pod":"nginx"}`,
+	}, evt.Meta)
+
+	// no message field, the whole event is the line
+	events, err = decodeBody([]byte(`{"msg":"hello","timestamp":1709294400.5}`))
+	require.NoError(t, err)
+
+	evt = v.makeEvent(events[0], "10.0.0.1")
+	assert.JSONEq(t, `{"msg":"hello","timestamp":1709294400.5}`, evt.Line.Raw)
+	assert.Equal(t, time.Date(2024, 3, 1, 12, 0, 0, 500000000, time.UTC), evt.Line.Time)
+}
+
+func TestRejectMetric(t *testing.T) {
+	v := VectorSource{}
+	err := v.Configure([]byte("source: vector\nlisten_addr: 127.0.0.1:9001"), log.WithField("type", dataSourceName), configuration.METRICS_FULL)
+	require.NoError(t, err)
+
+	v.reject("192.0.2.1", "unauthorized")
+	v.reject("192.0.2.2", "unauthorized")
+
+	// by listen address, not by client
+	assert.InDelta(t, 2, testutil.ToFloat64(requestsRejected.WithLabelValues("127.0.0.1:9001", "unauthorized")), 0)
+}
+
+func startSource(t *testing.T, config string) (chan types.Event, *tomb.Tomb) {
+	v := VectorSource{}
+	err := v.Configure([]byte(config), log.WithField("type", dataSourceName), configuration.METRICS_NONE)
+	require.NoError(t, err)
+
+	out := make(chan types.Event, 10)
+	tmb := &tomb.Tomb{}
+
+	require.NoError(t, v.StreamingAcquisition(t.Context(), out, tmb))
+
+	t.Cleanup(func() {
+		tmb.Kill(nil)
+		require.NoError(t, tmb.Wait())
+	})
+
+	return out, tmb
+}
+
+func post(t *testing.T, url string, body []byte, header map[string]string) int {
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, url, bytes.NewReader(body))
+	require.NoError(t, err)
+
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestStreamingAcquisition(t *testing.T) {
+	out, _ := startSource(t, `
+source: vector
+listen_addr: 127.0.0.1:49331
+auth_token: secret
+max_body_size: 1024`)
+
+	url := "http://127.0.0.1:49331/"
+	auth := map[string]string{"Authorization": "Bearer secret"}
+
+	resp, err := http.Get("http://127.0.0.1:49331/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, http.StatusUnauthorized, post(t, url, []byte(`{"message":"a"}`), nil))
+
+	assert.Equal(t, http.StatusOK, post(t, url, []byte("{\"message\":\"a\"}\n{\"message\":\"b\"}\n"), auth))
+	assert.Equal(t, "a", (<-out).Line.Raw)
+	assert.Equal(t, "b", (<-out).Line.Raw)
+
+	// the batch is rejected as a whole
+	assert.Equal(t, http.StatusBadRequest, post(t, url, []byte("{\"message\":\"c\"}\nnot json\n"), auth))
+
+	compressed := bytes.Buffer{}
+	gz := gzip.NewWriter(&compressed)
+	_, err = gz.Write([]byte(`[{"message":"d"}]`))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	gzAuth := map[string]string{"Authorization": "Bearer secret", "Content-Encoding": "gzip"}
+	assert.Equal(t, http.StatusOK, post(t, url, compressed.Bytes(), gzAuth))
+	assert.Equal(t, "d", (<-out).Line.Raw)
+
+	tooLarge := `[{"message":"` + strings.Repeat("x", 2048) + `"}]`
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(t, url, []byte(tooLarge), auth))
+
+	select {
+	case evt := <-out:
+		t.Fatalf("unexpected event %s", evt.Line.Raw)
+	default:
+	}
+}
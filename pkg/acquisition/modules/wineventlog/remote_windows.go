@@ -0,0 +1,176 @@
+//go:build windows
+
+package wineventlogacquisition
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/google/winops/winlog"
+	"github.com/google/winops/winlog/wevtapi"
+	"golang.org/x/sys/windows"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const (
+	// EVT_LOGIN_CLASS
+	evtRPCLoginClass = 1
+
+	initialBackoff    = 1 * time.Second
+	defaultMaxBackoff = 1 * time.Minute
+)
+
+// EVT_RPC_LOGIN_FLAGS
+var authFlags = map[string]uint32{
+	"default":   0,
+	"negotiate": 1,
+	"kerberos":  2,
+	"ntlm":      3,
+}
+
+// RemoteConfig reads the event log of another host, through the remote EventLog API (RPC).
+// The credentials must be given explicitly, the account crowdsec runs as is never used. The
+// account only needs to read the event logs of the remote host: on a domain, add it to the
+// "Event Log Readers" group of the host, and allow the "Remote Event Log Management" rules of
+// its firewall.
+type RemoteConfig struct {
+	Host       string        `yaml:"host"`
+	Username   string        `yaml:"username"`
+	Domain     string        `yaml:"domain"`
+	Password   string        `yaml:"password"`
+	Auth       string        `yaml:"auth"`        // default, negotiate, kerberos or ntlm
+	MaxBackoff time.Duration `yaml:"max_backoff"` // max delay between two reconnections
+}
+
+// EVT_RPC_LOGIN
+type evtRPCLogin struct {
+	Server   *uint16
+	User     *uint16
+	Domain   *uint16
+	Password *uint16
+	Flags    uint32
+}
+
+func (c *RemoteConfig) validate() error {
+	if c.Host == "" {
+		return errors.New("remote: host is required")
+	}
+
+	if c.Username == "" || c.Password == "" {
+		return errors.New("remote: username and password are required")
+	}
+
+	if c.Auth == "" {
+		c.Auth = "negotiate"
+	}
+
+	if _, ok := authFlags[c.Auth]; !ok {
+		return fmt.Errorf("remote: invalid auth '%s': must be default, negotiate, kerberos or ntlm", c.Auth)
+	}
+
+	if c.MaxBackoff < 0 {
+		return errors.New("remote: max_backoff must be positive")
+	}
+
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = defaultMaxBackoff
+	}
+
+	return nil
+}
+
+func (c *RemoteConfig) openSession() (windows.Handle, error) {
+	login := evtRPCLogin{Flags: authFlags[c.Auth]}
+
+	var err error
+
+	for _, field := range []struct {
+		value string
+		ptr   **uint16
+	}{
+		{c.Host, &login.Server},
+		{c.Username, &login.User},
+		{c.Domain, &login.Domain},
+		{c.Password, &login.Password},
+	} {
+		if field.value == "" {
+			continue
+		}
+
+		if *field.ptr, err = syscall.UTF16PtrFromString(field.value); err != nil {
+			return 0, fmt.Errorf("syscall.UTF16PtrFromString failed: %v", err)
+		}
+	}
+
+	session, err := wevtapi.EvtOpenSession(evtRPCLoginClass, uintptr(unsafe.Pointer(&login)), 0, 0)
+	runtime.KeepAlive(&login)
+
+	if err != nil {
+		return 0, fmt.Errorf("cannot open a session on %s: %w", c.Host, err)
+	}
+
+	return session, nil
+}
+
+// getRemoteEvents subscribes to the event log of the remote host, and subscribes again with
+// a backoff when the connection is lost. The subscription starts after the last event that
+// was read, so that the events logged while disconnected are not missed.
+func (w *WinEventLogSource) getRemoteEvents(out chan types.Event, t *tomb.Tomb) error {
+	bookmark, err := wevtapi.EvtCreateBookmark(nil)
+	if err != nil {
+		return fmt.Errorf("wevtapi.EvtCreateBookmark failed: %v", err)
+	}
+
+	defer winlog.Close(bookmark)
+
+	w.bookmark = bookmark
+
+	backoff := initialBackoff
+
+	for {
+		session, err := w.config.Remote.openSession()
+		if err == nil {
+			w.evtConfig.Session = session
+
+			if w.bookmarked {
+				w.evtConfig.Bookmark = bookmark
+				w.evtConfig.Flags = wevtapi.EvtSubscribeStartAfterBookmark
+			}
+
+			w.logger.Infof("subscribing to the event log of %s", w.config.Remote.Host)
+
+			var received bool
+
+			received, err = w.getEvents(out, t)
+
+			w.evtConfig.Session = 0
+
+			winlog.Close(session)
+
+			if err == nil {
+				return nil
+			}
+
+			if received {
+				backoff = initialBackoff
+			}
+		}
+
+		w.logger.Warnf("lost the event log of %s, reconnecting in %s: %s", w.config.Remote.Host, backoff, err)
+
+		select {
+		case <-t.Dying():
+			w.logger.Infof("wineventlog is dying")
+			return nil
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, w.config.Remote.MaxBackoff)
+	}
+}
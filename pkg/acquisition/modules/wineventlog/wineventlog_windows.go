@@ -33,7 +33,8 @@ type WinEventLogConfiguration struct {
 	EventIDs                          []int  `yaml:"event_ids"`
 	XPathQuery                        string `yaml:"xpath_query"`
 	EventFile                         string
-	PrettyName                        string `yaml:"pretty_name"`
+	PrettyName                        string        `yaml:"pretty_name"`
+	Remote                            *RemoteConfig `yaml:"remote"` // read the event log of another host
 }
 
 type WinEventLogSource struct {
@@ -43,6 +44,8 @@ type WinEventLogSource struct {
 	evtConfig    *winlog.SubscribeConfig
 	query        string
 	name         string
+	bookmark     windows.Handle // last event read from a remote host
+	bookmarked   bool
 }
 
 type QueryList struct {
@@ -107,6 +110,15 @@ func (w *WinEventLogSource) getXMLEvents(config *winlog.SubscribeConfig, publish
 		}
 	}()
 
+	// Resume after the last event when reconnecting to a remote host.
+	if w.bookmark != 0 && returned > 0 {
+		if err := wevtapi.EvtUpdateBookmark(w.bookmark, events[returned-1]); err != nil {
+			w.logger.Errorf("Failed to update bookmark: %v", err)
+		} else {
+			w.bookmarked = true
+		}
+	}
+
 	// Render events.
 	var renderedEvents []string
 	for _, event := range events[:returned] {
@@ -162,11 +174,15 @@ func (w *WinEventLogSource) buildXpathQuery() (string, error) {
 	return string(xpathQuery), nil
 }
 
-func (w *WinEventLogSource) getEvents(out chan types.Event, t *tomb.Tomb) error {
+// getEvents reads the subscription until the datasource is stopped. It returns whether events
+// were received, to reset the reconnection backoff of a remote host.
+func (w *WinEventLogSource) getEvents(out chan types.Event, t *tomb.Tomb) (bool, error) {
+	received := false
+
 	subscription, err := winlog.Subscribe(w.evtConfig)
 	if err != nil {
 		w.logger.Errorf("Failed to subscribe to event log: %s", err)
-		return err
+		return received, err
 	}
 	defer winlog.Close(subscription)
 	publisherCache := make(map[string]windows.Handle)
@@ -179,22 +195,27 @@ func (w *WinEventLogSource) getEvents(out chan types.Event, t *tomb.Tomb) error
 		select {
 		case <-t.Dying():
 			w.logger.Infof("wineventlog is dying")
-			return nil
+			return received, nil
 		default:
 			status, err := windows.WaitForSingleObject(w.evtConfig.SignalEvent, 1000)
 			if err != nil {
 				w.logger.Errorf("WaitForSingleObject failed: %s", err)
-				return err
+				return received, err
 			}
 			if status == syscall.WAIT_OBJECT_0 {
 				renderedEvents, err := w.getXMLEvents(w.evtConfig, publisherCache, subscription, 500)
 				if errors.Is(err, windows.ERROR_NO_MORE_ITEMS) {
 					windows.ResetEvent(w.evtConfig.SignalEvent)
 				} else if err != nil {
+					// the connection to the remote host is lost
+					if w.config.Remote != nil {
+						return received, err
+					}
 					w.logger.Errorf("getXMLEvents failed: %v", err)
 					continue
 				}
 				for _, event := range renderedEvents {
+					received = true
 					if w.metricsLevel != configuration.METRICS_NONE {
 						linesRead.With(prometheus.Labels{"source": w.name}).Inc()
 					}
@@ -278,9 +299,18 @@ func (w *WinEventLogSource) UnmarshalConfig(yamlConfig []byte) error {
 		}
 	}
 
-	if w.config.PrettyName != "" {
+	if w.config.Remote != nil {
+		if err := w.config.Remote.validate(); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case w.config.PrettyName != "":
 		w.name = w.config.PrettyName
-	} else {
+	case w.config.Remote != nil:
+		w.name = w.config.Remote.Host + ":" + w.query
+	default:
 		w.name = w.query
 	}
 
@@ -462,7 +492,11 @@ func (w *WinEventLogSource) CanRun() error {
 func (w *WinEventLogSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
 	t.Go(func() error {
 		defer trace.CatchPanic("crowdsec/acquis/wineventlog/streaming")
-		return w.getEvents(out, t)
+		if w.config.Remote != nil {
+			return w.getRemoteEvents(out, t)
+		}
+		_, err := w.getEvents(out, t)
+		return err
 	})
 	return nil
 }
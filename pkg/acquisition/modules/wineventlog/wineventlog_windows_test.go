@@ -59,6 +59,31 @@ xpath_query: test`,
 event_ids: true`,
 			expectedErr: "[2:12] boolean was used where sequence is expected",
 		},
+		{
+			config: `source: wineventlog
+event_channel: Security
+remote:
+  username: reader
+  password: secret`,
+			expectedErr: "remote: host is required",
+		},
+		{
+			config: `source: wineventlog
+event_channel: Security
+remote:
+  host: dc1.example.com`,
+			expectedErr: "remote: username and password are required",
+		},
+		{
+			config: `source: wineventlog
+event_channel: Security
+remote:
+  host: dc1.example.com
+  username: reader
+  password: secret
+  auth: basic`,
+			expectedErr: "remote: invalid auth 'basic': must be default, negotiate, kerberos or ntlm",
+		},
 	}
 
 	subLogger := log.WithField("type", "windowseventlog")
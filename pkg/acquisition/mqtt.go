@@ -0,0 +1,12 @@
+//go:build !no_datasource_mqtt
+
+package acquisition
+
+import (
+	mqttacquisition "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/mqtt"
+)
+
+//nolint:gochecknoinits
+func init() {
+	registerDataSource("mqtt", func() DataSource { return &mqttacquisition.MQTTSource{} })
+}
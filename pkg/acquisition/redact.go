@@ -0,0 +1,96 @@
+package acquisition
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// redactor masks the secrets and personal data of the events with redact, before they reach the
+// parsers, the buckets or the logs of crowdsec. The rules are applied in order, to the line and
+// to the metadata set by the datasource.
+type redactor struct {
+	rules      []redactRule
+	datasource string // for the metrics
+}
+
+type redactRule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+func newRedactor(rules []configuration.RedactRule, datasource string) (*redactor, error) {
+	r := &redactor{datasource: datasource}
+
+	for _, rule := range rules {
+		if rule.Pattern == "" {
+			return nil, errors.New("redact: empty pattern")
+		}
+
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redact: invalid pattern '%s': %w", rule.Pattern, err)
+		}
+
+		r.rules = append(r.rules, redactRule{re: re, replacement: rule.Replacement})
+	}
+
+	return r, nil
+}
+
+// replace returns s with the matches replaced, and their number.
+func (r redactRule) replace(s string) (string, int) {
+	matches := r.re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s, 0
+	}
+
+	ret := make([]byte, 0, len(s))
+	last := 0
+
+	for _, m := range matches {
+		ret = append(ret, s[last:m[0]]...)
+		ret = r.re.ExpandString(ret, r.replacement, s, m)
+		last = m[1]
+	}
+
+	ret = append(ret, s[last:]...)
+
+	return string(ret), len(matches)
+}
+
+func (r *redactor) redact(s string) (string, int) {
+	total := 0
+
+	for _, rule := range r.rules {
+		var n int
+
+		s, n = rule.replace(s)
+		total += n
+	}
+
+	return s, total
+}
+
+func (r *redactor) apply(evt *types.Event) {
+	var count int
+
+	evt.Line.Raw, count = r.redact(evt.Line.Raw)
+
+	for key, value := range evt.Meta {
+		redacted, n := r.redact(value)
+		if n > 0 {
+			evt.Meta[key] = redacted
+			count += n
+		}
+	}
+
+	if count > 0 {
+		redactedMatches.With(prometheus.Labels{"datasource": r.datasource}).Add(float64(count))
+	}
+}
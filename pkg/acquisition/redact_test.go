@@ -0,0 +1,73 @@
+package acquisition
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tomb "gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestRedactor(t *testing.T) {
+	r, err := newRedactor([]configuration.RedactRule{
+		{Pattern: `password=\S+`, Replacement: "password=***"},
+		{Pattern: `(?P<user>[a-z]+)@example\.com`, Replacement: "${user}@<redacted>"},
+		{Pattern: ` token [0-9a-f]+`},
+	}, "redact-test")
+	require.NoError(t, err)
+
+	evt := types.MakeEvent(false, types.LOG, true)
+	evt.Line.Raw = "login bob@example.com password=hunter2 token deadbeef, alice@example.com password=x"
+	evt.Meta["user_agent"] = "curl password=secret"
+	evt.Meta["host"] = "web"
+
+	r.apply(&evt)
+
+	assert.Equal(t, "login bob@<redacted> password=***, alice@<redacted> password=***", evt.Line.Raw)
+	assert.Equal(t, "curl password=***", evt.Meta["user_agent"])
+	assert.Equal(t, "web", evt.Meta["host"])
+	assert.InDelta(t, 6, testutil.ToFloat64(redactedMatches.WithLabelValues("redact-test")), 0)
+
+	_, err = newRedactor([]configuration.RedactRule{{Pattern: `password=(\S+`}}, "redact-test")
+	cstest.RequireErrorContains(t, err, "redact: invalid pattern 'password=(\\S+': error parsing regexp: missing closing ): `password=(\\S+`")
+
+	_, err = newRedactor([]configuration.RedactRule{{Replacement: "***"}}, "redact-test")
+	cstest.RequireErrorContains(t, err, "redact: empty pattern")
+}
+
+func TestRedact(t *testing.T) {
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:     "with-redact",
+		UniqueId: "redact-test-uuid",
+		Redact:   []configuration.RedactRule{{Pattern: `secret`, Replacement: "***"}},
+		EventID:  true,
+	}, configuration.CAT_MODE)
+	require.NoError(t, err)
+
+	input := make(chan types.Event, 1)
+	output := make(chan types.Event, 1)
+
+	input <- types.Event{Line: types.Line{Raw: "a secret line"}}
+
+	close(input)
+	rt.forward(input, output, &tomb.Tomb{})
+
+	evt := <-output
+	assert.Equal(t, "a *** line", evt.Line.Raw)
+
+	// the id doesn't depend on the secret either
+	other, err := newEventIDHasher(nil)
+	require.NoError(t, err)
+	assert.Equal(t, other.id(rt, &types.Event{Line: types.Line{Raw: "a *** line"}}), evt.Meta[eventIDMetaKey])
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{
+		Redact: []configuration.RedactRule{{Pattern: `[`}},
+	}, configuration.CAT_MODE)
+	cstest.RequireErrorContains(t, err, "redact: invalid pattern '[': error parsing regexp: missing closing ]: `[`")
+}
@@ -0,0 +1,103 @@
+package acquisition
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+
+	"github.com/crowdsecurity/go-cs-lib/csstring"
+	"github.com/crowdsecurity/go-cs-lib/csyaml"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+)
+
+// ReloadSourceQuery reads again the acquisition file of the named datasource, and applies its
+// new query without restarting the acquisition. It fails if the datasource does not support it,
+// or if anything else changed in its configuration: a full reload is required in that case.
+func ReloadSourceQuery(ctx context.Context, name string) error {
+	rts := findSourceRuntimes(name)
+	if len(rts) == 0 {
+		return fmt.Errorf("%w '%s'", ErrUnknownSource, name)
+	}
+
+	for _, rt := range rts {
+		if err := rt.reloadQuery(ctx); err != nil {
+			return fmt.Errorf("datasource '%s': %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (rt *sourceRuntime) reloadQuery(ctx context.Context) error {
+	reloader, ok := rt.source.(QueryReloader)
+	if !ok {
+		return fmt.Errorf("datasources of type %s don't support query reload", rt.sourceType)
+	}
+
+	if rt.acquisFile == "" {
+		return errors.New("only datasources from acquisition files can be reloaded")
+	}
+
+	if rt.name == rt.uuid {
+		return errors.New("the datasource must have a name to be reloaded")
+	}
+
+	doc, err := findSourceDocument(rt.acquisFile, rt.name)
+	if err != nil {
+		return err
+	}
+
+	if err := reloader.ReloadQuery(ctx, doc); err != nil {
+		return err
+	}
+
+	rt.logger.Info("datasource query reloaded")
+
+	return nil
+}
+
+// findSourceDocument returns the configuration of the named datasource in an acquisition file.
+func findSourceDocument(acquisFile string, name string) ([]byte, error) {
+	content, err := os.ReadFile(acquisFile)
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := csstring.StrictExpand(string(content), os.LookupEnv)
+
+	documents, err := csyaml.SplitDocuments(strings.NewReader(expanded))
+	if err != nil {
+		return nil, err
+	}
+
+	var found []byte
+
+	for _, doc := range documents {
+		var sub configuration.DataSourceCommonCfg
+
+		if err := yaml.UnmarshalWithOptions(doc, &sub); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", acquisFile, errors.New(yaml.FormatError(err, false, false)))
+		}
+
+		if sub.Name != name {
+			continue
+		}
+
+		if found != nil {
+			return nil, fmt.Errorf("several datasources named '%s' in %s", name, acquisFile)
+		}
+
+		found = doc
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("datasource '%s' not found in %s", name, acquisFile)
+	}
+
+	return found, nil
+}
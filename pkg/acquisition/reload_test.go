@@ -0,0 +1,45 @@
+package acquisition
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+)
+
+func TestFindSourceDocument(t *testing.T) {
+	acquisFile := filepath.Join(t.TempDir(), "acquis.yaml")
+
+	content := `name: first
+source: loki
+query: '{job="${TEST_RELOAD_JOB}"}'
+---
+name: second
+source: journalctl
+---
+name: second
+source: journalctl
+`
+
+	require.NoError(t, os.WriteFile(acquisFile, []byte(content), 0o600))
+	t.Setenv("TEST_RELOAD_JOB", "nginx")
+
+	doc, err := findSourceDocument(acquisFile, "first")
+	require.NoError(t, err)
+	assert.Contains(t, string(doc), "nginx")
+
+	_, err = findSourceDocument(acquisFile, "second")
+	cstest.RequireErrorContains(t, err, "several datasources named 'second'")
+
+	_, err = findSourceDocument(acquisFile, "third")
+	cstest.RequireErrorContains(t, err, "datasource 'third' not found")
+}
+
+func TestReloadSourceQuery(t *testing.T) {
+	err := ReloadSourceQuery(t.Context(), "no-such-source")
+	require.ErrorIs(t, err, ErrUnknownSource)
+}
@@ -0,0 +1,142 @@
+package acquisition
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const defaultReorderMaxEvents = 10000
+
+// reorderBuffer sorts the events of a one-shot datasource by timestamp (evt.Line.Time)
+// within a sliding window. An event is released once it is older than the most recent
+// timestamp seen minus the window, or when the buffer is full.
+// Events older than the last released one can't be put back in order: they are released
+// right away and counted as late.
+// The buffered events are accounted in acquisitionMemory: while max_acquisition_memory is
+// exceeded, the oldest events are released before the end of the window.
+// With maxWait, the streaming datasources can't wait for a more recent event: expire also
+// releases the oldest event once it was held that long.
+type reorderBuffer struct {
+	window     time.Duration
+	maxEvents  int
+	maxWait    time.Duration
+	events     reorderHeap
+	seq        uint64
+	newest     time.Time
+	released   time.Time
+	datasource string // for the metrics
+}
+
+type reorderItem struct {
+	evt     types.Event
+	seq     uint64
+	size    int64
+	arrival time.Time
+}
+
+type reorderHeap []reorderItem
+
+func (h reorderHeap) Len() int { return len(h) }
+
+func (h reorderHeap) Less(i, j int) bool {
+	ti, tj := h[i].evt.Line.Time, h[j].evt.Line.Time
+	if ti.Equal(tj) {
+		return h[i].seq < h[j].seq
+	}
+
+	return ti.Before(tj)
+}
+
+func (h reorderHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *reorderHeap) Push(x any) { *h = append(*h, x.(reorderItem)) }
+
+func (h *reorderHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+
+	return item
+}
+
+func newReorderBuffer(window time.Duration, maxEvents int) *reorderBuffer {
+	if maxEvents <= 0 {
+		maxEvents = defaultReorderMaxEvents
+	}
+
+	return &reorderBuffer{
+		window:    window,
+		maxEvents: maxEvents,
+	}
+}
+
+// push adds an event to the buffer and returns the events that can be emitted, in order.
+// late is true if the event was too old to be reordered and is returned as-is.
+func (b *reorderBuffer) push(evt types.Event) (ready []types.Event, late bool) {
+	ts := evt.Line.Time
+
+	if !b.released.IsZero() && ts.Before(b.released) {
+		return []types.Event{evt}, true
+	}
+
+	b.seq++
+	size := eventSize(&evt)
+	acquisitionMemory.reserve(size)
+	heap.Push(&b.events, reorderItem{evt: evt, seq: b.seq, size: size, arrival: time.Now()})
+
+	if ts.After(b.newest) {
+		b.newest = ts
+	}
+
+	limit := b.newest.Add(-b.window)
+
+	for b.events.Len() > 0 {
+		oldest := b.events[0].evt.Line.Time
+		if b.events.Len() <= b.maxEvents && !oldest.Before(limit) {
+			if !acquisitionMemory.exceeded() {
+				break
+			}
+
+			memoryLimitedEvents.With(prometheus.Labels{"datasource": b.datasource}).Inc()
+		}
+
+		ready = append(ready, b.pop())
+	}
+
+	return ready, false
+}
+
+// expire returns, in order, the oldest events until one was held less than maxWait.
+func (b *reorderBuffer) expire(now time.Time) []types.Event {
+	var ready []types.Event
+
+	for b.events.Len() > 0 && now.Sub(b.events[0].arrival) >= b.maxWait {
+		ready = append(ready, b.pop())
+	}
+
+	return ready
+}
+
+// flush returns all the buffered events, in order.
+func (b *reorderBuffer) flush() []types.Event {
+	ret := make([]types.Event, 0, b.events.Len())
+
+	for b.events.Len() > 0 {
+		ret = append(ret, b.pop())
+	}
+
+	return ret
+}
+
+func (b *reorderBuffer) pop() types.Event {
+	item := heap.Pop(&b.events).(reorderItem)
+	b.released = item.evt.Line.Time
+	acquisitionMemory.release(item.size)
+
+	return item.evt
+}
@@ -0,0 +1,129 @@
+package acquisition
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestReorderBuffer(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mkEvt := func(offset time.Duration, raw string) types.Event {
+		evt := types.Event{}
+		evt.Line.Time = base.Add(offset)
+		evt.Line.Raw = raw
+
+		return evt
+	}
+
+	b := newReorderBuffer(10*time.Second, 0)
+
+	var (
+		got  []string
+		late []string
+	)
+
+	collect := func(evts []types.Event) {
+		for _, evt := range evts {
+			got = append(got, evt.Line.Raw)
+		}
+	}
+
+	for _, evt := range []types.Event{
+		mkEvt(5*time.Second, "b"),
+		mkEvt(0, "a"),
+		mkEvt(8*time.Second, "c"),
+		mkEvt(20*time.Second, "e"), // releases a, b and c
+		mkEvt(1*time.Second, "late"),
+		mkEvt(15*time.Second, "d"),
+	} {
+		ready, isLate := b.push(evt)
+		if isLate {
+			late = append(late, evt.Line.Raw)
+		}
+
+		collect(ready)
+	}
+
+	collect(b.flush())
+
+	assert.Equal(t, []string{"a", "b", "c", "late", "d", "e"}, got)
+	assert.Equal(t, []string{"late"}, late)
+}
+
+func TestReorderBufferMaxEvents(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	b := newReorderBuffer(time.Hour, 2)
+
+	for i := range 5 {
+		evt := types.Event{}
+		evt.Line.Time = base.Add(time.Duration(i) * time.Second)
+		ready, _ := b.push(evt)
+
+		if i < 2 {
+			assert.Empty(t, ready)
+		} else {
+			assert.Len(t, ready, 1)
+		}
+	}
+
+	assert.Len(t, b.flush(), 2)
+}
+
+func TestReorderBufferMemoryLimit(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mkEvt := func(i int) types.Event {
+		evt := types.Event{}
+		evt.Line.Time = base.Add(time.Duration(i) * time.Second)
+		evt.Line.Raw = "0123456789"
+
+		return evt
+	}
+
+	evt := mkEvt(0)
+	size := eventSize(&evt)
+
+	acquisitionMemory.setLimit(3 * size)
+	t.Cleanup(func() { acquisitionMemory.setLimit(0) })
+
+	b := newReorderBuffer(time.Hour, 0)
+
+	released := 0
+
+	for i := range 6 {
+		ready, _ := b.push(mkEvt(i))
+		released += len(ready)
+
+		// the buffer holds at most the limit, the window is never reached
+		assert.LessOrEqual(t, acquisitionMemory.used.Load(), 3*size)
+	}
+
+	assert.Equal(t, 3, released)
+	assert.Len(t, b.flush(), 3)
+	assert.Equal(t, int64(0), acquisitionMemory.used.Load())
+}
+
+func TestReorderBufferExpire(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	b := newReorderBuffer(time.Hour, 0)
+	b.maxWait = time.Minute
+
+	for _, offset := range []time.Duration{2 * time.Second, time.Second} {
+		evt := types.Event{}
+		evt.Line.Time = base.Add(offset)
+
+		ready, _ := b.push(evt)
+		assert.Empty(t, ready)
+	}
+
+	assert.Empty(t, b.expire(time.Now()))
+
+	ready := b.expire(time.Now().Add(time.Minute))
+	assert.Len(t, ready, 2)
+	assert.Equal(t, base.Add(time.Second), ready[0].Line.Time)
+}
@@ -0,0 +1,648 @@
+package acquisition
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	tomb "gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/trace"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// sourceRuntime holds the state of a running datasource that is handled by the acquisition
+// manager rather than by the datasource itself. Events emitted by the datasource go through
+// forward() before reaching the transform expression (if any) and the parsers.
+type sourceRuntime struct {
+	name       string
+	uuid       string
+	sourceType string
+	mode       string
+	logger     *log.Entry
+
+	mu         sync.Mutex
+	paused     bool
+	resume     chan struct{}
+	stopReason string // error of a one-shot datasource, for the stopped lifecycle event
+
+	// max_events, for one-shot datasources
+	maxEvents    int
+	stopSource   context.CancelFunc
+	limitReached bool
+
+	reorder *reorderBuffer
+	backlog *backlogQueue // nil unless max_backlog is set
+
+	metadata        map[string]string
+	sequence        *atomic.Uint64     // nil unless include_sequence is set
+	eventID         *eventIDHasher     // nil unless event_id is set
+	redactor        *redactor          // nil unless redact is set
+	logLevel        *logLevelExtractor // nil unless normalize_log_level is set
+	pipelineTag     string
+	ingestLatency   bool
+	lifecycleEvents bool
+
+	// warmup_discard, at most one of them is set
+	warmupEvents   int
+	warmupDuration time.Duration
+
+	source     DataSource
+	acquisFile string // empty for command-line datasources
+}
+
+// SourceStatus is the runtime state of a datasource, as reported by SourcesStatus.
+type SourceStatus struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Paused       bool   `json:"paused"`
+	Degraded     bool   `json:"degraded"`
+	Reason       string `json:"reason,omitempty"`
+	Backlog      int    `json:"backlog,omitempty"`
+	Backpressure bool   `json:"backpressure,omitempty"`
+}
+
+var (
+	sourceRuntimes   = map[string]*sourceRuntime{}
+	sourceRuntimesMu sync.RWMutex
+
+	// sequence counters by datasource name, they are kept across reloads but not restarts.
+	// Sources without a name get a new unique id, hence a new counter, on each reload.
+	sequences   = map[string]*atomic.Uint64{}
+	sequencesMu sync.Mutex
+)
+
+// ErrUnknownSource is returned when no loaded datasource matches a name.
+var ErrUnknownSource = errors.New("no datasource named")
+
+// sequenceMetaKey is set on every event of the datasources with include_sequence.
+const sequenceMetaKey = "acquisition_sequence"
+
+// ingestLatencyMetaKey is set on the events of the datasources with ingest_latency.
+const ingestLatencyMetaKey = "ingest_latency_ms"
+
+// reservedMetaKeys are set by the parsers and the hub, they can't be used as static metadata.
+var reservedMetaKeys = []string{
+	sequenceMetaKey,
+	ingestLatencyMetaKey,
+	eventIDMetaKey,
+	logLevelMetaKey,
+	lifecycleSourceMetaKey,
+	lifecycleReasonMetaKey,
+	types.LifecycleMetaKey,
+	"datasource_path",
+	"datasource_type",
+	"log_type",
+	"log_subtype",
+	"machine",
+	types.PipelineTagMetaKey,
+	"service",
+	"source_ip",
+	"source_range",
+	"timestamp",
+}
+
+func newSourceRuntime(commonCfg configuration.DataSourceCommonCfg, mode string) (*sourceRuntime, error) {
+	name := commonCfg.Name
+	if name == "" {
+		name = commonCfg.UniqueId
+	}
+
+	rt := &sourceRuntime{
+		name:       name,
+		uuid:       commonCfg.UniqueId,
+		sourceType: commonCfg.Source,
+		mode:       mode,
+		logger: log.WithFields(log.Fields{
+			"component":  "acquisition",
+			"datasource": name,
+		}),
+	}
+
+	if commonCfg.ReorderWindow < 0 || commonCfg.ReorderMaxEvents < 0 {
+		return nil, errors.New("reorder_window and reorder_max_events must be positive")
+	}
+
+	if commonCfg.ReorderWindow > 0 {
+		if mode != configuration.CAT_MODE {
+			return nil, errors.New("reorder_window is only supported in cat mode")
+		}
+
+		rt.reorder = newReorderBuffer(commonCfg.ReorderWindow, commonCfg.ReorderMaxEvents)
+		rt.reorder.datasource = name
+	}
+
+	// values have already been expanded with the rest of the acquisition file
+	for key := range commonCfg.Metadata {
+		if key == "" {
+			return nil, errors.New("metadata: empty key")
+		}
+
+		if slices.Contains(reservedMetaKeys, key) {
+			return nil, fmt.Errorf("metadata: '%s' is a reserved key", key)
+		}
+	}
+
+	if len(commonCfg.Metadata) > 0 {
+		rt.metadata = maps.Clone(commonCfg.Metadata)
+	}
+
+	if commonCfg.IncludeSequence {
+		rt.sequence = sequenceCounter(name)
+	}
+
+	if strings.TrimSpace(commonCfg.PipelineTag) != commonCfg.PipelineTag {
+		return nil, fmt.Errorf("pipeline_tag: '%s' has leading or trailing spaces", commonCfg.PipelineTag)
+	}
+
+	rt.pipelineTag = commonCfg.PipelineTag
+	rt.ingestLatency = commonCfg.IngestLatency
+	rt.lifecycleEvents = commonCfg.LifecycleEvents
+
+	if commonCfg.MaxBacklog < 0 {
+		return nil, errors.New("max_backlog must be positive")
+	}
+
+	if commonCfg.MaxBacklog > 0 {
+		rt.backlog = newBacklogQueue(commonCfg.MaxBacklog, name, rt.logger)
+	}
+
+	if err := rt.setWarmup(commonCfg.WarmupDiscard); err != nil {
+		return nil, err
+	}
+
+	if commonCfg.MaxEvents < 0 {
+		return nil, errors.New("max_events must be positive")
+	}
+
+	if commonCfg.MaxEvents > 0 && mode != configuration.CAT_MODE {
+		return nil, errors.New("max_events is only supported in cat mode")
+	}
+
+	rt.maxEvents = commonCfg.MaxEvents
+
+	if len(commonCfg.EventIDFields) > 0 && !commonCfg.EventID {
+		return nil, errors.New("event_id_fields requires event_id")
+	}
+
+	if commonCfg.EventID {
+		var err error
+
+		if rt.eventID, err = newEventIDHasher(commonCfg.EventIDFields); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(commonCfg.Redact) > 0 {
+		var err error
+
+		if rt.redactor, err = newRedactor(commonCfg.Redact, name); err != nil {
+			return nil, err
+		}
+	}
+
+	if (commonCfg.LogLevelField != "" || commonCfg.LogLevelRegexp != "") && !commonCfg.LogLevelNorm {
+		return nil, errors.New("log_level_field and log_level_regexp require normalize_log_level")
+	}
+
+	if commonCfg.LogLevelNorm {
+		var err error
+
+		if rt.logLevel, err = newLogLevelExtractor(commonCfg.LogLevelField, commonCfg.LogLevelRegexp); err != nil {
+			return nil, err
+		}
+	}
+
+	return rt, nil
+}
+
+// setWarmup parses warmup_discard, which is either a number of events or a duration.
+func (rt *sourceRuntime) setWarmup(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	if count, err := strconv.Atoi(value); err == nil {
+		if count < 0 {
+			return errors.New("warmup_discard must be positive")
+		}
+
+		rt.warmupEvents = count
+
+		return nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("warmup_discard: '%s' is neither a number of events nor a duration", value)
+	}
+
+	if duration < 0 {
+		return errors.New("warmup_discard must be positive")
+	}
+
+	rt.warmupDuration = duration
+
+	return nil
+}
+
+func sequenceCounter(name string) *atomic.Uint64 {
+	sequencesMu.Lock()
+	defer sequencesMu.Unlock()
+
+	counter, ok := sequences[name]
+	if !ok {
+		counter = &atomic.Uint64{}
+		sequences[name] = counter
+	}
+
+	return counter
+}
+
+// resetSourceRuntimes forgets the datasources of the previous load, before a reload.
+func resetSourceRuntimes() {
+	sourceRuntimesMu.Lock()
+	defer sourceRuntimesMu.Unlock()
+
+	sourceRuntimes = map[string]*sourceRuntime{}
+}
+
+func registerSourceRuntime(rt *sourceRuntime, src DataSource) {
+	sourceRuntimesMu.Lock()
+	defer sourceRuntimesMu.Unlock()
+
+	rt.source = src
+	sourceRuntimes[rt.uuid] = rt
+}
+
+func getSourceRuntime(uuid string) (*sourceRuntime, bool) {
+	sourceRuntimesMu.RLock()
+	defer sourceRuntimesMu.RUnlock()
+
+	rt, ok := sourceRuntimes[uuid]
+
+	return rt, ok
+}
+
+// findSourceRuntimes returns the runtimes matching a datasource name (or unique id).
+func findSourceRuntimes(name string) []*sourceRuntime {
+	sourceRuntimesMu.RLock()
+	defer sourceRuntimesMu.RUnlock()
+
+	var ret []*sourceRuntime
+
+	for _, rt := range sourceRuntimes {
+		if rt.name == name || rt.uuid == name {
+			ret = append(ret, rt)
+		}
+	}
+
+	return ret
+}
+
+// PauseSource stops forwarding the events of the named datasource to the parsers.
+// The datasource is not stopped: it blocks on its output channel, which holds its read loop
+// while keeping its connection (or file handle) open. The events already queued by max_backlog
+// are still sent.
+func PauseSource(name string) error {
+	rts := findSourceRuntimes(name)
+	if len(rts) == 0 {
+		return fmt.Errorf("%w '%s'", ErrUnknownSource, name)
+	}
+
+	for _, rt := range rts {
+		rt.pause()
+	}
+
+	return nil
+}
+
+// ResumeSource resumes a datasource paused by PauseSource.
+func ResumeSource(name string) error {
+	rts := findSourceRuntimes(name)
+	if len(rts) == 0 {
+		return fmt.Errorf("%w '%s'", ErrUnknownSource, name)
+	}
+
+	for _, rt := range rts {
+		rt.unpause()
+	}
+
+	return nil
+}
+
+// SourcesStatus returns the runtime state of all the datasources loaded from acquisition files.
+func SourcesStatus() []SourceStatus {
+	sourceRuntimesMu.RLock()
+	defer sourceRuntimesMu.RUnlock()
+
+	ret := make([]SourceStatus, 0, len(sourceRuntimes))
+
+	for _, rt := range sourceRuntimes {
+		status := SourceStatus{
+			Name:   rt.name,
+			Type:   rt.sourceType,
+			Paused: rt.isPaused(),
+		}
+
+		if hr, ok := rt.source.(HealthReporter); ok {
+			status.Degraded, status.Reason = hr.Degraded()
+		}
+
+		if rt.backlog != nil {
+			status.Backlog = rt.backlog.size()
+			status.Backpressure = rt.backlog.underPressure()
+		}
+
+		ret = append(ret, status)
+	}
+
+	slices.SortFunc(ret, func(a, b SourceStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return ret
+}
+
+func (rt *sourceRuntime) pause() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.paused {
+		return
+	}
+
+	rt.paused = true
+	rt.resume = make(chan struct{})
+	rt.logger.Info("datasource paused")
+}
+
+func (rt *sourceRuntime) unpause() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if !rt.paused {
+		return
+	}
+
+	rt.paused = false
+	close(rt.resume)
+	rt.logger.Info("datasource resumed")
+}
+
+func (rt *sourceRuntime) isPaused() bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	return rt.paused
+}
+
+// waitIfPaused blocks while the datasource is paused. It returns false if the acquisition is over.
+func (rt *sourceRuntime) waitIfPaused(acquisTomb *tomb.Tomb) bool {
+	rt.mu.Lock()
+	paused, resume := rt.paused, rt.resume
+	rt.mu.Unlock()
+
+	if !paused {
+		return true
+	}
+
+	select {
+	case <-resume:
+		return true
+	case <-acquisTomb.Dying():
+		// don't hold back the datasource while shutting down
+		return true
+	case <-acquisTomb.Dead():
+		return false
+	}
+}
+
+// sourceContext returns the context of a one-shot datasource, it is canceled once the
+// datasource has sent max_events.
+func (rt *sourceRuntime) sourceContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.maxEvents > 0 {
+		rt.stopSource = cancel
+	}
+
+	return ctx, cancel
+}
+
+func (rt *sourceRuntime) reachLimit() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.logger.Infof("max_events reached, stopping the datasource")
+
+	rt.limitReached = true
+
+	if rt.stopSource != nil {
+		rt.stopSource()
+	}
+}
+
+// stoppedByLimit returns whether the datasource was stopped by max_events: the error it
+// returns when its context is canceled is not a failure of the acquisition.
+func (rt *sourceRuntime) stoppedByLimit() bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	return rt.limitReached
+}
+
+// forward passes the events of the datasource to the output. It returns when the input is
+// closed (one-shot datasources) or when the acquisition tomb is dead. Events keep flowing
+// while the tomb is dying so that the datasource doesn't block on its output.
+func (rt *sourceRuntime) forward(input chan types.Event, output chan types.Event, acquisTomb *tomb.Tomb) {
+	defer trace.CatchPanic("crowdsec/acquis")
+
+	if rt.backlog != nil {
+		drained := make(chan struct{})
+
+		go func() {
+			defer close(drained)
+			rt.backlog.drain(output, acquisTomb)
+		}()
+
+		defer func() {
+			rt.backlog.close()
+			<-drained
+		}()
+	}
+
+	var dying <-chan struct{}
+
+	if rt.lifecycleEvents {
+		if !rt.send([]types.Event{rt.lifecycleEvent(lifecycleStarted, "acquisition started")}, output, acquisTomb) {
+			return
+		}
+
+		// streaming datasources stop with the acquisition, one-shot ones when their input is closed
+		if rt.mode == configuration.TAIL_MODE {
+			dying = acquisTomb.Dying()
+		}
+	}
+
+	discard := rt.warmupEvents
+	warmupEnd := time.Now().Add(rt.warmupDuration)
+	sent := 0
+
+	for {
+		select {
+		case <-acquisTomb.Dead():
+			return
+		case <-dying:
+			dying = nil
+
+			rt.send([]types.Event{rt.lifecycleEvent(lifecycleStopped, rt.stoppedReason(acquisTomb.Err(), false))}, output, acquisTomb)
+		case evt, ok := <-input:
+			if !ok {
+				if rt.reorder != nil {
+					rt.send(rt.reorder.flush(), output, acquisTomb)
+				}
+
+				if rt.maxEvents > 0 {
+					rt.logger.Infof("%d events sent (max_events: %d)", sent, rt.maxEvents)
+				}
+
+				if rt.lifecycleEvents {
+					rt.send([]types.Event{rt.lifecycleEvent(lifecycleStopped, rt.stoppedReason(nil, true))}, output, acquisTomb)
+				}
+
+				return
+			}
+
+			// warmup_discard: the events replayed when the datasource starts are dropped
+			if discard > 0 || (rt.warmupDuration > 0 && time.Now().Before(warmupEnd)) {
+				discard = max(discard-1, 0)
+
+				warmupDiscarded.With(prometheus.Labels{"datasource": rt.name}).Inc()
+
+				continue
+			}
+
+			// max_events: the datasource is stopped, what it sends until then is dropped
+			if rt.maxEvents > 0 && sent >= rt.maxEvents {
+				continue
+			}
+
+			// before anything else sees the line
+			if rt.redactor != nil {
+				rt.redactor.apply(&evt)
+			}
+
+			if dedup := acquisitionDedup.Load(); dedup != nil && dedup.seen(&evt) {
+				duplicateEvents.With(prometheus.Labels{"datasource": rt.name}).Inc()
+
+				continue
+			}
+
+			// before pausing, the time spent paused is not a lag of the datasource
+			if rt.ingestLatency {
+				rt.setIngestLatency(&evt)
+			}
+
+			if !rt.waitIfPaused(acquisTomb) {
+				return
+			}
+
+			for key, value := range rt.metadata {
+				evt.SetMeta(key, value)
+			}
+
+			if rt.pipelineTag != "" {
+				evt.SetMeta(types.PipelineTagMetaKey, rt.pipelineTag)
+			}
+
+			if rt.sequence != nil {
+				evt.SetMeta(sequenceMetaKey, strconv.FormatUint(rt.sequence.Add(1), 10))
+			}
+
+			if rt.logLevel != nil {
+				rt.logLevel.apply(&evt)
+			}
+
+			// after the metadata, that can be part of the id
+			if rt.eventID != nil {
+				evt.SetMeta(eventIDMetaKey, rt.eventID.id(rt, &evt))
+			}
+
+			if !rt.emit(evt, output, acquisTomb) {
+				return
+			}
+
+			sent++
+
+			if rt.maxEvents > 0 && sent >= rt.maxEvents {
+				rt.reachLimit()
+			}
+		}
+	}
+}
+
+// setIngestLatency records the delay between the timestamp of the log and now. It is skipped
+// for the events without a timestamp. The datasources that don't know the time of the logs
+// use the time they read them, the latency only covers the acquisition for them.
+// Timestamps in the future (clock skew) count as no latency.
+func (rt *sourceRuntime) setIngestLatency(evt *types.Event) {
+	if evt.Line.Time.IsZero() {
+		return
+	}
+
+	latency := max(time.Since(evt.Line.Time), 0)
+
+	evt.SetMeta(ingestLatencyMetaKey, strconv.FormatInt(latency.Milliseconds(), 10))
+	ingestLatencySeconds.With(prometheus.Labels{"datasource": rt.name}).Observe(latency.Seconds())
+}
+
+// emit sends an event to the output, going through the reordering buffer if enabled.
+func (rt *sourceRuntime) emit(evt types.Event, output chan types.Event, acquisTomb *tomb.Tomb) bool {
+	if rt.reorder == nil {
+		return rt.send([]types.Event{evt}, output, acquisTomb)
+	}
+
+	ready, late := rt.reorder.push(evt)
+	if late {
+		lateEvents.With(prometheus.Labels{"datasource": rt.name}).Inc()
+	}
+
+	return rt.send(ready, output, acquisTomb)
+}
+
+// send writes the events to the output, or to the backlog queue. It returns false if the
+// acquisition is over.
+func (rt *sourceRuntime) send(evts []types.Event, output chan types.Event, acquisTomb *tomb.Tomb) bool {
+	for _, evt := range evts {
+		if rt.backlog != nil {
+			if !rt.backlog.push(evt, acquisTomb) {
+				return false
+			}
+
+			continue
+		}
+
+		select {
+		case output <- evt:
+		case <-acquisTomb.Dead():
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,557 @@
+package acquisition
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tomb "gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
+	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+type MockTailWithUUID struct {
+	MockTail
+}
+
+func (f *MockTailWithUUID) GetUuid() string { return f.UniqueId }
+
+// MockCatUntilCanceled sends events until its context is canceled.
+type MockCatUntilCanceled struct {
+	MockCat
+}
+
+func (f *MockCatUntilCanceled) GetUuid() string { return f.UniqueId }
+
+func (f *MockCatUntilCanceled) OneShotAcquisition(ctx context.Context, out chan types.Event, _ *tomb.Tomb) error {
+	for i := 0; ; i++ {
+		evt := types.Event{}
+		evt.Line.Raw = strconv.Itoa(i)
+
+		select {
+		case out <- evt:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestReloadSourcesStatus(t *testing.T) {
+	appendMockSource()
+	t.Setenv("TEST_ENV", "test_value")
+
+	config := &csconfig.CrowdsecServiceCfg{AcquisitionFiles: []string{"testdata/metadata.yaml"}}
+
+	for range 2 {
+		_, err := LoadAcquisitionFromFiles(config, nil)
+		require.NoError(t, err)
+	}
+
+	// the datasources of the first load are forgotten
+	status := SourcesStatus()
+	require.Len(t, status, 1)
+	assert.Equal(t, "mock", status[0].Type)
+}
+
+func TestPauseResumeSource(t *testing.T) {
+	ctx := t.Context()
+
+	src := &MockTailWithUUID{}
+	src.UniqueId = "pause-test-uuid"
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:     "pausable",
+		Source:   "mock_tail",
+		UniqueId: src.UniqueId,
+	}, configuration.TAIL_MODE)
+	require.NoError(t, err)
+	registerSourceRuntime(rt, src)
+
+	require.Error(t, PauseSource("does-not-exist"))
+	require.NoError(t, PauseSource("pausable"))
+
+	status := SourcesStatus()
+	found := false
+
+	for _, s := range status {
+		if s.Name == "pausable" {
+			found = true
+
+			assert.True(t, s.Paused)
+			assert.Equal(t, "mock_tail", s.Type)
+		}
+	}
+
+	assert.True(t, found)
+
+	out := make(chan types.Event)
+	acquisTomb := tomb.Tomb{}
+
+	go func() {
+		_ = StartAcquisition(ctx, []DataSource{src}, out, &acquisTomb)
+	}()
+
+	select {
+	case <-out:
+		t.Fatal("received an event from a paused datasource")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	require.NoError(t, ResumeSource("pausable"))
+
+	count := 0
+READLOOP:
+	for {
+		select {
+		case <-out:
+			count++
+		case <-time.After(1 * time.Second):
+			break READLOOP
+		}
+	}
+
+	assert.Equal(t, 10, count)
+
+	acquisTomb.Kill(nil)
+}
+
+func TestStaticMetadata(t *testing.T) {
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:     "annotated",
+		UniqueId: "metadata-test-uuid",
+		Metadata: map[string]string{"team": "payments", "env": "prod"},
+	}, configuration.CAT_MODE)
+	require.NoError(t, err)
+
+	input := make(chan types.Event, 2)
+	output := make(chan types.Event, 2)
+	acquisTomb := tomb.Tomb{}
+
+	input <- types.Event{}
+	input <- types.Event{Meta: map[string]string{"team": "overridden", "foo": "bar"}}
+	close(input)
+
+	rt.forward(input, output, &acquisTomb)
+
+	evt := <-output
+	assert.Equal(t, map[string]string{"team": "payments", "env": "prod"}, evt.Meta)
+
+	evt = <-output
+	assert.Equal(t, map[string]string{"team": "payments", "env": "prod", "foo": "bar"}, evt.Meta)
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{
+		Metadata: map[string]string{"log_type": "foo"},
+	}, configuration.CAT_MODE)
+	require.EqualError(t, err, "metadata: 'log_type' is a reserved key")
+}
+
+func TestIncludeSequence(t *testing.T) {
+	cfg := configuration.DataSourceCommonCfg{
+		Name:            "sequenced",
+		UniqueId:        "sequence-test-uuid",
+		IncludeSequence: true,
+	}
+
+	run := func() []string {
+		rt, err := newSourceRuntime(cfg, configuration.CAT_MODE)
+		require.NoError(t, err)
+
+		input := make(chan types.Event, 3)
+		output := make(chan types.Event, 3)
+		acquisTomb := tomb.Tomb{}
+
+		for range 3 {
+			input <- types.Event{}
+		}
+
+		close(input)
+
+		rt.forward(input, output, &acquisTomb)
+		close(output)
+
+		ret := []string{}
+		for evt := range output {
+			ret = append(ret, evt.Meta["acquisition_sequence"])
+		}
+
+		return ret
+	}
+
+	assert.Equal(t, []string{"1", "2", "3"}, run())
+	// the counter is kept when the datasource is loaded again (reload)
+	assert.Equal(t, []string{"4", "5", "6"}, run())
+}
+
+func TestWarmupDiscard(t *testing.T) {
+	run := func(warmup string, delay time.Duration) int {
+		rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+			Name:          "warmup",
+			UniqueId:      "warmup-test-uuid",
+			WarmupDiscard: warmup,
+		}, configuration.TAIL_MODE)
+		require.NoError(t, err)
+
+		input := make(chan types.Event)
+		output := make(chan types.Event, 10)
+		acquisTomb := tomb.Tomb{}
+
+		go func() {
+			for i := range 5 {
+				if i == 3 {
+					time.Sleep(delay)
+				}
+
+				input <- types.Event{}
+			}
+
+			close(input)
+		}()
+
+		rt.forward(input, output, &acquisTomb)
+
+		return len(output)
+	}
+
+	assert.Equal(t, 5, run("", 0))
+	assert.Equal(t, 3, run("2", 0))
+	assert.Equal(t, 0, run("10", 0))
+	// the first three events are received within the warmup
+	assert.Equal(t, 2, run("200ms", 400*time.Millisecond))
+
+	_, err := newSourceRuntime(configuration.DataSourceCommonCfg{WarmupDiscard: "-1"}, configuration.TAIL_MODE)
+	require.EqualError(t, err, "warmup_discard must be positive")
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{WarmupDiscard: "a while"}, configuration.TAIL_MODE)
+	require.EqualError(t, err, "warmup_discard: 'a while' is neither a number of events nor a duration")
+}
+
+func TestPipelineTag(t *testing.T) {
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:        "tagged",
+		UniqueId:    "pipeline-tag-test-uuid",
+		PipelineTag: "web",
+	}, configuration.CAT_MODE)
+	require.NoError(t, err)
+
+	input := make(chan types.Event, 1)
+	output := make(chan types.Event, 1)
+	acquisTomb := tomb.Tomb{}
+
+	input <- types.Event{}
+	close(input)
+
+	rt.forward(input, output, &acquisTomb)
+
+	evt := <-output
+	assert.Equal(t, "web", evt.Meta["pipeline_tag"])
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{PipelineTag: " web"}, configuration.CAT_MODE)
+	require.EqualError(t, err, "pipeline_tag: ' web' has leading or trailing spaces")
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{
+		Metadata: map[string]string{"pipeline_tag": "web"},
+	}, configuration.CAT_MODE)
+	require.EqualError(t, err, "metadata: 'pipeline_tag' is a reserved key")
+}
+
+func TestIngestLatency(t *testing.T) {
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:          "lagging",
+		UniqueId:      "ingest-latency-test-uuid",
+		IngestLatency: true,
+	}, configuration.TAIL_MODE)
+	require.NoError(t, err)
+
+	input := make(chan types.Event, 3)
+	output := make(chan types.Event, 3)
+	acquisTomb := tomb.Tomb{}
+
+	input <- types.Event{Line: types.Line{Time: time.Now().Add(-90 * time.Second)}}
+	input <- types.Event{Line: types.Line{Time: time.Now().Add(time.Hour)}}
+	// no timestamp
+	input <- types.Event{}
+	close(input)
+
+	rt.forward(input, output, &acquisTomb)
+
+	evt := <-output
+	latency, err := strconv.Atoi(evt.Meta["ingest_latency_ms"])
+	require.NoError(t, err)
+	assert.InDelta(t, 90000, latency, 5000)
+
+	evt = <-output
+	assert.Equal(t, "0", evt.Meta["ingest_latency_ms"])
+
+	evt = <-output
+	assert.NotContains(t, evt.Meta, "ingest_latency_ms")
+}
+
+func TestMaxBacklog(t *testing.T) {
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:       "backlogged",
+		UniqueId:   "backlog-test-uuid",
+		MaxBacklog: 3,
+	}, configuration.TAIL_MODE)
+	require.NoError(t, err)
+
+	input := make(chan types.Event, 10)
+	output := make(chan types.Event)
+	acquisTomb := tomb.Tomb{}
+
+	for i := range 10 {
+		input <- types.Event{Line: types.Line{Raw: strconv.Itoa(i)}}
+	}
+
+	close(input)
+
+	done := make(chan struct{})
+
+	go func() {
+		rt.forward(input, output, &acquisTomb)
+		close(done)
+	}()
+
+	// nothing reads the output: one event is held by the drain, the queue is full
+	require.Eventually(t, func() bool {
+		return rt.backlog.size() == 3 && rt.backlog.underPressure()
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Len(t, input, 10-1-3-1)
+
+	for i := range 10 {
+		evt := <-output
+		assert.Equal(t, strconv.Itoa(i), evt.Line.Raw)
+	}
+
+	<-done
+
+	assert.Equal(t, 0, rt.backlog.size())
+	assert.False(t, rt.backlog.underPressure())
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{MaxBacklog: -1}, configuration.TAIL_MODE)
+	require.EqualError(t, err, "max_backlog must be positive")
+}
+
+func TestMaxBacklogMemory(t *testing.T) {
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:       "backlogged-memory",
+		UniqueId:   "backlog-memory-test-uuid",
+		MaxBacklog: 100,
+	}, configuration.TAIL_MODE)
+	require.NoError(t, err)
+
+	input := make(chan types.Event, 10)
+	output := make(chan types.Event)
+	acquisTomb := tomb.Tomb{}
+
+	for i := range 10 {
+		input <- types.Event{Line: types.Line{Raw: strconv.Itoa(i)}}
+	}
+
+	close(input)
+
+	evt := <-input
+	size := eventSize(&evt)
+
+	acquisitionMemory.setLimit(2 * size)
+	t.Cleanup(func() { acquisitionMemory.setLimit(0) })
+
+	done := make(chan struct{})
+
+	go func() {
+		rt.forward(input, output, &acquisTomb)
+		close(done)
+	}()
+
+	// the queue is far from max_backlog, but holds the memory limit
+	require.Eventually(t, func() bool {
+		return rt.backlog.size() == 3 && rt.backlog.underPressure()
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 3*size, acquisitionMemory.used.Load())
+
+	for i := 1; i < 10; i++ {
+		evt := <-output
+		assert.Equal(t, strconv.Itoa(i), evt.Line.Raw)
+	}
+
+	<-done
+
+	assert.Equal(t, int64(0), acquisitionMemory.used.Load())
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	// one-shot: stopped once the input is closed
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:            "lifecycle",
+		Source:          "file",
+		UniqueId:        "lifecycle-test-uuid",
+		PipelineTag:     "web",
+		LifecycleEvents: true,
+	}, configuration.CAT_MODE)
+	require.NoError(t, err)
+
+	input := make(chan types.Event, 1)
+	output := make(chan types.Event, 10)
+	acquisTomb := tomb.Tomb{}
+
+	input <- types.Event{Line: types.Line{Raw: "a line"}}
+
+	close(input)
+	rt.forward(input, output, &acquisTomb)
+
+	require.Len(t, output, 3)
+
+	evt := <-output
+	assert.True(t, evt.IsLifecycle())
+	assert.Equal(t, "started", evt.Meta[types.LifecycleMetaKey])
+	assert.Equal(t, "lifecycle", evt.Meta["acquisition_source"])
+	assert.Equal(t, "acquisition started", evt.Meta["acquisition_lifecycle_reason"])
+	assert.Equal(t, "web", evt.Meta[types.PipelineTagMetaKey])
+	assert.Equal(t, "acquisition_lifecycle", evt.Line.Labels["type"])
+	assert.Equal(t, "file", evt.Line.Module)
+
+	evt = <-output
+	assert.False(t, evt.IsLifecycle())
+	assert.Equal(t, "a line", evt.Line.Raw)
+
+	evt = <-output
+	assert.Equal(t, "stopped", evt.Meta[types.LifecycleMetaKey])
+	assert.Equal(t, "completed", evt.Meta["acquisition_lifecycle_reason"])
+
+	// the error of the datasource
+	input = make(chan types.Event)
+	close(input)
+	rt.setStopReason(errors.New("could not read the file"))
+	rt.forward(input, output, &acquisTomb)
+
+	require.Len(t, output, 2)
+	<-output
+	evt = <-output
+	assert.Equal(t, "could not read the file", evt.Meta["acquisition_lifecycle_reason"])
+
+	// streaming: stopped with the acquisition
+	rt, err = newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:            "lifecycle-tail",
+		UniqueId:        "lifecycle-tail-test-uuid",
+		LifecycleEvents: true,
+	}, configuration.TAIL_MODE)
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	acquisTomb = tomb.Tomb{}
+
+	acquisTomb.Go(func() error {
+		<-release
+		return nil
+	})
+
+	done := make(chan struct{})
+
+	go func() {
+		rt.forward(make(chan types.Event), output, &acquisTomb)
+		close(done)
+	}()
+
+	evt = <-output
+	assert.Equal(t, "started", evt.Meta[types.LifecycleMetaKey])
+
+	acquisTomb.Kill(errors.New("shutting down"))
+
+	evt = <-output
+	assert.Equal(t, "stopped", evt.Meta[types.LifecycleMetaKey])
+	assert.Equal(t, "shutting down", evt.Meta["acquisition_lifecycle_reason"])
+
+	close(release)
+	<-done
+
+	// off by default
+	rt, err = newSourceRuntime(configuration.DataSourceCommonCfg{Name: "quiet", UniqueId: "quiet-test-uuid"}, configuration.CAT_MODE)
+	require.NoError(t, err)
+
+	input = make(chan types.Event)
+	close(input)
+	rt.forward(input, output, &tomb.Tomb{})
+	assert.Empty(t, output)
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{Metadata: map[string]string{"acquisition_lifecycle": "x"}}, configuration.TAIL_MODE)
+	require.EqualError(t, err, "metadata: 'acquisition_lifecycle' is a reserved key")
+}
+
+func TestMaxEvents(t *testing.T) {
+	ctx := t.Context()
+
+	src := &MockCatUntilCanceled{}
+	src.UniqueId = "max-events-test-uuid"
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:            "bounded",
+		UniqueId:        src.UniqueId,
+		MaxEvents:       5,
+		LifecycleEvents: true,
+	}, configuration.CAT_MODE)
+	require.NoError(t, err)
+	registerSourceRuntime(rt, src)
+
+	out := make(chan types.Event, 100)
+	acquisTomb := tomb.Tomb{}
+
+	// the error of the canceled datasource doesn't fail the acquisition
+	require.NoError(t, StartAcquisition(ctx, []DataSource{src}, out, &acquisTomb))
+
+	require.Len(t, out, 7)
+
+	evt := <-out
+	assert.Equal(t, "started", evt.Meta[types.LifecycleMetaKey])
+
+	for i := range 5 {
+		evt = <-out
+		assert.Equal(t, strconv.Itoa(i), evt.Line.Raw)
+	}
+
+	evt = <-out
+	assert.Equal(t, "stopped", evt.Meta[types.LifecycleMetaKey])
+	assert.Equal(t, "max_events reached", evt.Meta["acquisition_lifecycle_reason"])
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{MaxEvents: -1}, configuration.CAT_MODE)
+	require.EqualError(t, err, "max_events must be positive")
+
+	_, err = newSourceRuntime(configuration.DataSourceCommonCfg{MaxEvents: 5}, configuration.TAIL_MODE)
+	require.EqualError(t, err, "max_events is only supported in cat mode")
+}
+
+func TestMaxEventsReorder(t *testing.T) {
+	rt, err := newSourceRuntime(configuration.DataSourceCommonCfg{
+		Name:          "bounded-reorder",
+		UniqueId:      "max-events-reorder-test-uuid",
+		MaxEvents:     3,
+		ReorderWindow: time.Minute,
+	}, configuration.CAT_MODE)
+	require.NoError(t, err)
+
+	input := make(chan types.Event, 5)
+	output := make(chan types.Event, 5)
+	now := time.Now()
+
+	// the first 3 events are sent, sorted
+	for _, offset := range []int{3, 1, 2, 0, 4} {
+		evt := types.Event{}
+		evt.Line.Raw = strconv.Itoa(offset)
+		evt.Line.Time = now.Add(time.Duration(offset) * time.Second)
+		input <- evt
+	}
+
+	close(input)
+	rt.forward(input, output, &tomb.Tomb{})
+
+	require.Len(t, output, 3)
+
+	for _, expected := range []string{"1", "2", "3"} {
+		evt := <-output
+		assert.Equal(t, expected, evt.Line.Raw)
+	}
+}
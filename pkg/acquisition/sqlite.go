@@ -0,0 +1,12 @@
+//go:build !no_datasource_sqlite
+
+package acquisition
+
+import (
+	sqliteacquisition "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/sqlite"
+)
+
+//nolint:gochecknoinits
+func init() {
+	registerDataSource("sqlite", func() DataSource { return &sqliteacquisition.SqliteSource{} })
+}
@@ -0,0 +1,12 @@
+//go:build !no_datasource_tar
+
+package acquisition
+
+import (
+	taracquisition "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/tar"
+)
+
+//nolint:gochecknoinits
+func init() {
+	registerDataSource("tar", func() DataSource { return &taracquisition.TarSource{} })
+}
@@ -0,0 +1,12 @@
+//go:build !no_datasource_vector
+
+package acquisition
+
+import (
+	vectoracquisition "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/vector"
+)
+
+//nolint:gochecknoinits
+func init() {
+	registerDataSource("vector", func() DataSource { return &vectoracquisition.VectorSource{} })
+}
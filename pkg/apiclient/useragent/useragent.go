@@ -11,3 +11,7 @@ func Default() string {
 func AppsecUserAgent() string {
 	return "appsec/" + version.String() + "-" + version.System
 }
+
+func LokiUserAgent() string {
+	return "crowdsec-loki/" + version.String() + "-" + version.System
+}
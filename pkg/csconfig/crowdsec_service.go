@@ -1,9 +1,11 @@
 package csconfig
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -27,10 +29,119 @@ type CrowdsecServiceCfg struct {
 	BucketStateDumpDir        string            `yaml:"state_output_dir,omitempty"` // if we need to unserialize buckets on shutdown
 	BucketsGCEnabled          bool              `yaml:"-"`                          // we need to garbage collect buckets when in forensic mode
 
+	// estimated memory (bytes) held by the reorder buffers and the backlog queues across all
+	// datasources, 0 for no limit
+	MaxAcquisitionMemory int64 `yaml:"max_acquisition_memory,omitempty"`
+
+	// drops the lines received several times, from one or several datasources
+	AcquisitionDedup *AcquisitionDedupCfg `yaml:"acquisition_dedup,omitempty"`
+
+	// sorts the events of the streaming datasources by timestamp, across datasources
+	AcquisitionMerge *AcquisitionMergeCfg `yaml:"acquisition_merge,omitempty"`
+
+	// pause, resume and reload the datasources over HTTP, disabled if nil
+	AcquisitionControl *AcquisitionControlCfg `yaml:"acquisition_control,omitempty"`
+
 	SimulationFilePath string              `yaml:"-"`
 	ContextToSend      map[string][]string `yaml:"-"`
 }
 
+// AcquisitionDedupCfg configures the probabilistic de-duplication of the acquired lines: a line
+// is a duplicate if the same text, with a timestamp in the same window, was already seen.
+type AcquisitionDedupCfg struct {
+	Capacity          int           `yaml:"capacity"`            // distinct lines remembered before the oldest are forgotten
+	FalsePositiveRate float64       `yaml:"false_positive_rate"` // chance to drop a line that was not seen
+	Window            time.Duration `yaml:"window"`              // timestamps are compared by window
+}
+
+// AcquisitionMergeCfg configures the ordering of the events of all the streaming datasources:
+// the events are held up to window, and emitted by timestamp. Every event is delayed by up to
+// window, the ones that arrive after a more recent event was emitted are not reordered.
+type AcquisitionMergeCfg struct {
+	Window    time.Duration `yaml:"window"`     // how long an event is held, waiting for older ones
+	MaxEvents int           `yaml:"max_events"` // events held before the oldest are emitted early
+}
+
+// AcquisitionControlCfg configures the listener of the acquisition control endpoints. They are
+// not authenticated: anyone who can reach the listener can pause the datasources.
+type AcquisitionControlCfg struct {
+	ListenAddr string `yaml:"listen_addr"` // default is 127.0.0.1
+	ListenPort int    `yaml:"listen_port"`
+}
+
+const (
+	defaultDedupCapacity          = 1000000
+	defaultDedupFalsePositiveRate = 0.0001
+	defaultDedupWindow            = time.Minute
+)
+
+func (d *AcquisitionDedupCfg) setDefaults() error {
+	if d.Capacity < 0 {
+		return errors.New("acquisition_dedup: capacity must be positive")
+	}
+
+	if d.Capacity == 0 {
+		d.Capacity = defaultDedupCapacity
+	}
+
+	if d.FalsePositiveRate < 0 || d.FalsePositiveRate >= 1 {
+		return errors.New("acquisition_dedup: false_positive_rate must be between 0 and 1")
+	}
+
+	if d.FalsePositiveRate == 0 {
+		d.FalsePositiveRate = defaultDedupFalsePositiveRate
+	}
+
+	if d.Window < 0 {
+		return errors.New("acquisition_dedup: window must be positive")
+	}
+
+	if d.Window == 0 {
+		d.Window = defaultDedupWindow
+	}
+
+	return nil
+}
+
+const defaultAcquisitionControlAddr = "127.0.0.1"
+
+func (a *AcquisitionControlCfg) setDefaults() error {
+	if a.ListenAddr == "" {
+		a.ListenAddr = defaultAcquisitionControlAddr
+	}
+
+	if a.ListenPort <= 0 {
+		return errors.New("acquisition_control: listen_port is mandatory")
+	}
+
+	return nil
+}
+
+const (
+	defaultMergeWindow    = time.Second
+	defaultMergeMaxEvents = 10000
+)
+
+func (m *AcquisitionMergeCfg) setDefaults() error {
+	if m.Window < 0 {
+		return errors.New("acquisition_merge: window must be positive")
+	}
+
+	if m.Window == 0 {
+		m.Window = defaultMergeWindow
+	}
+
+	if m.MaxEvents < 0 {
+		return errors.New("acquisition_merge: max_events must be positive")
+	}
+
+	if m.MaxEvents == 0 {
+		m.MaxEvents = defaultMergeMaxEvents
+	}
+
+	return nil
+}
+
 func (c *Config) LoadCrowdsec() error {
 	var err error
 
@@ -108,6 +219,28 @@ func (c *Config) LoadCrowdsec() error {
 		c.Crowdsec.OutputRoutinesCount = 1
 	}
 
+	if c.Crowdsec.MaxAcquisitionMemory < 0 {
+		return errors.New("max_acquisition_memory must be positive")
+	}
+
+	if c.Crowdsec.AcquisitionDedup != nil {
+		if err = c.Crowdsec.AcquisitionDedup.setDefaults(); err != nil {
+			return err
+		}
+	}
+
+	if c.Crowdsec.AcquisitionMerge != nil {
+		if err = c.Crowdsec.AcquisitionMerge.setDefaults(); err != nil {
+			return err
+		}
+	}
+
+	if c.Crowdsec.AcquisitionControl != nil {
+		if err = c.Crowdsec.AcquisitionControl.setDefaults(); err != nil {
+			return err
+		}
+	}
+
 	crowdsecCleanup := []*string{
 		&c.Crowdsec.AcquisitionFilePath,
 		&c.Crowdsec.ConsoleContextPath,
@@ -167,6 +167,82 @@ func TestLoadCrowdsec(t *testing.T) {
 			},
 			expectedErr: cstest.FileNotFoundMessage,
 		},
+		{
+			name: "negative max_acquisition_memory",
+			input: &Config{
+				ConfigPaths: &ConfigurationPaths{
+					ConfigDir: "./testdata",
+					DataDir:   "./data",
+					HubDir:    "./hub",
+				},
+				API: &APICfg{
+					Client: &LocalApiClientCfg{
+						CredentialsFilePath: "./testdata/lapi-secrets.yaml",
+					},
+				},
+				Crowdsec: &CrowdsecServiceCfg{
+					MaxAcquisitionMemory: -1,
+				},
+			},
+			expectedErr: "max_acquisition_memory must be positive",
+		},
+		{
+			name: "invalid acquisition_dedup",
+			input: &Config{
+				ConfigPaths: &ConfigurationPaths{
+					ConfigDir: "./testdata",
+					DataDir:   "./data",
+					HubDir:    "./hub",
+				},
+				API: &APICfg{
+					Client: &LocalApiClientCfg{
+						CredentialsFilePath: "./testdata/lapi-secrets.yaml",
+					},
+				},
+				Crowdsec: &CrowdsecServiceCfg{
+					AcquisitionDedup: &AcquisitionDedupCfg{FalsePositiveRate: 1.5},
+				},
+			},
+			expectedErr: "acquisition_dedup: false_positive_rate must be between 0 and 1",
+		},
+		{
+			name: "invalid acquisition_merge",
+			input: &Config{
+				ConfigPaths: &ConfigurationPaths{
+					ConfigDir: "./testdata",
+					DataDir:   "./data",
+					HubDir:    "./hub",
+				},
+				API: &APICfg{
+					Client: &LocalApiClientCfg{
+						CredentialsFilePath: "./testdata/lapi-secrets.yaml",
+					},
+				},
+				Crowdsec: &CrowdsecServiceCfg{
+					AcquisitionMerge: &AcquisitionMergeCfg{MaxEvents: -1},
+				},
+			},
+			expectedErr: "acquisition_merge: max_events must be positive",
+		},
+		{
+			name: "acquisition_control without listen_port",
+			input: &Config{
+				ConfigPaths: &ConfigurationPaths{
+					ConfigDir: "./testdata",
+					DataDir:   "./data",
+					HubDir:    "./hub",
+				},
+				API: &APICfg{
+					Client: &LocalApiClientCfg{
+						CredentialsFilePath: "./testdata/lapi-secrets.yaml",
+					},
+				},
+				Crowdsec: &CrowdsecServiceCfg{
+					AcquisitionControl: &AcquisitionControlCfg{},
+				},
+			},
+			expectedErr: "acquisition_control: listen_port is mandatory",
+		},
 		{
 			name: "agent disabled",
 			input: &Config{
@@ -8,21 +8,29 @@ package component
 // Built is a map of all the known components, and whether they are built-in or not.
 // This is populated as soon as possible by the respective init() functions
 var Built = map[string]bool{
-	"datasource_appsec":       false,
-	"datasource_cloudwatch":   false,
-	"datasource_docker":       false,
-	"datasource_file":         false,
-	"datasource_journalctl":   false,
-	"datasource_k8s-audit":    false,
-	"datasource_kafka":        false,
-	"datasource_kinesis":      false,
-	"datasource_loki":         false,
-	"datasource_s3":           false,
-	"datasource_syslog":       false,
-	"datasource_wineventlog":  false,
-	"datasource_victorialogs": false,
-  "datasource_http":        false,
-	"cscli_setup":             false,
+	"datasource_appsec":        false,
+	"datasource_cloudwatch":    false,
+	"datasource_docker":        false,
+	"datasource_elasticsearch": false,
+	"datasource_file":          false,
+	"datasource_forward":       false,
+	"datasource_gelf":          false,
+	"datasource_grpc":          false,
+	"datasource_journalctl":    false,
+	"datasource_k8s-audit":     false,
+	"datasource_kafka":         false,
+	"datasource_kinesis":       false,
+	"datasource_loki":          false,
+	"datasource_mqtt":          false,
+	"datasource_s3":            false,
+	"datasource_sqlite":        false,
+	"datasource_syslog":        false,
+	"datasource_tar":           false,
+	"datasource_wineventlog":   false,
+	"datasource_vector":        false,
+	"datasource_victorialogs":  false,
+	"datasource_http":          false,
+	"cscli_setup":              false,
 }
 
 func Register(name string) {
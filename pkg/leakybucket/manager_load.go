@@ -40,6 +40,7 @@ type BucketFactory struct {
 	LeakSpeed           string                 `yaml:"leakspeed"`           // Leakspeed is a float representing how many events per second leak out of the bucket
 	Duration            string                 `yaml:"duration"`            // Duration allows 'counter' buckets to have a fixed life-time
 	Filter              string                 `yaml:"filter"`              // Filter is an expr that determines if an event is elligible for said bucket. Filter is evaluated against the Event struct
+	PipelineTags        []string               `yaml:"pipeline_tags"`       // if set, only the events of the datasources with one of these pipeline_tag are considered, before the filter
 	GroupBy             string                 `yaml:"groupby,omitempty"`   // groupy is an expr that allows to determine the partitions of the bucket. A common example is the source_ip
 	Distinct            string                 `yaml:"distinct"`            // Distinct, when present, adds a `Pour()` processor that will only pour uniq items (based on distinct expr result)
 	Debug               bool                   `yaml:"debug"`               // Debug, when set to true, will enable debugging for _this_ scenario specifically
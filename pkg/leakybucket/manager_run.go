@@ -286,6 +286,11 @@ var orderEvent map[string]*sync.WaitGroup
 func PourItemToHolders(parsed types.Event, holders []BucketFactory, buckets *Buckets) (bool, error) {
 	var ok, condition, poured bool
 
+	// the datasources starting and stopping are not detection events
+	if parsed.IsLifecycle() {
+		return false, nil
+	}
+
 	if BucketPourTrack {
 		if BucketPourCache == nil {
 			BucketPourCache = make(map[string][]types.Event)
@@ -300,6 +305,11 @@ func PourItemToHolders(parsed types.Event, holders []BucketFactory, buckets *Buc
 	for idx := range holders {
 		//for idx, holder := range holders {
 
+		if !parsed.MatchPipelineTags(holders[idx].PipelineTags) {
+			holders[idx].logger.Debugf("Event leaving node : ko (pipeline tag mismatch)")
+			continue
+		}
+
 		//evaluate bucket's condition
 		if holders[idx].RunTimeFilter != nil {
 			holders[idx].logger.Tracef("event against holder %d/%d", idx, len(holders))
@@ -8,6 +8,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/tomb.v2"
 
+	"github.com/crowdsecurity/crowdsec/pkg/parser"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
 
@@ -179,3 +180,62 @@ func TestShutdownBuckets(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestPourLifecycle(t *testing.T) {
+	buckets := NewBuckets()
+	tomb := &tomb.Tomb{}
+
+	Holders := []BucketFactory{
+		{
+			Name:        "test_counter_all",
+			Description: "test_counter_all",
+			Type:        "counter",
+			Capacity:    -1,
+			Duration:    "10m",
+			Filter:      "true",
+			wgDumpState: buckets.wgDumpState,
+			wgPour:      buckets.wgPour,
+		},
+	}
+
+	for idx := range Holders {
+		if err := LoadBucket(&Holders[idx], tomb); err != nil {
+			t.Fatalf("while loading (%d/%d): %s", idx, len(Holders), err)
+		}
+	}
+
+	// a lifecycle event, as sent by the acquisition
+	evt := types.MakeEvent(false, types.LOG, true)
+	evt.Line = types.Line{
+		Raw:     "datasource file stopped: completed",
+		Labels:  map[string]string{"type": "acquisition_lifecycle"},
+		Process: true,
+		Module:  "file",
+	}
+	evt.SetMeta(types.LifecycleMetaKey, "stopped")
+
+	// no parser handles it, it is still not discarded
+	pctx := parser.UnixParserCtx{Stages: []string{"s00-raw", "s01-parse", "s02-enrich"}}
+
+	parsed, err := parser.Parse(pctx, evt, nil)
+	if err != nil {
+		t.Fatalf("while parsing : %s", err)
+	}
+
+	if !parsed.Process {
+		t.Fatalf("the lifecycle event was discarded by the parsers")
+	}
+
+	ok, err := PourItemToHolders(parsed, Holders, buckets)
+	if err != nil {
+		t.Fatalf("while pouring item : %s", err)
+	}
+
+	if ok {
+		t.Fatalf("the lifecycle event was poured")
+	}
+
+	if err := expectBucketCount(buckets, 0); err != nil {
+		t.Fatal(err)
+	}
+}
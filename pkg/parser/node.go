@@ -46,6 +46,9 @@ type Node struct {
 	// and must succeed or node is exited
 	Filter        string      `yaml:"filter,omitempty"`
 	RunTimeFilter *vm.Program `yaml:"-" json:"-"` // the actual compiled filter
+	// PipelineTags, if set, restricts the node to the events of the datasources with one of
+	// these pipeline_tag. It is checked before the filter.
+	PipelineTags []string `yaml:"pipeline_tags,omitempty"`
 	// If node has leafs, execute all of them until one asks for a 'break'
 	LeavesNodes []Node `yaml:"nodes,omitempty"`
 	// Flag used to describe when to 'break' or return an 'error'
@@ -287,6 +290,11 @@ func (n *Node) process(p *types.Event, ctx UnixParserCtx, expressionEnv map[stri
 
 	clog.Tracef("Event entering node")
 
+	if !p.MatchPipelineTags(n.PipelineTags) {
+		clog.Debugf("Event leaving node : ko (pipeline tag mismatch)")
+		return false, nil
+	}
+
 	NodeState, err := n.processFilter(cachedExprEnv)
 	if err != nil {
 		return false, err
@@ -301,6 +301,12 @@ func Parse(ctx UnixParserCtx, xp types.Event, nodes []Node) (types.Event, error)
 		log.Tracef("INPUT '%s'", event.Line.Raw)
 	}
 
+	/* the datasources starting and stopping are not logs, no parser is expected to handle them */
+	if event.IsLifecycle() {
+		event.Process = true
+		return event, nil
+	}
+
 	if ParseDump {
 		if StageParseCache == nil {
 			StageParseMutex.Lock()
@@ -2,6 +2,7 @@ package types
 
 import (
 	"net"
+	"slices"
 	"strings"
 	"time"
 
@@ -117,6 +118,35 @@ func (e *Event) GetMeta(key string) string {
 	return ""
 }
 
+// PipelineTagMetaKey is set by the acquisition on the events of the datasources with a pipeline_tag.
+const PipelineTagMetaKey = "pipeline_tag"
+
+// MatchPipelineTags reports whether a parser or scenario with the given pipeline_tags processes
+// the event: either the list is empty, or the event comes from a datasource with one of the tags.
+func (e *Event) MatchPipelineTags(tags []string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+
+	tag, ok := e.Meta[PipelineTagMetaKey]
+	if !ok {
+		return false
+	}
+
+	return slices.Contains(tags, tag)
+}
+
+// LifecycleMetaKey is set by the acquisition on the events that report a datasource starting or
+// stopping, to "started" or "stopped". They go through the parsers untouched and are not poured
+// in the buckets.
+const LifecycleMetaKey = "acquisition_lifecycle"
+
+// IsLifecycle reports whether the event is a lifecycle event of a datasource.
+func (e *Event) IsLifecycle() bool {
+	_, ok := e.Meta[LifecycleMetaKey]
+	return ok
+}
+
 func (e *Event) ParseIPSources() []net.IP {
 	var srcs []net.IP
 
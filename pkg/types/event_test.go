@@ -87,6 +87,23 @@ func TestSetMeta(t *testing.T) {
 	}
 }
 
+func TestMatchPipelineTags(t *testing.T) {
+	tagged := &Event{Meta: map[string]string{PipelineTagMetaKey: "web"}}
+	untagged := &Event{}
+
+	assert.True(t, tagged.MatchPipelineTags(nil))
+	assert.True(t, untagged.MatchPipelineTags(nil))
+	assert.True(t, tagged.MatchPipelineTags([]string{"db", "web"}))
+	assert.False(t, tagged.MatchPipelineTags([]string{"db"}))
+	assert.False(t, untagged.MatchPipelineTags([]string{"web"}))
+}
+
+func TestIsLifecycle(t *testing.T) {
+	assert.True(t, (&Event{Meta: map[string]string{LifecycleMetaKey: "started"}}).IsLifecycle())
+	assert.False(t, (&Event{Meta: map[string]string{"source_ip": "127.0.0.1"}}).IsLifecycle())
+	assert.False(t, (&Event{}).IsLifecycle())
+}
+
 func TestParseIPSources(t *testing.T) {
 	tests := []struct {
 		name     string